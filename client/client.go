@@ -0,0 +1,124 @@
+package client
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/repproto"
+)
+
+// Version of this release
+const Version = "0.0.1 very alpha"
+
+// innerHeader is the size of the inner message header
+const innerHeader = 332
+
+var (
+	// ErrNoServer is returned if no server is configured or given
+	ErrNoServer = errors.New("client: No server")
+	// ErrNoPeers is returned if no peers could be discovered
+	ErrNoPeers = errors.New("client: No peers")
+	// ErrTooBig is returned if the data to post exceeds the message size
+	ErrTooBig = errors.New("client: Data too big")
+	// ErrBadMessageID is returned if a message ID could not be parsed
+	ErrBadMessageID = errors.New("client: Bad message ID")
+	// ErrNoKey is returned if a required key is missing
+	ErrNoKey = errors.New("client: Key missing")
+	// ErrRepost is returned if a repost message is to be posted. Reposts are encrypted with Encrypt and handed to the next hop
+	ErrRepost = errors.New("client: Repost messages cannot be posted")
+)
+
+// Config contains the client configuration. It mirrors the repclient config file.
+type Config struct {
+	BodyLength    int      // Total length of a message
+	PadToLength   int      // Length of random padding
+	MinHashCash   byte     // Minimum hashcash bits to produce and accept
+	SocksServer   string   // URL of the socks server, if any
+	BootStrapPeer string   // Peer to bootstrap the server list from
+	PasteServers  []string // URLs of repservers
+	Concurrency   int      // Number of servers to query concurrently. One by one if < 2
+}
+
+// DefaultConfig returns the default client configuration.
+func DefaultConfig() Config {
+	return Config{
+		BodyLength:  message.DefaultTotalLength,
+		PadToLength: message.DefaultPadToLength,
+		MinHashCash: 24,
+		SocksServer: "socks5://127.0.0.1:9050",
+	}
+}
+
+// Client is a repbin client.
+type Client struct {
+	Config   Config
+	KeyStore KeyStore // Optional source for signer keys and private keys
+}
+
+// New returns a new client for config.
+func New(config Config) *Client {
+	c := &Client{
+		Config: config,
+	}
+	if c.Config.BodyLength <= 0 {
+		c.Config.BodyLength = message.DefaultTotalLength
+	}
+	if c.Config.PadToLength <= 0 {
+		c.Config.PadToLength = message.DefaultPadToLength
+	}
+	if c.Config.MinHashCash == 0 {
+		c.Config.MinHashCash = message.DefaultHashCashBits
+	}
+	return c
+}
+
+// MessageID identifies a message.
+type MessageID [message.MessageIDSize]byte
+
+// String returns the encoded message ID.
+func (id MessageID) String() string {
+	return utils.B58encode(id[:])
+}
+
+// ParseMessageID decodes an encoded message ID.
+func ParseMessageID(s string) (MessageID, error) {
+	var id MessageID
+	d := utils.B58decode(s)
+	if len(d) != message.MessageIDSize {
+		return id, ErrBadMessageID
+	}
+	copy(id[:], d)
+	return id, nil
+}
+
+// ParseAddress splits a pastebin address into server, message ID and key.
+// Supported formats are server/messageID_key, server/messageID, messageID_key and messageID.
+func ParseAddress(address string) (server string, id MessageID, key string, err error) {
+	var messageID string
+	fsplit := strings.SplitN(address, "_", 2)
+	if len(fsplit) == 2 {
+		key = fsplit[1]
+	}
+	ssep := strings.LastIndex(fsplit[0], "/")
+	if ssep == -1 {
+		messageID = fsplit[0]
+	} else {
+		messageID = fsplit[0][ssep+1:]
+		server = fsplit[0][:ssep]
+	}
+	id, err = ParseMessageID(messageID)
+	return server, id, key, err
+}
+
+// proto returns a protocol wrapper for server, or for the configured servers if server is empty.
+func (c *Client) proto(server string) *repproto.Proto {
+	if server != "" {
+		return repproto.New(c.Config.SocksServer, server)
+	}
+	proto := repproto.New(c.Config.SocksServer, "", c.Config.PasteServers...)
+	proto.Parallel = c.Config.Concurrency
+	proto.MinHashCash = c.Config.MinHashCash
+	return proto
+}
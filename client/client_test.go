@@ -0,0 +1,126 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+)
+
+func testClient() *Client {
+	config := DefaultConfig()
+	config.MinHashCash = 12
+	return New(config)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	c := testClient()
+	msg := []byte("This is a small test message for verification, it just has to be not too short to be not boring")
+	encMessage, keys, err := c.Encrypt(msg, &PostOptions{EmbedKey: true})
+	if err != nil {
+		t.Fatalf("Encrypt: %s", err)
+	}
+	if keys.MessageKey == nil {
+		t.Fatal("Message key missing")
+	}
+	if keys.EmbedPublicKey == nil || keys.EmbedPrivateKey == nil {
+		t.Fatal("Embedded keys missing")
+	}
+	dec, err := c.Decrypt(encMessage, &DecryptOptions{PrivateKey: utils.B58encode(keys.MessageKey[:])})
+	if err != nil {
+		t.Fatalf("Decrypt: %s", err)
+	}
+	if dec.MessageID != keys.MessageID {
+		t.Error("MessageIDs do not match")
+	}
+	if dec.Type != message.MsgTypeBlob {
+		t.Error("Message type does not match")
+	}
+	if *dec.EmbedPublicKey != *keys.EmbedPublicKey {
+		t.Error("Embedded key does not match")
+	}
+	if !bytes.Equal(dec.Body, msg) {
+		t.Error("Message corrupted")
+	}
+}
+
+func TestDecryptKeyStore(t *testing.T) {
+	c := testClient()
+	msg := []byte("This is a small test message for verification, it just has to be not too short to be not boring")
+	recipient, _ := message.GenLongTermKey(false, false)
+	tempkey, _ := message.GenRandomKey()
+	recipientKey := utils.B58encode(message.GenPubKey(recipient)[:]) + "_" + utils.B58encode(message.GenPubKey(tempkey)[:])
+	encMessage, keys, err := c.Encrypt(msg, &PostOptions{RecipientKey: recipientKey})
+	if err != nil {
+		t.Fatalf("Encrypt: %s", err)
+	}
+	if keys.MessageKey != nil {
+		t.Error("No message key expected")
+	}
+	if _, err := c.Decrypt(encMessage, nil); err == nil {
+		t.Error("Decrypt must fail without keys")
+	}
+	ks := NewDirKeyStore("")
+	ks.AddKey(utils.B58encode(recipient[:]) + "_" + utils.B58encode(tempkey[:]))
+	c.KeyStore = ks
+	dec, err := c.Decrypt(encMessage, nil)
+	if err != nil {
+		t.Fatalf("Decrypt: %s", err)
+	}
+	if !bytes.Equal(dec.Body, msg) {
+		t.Error("Message corrupted")
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	id := MessageID{0x01, 0x02, 0x03}
+	server, pid, key, err := ParseAddress("http://example.onion/" + id.String() + "_abc")
+	if err != nil {
+		t.Fatalf("ParseAddress: %s", err)
+	}
+	if server != "http://example.onion" || pid != id || key != "abc" {
+		t.Errorf("Bad parse: %s %s %s", server, pid, key)
+	}
+	server, pid, key, err = ParseAddress(id.String())
+	if err != nil {
+		t.Fatalf("ParseAddress: %s", err)
+	}
+	if server != "" || pid != id || key != "" {
+		t.Errorf("Bad parse: %s %s %s", server, pid, key)
+	}
+	if _, _, _, err := ParseAddress("http://example.onion/abc"); err != ErrBadMessageID {
+		t.Errorf("Bad message ID not detected: %v", err)
+	}
+	keys := &Keys{Server: "http://example.onion/", MessageID: id}
+	if keys.Address() != "http://example.onion/"+id.String() {
+		t.Errorf("Bad address: %s", keys.Address())
+	}
+}
+
+func TestPostRepost(t *testing.T) {
+	c := testClient()
+	c.Config.PasteServers = []string{"http://example.onion/"}
+	if _, _, err := c.Post(context.Background(), []byte("repost"), &PostOptions{Repost: true}); err != ErrRepost {
+		t.Errorf("Post must refuse reposts: %v", err)
+	}
+}
+
+func ExampleClient() {
+	c := New(DefaultConfig())
+	ctx := context.Background()
+	id, keys, err := c.Post(ctx, []byte("message"), nil)
+	if err != nil {
+		return
+	}
+	data, _, err := c.Fetch(ctx, id, keys.Server)
+	if err != nil {
+		return
+	}
+	msg, err := c.Decrypt(data, &DecryptOptions{PrivateKey: utils.B58encode(keys.MessageKey[:])})
+	if err != nil {
+		return
+	}
+	_ = msg.Body
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+)
+
+var (
+	// ErrShort is returned if the data to decrypt is too short
+	ErrShort = errors.New("client: Message too short")
+	// ErrMessageIDConflict is returned if the ID of a repost message does not match its signature
+	ErrMessageIDConflict = errors.New("client: MessageID conflict")
+)
+
+// DecryptOptions control message decryption.
+type DecryptOptions struct {
+	PrivateKey  string                                              // Private key(s) as privkey or privkey_tempkey. KeyStore is used if empty
+	KeyCallBack func(*message.Curve25519Key) *message.Curve25519Key // Returns the private key for a public key. Used instead of KeyStore if set
+	SenderKey   *message.Curve25519Key                              // Optional sender public key to verify
+}
+
+// Message is a decrypted message.
+type Message struct {
+	MessageID               MessageID
+	Type                    byte                   // message.MsgTypeBlob, MsgTypeList or MsgTypeRepost
+	ReceiverPubKey          *message.Curve25519Key // Constant public key of recipient
+	SenderPubKey            *message.Curve25519Key // Constant public key of sender
+	EmbedPublicKey          *message.Curve25519Key // Embedded constant public key, if any
+	EmbedTemporaryPublicKey *message.Curve25519Key // Embedded temporary public key, if any
+	Body                    []byte                 // Message content. Reposts contain the message to post
+	List                    []string               // List items of list messages
+	Repost                  *Repost                // Repost details of repost messages
+}
+
+// Repost contains the details of a repost message.
+type Repost struct {
+	MessageID     MessageID
+	MinDelay      uint32
+	MaxDelay      uint32
+	SendTime      int64 // Unix time at which the message should be posted
+	SignerPubKey  []byte
+	HashCashBits  byte
+	HashCashNonce []byte
+}
+
+// Decrypt decrypts and verifies a message.
+func (c *Client) Decrypt(data []byte, opts *DecryptOptions) (*Message, error) {
+	var nullKey message.Curve25519Key
+	if opts == nil {
+		opts = new(DecryptOptions)
+	}
+	if len(data) < (message.KeyHeaderSize+message.SignHeaderSize)*4 {
+		return nil, ErrShort
+	}
+	receiver := message.Receiver{
+		HashCashBits:    c.Config.MinHashCash,
+		SenderPublicKey: opts.SenderKey,
+	}
+	if opts.PrivateKey != "" {
+		receiver.ReceiveConstantPrivateKey, receiver.ReceiveTemporaryPrivateKey = utils.ParseKeyPair(opts.PrivateKey)
+	} else if opts.KeyCallBack != nil {
+		receiver.KeyCallBack = opts.KeyCallBack
+	} else if c.KeyStore != nil {
+		receiver.KeyCallBack = c.KeyStore.PrivateKey
+	}
+	decMessage, meta, err := receiver.Decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	msg := &Message{
+		MessageID:      MessageID(meta.MessageID),
+		Type:           meta.MessageType,
+		ReceiverPubKey: meta.ReceiveConstantPublicKey,
+		SenderPubKey:   meta.SenderConstantPublicKey,
+	}
+	embedConstant, embedTemporary := utils.DecodeEmbedded(decMessage[:message.Curve25519KeySize*2])
+	if *embedConstant != nullKey {
+		msg.EmbedPublicKey, msg.EmbedTemporaryPublicKey = embedConstant, embedTemporary
+	}
+	decMessage = decMessage[message.Curve25519KeySize*2:]
+	switch meta.MessageType {
+	case message.MsgTypeList:
+		if err := utils.VerifyListContent(decMessage); err != nil {
+			return nil, err
+		}
+		for _, l := range bytes.Split(decMessage, []byte("\n")) {
+			msg.List = append(msg.List, string(l))
+		}
+	case message.MsgTypeRepost:
+		padkey, minDelay, maxDelay := utils.DecodeRepostHeader(decMessage[:utils.RepostHeaderSize])
+		repostMsg := message.RePad(decMessage[utils.RepostHeaderSize:], padkey, c.Config.BodyLength)
+		signHeader := new([message.SignHeaderSize]byte)
+		copy(signHeader[:], repostMsg[:message.SignHeaderSize])
+		details, err := message.VerifySignature(*signHeader, c.Config.MinHashCash)
+		if err != nil {
+			return nil, err
+		}
+		msgID := message.CalcMessageID(repostMsg)
+		if *msgID != details.MsgID {
+			return nil, ErrMessageIDConflict
+		}
+		msg.Repost = &Repost{
+			MessageID:     MessageID(*msgID),
+			MinDelay:      minDelay,
+			MaxDelay:      maxDelay,
+			SendTime:      utils.STM(int(minDelay), int(maxDelay)),
+			SignerPubKey:  details.PublicKey[:],
+			HashCashBits:  details.HashCashBits,
+			HashCashNonce: details.HashCashNonce[:],
+		}
+		decMessage = message.EncodeBase64(repostMsg)
+	}
+	msg.Body = decMessage
+	return msg, nil
+}
@@ -0,0 +1,12 @@
+// Package client implements an importable repbin client.
+//
+// It wraps message encryption, posting, fetching, post-box listing and
+// decryption behind a Client type that returns typed results instead of
+// writing STATUS lines to the console like repclient does.
+//
+//	c := client.New(client.DefaultConfig())
+//	id, keys, err := c.Post(ctx, data, nil)
+//	...
+//	data, _, err := c.Fetch(ctx, id, keys.Server)
+//	msg, err := c.Decrypt(data, &client.DecryptOptions{PrivateKey: utils.B58encode(keys.MessageKey[:])})
+package client
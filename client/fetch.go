@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+// Index is a page of a post-box index.
+type Index struct {
+	Server   string                   // Server the index was fetched from
+	Start    int                      // Start position of the page
+	Messages []*structs.MessageStruct // Index entries
+	More     bool                     // More entries may be available
+}
+
+// Fetch fetches the message with id from server, or from a server selected from the configuration if server is empty.
+// It returns the encrypted message and the server it was fetched from.
+func (c *Client) Fetch(ctx context.Context, id MessageID, server string) ([]byte, string, error) {
+	if server == "" && len(c.Config.PasteServers) == 0 {
+		return nil, "", ErrNoServer
+	}
+	proto := c.proto(server)
+	if server == "" {
+		fetchServer, data, err := proto.GetContext(ctx, id[:])
+		if err != nil {
+			return nil, "", err
+		}
+		return data, fetchServer, nil
+	}
+	data, err := proto.GetSpecificContext(ctx, server, id[:])
+	if err != nil {
+		return nil, "", err
+	}
+	return data, server, nil
+}
+
+// FetchMany fetches the messages with ids from server in batches and calls found for each message
+// returned. Messages the server does not return are skipped, they can be fetched with Fetch.
+func (c *Client) FetchMany(ctx context.Context, server string, ids []MessageID, found func(id MessageID, data []byte) error) error {
+	if server == "" {
+		return ErrNoServer
+	}
+	messageIDs := make([][]byte, 0, len(ids))
+	for i := range ids {
+		messageIDs = append(messageIDs, ids[i][:])
+	}
+	return c.proto(server).GetManyContext(ctx, server, "", messageIDs, func(messageID, data []byte) error {
+		var id MessageID
+		copy(id[:], messageID)
+		return found(id, data)
+	})
+}
+
+// List returns the index of the post-box of privkey on server, beginning at start.
+func (c *Client) List(ctx context.Context, server string, privkey *message.Curve25519Key, start, count int) (*Index, error) {
+	if server == "" {
+		return nil, ErrNoServer
+	}
+	if privkey == nil {
+		return nil, ErrNoKey
+	}
+	pubkey := message.CalcPub(privkey)
+	messages, more, err := c.proto(server).ListSpecificContext(ctx, server, pubkey[:], privkey[:], start, count)
+	if err != nil {
+		return nil, err
+	}
+	return &Index{
+		Server:   server,
+		Start:    start,
+		Messages: messages,
+		More:     more,
+	}, nil
+}
+
+// Watch waits until the post-box of privkey on server contains messages with a counter
+// above since and returns them. Watch returns early when ctx is done.
+func (c *Client) Watch(ctx context.Context, server string, privkey *message.Curve25519Key, since int) (*Index, error) {
+	if server == "" {
+		return nil, ErrNoServer
+	}
+	if privkey == nil {
+		return nil, ErrNoKey
+	}
+	pubkey := message.CalcPub(privkey)
+	proto := c.proto(server)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		messages, more, err := proto.WatchSpecificContext(ctx, server, pubkey[:], privkey[:], since)
+		if err != nil {
+			return nil, err
+		}
+		if len(messages) > 0 {
+			return &Index{
+				Server:   server,
+				Start:    since + 1,
+				Messages: messages,
+				More:     more,
+			}, nil
+		}
+	}
+}
+
+// DeleteOptions select the key a message is deleted with.
+type DeleteOptions struct {
+	PrivateKey *message.Curve25519Key // Private key of the recipient, it is not sent to the server
+	Signer     *message.SignKeyPair   // Signature keypair the message was posted with. Used if set
+}
+
+// Delete deletes the message with id from server and its peers, as recipient or, if
+// opts.Signer is set, as signer of the message.
+func (c *Client) Delete(ctx context.Context, id MessageID, server string, opts *DeleteOptions) error {
+	if server == "" {
+		return ErrNoServer
+	}
+	if opts == nil || (opts.PrivateKey == nil && opts.Signer == nil) {
+		return ErrNoKey
+	}
+	messageID := [message.MessageIDSize]byte(id)
+	if opts.Signer != nil {
+		return c.proto(server).DeleteSignerSpecificContext(ctx, server, &messageID, opts.Signer)
+	}
+	return c.proto(server).DeleteSpecificContext(ctx, server, &messageID, opts.PrivateKey)
+}
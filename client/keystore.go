@@ -0,0 +1,76 @@
+package client
+
+import (
+	"os"
+	"sync"
+
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+)
+
+// KeyStore supplies signer keypairs for posting and private keys for decryption.
+type KeyStore interface {
+	// SignKey returns a signer keypair or nil if none is available. The returned function
+	// must be called after the signer has been used successfully.
+	SignKey() (*message.SignKeyPair, func(), error)
+	// PrivateKey returns the private key for pubkey or nil if it is unknown.
+	PrivateKey(pubkey *message.Curve25519Key) *message.Curve25519Key
+}
+
+// DirKeyStore is a KeyStore that loads signers from a directory (like repclient --signdir)
+// and keeps private keys in memory.
+type DirKeyStore struct {
+	Dir   string // Directory containing signer keypairs, may be empty
+	mutex sync.Mutex
+	keys  map[message.Curve25519Key]message.Curve25519Key
+}
+
+// NewDirKeyStore returns a DirKeyStore for signer directory dir.
+func NewDirKeyStore(dir string) *DirKeyStore {
+	return &DirKeyStore{
+		Dir:  dir,
+		keys: make(map[message.Curve25519Key]message.Curve25519Key),
+	}
+}
+
+// SignKey loads a random signer from the directory. The file is removed by the returned function.
+func (ks *DirKeyStore) SignKey() (*message.SignKeyPair, func(), error) {
+	if ks.Dir == "" {
+		return nil, func() {}, nil
+	}
+	d, removeFile, err := utils.ReadRandomFile(ks.Dir, 2048)
+	if err != nil {
+		return nil, func() {}, err
+	}
+	kp, err := new(message.SignKeyPair).Unmarshal(d)
+	if err != nil {
+		return nil, func() {}, err
+	}
+	return kp, func() { os.Remove(removeFile) }, nil
+}
+
+// AddKey adds private keys to the store. Keys are given as privkey or privkey_tempkey.
+func (ks *DirKeyStore) AddKey(keypair string) {
+	k1, k2 := utils.ParseKeyPair(keypair)
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	if ks.keys == nil {
+		ks.keys = make(map[message.Curve25519Key]message.Curve25519Key)
+	}
+	if k1 != nil {
+		ks.keys[*message.GenPubKey(k1)] = *k1
+	}
+	if k2 != nil {
+		ks.keys[*message.GenPubKey(k2)] = *k2
+	}
+}
+
+// PrivateKey returns the private key for pubkey, or nil.
+func (ks *DirKeyStore) PrivateKey(pubkey *message.Curve25519Key) *message.Curve25519Key {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	if v, ok := ks.keys[*pubkey]; ok {
+		return &v
+	}
+	return nil
+}
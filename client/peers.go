@@ -0,0 +1,35 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// UpdatePeers queries a configured server (or the bootstrap peer) for its peers and replaces
+// Config.PasteServers and Config.MinHashCash with the result.
+func (c *Client) UpdatePeers(ctx context.Context) ([]string, error) {
+	var server string
+	if len(c.Config.PasteServers) > 0 {
+		server = c.Config.PasteServers[rand.New(rand.NewSource(time.Now().UnixNano())).Intn(len(c.Config.PasteServers))]
+	}
+	if server == "" {
+		server = c.Config.BootStrapPeer
+	}
+	if server == "" {
+		return nil, ErrNoPeers
+	}
+	serverInfo, err := c.proto(server).IDContext(ctx, server)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, ErrNoPeers
+	}
+	if len(serverInfo.Peers) == 0 {
+		return nil, ErrNoPeers
+	}
+	c.Config.PasteServers = serverInfo.Peers
+	c.Config.MinHashCash = serverInfo.MinHashCashBits
+	return serverInfo.Peers, nil
+}
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+)
+
+// PostOptions control message encryption. The zero value creates an unsigned blob with a fresh message key.
+type PostOptions struct {
+	MessageType  int                    // Message type. message.MsgTypeBlob if 0
+	PrivateKey   *message.Curve25519Key // Sender private key. Random if nil
+	RecipientKey string                 // Recipient public key(s) as pubkey or pubkey_tempkey. Message key is generated if empty
+	Signer       *message.SignKeyPair   // Signer keypair. Taken from the KeyStore if nil
+	Anonymous    bool                   // Use neither PrivateKey nor Signer
+	EmbedKey     bool                   // Embed a fresh public key for replies
+	Notrace      bool                   // Embedded key does not depend on PrivateKey
+	Hidden       bool                   // Embedded key is hidden
+	Sync         bool                   // Embedded key is sync
+	Repost       bool                   // Create a repost message. Repost messages are never posted
+	MinDelay     uint32                 // Minimum repost delay
+	MaxDelay     uint32                 // Maximum repost delay
+	Server       string                 // Server to post to. Selected from config if empty
+}
+
+// Keys are the keys and address of a message.
+type Keys struct {
+	Server                   string                 // Server the message was posted to
+	MessageID                MessageID              // ID of the message
+	MessageKey               *message.Curve25519Key // Private key to decrypt the message, if generated
+	ReceiverPubKey           *message.Curve25519Key // Constant public key of recipient
+	EmbedPublicKey           *message.Curve25519Key // Embedded constant public key, if any
+	EmbedTemporaryPublicKey  *message.Curve25519Key // Embedded temporary public key, if any
+	EmbedPrivateKey          *message.Curve25519Key // Private key of EmbedPublicKey
+	EmbedTemporaryPrivateKey *message.Curve25519Key // Private key of EmbedTemporaryPublicKey
+}
+
+// Address returns the pastebin address of the message.
+func (keys *Keys) Address() string {
+	var address string
+	if keys.Server != "" {
+		address = keys.Server
+		if address[len(address)-1] != '/' {
+			address += "/"
+		}
+	}
+	address += keys.MessageID.String()
+	if keys.MessageKey != nil {
+		address += "_" + utils.B58encode(keys.MessageKey[:])
+	}
+	return address
+}
+
+// MaxDataSize returns the maximum size of data that can be encrypted into one message.
+func (c *Client) MaxDataSize(repost bool) int {
+	maxInData := c.Config.BodyLength - (message.Curve25519KeySize * 2) - innerHeader
+	if repost {
+		maxInData -= utils.RepostHeaderSize - message.KeyHeaderSize - message.SignHeaderSize
+	}
+	return maxInData
+}
+
+// Encrypt encrypts data into a message. Repost messages are prefixed with the repost header.
+func (c *Client) Encrypt(data []byte, opts *PostOptions) (encMessage []byte, keys *Keys, err error) {
+	var embedConstantPrivKey, embedTemporaryPrivKey *message.Curve25519Key
+	var meta *message.MetaDataSend
+	var done func()
+	if opts == nil {
+		opts = new(PostOptions)
+	}
+	if len(data) > c.MaxDataSize(opts.Repost) {
+		return nil, nil, ErrTooBig
+	}
+	messageType := opts.MessageType
+	if messageType == 0 {
+		messageType = message.MsgTypeBlob
+	}
+	if messageType == message.MsgTypeList {
+		if err := utils.VerifyListContent(data); err != nil {
+			return nil, nil, err
+		}
+	}
+	privkey, signKeyPair := opts.PrivateKey, opts.Signer
+	if opts.Anonymous {
+		privkey, signKeyPair = nil, nil
+	} else if signKeyPair == nil && c.KeyStore != nil {
+		signKeyPair, done, err = c.KeyStore.SignKey()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	keys = new(Keys)
+	if opts.EmbedKey {
+		if opts.Notrace || privkey == nil {
+			embedConstantPrivKey, err = message.GenLongTermKey(opts.Hidden, opts.Sync)
+			if err != nil {
+				return nil, nil, err
+			}
+		} else {
+			embedConstantPrivKey = privkey
+		}
+		embedTemporaryPrivKey, err = message.GenRandomKey()
+		if err != nil {
+			return nil, nil, err
+		}
+		keys.EmbedPrivateKey, keys.EmbedTemporaryPrivateKey = embedConstantPrivKey, embedTemporaryPrivKey
+		keys.EmbedPublicKey, keys.EmbedTemporaryPublicKey = message.GenPubKey(embedConstantPrivKey), message.GenPubKey(embedTemporaryPrivKey)
+	}
+	embedded := utils.EncodeEmbedded(keys.EmbedPublicKey, keys.EmbedTemporaryPublicKey)
+	recipientConstantPubKey, recipientTemporaryPubKey := utils.ParseKeyPair(opts.RecipientKey)
+
+	sender := message.Sender{
+		Signer:                    signKeyPair,
+		SenderPrivateKey:          privkey,
+		ReceiveConstantPublicKey:  recipientConstantPubKey,
+		ReceiveTemporaryPublicKey: recipientTemporaryPubKey,
+		TotalLength:               c.Config.BodyLength,
+		PadToLength:               c.Config.PadToLength,
+		HashCashBits:              c.Config.MinHashCash,
+	}
+	data = append(embedded, data...)
+	if opts.Repost {
+		encMessage, meta, err = sender.EncryptRepost(byte(messageType), data)
+		if err == nil {
+			rph := utils.EncodeRepostHeader(meta.PadKey, opts.MinDelay, opts.MaxDelay)
+			encMessage = append(rph[:], encMessage...)
+		}
+	} else {
+		encMessage, meta, err = sender.Encrypt(byte(messageType), data)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if done != nil {
+		// Signer has been used, remove it from the store
+		done()
+	}
+	keys.MessageID = MessageID(meta.MessageID)
+	keys.MessageKey = meta.MessageKey
+	keys.ReceiverPubKey = meta.ReceiverConstantPubKey
+	return encMessage, keys, nil
+}
+
+// Post encrypts data and posts it to opts.Server or a server selected from the configuration.
+func (c *Client) Post(ctx context.Context, data []byte, opts *PostOptions) (MessageID, *Keys, error) {
+	if opts == nil {
+		opts = new(PostOptions)
+	}
+	if opts.Repost {
+		// Reposts are handed to the next hop, not posted
+		return MessageID{}, nil, ErrRepost
+	}
+	if opts.Server == "" && len(c.Config.PasteServers) == 0 {
+		return MessageID{}, nil, ErrNoServer
+	}
+	encMessage, keys, err := c.Encrypt(data, opts)
+	if err != nil {
+		return MessageID{}, nil, err
+	}
+	keys.Server, err = c.PostRaw(ctx, opts.Server, keys.MessageID, encMessage)
+	if err != nil {
+		return MessageID{}, nil, err
+	}
+	return keys.MessageID, keys, nil
+}
+
+// PostRaw posts an already encrypted message. It returns the server the message was posted to.
+func (c *Client) PostRaw(ctx context.Context, server string, id MessageID, encMessage []byte) (string, error) {
+	proto := c.proto(server)
+	if server == "" {
+		return proto.PostContext(ctx, id[:], encMessage)
+	}
+	if err := proto.PostSpecificContext(ctx, server, encMessage); err != nil {
+		return "", err
+	}
+	return server, nil
+}
@@ -1,24 +1,22 @@
 package client
 
 import (
-	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"os"
 
+	clientlib "github.com/repbin/repbin/client"
 	log "github.com/repbin/repbin/deferconsole"
 	"github.com/repbin/repbin/message"
 	"github.com/repbin/repbin/utils"
-	"github.com/repbin/repbin/utils/repproto"
 )
 
 // CmdDecrypt implements decryption functions
 func CmdDecrypt() int {
-	var inData, decMessage []byte
+	var inData []byte
 	var err error
-	var meta *message.MetaDataRecieve
 	var privkeystr string
-	var nullKey message.Curve25519Key
 	if OptionsVar.Server == "" {
 		getPeers(false)
 	}
@@ -26,6 +24,7 @@ func CmdDecrypt() int {
 		log.Fatalf("stmdir does not exist or is no directory: %s\n", OptionsVar.Stmdir)
 		return 1
 	}
+	c := newClient()
 
 	// Read input data
 	maxInData := int64(GlobalConfigVar.BodyLength+message.KeyHeaderSize+message.SignHeaderSize) * 4
@@ -44,12 +43,12 @@ func CmdDecrypt() int {
 		if server == "" {
 			server = OptionsVar.Server
 		}
-		proto := repproto.New(OptionsVar.Socksserver, OptionsVar.Server, GlobalConfigVar.PasteServers...)
-		if server == "" {
-			server, inData, err = proto.Get(messageidcl)
-		} else {
-			inData, err = proto.GetSpecific(server, messageidcl)
+		messageID, ok := toMessageID(messageidcl)
+		if !ok {
+			log.Fatal("MessageID invalid")
+			return 1
 		}
+		inData, server, err = c.Fetch(context.Background(), messageID, server)
 		if err != nil {
 			log.Fatalf("Fetch error: %s\n", err)
 			return 1
@@ -61,13 +60,10 @@ func CmdDecrypt() int {
 		return 1
 	}
 
-	// Set up receiver parameters
-	receiver := message.Receiver{
-		HashCashBits: GlobalConfigVar.MinHashCash,
-	}
-
+	// Set up decryption options
+	opts := new(clientlib.DecryptOptions)
 	if OptionsVar.Senderkey != "" {
-		receiver.SenderPublicKey, _ = utils.ParseKeyPair(OptionsVar.Senderkey)
+		opts.SenderKey, _ = utils.ParseKeyPair(OptionsVar.Senderkey)
 	}
 
 	// Select private key to use
@@ -75,78 +71,49 @@ func CmdDecrypt() int {
 		if privkeystr == "" { // might have been set from commandline
 			privkeystr = selectPrivKey(OptionsVar.Privkey, GlobalConfigVar.PrivateKey, "tty")
 		}
-		// Parse privkey
-		if privkeystr != "" {
-			receiver.ReceiveConstantPrivateKey, receiver.ReceiveTemporaryPrivateKey = utils.ParseKeyPair(privkeystr)
-		}
+		opts.PrivateKey = privkeystr
 	} else {
 		// Register callback, OptionsVar.keymgt == fd
 		keyMgtFile, callback := KeyCallBack(OptionsVar.Keymgt)
 		defer keyMgtFile.Close()
-		receiver.KeyCallBack = callback
+		opts.KeyCallBack = callback
 	}
 
 	log.Datas("STATUS (Process):\tREAD\n")
 
 	// Decrypt
-	decMessage, meta, err = receiver.Decrypt(inData)
+	msg, err := c.Decrypt(inData, opts)
 	if err != nil {
 		log.Fatalf("%s\n", err)
 		return 1
 	}
-	log.Dataf("STATUS (MessageID):\t%s\n", utils.B58encode(meta.MessageID[:]))
-	log.Dataf("STATUS (RecPubKey):\t%s\n", utils.B58encode(meta.ReceiveConstantPublicKey[:]))
-	log.Dataf("STATUS (SenderPubKey):\t%s\n", utils.B58encode(meta.SenderConstantPublicKey[:]))
+	log.Dataf("STATUS (MessageID):\t%s\n", msg.MessageID)
+	log.Dataf("STATUS (RecPubKey):\t%s\n", utils.B58encode(msg.ReceiverPubKey[:]))
+	log.Dataf("STATUS (SenderPubKey):\t%s\n", utils.B58encode(msg.SenderPubKey[:]))
 
 	// Get replyKeys
-	embedConstant, embedTemporary := utils.DecodeEmbedded(decMessage[:message.Curve25519KeySize*2])
-	if *embedConstant != nullKey {
-		log.Dataf("STATUS (EmbedPublicKey):\t%s_%s\n", utils.B58encode(embedConstant[:]), utils.B58encode(embedTemporary[:]))
+	if msg.EmbedPublicKey != nil {
+		log.Dataf("STATUS (EmbedPublicKey):\t%s_%s\n", utils.B58encode(msg.EmbedPublicKey[:]), utils.B58encode(msg.EmbedTemporaryPublicKey[:]))
 	}
 	// If messageType list: print list to DATA
-	if meta.MessageType == message.MsgTypeList {
+	if msg.Type == message.MsgTypeList {
 		log.Datas("STATUS (Process):\tLIST\n")
-		err := utils.VerifyListContent(decMessage[message.Curve25519KeySize*2:])
-		if err != nil {
-			log.Fatalf("%s\n", err)
-			return 1
-		}
-		lines := bytes.Split(decMessage[message.Curve25519KeySize*2:], []byte("\n"))
-		for _, l := range lines {
-			log.Dataf("STATUS (ListItem):\t%s\n", string(l))
+		for _, l := range msg.List {
+			log.Dataf("STATUS (ListItem):\t%s\n", l)
 		}
 		return 0
 	}
-	decMessage = decMessage[message.Curve25519KeySize*2:]
-	// meta.MessageType
-	if meta.MessageType == message.MsgTypeRepost {
+	if msg.Repost != nil {
 		log.Datas("STATUS (Process):\tREPOST\n")
-		// If messageType repost: get padkey,mindelay,maxdelay. Repad
-		padkey, minDelay, maxDelay := utils.DecodeRepostHeader(decMessage[:utils.RepostHeaderSize])
-		timePoint := utils.STM(int(minDelay), int(maxDelay))
-		log.Dataf("STATUS (STM):\t%d %d %d\n", minDelay, maxDelay, timePoint)
-		repostMsgt := message.RePad(decMessage[utils.RepostHeaderSize:], padkey, GlobalConfigVar.BodyLength)
-		signHeader := new([message.SignHeaderSize]byte)
-		copy(signHeader[:], repostMsgt[:message.SignHeaderSize])
-		details, err := message.VerifySignature(*signHeader, GlobalConfigVar.MinHashCash)
-		if err != nil {
-			log.Fatalf("%s\n", err)
-			return 1
-		}
-		msgIDMsg := message.CalcMessageID(repostMsgt)
-		if *msgIDMsg != details.MsgID {
-			log.Fatals("MessageID conflict\n")
-			return 1
-		}
-		log.Dataf("STATUS (MessageIDSig):\t%s\n", utils.B58encode(details.MsgID[:]))
-		log.Dataf("STATUS (PubKeySig):\t%s\n", utils.B58encode(details.PublicKey[:]))
-		log.Dataf("STATUS (NonceSig):\t%x\n", details.HashCashNonce[:])
-		log.Dataf("STATUS (BitsSig):\t%d\n", details.HashCashBits)
-		decMessage = message.EncodeBase64(repostMsgt)
+		log.Dataf("STATUS (STM):\t%d %d %d\n", msg.Repost.MinDelay, msg.Repost.MaxDelay, msg.Repost.SendTime)
+		log.Dataf("STATUS (MessageIDSig):\t%s\n", msg.Repost.MessageID)
+		log.Dataf("STATUS (PubKeySig):\t%s\n", utils.B58encode(msg.Repost.SignerPubKey))
+		log.Dataf("STATUS (NonceSig):\t%x\n", msg.Repost.HashCashNonce)
+		log.Dataf("STATUS (BitsSig):\t%d\n", msg.Repost.HashCashBits)
 		if OptionsVar.Stmdir != "" { // Exist/Dir test done early
-			filename := fmt.Sprintf("%s%s%d.%s", OptionsVar.Stmdir, string(os.PathSeparator), timePoint, utils.B58encode(msgIDMsg[:]))
+			filename := fmt.Sprintf("%s%s%d.%s", OptionsVar.Stmdir, string(os.PathSeparator), msg.Repost.SendTime, msg.Repost.MessageID)
 			log.Dataf("STATUS (STMFile):\t%s\n", filename)
-			err := utils.WriteNewFile(filename, decMessage)
+			err := utils.WriteNewFile(filename, msg.Body)
 			if err != nil {
 				log.Fatalf("%s\n", err)
 				return 1
@@ -154,7 +121,7 @@ func CmdDecrypt() int {
 			return 0
 		}
 	}
-	err = outputData(OptionsVar.Outfile, decMessage)
+	err = outputData(OptionsVar.Outfile, msg.Body)
 	if err != nil {
 		log.Fatalf("Output failed: %s\n", err)
 		return 1
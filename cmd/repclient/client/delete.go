@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"flag"
+	"strings"
+
+	clientlib "github.com/repbin/repbin/client"
+	log "github.com/repbin/repbin/deferconsole"
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+)
+
+// CmdDelete deletes a message from a server. The private key does not leave the client.
+// If a signature keypair file is given the message is deleted as its signer
+func CmdDelete() int {
+	var privkey message.Curve25519Key
+	args := flag.Args()
+	if len(args) == 0 {
+		log.Fatal("MessageID missing")
+		return 1
+	}
+	server, messageIDT, _ := cmdlineURLparse(args...)
+	if OptionsVar.Server != "" {
+		server = OptionsVar.Server
+	}
+	if server == "" {
+		log.Fatal("Server must be specified: --server")
+		return 1
+	}
+	messageID, ok := toMessageID(messageIDT)
+	if !ok {
+		log.Fatal("MessageID invalid")
+		return 1
+	}
+	opts := new(clientlib.DeleteOptions)
+	if OptionsVar.Signkey != "" {
+		signKeyPair, err := readSignKey(OptionsVar.Signkey)
+		if err != nil {
+			log.Fatalf("Sign keypair read error: %s\n", err)
+			return 1
+		}
+		opts.Signer = signKeyPair
+	} else {
+		privkeystr := selectPrivKey(OptionsVar.Privkey, GlobalConfigVar.PrivateKey, "tty")
+		if privkeystr == "" {
+			log.Fatal("Private key missing: --privkey\n")
+			return 1
+		}
+		// Test if long key is given, if yes, use only first part
+		if pos := strings.Index(privkeystr, "_"); pos > 0 {
+			privkeystr = privkeystr[:pos]
+		}
+		copy(privkey[:], utils.B58decode(privkeystr))
+		opts.PrivateKey = &privkey
+	}
+
+	log.Dataf("STATUS (Process):\tDELETE\n")
+	if err := newClient().Delete(context.Background(), messageID, server, opts); err != nil {
+		log.Dataf("STATUS (Result):\tFAIL\n")
+		log.Fatalf("Delete error: %s\n", err)
+		return 1
+	}
+	log.Dataf("STATUS (Result):\tDONE\n")
+	return 0
+}
@@ -1,22 +1,21 @@
 package client
 
 import (
-	"os"
+	"context"
 
+	clientlib "github.com/repbin/repbin/client"
 	log "github.com/repbin/repbin/deferconsole"
 	"github.com/repbin/repbin/message"
 	"github.com/repbin/repbin/utils"
-	"github.com/repbin/repbin/utils/repproto"
 )
 
 // CmdEncrypt encrypts data.
 func CmdEncrypt() int {
-	var privkey, embedConstantPrivKey, embedTemporaryPrivKey, embedConstantPubKey, embedTemporaryPubKey *message.Curve25519Key
+	var privkey *message.Curve25519Key
 	var err error
-	var inData, encMessage []byte
-	var meta *message.MetaDataSend
+	var inData []byte
 	var signKeyPair *message.SignKeyPair
-	var removeFile string
+	var signerUsed func()
 	if OptionsVar.Server == "" {
 		getPeers(false)
 	}
@@ -26,15 +25,10 @@ func CmdEncrypt() int {
 		OptionsVar.Privkey = ""
 		GlobalConfigVar.PrivateKey = ""
 	}
-	mindelay := uint32(OptionsVar.Mindelay)
-	maxdelay := uint32(OptionsVar.Maxdelay)
+	c := newClient()
 
 	// Read input data
-	maxInData := int64(GlobalConfigVar.BodyLength - (message.Curve25519KeySize * 2) - innerHeader)
-	//maxInData := int64(MsgSizeLimit)
-	if OptionsVar.Repost {
-		maxInData -= utils.RepostHeaderSize - message.KeyHeaderSize - message.SignHeaderSize
-	}
+	maxInData := int64(c.MaxDataSize(OptionsVar.Repost))
 	log.Debugf("Size limit: %d\n", maxInData)
 	inData, err = inputData(OptionsVar.Infile, maxInData)
 	if err != nil {
@@ -42,15 +36,6 @@ func CmdEncrypt() int {
 		return 1
 	}
 
-	// Verify list contents
-	if OptionsVar.MessageType == message.MsgTypeList {
-		err := utils.VerifyListContent(inData)
-		if err != nil {
-			log.Errorf("Could not verify list input: %s\n", err)
-			return 1
-		}
-	}
-
 	// Select private key to use
 	privkeystr := selectPrivKey(OptionsVar.Privkey, GlobalConfigVar.PrivateKey, "")
 	// Parse privkey
@@ -58,27 +43,6 @@ func CmdEncrypt() int {
 		privkey = new(message.Curve25519Key)
 		copy(privkey[:], utils.B58decode(privkeystr))
 	}
-	// Generate embedded keypairs
-	if OptionsVar.Embedkey {
-		if OptionsVar.Notrace || privkey == nil {
-			embedConstantPrivKey, err = message.GenLongTermKey(OptionsVar.Hidden, OptionsVar.Sync)
-			if err != nil {
-				log.Errorf("Key generation error:%s\n", err)
-				return 1
-			}
-		} else {
-			embedConstantPrivKey = privkey
-		}
-		embedConstantPubKey = message.GenPubKey(embedConstantPrivKey)
-		embedTemporaryPrivKey, err = message.GenRandomKey()
-		if err != nil {
-			log.Errorf("Key generation error:%s\n", err)
-			return 1
-		}
-		embedTemporaryPubKey = message.GenPubKey(embedTemporaryPrivKey)
-	}
-	embedded := utils.EncodeEmbedded(embedConstantPubKey, embedTemporaryPubKey)
-	recipientConstantPubKey, recipientTemporaryPubKey := utils.ParseKeyPair(OptionsVar.Recipientkey)
 
 	// Find a signature keypair if we can
 	signKeyDir := ""
@@ -90,72 +54,45 @@ func CmdEncrypt() int {
 	}
 
 	if OptionsVar.Signkey != "" {
-		var d []byte
-		d, err = utils.MaxReadFile(2048, OptionsVar.Signkey)
-		if err == nil {
-			signKeyPair = new(message.SignKeyPair)
-			kp, err := signKeyPair.Unmarshal(d)
-			if err != nil {
-				log.Errorf("Sign keypair decode error: %s\n", err)
-				signKeyPair = nil
-			} else {
-				signKeyPair = kp
-			}
-		} else {
+		signKeyPair, err = readSignKey(OptionsVar.Signkey)
+		if err != nil {
 			log.Errorf("Sign keypair read error: %s\n", err)
+			signKeyPair = nil
 		}
 	}
 	if signKeyPair == nil && signKeyDir != "" {
-		var d []byte
-		d, removeFile, err = utils.ReadRandomFile(signKeyDir, 2048)
-		if err == nil {
-			signKeyPair = new(message.SignKeyPair)
-			kp, err := signKeyPair.Unmarshal(d)
-			if err != nil {
-				log.Errorf("Sign keypair decode error: %s\n", err)
-				signKeyPair = nil
-				removeFile = ""
-			} else {
-				signKeyPair = kp
-			}
-		} else {
+		signKeyPair, signerUsed, err = clientlib.NewDirKeyStore(signKeyDir).SignKey()
+		if err != nil {
 			log.Errorf("Sign keypair read error: %s\n", err)
+			signKeyPair, signerUsed = nil, nil
 		}
 	}
 
-	// Set up sender parameters
-	sender := message.Sender{
-		Signer:                    signKeyPair,
-		SenderPrivateKey:          privkey,
-		ReceiveConstantPublicKey:  recipientConstantPubKey,
-		ReceiveTemporaryPublicKey: recipientTemporaryPubKey,
-		TotalLength:               GlobalConfigVar.BodyLength,
-		PadToLength:               GlobalConfigVar.PadToLength,
-		HashCashBits:              GlobalConfigVar.MinHashCash,
-	}
 	// We want encryption output in realtime
 	log.Sync()
-	inData = append(embedded, inData...)
-	if OptionsVar.Repost {
-		// Generate a repost-message
-		encMessage, meta, err = sender.EncryptRepost(byte(OptionsVar.MessageType), inData)
-		if err == nil {
-			rph := utils.EncodeRepostHeader(meta.PadKey, mindelay, maxdelay)
-			encMessage = append(rph[:], encMessage...)
-			log.Datas("STATUS (Process):\tPREPOST\n")
-			log.Dataf("STATUS (RepostSettings):\t%d %d\n", mindelay, maxdelay)
-		}
-	} else {
-		// Generate a normal message
-		encMessage, meta, err = sender.Encrypt(byte(OptionsVar.MessageType), inData)
-		if err == nil {
-			log.Datas("STATUS (Process):\tPOST\n")
-		}
-	}
+	encMessage, keys, err := c.Encrypt(inData, &clientlib.PostOptions{
+		MessageType:  OptionsVar.MessageType,
+		PrivateKey:   privkey,
+		RecipientKey: OptionsVar.Recipientkey,
+		Signer:       signKeyPair,
+		EmbedKey:     OptionsVar.Embedkey,
+		Notrace:      OptionsVar.Notrace,
+		Hidden:       OptionsVar.Hidden,
+		Sync:         OptionsVar.Sync,
+		Repost:       OptionsVar.Repost,
+		MinDelay:     uint32(OptionsVar.Mindelay),
+		MaxDelay:     uint32(OptionsVar.Maxdelay),
+	})
 	if err != nil {
 		log.Fatalf("Encryption failed: %s\n", err)
 		return 1
 	}
+	if OptionsVar.Repost {
+		log.Datas("STATUS (Process):\tPREPOST\n")
+		log.Dataf("STATUS (RepostSettings):\t%d %d\n", OptionsVar.Mindelay, OptionsVar.Maxdelay)
+	} else {
+		log.Datas("STATUS (Process):\tPOST\n")
+	}
 	log.Sync()
 
 	// Output. repost is only written to stdout or file
@@ -163,68 +100,49 @@ func CmdEncrypt() int {
 		err = utils.WriteStdout(encMessage)
 		// Display data as necessary
 		if err == nil {
-			log.Dataf("STATUS (RecPubKey):\t%s\n", utils.B58encode(meta.ReceiverConstantPubKey[:]))
-			if OptionsVar.Embedkey {
-				log.Dataf("STATUS (EmbedPublicKey):\t%s_%s\n", utils.B58encode(embedConstantPubKey[:]), utils.B58encode(embedTemporaryPubKey[:]))
-				log.Dataf("STATUS (EmbedPrivateKey):\t%s_%s\n", utils.B58encode(embedConstantPrivKey[:]), utils.B58encode(embedTemporaryPrivKey[:]))
-			}
-			if meta.MessageKey != nil {
-				log.Dataf("STATUS (ListInput):\tNULL %s %s\n", utils.B58encode(meta.MessageID[:]), utils.B58encode(meta.MessageKey[:]))
-				log.Dataf("STATUS (Message):\t%s_%s\n", utils.B58encode(meta.MessageID[:]), utils.B58encode(meta.MessageKey[:]))
+			log.Dataf("STATUS (RecPubKey):\t%s\n", utils.B58encode(keys.ReceiverPubKey[:]))
+			printEmbedded(keys)
+			if keys.MessageKey != nil {
+				log.Dataf("STATUS (ListInput):\tNULL %s %s\n", keys.MessageID, utils.B58encode(keys.MessageKey[:]))
+				log.Dataf("STATUS (Message):\t%s\n", keys.Address())
 			} else {
-				log.Dataf("STATUS (ListInput):\tNULL %s NULL\n", utils.B58encode(meta.MessageID[:]))
-				log.Dataf("STATUS (MessageID):\t%s\n", utils.B58encode(meta.MessageID[:]))
+				log.Dataf("STATUS (ListInput):\tNULL %s NULL\n", keys.MessageID)
+				log.Dataf("STATUS (MessageID):\t%s\n", keys.MessageID)
 			}
 		}
 	} else if OptionsVar.Outfile != "" || OptionsVar.Repost {
 		err = utils.WriteNewFile(OptionsVar.Outfile, encMessage)
 		// Display data as necessary
-		log.Dataf("STATUS (RecPubKey):\t%s\n", utils.B58encode(meta.ReceiverConstantPubKey[:]))
+		log.Dataf("STATUS (RecPubKey):\t%s\n", utils.B58encode(keys.ReceiverPubKey[:]))
 		if err == nil {
-			if OptionsVar.Embedkey {
-				log.Dataf("STATUS (EmbedPublicKey):\t%s_%s\n", utils.B58encode(embedConstantPubKey[:]), utils.B58encode(embedTemporaryPubKey[:]))
-				log.Dataf("STATUS (EmbedPrivateKey):\t%s_%s\n", utils.B58encode(embedConstantPrivKey[:]), utils.B58encode(embedTemporaryPrivKey[:]))
-			}
-			if meta.MessageKey != nil {
-				log.Dataf("STATUS (ListInput):\tNULL %s %s\n", utils.B58encode(meta.MessageID[:]), utils.B58encode(meta.MessageKey[:]))
-				log.Dataf("STATUS (Message):\t%s_%s\n", utils.B58encode(meta.MessageID[:]), utils.B58encode(meta.MessageKey[:]))
-				log.Printf("Pastebin Address:\t%s_%s\n", utils.B58encode(meta.MessageID[:]), utils.B58encode(meta.MessageKey[:]))
+			printEmbedded(keys)
+			if keys.MessageKey != nil {
+				log.Dataf("STATUS (ListInput):\tNULL %s %s\n", keys.MessageID, utils.B58encode(keys.MessageKey[:]))
+				log.Dataf("STATUS (Message):\t%s\n", keys.Address())
+				log.Printf("Pastebin Address:\t%s\n", keys.Address())
 			} else {
-				log.Dataf("STATUS (ListInput):\tNULL %s NULL\n", utils.B58encode(meta.MessageID[:]))
-				log.Dataf("STATUS (MessageID):\t%s\n", utils.B58encode(meta.MessageID[:]))
-				log.Printf("Pastebin Address:\t%s\n", utils.B58encode(meta.MessageID[:]))
+				log.Dataf("STATUS (ListInput):\tNULL %s NULL\n", keys.MessageID)
+				log.Dataf("STATUS (MessageID):\t%s\n", keys.MessageID)
+				log.Printf("Pastebin Address:\t%s\n", keys.Address())
 			}
 		}
 	} else {
 		// Post to server
-		server := OptionsVar.Server
-		proto := repproto.New(OptionsVar.Socksserver, OptionsVar.Server, GlobalConfigVar.PasteServers...)
-		if server == "" {
-			server, err = proto.Post(meta.MessageID[:], encMessage)
-		} else {
-			err = proto.PostSpecific(server, encMessage)
-		}
-		sep := "/"
-		if server != "" && server[len(server)-1] == '/' {
-			sep = ""
-		}
+		keys.Server, err = c.PostRaw(context.Background(), OptionsVar.Server, keys.MessageID, encMessage)
 		if err == nil {
-			if meta.MessageKey != nil {
-				log.Dataf("STATUS (URL):\t%s/%s_%s\n", server, utils.B58encode(meta.MessageID[:]), utils.B58encode(meta.MessageKey[:]))
+			server := keys.Server
+			if keys.MessageKey != nil {
+				log.Dataf("STATUS (URL):\t%s/%s_%s\n", server, keys.MessageID, utils.B58encode(keys.MessageKey[:]))
 			}
-			if OptionsVar.Embedkey {
-				log.Dataf("STATUS (EmbedPublicKey):\t%s_%s\n", utils.B58encode(embedConstantPubKey[:]), utils.B58encode(embedTemporaryPubKey[:]))
-				log.Dataf("STATUS (EmbedPrivateKey):\t%s_%s\n", utils.B58encode(embedConstantPrivKey[:]), utils.B58encode(embedTemporaryPrivKey[:]))
-			}
-			if meta.MessageKey != nil {
-				log.Dataf("STATUS (ListInput):\t%s %s %s\n", server, utils.B58encode(meta.MessageID[:]), utils.B58encode(meta.MessageKey[:]))
-				log.Dataf("STATUS (Message):\t%s_%s\n", utils.B58encode(meta.MessageID[:]), utils.B58encode(meta.MessageKey[:]))
-				log.Printf("Pastebin Address:\t%s%s%s_%s\n", server, sep, utils.B58encode(meta.MessageID[:]), utils.B58encode(meta.MessageKey[:]))
+			printEmbedded(keys)
+			if keys.MessageKey != nil {
+				log.Dataf("STATUS (ListInput):\t%s %s %s\n", server, keys.MessageID, utils.B58encode(keys.MessageKey[:]))
+				log.Dataf("STATUS (Message):\t%s_%s\n", keys.MessageID, utils.B58encode(keys.MessageKey[:]))
 			} else {
-				log.Dataf("STATUS (ListInput):\t%s %s NULL\n", server, utils.B58encode(meta.MessageID[:]))
-				log.Dataf("STATUS (MessageID):\t%s\n", utils.B58encode(meta.MessageID[:]))
-				log.Printf("Pastebin Address:\t%s%s%s\n", server, sep, utils.B58encode(meta.MessageID[:]))
+				log.Dataf("STATUS (ListInput):\t%s %s NULL\n", server, keys.MessageID)
+				log.Dataf("STATUS (MessageID):\t%s\n", keys.MessageID)
 			}
+			log.Printf("Pastebin Address:\t%s\n", keys.Address())
 		}
 	}
 	if err != nil {
@@ -232,9 +150,17 @@ func CmdEncrypt() int {
 		log.Sync()
 		return 1
 	}
-	if removeFile != "" {
+	if signerUsed != nil {
 		// Operation has been successful, remove signer keyfile (if any)
-		os.Remove(removeFile)
+		signerUsed()
 	}
 	return 0
 }
+
+// printEmbedded prints the embedded keys, if any
+func printEmbedded(keys *clientlib.Keys) {
+	if keys.EmbedPublicKey != nil {
+		log.Dataf("STATUS (EmbedPublicKey):\t%s_%s\n", utils.B58encode(keys.EmbedPublicKey[:]), utils.B58encode(keys.EmbedTemporaryPublicKey[:]))
+		log.Dataf("STATUS (EmbedPrivateKey):\t%s_%s\n", utils.B58encode(keys.EmbedPrivateKey[:]), utils.B58encode(keys.EmbedTemporaryPrivateKey[:]))
+	}
+}
@@ -1,10 +1,12 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
 
+	clientlib "github.com/repbin/repbin/client"
 	log "github.com/repbin/repbin/deferconsole"
 	"github.com/repbin/repbin/message"
 	"github.com/repbin/repbin/utils"
@@ -16,7 +18,6 @@ import (
 func CmdIndex() int {
 	var server string
 	var err error
-	var pubkey *message.Curve25519Key
 	var privkey message.Curve25519Key
 	var messages []*structs.MessageStruct
 	var moreMessages bool
@@ -44,21 +45,25 @@ func CmdIndex() int {
 	}
 	privT := utils.B58decode(privkeystr)
 	copy(privkey[:], privT)
-	pubkey = message.CalcPub(&privkey)
 
-	proto := repproto.New(OptionsVar.Socksserver, OptionsVar.Server)
-	// If we want to change to using the server list instead. Not a great idea
-	// proto := repproto.New(OptionsVar.Socksserver, OptionsVar.Server, GlobalConfigVar.PasteServers...)
-	// re-enable if we want to use the server list: server, messages, moreMessages, err = proto.List(pubkey[:], privkey[:], OptionsVar.Start, OptionsVar.Count)
+	c := newClient()
+	ctx := context.Background()
 
 	log.Dataf("STATUS (Process):\tLIST\n")
 
-	messages, moreMessages, err = proto.ListSpecific(server, pubkey[:], privkey[:], OptionsVar.Start, OptionsVar.Count)
+	var index *clientlib.Index
+	if OptionsVar.Watch {
+		log.Dataf("STATUS (Process):\tWATCH\n")
+		index, err = c.Watch(ctx, server, &privkey, OptionsVar.Start-1)
+	} else {
+		index, err = c.List(ctx, server, &privkey, OptionsVar.Start, OptionsVar.Count)
+	}
 
 	if err != nil {
 		log.Fatalf("List error: %s\n", err)
 		return 1
 	}
+	messages, moreMessages = index.Messages, index.More
 	if len(messages) > 0 {
 		fmt.Print("Index\t\tMessageID\n")
 		fmt.Print("------------------------------------------------------------\n")
@@ -80,7 +85,29 @@ func CmdIndex() int {
 		log.Dataf("STATUS (Process):\tFETCHMANY\n")
 		fmt.Print("Fetching messages...")
 		hasErrors := 0
+		// Batch download. Messages that were not returned are fetched one by one
+		fetched := make(map[string]bool)
+		messageIDs := make([]clientlib.MessageID, 0, len(messages))
 		for _, msg := range messages {
+			messageIDs = append(messageIDs, clientlib.MessageID(msg.MessageID))
+		}
+		err = c.FetchMany(ctx, server, messageIDs, func(messageID clientlib.MessageID, data []byte) error {
+			messageIDenc := messageID.String()
+			if err := outputData(OptionsVar.Outdir+string(os.PathSeparator)+messageIDenc, data); err != nil {
+				return err
+			}
+			fetched[messageIDenc] = true
+			fmt.Print(".o")
+			log.Dataf("STATUS (FetchComplete):\t%s\n", messageIDenc)
+			return nil
+		})
+		if err != nil && err != repproto.ErrBadProto {
+			log.Errorf("Fetch error: %s\n", err)
+		}
+		for _, msg := range messages {
+			if fetched[utils.B58encode(msg.MessageID[:])] {
+				continue
+			}
 			log.Dataf("STATUS (Fetch):\t%s\n", utils.B58encode(msg.MessageID[:]))
 			err = loadStoreMessage(server, msg.MessageID[:], OptionsVar.Outdir+string(os.PathSeparator)+utils.B58encode(msg.MessageID[:])) // use index server for download, store with name messageID
 			if err != nil {
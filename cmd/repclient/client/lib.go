@@ -0,0 +1,39 @@
+package client
+
+import (
+	clientlib "github.com/repbin/repbin/client"
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+)
+
+// newClient returns a client of the client library for the options and configuration
+func newClient() *clientlib.Client {
+	return clientlib.New(clientlib.Config{
+		BodyLength:    GlobalConfigVar.BodyLength,
+		PadToLength:   GlobalConfigVar.PadToLength,
+		MinHashCash:   GlobalConfigVar.MinHashCash,
+		SocksServer:   OptionsVar.Socksserver,
+		BootStrapPeer: GlobalConfigVar.BootStrapPeer,
+		PasteServers:  GlobalConfigVar.PasteServers,
+		Concurrency:   GlobalConfigVar.Concurrency,
+	})
+}
+
+// toMessageID converts a decoded message ID. Returns false if it has the wrong size
+func toMessageID(d []byte) (clientlib.MessageID, bool) {
+	var id clientlib.MessageID
+	if len(d) != message.MessageIDSize {
+		return id, false
+	}
+	copy(id[:], d)
+	return id, true
+}
+
+// readSignKey reads a signature keypair from file
+func readSignKey(filename string) (*message.SignKeyPair, error) {
+	d, err := utils.MaxReadFile(2048, filename)
+	if err != nil {
+		return nil, err
+	}
+	return new(message.SignKeyPair).Unmarshal(d)
+}
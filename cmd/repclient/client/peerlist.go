@@ -1,11 +1,10 @@
 package client
 
 import (
-	"math/rand"
+	"context"
 	"time"
 
 	log "github.com/repbin/repbin/deferconsole"
-	"github.com/repbin/repbin/utils/repproto"
 )
 
 // CmdPeerList fetches the peerlist and returns a new configfile
@@ -28,31 +27,20 @@ func getPeers(force bool) error {
 		log.Debugs("Updating peers.\n")
 		defer log.Debugs("Peer update done.\n")
 		GlobalConfigVar.PeerUpdate = time.Now().Unix()
-		server := OptionsVar.Server
-		if server == "" && GlobalConfigVar.PasteServers != nil && len(GlobalConfigVar.PasteServers) > 0 { // no server defined, we select one
-			servercount := len(GlobalConfigVar.PasteServers)
-			rand.Seed(time.Now().UnixNano())
-			pos := rand.Int() % servercount
-			server = GlobalConfigVar.PasteServers[pos]
+		c := newClient()
+		if OptionsVar.Server != "" {
+			// Ask the given server
+			c.Config.PasteServers = []string{OptionsVar.Server}
 		}
-		if server == "" {
-			server = GlobalConfigVar.BootStrapPeer
-		}
-		if server == "" {
-			return ErrNoPeers
-		}
-		proto := repproto.New(OptionsVar.Socksserver, OptionsVar.Server, GlobalConfigVar.PasteServers...)
-		serverInfo, err := proto.ID(server)
+		peers, err := c.UpdatePeers(context.Background())
 		if err != nil {
 			return ErrNoPeers
 		}
-		if serverInfo.Peers != nil && len(serverInfo.Peers) > 0 {
-			GlobalConfigVar.PasteServers = serverInfo.Peers
-			GlobalConfigVar.MinHashCash = serverInfo.MinHashCashBits
-			err := WriteConfigFile(GlobalConfigVar)
-			if err != nil {
-				log.Errorf("Error writing config-file: %s\n", err)
-			}
+		GlobalConfigVar.PasteServers = peers
+		GlobalConfigVar.MinHashCash = c.Config.MinHashCash
+		err = WriteConfigFile(GlobalConfigVar)
+		if err != nil {
+			log.Errorf("Error writing config-file: %s\n", err)
 		}
 	}
 	return nil
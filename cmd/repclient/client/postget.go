@@ -1,8 +1,10 @@
 package client
 
 import (
+	"context"
 	"flag"
 
+	clientlib "github.com/repbin/repbin/client"
 	log "github.com/repbin/repbin/deferconsole"
 	"github.com/repbin/repbin/message"
 	"github.com/repbin/repbin/utils/repproto"
@@ -12,7 +14,7 @@ import (
 func CmdPost() int {
 	var inData []byte
 	var err error
-	if OptionsVar.Server == "" {
+	if OptionsVar.Server == "" && OptionsVar.Replicas < 2 {
 		log.Fatal("Server must be specified: --server")
 		return 1
 	}
@@ -24,11 +26,14 @@ func CmdPost() int {
 		return 1
 	}
 	log.Datas("STATUS (Process):\tPOST\n")
-	proto := repproto.New(OptionsVar.Socksserver, OptionsVar.Server)
-	err = proto.PostSpecific(OptionsVar.Server, inData)
+	err = postMessage(inData)
 	if err != nil {
 		log.Fatalf("Output failed: %s\n", err)
 		log.Datas("STATUS (Result):\tFAIL\n")
+		if err == repproto.ErrQuorum {
+			log.Sync()
+			return 1
+		}
 	} else {
 		log.Datas("STATUS (Result):\tDONE\n")
 	}
@@ -37,6 +42,38 @@ func CmdPost() int {
 	return 0
 }
 
+// postMessage posts inData to OptionsVar.Server. If more than one replica is
+// requested the message is posted to OptionsVar.Server (if given) and as many
+// servers from the configured PasteServers as needed.
+func postMessage(inData []byte) error {
+	if OptionsVar.Replicas < 2 {
+		// The message ID is only used to select a server, OptionsVar.Server is always set here
+		_, err := newClient().PostRaw(context.Background(), OptionsVar.Server, clientlib.MessageID{}, inData)
+		return err
+	}
+	servers := make([]string, 0, len(GlobalConfigVar.PasteServers)+1)
+	known := make(map[string]bool)
+	for _, server := range append([]string{OptionsVar.Server}, GlobalConfigVar.PasteServers...) {
+		if server != "" && !known[server] {
+			known[server] = true
+			servers = append(servers, server)
+		}
+	}
+	if len(servers) < OptionsVar.Replicas {
+		log.Errorf("Only %d servers known for %d replicas\n", len(servers), OptionsVar.Replicas)
+	}
+	proto := repproto.New(OptionsVar.Socksserver, "", servers...)
+	results, err := proto.PostReplicated(context.Background(), OptionsVar.Server, nil, inData, OptionsVar.Replicas, OptionsVar.Quorum)
+	for _, res := range results {
+		if res.Err != nil {
+			log.Dataf("STATUS (PostRes):\t%s\tFAIL\t%s\n", res.Server, res.Err)
+		} else {
+			log.Dataf("STATUS (PostRes):\t%s\tDONE\n", res.Server)
+		}
+	}
+	return err
+}
+
 // CmdGet gets a file
 func CmdGet() int {
 	var err error
@@ -69,10 +106,14 @@ func CmdGet() int {
 	return 0
 }
 
-func loadStoreMessage(server string, messageID []byte, outfile string) error {
-	proto := repproto.New(OptionsVar.Socksserver, server)
+func loadStoreMessage(server string, messageIDT []byte, outfile string) error {
+	messageID, ok := toMessageID(messageIDT)
+	if !ok {
+		log.Fatal("MessageID invalid")
+		return ErrBadMessageID
+	}
 	log.Dataf("STATUS (Process):\tFETCH\n")
-	inData, err := proto.GetSpecific(server, messageID)
+	inData, _, err := newClient().Fetch(context.Background(), messageID, server)
 	if err != nil {
 		log.Dataf("STATUS (Process):\tFAIL\n")
 		log.Fatalf("Fetch error: %s\n", err)
@@ -15,7 +15,7 @@ import (
 
 // CmdSTM does an STM run to specific server and from specific stmdir
 func CmdSTM() int {
-	if OptionsVar.Server == "" {
+	if OptionsVar.Server == "" && OptionsVar.Replicas < 2 {
 		log.Fatal("Server must be specified: --server\n")
 		return 1
 	}
@@ -73,11 +73,10 @@ func sendSTM(files []string) error {
 		}
 		remove := false
 		log.Dataf("STATUS (STMTrans):\t%s\n", file)
-		proto := repproto.New(OptionsVar.Socksserver, OptionsVar.Server)
-		err = proto.PostSpecific(OptionsVar.Server, inData)
+		err = postMessage(inData)
 		if err != nil {
 			log.Dataf("STATUS (STMRes):\t%s\tFAIL\t%s\n", file, err)
-			if err.Error() == "Server error: db: Duplicate" {
+			if repproto.IsDuplicate(err) {
 				remove = true
 			} else if err.Error() == "Server error: Message too small" {
 				remove = true
@@ -9,13 +9,14 @@ const Version = "0.0.2 very alpha"
 
 // PeerUPdateDuration is the maximum time to wait until peer updates are forced
 const PeerUpdateDuration = 259200
-const innerHeader = 332 // inner header size
 
 var (
 	// ErrNoPeers is returned if the client could not find new peers
 	ErrNoPeers = errors.New("client: No peers")
 	// ErrNoConfig is returned when no config file could be named
 	ErrNoConfig = errors.New("client: No config file")
+	// ErrBadMessageID is returned if a message ID has the wrong size
+	ErrBadMessageID = errors.New("client: Bad message ID")
 )
 
 // Options are options used in the client
@@ -50,11 +51,14 @@ type Options struct {
 
 	Keymgt int // key management file descriptor
 
-	Start int // start position for index fetch
-	Count int // maximum number of index entries to fetch
+	Start int  // start position for index fetch
+	Count int  // maximum number of index entries to fetch
+	Watch bool // wait for new index entries
 
 	Socksserver string // socks server to use
 	Server      string // server to use
+	Replicas    int    // number of servers to post to
+	Quorum      int    // number of servers that must accept a post
 
 	MessageType int // message type of message
 }
@@ -70,6 +74,7 @@ type ConfigVariables struct {
 	PeerUpdate    int64    // when did we update the peers last?
 	BootStrapPeer string   // What peer to bootstrap from
 	PasteServers  []string // urls of pastebins
+	Concurrency   int      // number of servers to query concurrently. One by one if < 2
 }
 
 // OptionsVar .
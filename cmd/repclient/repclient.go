@@ -28,6 +28,7 @@ const (
 	cmdDecrypt
 	cmdPost
 	cmdGet
+	cmdDelete
 	cmdRewrap
 	cmdIndex
 	cmdShowConfig
@@ -79,6 +80,9 @@ func init() {
 	flag.BoolVar(commands[cmdGet], "get", false, "Get message")
 	callFunc[cmdGet] = client.CmdGet
 
+	flag.BoolVar(commands[cmdDelete], "delete", false, "Delete message")
+	callFunc[cmdDelete] = client.CmdDelete
+
 	flag.BoolVar(commands[cmdSTM], "stm", false, "STM post run")
 	callFunc[cmdSTM] = client.CmdSTM
 
@@ -103,6 +107,7 @@ func init() {
 	flag.IntVar(&options.Start, "start", 0, "index start position")
 	flag.IntVar(&options.Count, "count", 10, "index count")
 	flag.StringVar(&options.Outdir, "outdir", "", "Index batch download directory")
+	flag.BoolVar(&options.Watch, "watch", false, "Wait for new index entries from start")
 
 	flag.BoolVar(&options.Verbose, "verbose", false, "be verbose")
 	flag.BoolVar(&options.KEYVERB, "KEYVERB", false, "show secrets during calculation")
@@ -135,6 +140,8 @@ func init() {
 
 	flag.StringVar(&options.Socksserver, "socksserver", "socks5://127.0.0.1:9050", "Socks server URL")
 	flag.StringVar(&options.Server, "server", "", "Repbin server")
+	flag.IntVar(&options.Replicas, "replicas", 1, "Number of servers to post to")
+	flag.IntVar(&options.Quorum, "quorum", 0, "Number of servers that must accept a post (default: all replicas, at most the servers known)")
 
 	flag.StringVar(&options.Signkey, "signkey", "", "Post signature file")
 	flag.StringVar(&options.Signdir, "signdir", "", "Post signature directory")
@@ -293,6 +300,9 @@ Temporary key generation:
 Post/Get message:
   -get             Get message. MessageID on commandline
   -post            Post message. Read from stdin.
+  -replicas <NUMBER>  Post to NUMBER servers from the config (always -server first)
+  -quorum <NUMBER>    Fail unless NUMBER servers accepted the post.
+                      Default: all replicas, at most the servers known
 
 Post-Box support:
   -index           List Post-Box index
@@ -301,6 +311,10 @@ Post-Box support:
   -start <NUMBER>  Start at index NUMBER
   -count <NUMBER>  Return at most NUMBER posts
   -outdir <DIR>    Download messages to DIR
+  -watch           Wait until messages at or after -start arrive
+  -delete          Delete message. MessageID on commandline
+                   Uses -privkey of the recipient, or
+  -signkey <FILE>  to delete as signer of the message
 `)
 	return 0
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/repbin/repbin/cmd/repserver/handlers"
+)
+
+// exportStore writes the archive of the store to file. The file must not exist.
+func exportStore(ms *handlers.MessageServer, file string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	report, err := ms.DB.Export(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file)
+		return err
+	}
+	fmt.Printf("Export: %s\n", report)
+	return nil
+}
+
+// importStore reads the archive in file into the empty store.
+func importStore(ms *handlers.MessageServer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	report, err := ms.DB.Import(f, ms.MinHashCashBits)
+	if report != nil {
+		fmt.Printf("Import: %s\n", report)
+	}
+	return err
+}
@@ -9,7 +9,9 @@ import (
 	"github.com/agl/ed25519"
 	"github.com/repbin/repbin/cmd/repserver/handlers"
 	"github.com/repbin/repbin/cmd/repserver/messagestore"
+	log "github.com/repbin/repbin/deferconsole"
 	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/repproto/structs"
 )
 
 // ServerConfig are configurable parameters
@@ -20,7 +22,11 @@ type ServerConfig struct {
 	MinHashCashBits      byte   // Minimum hashcash bits required
 	NotifyDuration       int64  // Time between notifications
 	FetchDuration        int64  // Time between fetches
+	FetchWorkers         int    // Number of messages downloaded and verified concurrently per peer
 	ExpireDuration       int64  // Time between expire runs
+	ExpireBatchSize      int    // Number of expired messages deleted per transaction
+	ExpireBudget         int64  // Maximum time an expire run spends deleting messages, 0 for no limit
+	MaxBlobMismatches    int    // Orphaned blobs and messages without blob accepted on start, negative for no limit
 	SocksProxy           string // Socks5 proxy
 	EnableDeleteHandler  bool   // should the delete handler be enabled?
 	EnableOneTimeHandler bool   // should the one-time message handler be enabled?
@@ -33,10 +39,24 @@ type ServerConfig struct {
 	MaxStoreTime         int    // Maximum time to store a message
 	PeeringPublicKey     string // public key for peering authentication
 	PeeringPrivateKey    string // private key for peering authentication
-	DBDriver             string // Database driver of the storage backend (mysql, sqlite3)
+	DBDriver             string // Database driver of the storage backend (mysql, sqlite3, postgres)
 	DBURL                string // database access URL, user:password@server/database
+	BlobStorage          string // Storage backend for messages (fs, db, pack)
+	AutoMigrate          bool   // apply database schema migrations on start
 	MaxAgeSigners        int64
 	MaxAgeRecipients     int64
+	WatchTimeout         int64  // Maximum time a key index watch waits for messages
+	AdminListen          string // host:port of the admin listener for /metrics, disabled if empty
+	Reconcile            bool   // reconcile message sets with peers before walking their global index
+	MaxDistance          uint64 // maximum number of hops of messages fetched from peers, 0 for no limit
+	ReadThrough          bool   // ask peers for messages that are not stored locally
+	ReadThroughWorkers   int    // maximum number of concurrent read-through lookups
+	ReadThroughHops      int    // number of servers a read-through lookup may pass
+	ReadThroughCache     int64  // time a failed read-through lookup is cached
+	PeerQuarantineAfter  int    // consecutive failures after which a peer is quarantined, 0 to never quarantine
+	PeerBackoffMax       int64  // maximum time between attempts to reach a failing peer
+	PeerTrust            string // trust policy for discovered peers: off, manual, tofu or wot
+	PeerTrustThreshold   int    // number of peers that must announce a discovered peer for wot
 }
 
 var defaultSettings = &ServerConfig{
@@ -45,7 +65,11 @@ var defaultSettings = &ServerConfig{
 	MinHashCashBits:      handlers.MinHashCashBits,
 	NotifyDuration:       handlers.DefaultNotifyDuration,
 	FetchDuration:        handlers.DefaultFetchDuration,
+	FetchWorkers:         handlers.DefaultFetchWorkers,
 	ExpireDuration:       handlers.DefaultExpireDuration,
+	ExpireBatchSize:      handlers.DefaultExpireBatchSize,
+	ExpireBudget:         handlers.DefaultExpireBudget,
+	MaxBlobMismatches:    handlers.DefaultMaxBlobMismatches,
 	StepLimit:            handlers.DefaultStepLimit,
 	ListenPort:           handlers.DefaultListenPort,
 	EnableDeleteHandler:  false,
@@ -60,8 +84,22 @@ var defaultSettings = &ServerConfig{
 	PeeringPrivateKey:    "",
 	DBDriver:             "mysql",
 	DBURL:                "repbin:repbin@/repbin",
+	BlobStorage:          messagestore.BlobFS,
+	AutoMigrate:          false,
 	MaxAgeSigners:        handlers.DefaultMaxAgeSigners,
 	MaxAgeRecipients:     handlers.DefaultMaxAgeRecipients,
+	WatchTimeout:         handlers.DefaultWatchTimeout,
+	AdminListen:          "",
+	Reconcile:            true,
+	MaxDistance:          0,
+	ReadThrough:          false,
+	ReadThroughWorkers:   handlers.DefaultReadThroughWorkers,
+	ReadThroughHops:      handlers.DefaultReadThroughHops,
+	ReadThroughCache:     handlers.DefaultReadThroughCache,
+	PeerQuarantineAfter:  handlers.DefaultPeerQuarantineAfter,
+	PeerBackoffMax:       handlers.DefaultPeerBackoffMax,
+	PeerTrust:            handlers.DefaultPeerTrust,
+	PeerTrustThreshold:   handlers.DefaultPeerTrustThreshold,
 }
 
 // showConfig shows current (default) config
@@ -89,14 +127,18 @@ func loadConfig(filename string) error {
 	return nil
 }
 
-func applyConfig(ms *handlers.MessageServer) {
+func applyConfig(ms *handlers.MessageServer) error {
 	ms.AddToPeer = defaultSettings.AddToPeer
 	ms.URL = defaultSettings.URL
 	ms.MaxTimeSkew = defaultSettings.MaxTimeSkew
 	ms.MinHashCashBits = defaultSettings.MinHashCashBits
 	ms.NotifyDuration = defaultSettings.NotifyDuration
 	ms.FetchDuration = defaultSettings.FetchDuration
+	ms.FetchWorkers = defaultSettings.FetchWorkers
 	ms.ExpireDuration = defaultSettings.ExpireDuration
+	ms.ExpireBatchSize = defaultSettings.ExpireBatchSize
+	ms.ExpireBudget = defaultSettings.ExpireBudget
+	ms.MaxBlobMismatches = defaultSettings.MaxBlobMismatches
 	ms.StepLimit = defaultSettings.StepLimit
 	ms.ListenPort = defaultSettings.ListenPort
 	ms.MinStoreTime = defaultSettings.MinStoreTime
@@ -108,6 +150,37 @@ func applyConfig(ms *handlers.MessageServer) {
 	ms.SocksProxy = defaultSettings.SocksProxy
 	ms.MaxAgeSigners = defaultSettings.MaxAgeSigners
 	ms.MaxAgeRecipients = defaultSettings.MaxAgeRecipients
+	ms.WatchTimeout = defaultSettings.WatchTimeout
+	ms.AdminListen = defaultSettings.AdminListen
+	ms.Reconcile = defaultSettings.Reconcile
+	ms.MaxDistance = defaultSettings.MaxDistance
+	ms.ReadThrough = defaultSettings.ReadThrough
+	ms.ReadThroughWorkers = defaultSettings.ReadThroughWorkers
+	ms.ReadThroughHops = defaultSettings.ReadThroughHops
+	ms.ReadThroughCache = defaultSettings.ReadThroughCache
+	ms.PeerQuarantineAfter = defaultSettings.PeerQuarantineAfter
+	ms.PeerBackoffMax = defaultSettings.PeerBackoffMax
+	ms.PeerTrust = defaultSettings.PeerTrust
+	ms.PeerTrustThreshold = defaultSettings.PeerTrustThreshold
+	if ms.ExpireBatchSize < 1 {
+		return fmt.Errorf("ExpireBatchSize must be at least 1")
+	}
+	if ms.WatchTimeout > handlers.MaxWatchTimeout {
+		log.Errorf("WatchTimeout %d above %d, reduced to %d\n", ms.WatchTimeout, handlers.MaxWatchTimeout, handlers.MaxWatchTimeout)
+		ms.WatchTimeout = handlers.MaxWatchTimeout
+	}
+	switch ms.PeerTrust {
+	case structs.TrustOff, structs.TrustManual, structs.TrustTOFU, structs.TrustWoT:
+	default:
+		return fmt.Errorf("unknown PeerTrust %q", ms.PeerTrust)
+	}
 	messagestore.MaxAgeSigners = defaultSettings.MaxAgeSigners
 	messagestore.MaxAgeRecipients = defaultSettings.MaxAgeSigners
+	messagestore.ExpireBatchSize = ms.ExpireBatchSize
+	messagestore.ExpireBudget = ms.ExpireBudget
+	messagestore.MaxBlobMismatches = ms.MaxBlobMismatches
+	if defaultSettings.BlobStorage != "" {
+		return ms.DB.SelectBlobStore(defaultSettings.BlobStorage)
+	}
+	return nil
 }
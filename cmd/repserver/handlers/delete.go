@@ -3,51 +3,94 @@ package handlers
 import (
 	"io"
 	"net/http"
+	"net/url"
 
 	log "github.com/repbin/repbin/deferconsole"
 	"github.com/repbin/repbin/message"
 	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/keyauth"
+	"github.com/repbin/repbin/utils/repproto/structs"
 )
 
-// Delete implements the delete call for messages.
+// Delete implements the delete call for messages. The recipient proves the knowledge of the
+// private key by an authentication bound to the delete operation and the message ID. If a
+// tombstone is given it is replicated to the peers, otherwise the message is only expired locally.
+// The signer of a message deletes it with a signer tombstone alone, no authentication is required.
 func (ms MessageServer) Delete(w http.ResponseWriter, r *http.Request) {
-	var messageID *[message.MessageIDSize]byte
-	var privateKey *message.Curve25519Key
 	for i := 0; i < 4; i++ {
 		ms.RandomSleep() // Let's not give instant gratification here
 	}
 	w.Header().Set("Content-Type", "text/plain; charset=us-ascii")
-	getValues := r.URL.Query()
-	if getValues != nil {
-		if v, ok := getValues["messageid"]; ok {
-			t := utils.B58decode(v[0])
-			if len(t) < message.MessageIDSize || len(t) > message.MessageIDSize {
-				io.WriteString(w, "ERROR: Bad parameter\n")
-				return
-			}
-			messageID = new([message.MessageIDSize]byte)
-			copy(messageID[:], t)
-		}
-		if v, ok := getValues["privkey"]; ok {
-			t := utils.B58decode(v[0])
-			if len(t) < message.Curve25519KeySize || len(t) > message.Curve25519KeySize {
-				io.WriteString(w, "ERROR: Bad parameter\n")
-				return
-			}
-			privateKey = new(message.Curve25519Key)
-			copy(privateKey[:], t)
+	if err := ms.delete(r.URL.Query()); err != nil {
+		io.WriteString(w, "ERROR: "+err.Error()+"\n")
+		return
+	}
+	io.WriteString(w, "SUCCESS: If you want to call it that\n")
+}
+
+// delete verifies the authentication of the recipient, or the signer tombstone, and deletes the message.
+func (ms MessageServer) delete(getValues url.Values) error {
+	var messageID [message.MessageIDSize]byte
+	var ts *structs.TombstoneStruct
+	if getValues == nil {
+		return errMissingParam
+	}
+	v, ok := getValues["messageid"]
+	if !ok {
+		return errMissingParam
+	}
+	t := utils.B58decode(v[0])
+	if len(t) != message.MessageIDSize {
+		return errBadParam
+	}
+	copy(messageID[:], t)
+	if v, ok := getValues["tombstone"]; ok {
+		if len(v[0]) > structs.TombstoneSize*2 {
+			return errBadParam
 		}
-		if privateKey != nil && messageID != nil {
-			publicKey := message.GenPubKey(privateKey)
-			err := ms.DB.PreExpire(messageID, publicKey)
-			if err == nil {
-				log.Errorf("Message censored: %s, asshole.\n", utils.B58encode(messageID[:]))
-				io.WriteString(w, "SUCCESS: If you want to call it that\n")
-				return
-			}
-			log.Errorf("Message censoring failed! %s\n", err)
+		ts = structs.TombstoneDecode(utils.B58decode(v[0]))
+		if ts == nil || ts.MessageID != messageID {
+			return errBadParam
 		}
 	}
-	io.WriteString(w, "ERROR: Missing parameters\n")
-	return
+	if ts != nil && ts.AuthType == structs.TombstoneSigner {
+		// AddTombstone verifies the signature and that it is the signer of the message
+		return ms.addTombstone(ts)
+	}
+	w, ok := getValues["auth"]
+	if !ok {
+		return errMissingParam
+	}
+	if len(w[0]) > keyauth.AnswerSize*10 {
+		return errBadParam
+	}
+	auth := utils.B58decode(w[0])
+	pubKey, err := ms.DB.Recipient(&messageID)
+	if err != nil {
+		return storeError(err)
+	}
+	if err := ms.verifyKeyAuth(auth, pubKey, structs.OpDelete, messageID[:]); err != nil {
+		log.Debugf("Delete: %s %s\n", err, utils.B58encode(messageID[:]))
+		return err
+	}
+	if ts != nil {
+		return ms.addTombstone(ts)
+	}
+	if err := ms.DB.PreExpire(&messageID, pubKey); err != nil {
+		log.Errorf("Message censoring failed! %s\n", err)
+		return storeError(err)
+	}
+	log.Errorf("Message censored: %s, asshole.\n", utils.B58encode(messageID[:]))
+	return nil
+}
+
+// addTombstone deletes the message of a tombstone and notifies the peers.
+func (ms MessageServer) addTombstone(ts *structs.TombstoneStruct) error {
+	if err := ms.DB.AddTombstone(ts, true); err != nil {
+		log.Errorf("Message censoring failed! %s\n", err)
+		return storeError(err)
+	}
+	log.Errorf("Message censored: %s, asshole.\n", utils.B58encode(ts.MessageID[:]))
+	ms.notifyChan <- true
+	return nil
 }
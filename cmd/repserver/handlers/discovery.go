@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/repbin/repbin/cmd/repserver/stat"
+	log "github.com/repbin/repbin/deferconsole"
+	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/repproto"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+const (
+	// maxPeerRecords is the maximum number of records used from the answer of a peer.
+	maxPeerRecords = 100
+	// maxDiscoveredPeers is the maximum number of discovered peers remembered.
+	maxDiscoveredPeers = 1000
+)
+
+// DiscoveredPeer is a peer learned from the peer records of other peers.
+type DiscoveredPeer struct {
+	Record    structs.PeerRecord // Latest record signed by the peer
+	Vouchers  []string           // Public keys of the active peers that announced the record
+	FirstSeen int64              // Time the peer was discovered
+	Approved  bool               // The peer is active. Set by the operator or the trust policy
+	Rejected  bool               // The peer is never activated. Set by the operator
+	IsHub     bool               // The peer is used as hub. Set by the operator, Record.IsHub is only shown
+}
+
+var discoveryMutex = new(sync.Mutex)  // access to discoveredPeers and the discovered file
+var discoveredPeers []*DiscoveredPeer // Current discovered peers
+var discoveryRunning int32            // 1 while a discovery run is active
+var lastDiscovery int64               // Time of the last discovery run
+
+// ownPeerRecord returns the signed peer record of the server, or nil if it has no URL.
+func (ms MessageServer) ownPeerRecord() *structs.PeerRecord {
+	if ms.URL == "" {
+		return nil
+	}
+	return structs.NewPeerRecord(ms.URL, ms.HubOnly, ms.PeerTrust, CurrentTime()+ms.TimeSkew, ms.TokenPubKey, ms.TokenPrivKey)
+}
+
+// loadDiscovered reads the discovered peers from file. A missing file is an empty list.
+func loadDiscovered(filename string) ([]*DiscoveredPeer, error) {
+	var peers []*DiscoveredPeer
+	d, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(d, &peers); err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+// saveDiscovered writes the discovered peers to file.
+func saveDiscovered(filename string, peers []*DiscoveredPeer) error {
+	d, err := json.MarshalIndent(peers, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, d, 0600)
+}
+
+// loadDiscoveredPeers reloads the discovered peers to pick up changes by the operator and
+// returns the approved ones.
+func (ms MessageServer) loadDiscoveredPeers() []*DiscoveredPeer {
+	var approved []*DiscoveredPeer
+	discoveryMutex.Lock()
+	defer discoveryMutex.Unlock()
+	peers, err := loadDiscovered(ms.DiscoveredFile)
+	if err != nil {
+		log.Errorf("Could not read discovered peers: %s\n", err)
+		return nil
+	}
+	discoveredPeers = peers
+	for _, p := range peers {
+		if p.Approved && !p.Rejected {
+			approved = append(approved, p)
+		}
+	}
+	return approved
+}
+
+// mergeRecord adds a record announced by voucher to the discovered peers. The record of an
+// entry is replaced by newer ones, the vouchers are collected.
+func mergeRecord(peers []*DiscoveredPeer, record *structs.PeerRecord, voucher string, now int64) ([]*DiscoveredPeer, *DiscoveredPeer) {
+	var entry *DiscoveredPeer
+	for _, p := range peers {
+		if p.Record.PubKey == record.PubKey {
+			entry = p
+			break
+		}
+	}
+	if entry == nil {
+		if len(peers) >= maxDiscoveredPeers {
+			return peers, nil
+		}
+		entry = &DiscoveredPeer{Record: *record, FirstSeen: now}
+		peers = append(peers, entry)
+	} else if record.Time > entry.Record.Time {
+		entry.Record = *record
+	}
+	if voucher != record.PubKey {
+		for _, v := range entry.Vouchers {
+			if v == voucher {
+				return peers, entry
+			}
+		}
+		entry.Vouchers = append(entry.Vouchers, voucher)
+	}
+	return peers, entry
+}
+
+// applyTrust approves a discovered peer according to the trust policy. Returns true if the
+// peer was approved.
+func applyTrust(entry *DiscoveredPeer, policy string, threshold int) bool {
+	if entry.Approved || entry.Rejected {
+		return false
+	}
+	switch policy {
+	case structs.TrustTOFU:
+		entry.Approved = true
+	case structs.TrustWoT:
+		entry.Approved = len(entry.Vouchers) >= threshold
+	}
+	return entry.Approved
+}
+
+// validRecord verifies a record received from a peer.
+func (ms MessageServer) validRecord(record *structs.PeerRecord, now int64) bool {
+	if !strings.HasPrefix(record.URL, "http://") && !strings.HasPrefix(record.URL, "https://") {
+		return false
+	}
+	if record.Time > now+ms.MaxTimeSkew+int64(DefaultTimeGrace) || record.Time < now-DefaultPeerRecordAge {
+		return false
+	}
+	return record.Verify()
+}
+
+// DiscoverPeers asks the peers for their peer records and activates the discovered peers
+// approved by the trust policy. It runs at most every 4 times FetchDuration.
+func (ms MessageServer) DiscoverPeers() {
+	var changed bool
+	now := CurrentTime()
+	if ms.PeerTrust == structs.TrustOff || atomic.LoadInt64(&lastDiscovery) > now-ms.FetchDuration*4 {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&discoveryRunning, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&discoveryRunning, 0)
+	atomic.StoreInt64(&lastDiscovery, now)
+	type vouchedRecord struct {
+		record  structs.PeerRecord
+		voucher string
+	}
+	var records []vouchedRecord
+	own := utils.B58encode(ms.TokenPubKey[:])
+	proto := repproto.New(ms.SocksProxy, "")
+	for _, peer := range ms.authPeers() {
+		peerRecords, err := proto.PeerRecords(peer.url, peer.auth)
+		if err != nil {
+			log.Debugf("Discovery: %s %s\n", peer.url, err)
+			continue
+		}
+		if len(peerRecords) > maxPeerRecords {
+			peerRecords = peerRecords[:maxPeerRecords]
+		}
+		voucher := utils.B58encode(peer.pubKey[:])
+		for _, record := range peerRecords {
+			if record.PubKey == own || !ms.validRecord(&record, now) {
+				continue
+			}
+			records = append(records, vouchedRecord{record: record, voucher: voucher})
+		}
+	}
+	active := make(map[string]bool)
+	systemPeersMutex.Lock()
+	for pubKey := range systemPeers {
+		active[utils.B58encode(pubKey[:])] = true
+	}
+	systemPeersMutex.Unlock()
+	discoveryMutex.Lock()
+	peers, err := loadDiscovered(ms.DiscoveredFile)
+	if err != nil {
+		discoveryMutex.Unlock()
+		log.Errorf("Could not read discovered peers: %s\n", err)
+		return
+	}
+	for i := range records {
+		var entry *DiscoveredPeer
+		var oldURL string
+		for _, p := range peers {
+			if p.Record.PubKey == records[i].record.PubKey {
+				oldURL = p.Record.URL
+			}
+		}
+		peers, entry = mergeRecord(peers, &records[i].record, records[i].voucher, now)
+		if entry == nil || (active[entry.Record.PubKey] && !entry.Approved) {
+			continue // Configured in the peer file
+		}
+		if applyTrust(entry, ms.PeerTrust, ms.PeerTrustThreshold) {
+			log.Printf("Discovered peer approved: %s %s\n", entry.Record.URL, entry.Record.PubKey)
+			changed = true
+		} else if entry.Approved && entry.Record.URL != oldURL {
+			changed = true
+		}
+	}
+	err = saveDiscovered(ms.DiscoveredFile, peers)
+	discoveredPeers = peers
+	setDiscoveryStat(peers, active)
+	discoveryMutex.Unlock()
+	if err != nil {
+		log.Errorf("Could not write discovered peers: %s\n", err)
+	}
+	if changed {
+		ms.LoadPeers()
+	}
+}
+
+// setDiscoveryStat updates the discovered peer metrics. Peers of the peer file are not counted.
+func setDiscoveryStat(peers []*DiscoveredPeer, active map[string]bool) {
+	var approved, rejected, pending float64
+	for _, p := range peers {
+		switch {
+		case active[p.Record.PubKey] && !p.Approved:
+		case p.Rejected:
+			rejected++
+		case p.Approved:
+			approved++
+		default:
+			pending++
+		}
+	}
+	stat.DiscoveredPeers.With("approved").Set(approved)
+	stat.DiscoveredPeers.With("rejected").Set(rejected)
+	stat.DiscoveredPeers.With("pending").Set(pending)
+}
+
+// activeRecords returns the known peer records of the active peers.
+func (ms MessageServer) activeRecords() []structs.PeerRecord {
+	var records []structs.PeerRecord
+	active := make(map[string]bool)
+	systemPeersMutex.Lock()
+	for pubKey := range systemPeers {
+		active[utils.B58encode(pubKey[:])] = true
+	}
+	systemPeersMutex.Unlock()
+	discoveryMutex.Lock()
+	defer discoveryMutex.Unlock()
+	for _, p := range discoveredPeers {
+		if active[p.Record.PubKey] && !p.Rejected {
+			records = append(records, p.Record)
+		}
+	}
+	return records
+}
+
+// PeersV2 returns the signed peer records of the server and its active peers to authenticated peers.
+func (ms MessageServer) PeersV2(w http.ResponseWriter, r *http.Request) {
+	auth := r.URL.Query().Get("auth")
+	if auth == "" {
+		writeJSONError(w, errMissingParam)
+		return
+	}
+	if err := ms.AuthenticatePeer(auth); err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	var records []structs.PeerRecord
+	if own := ms.ownPeerRecord(); own != nil {
+		records = append(records, *own)
+	}
+	records = append(records, ms.activeRecords()...)
+	writeJSON(w, &structs.APIResponse{PeerRecords: records})
+}
+
+// discoveredPeer returns the peer of an approved discovered entry. The hub flag of the record is
+// ignored, a peer only becomes a hub if the operator sets IsHub. Returns false if the public key is invalid.
+func discoveredPeer(entry *DiscoveredPeer) (Peer, bool) {
+	pubKey := entry.Record.PublicKey()
+	if pubKey == nil {
+		return Peer{}, false
+	}
+	return Peer{PubKey: *pubKey, URL: entry.Record.URL, IsHub: entry.IsHub}, true
+}
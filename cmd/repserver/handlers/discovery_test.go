@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/agl/ed25519"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+func TestDiscoveryTrust(t *testing.T) {
+	pubKey, privKey, _ := ed25519.GenerateKey(rand.Reader)
+	record := structs.NewPeerRecord("http://example.onion/", false, structs.TrustManual, 100, pubKey, privKey)
+	peers, entry := mergeRecord(nil, record, record.PubKey, 10)
+	if len(peers) != 1 || entry.FirstSeen != 10 || len(entry.Vouchers) != 0 {
+		t.Fatal("Self-announced record not added without voucher")
+	}
+	peers, entry = mergeRecord(peers, record, "voucherA", 20)
+	peers, entry = mergeRecord(peers, record, "voucherA", 30)
+	if len(peers) != 1 || len(entry.Vouchers) != 1 || entry.FirstSeen != 10 {
+		t.Errorf("Vouchers not collected: %v", entry.Vouchers)
+	}
+	newer := structs.NewPeerRecord("http://other.onion/", false, structs.TrustManual, 200, pubKey, privKey)
+	peers, entry = mergeRecord(peers, newer, "voucherB", 40)
+	if entry.Record.URL != newer.URL || len(entry.Vouchers) != 2 {
+		t.Error("Newer record not merged")
+	}
+	_, entry = mergeRecord(peers, record, "voucherB", 50)
+	if entry.Record.URL != newer.URL {
+		t.Error("Older record replaced newer one")
+	}
+	if applyTrust(entry, structs.TrustManual, 1) {
+		t.Error("Manual policy approved peer")
+	}
+	if applyTrust(entry, structs.TrustWoT, 3) {
+		t.Error("WoT policy approved peer below threshold")
+	}
+	if !applyTrust(entry, structs.TrustWoT, 2) || !entry.Approved {
+		t.Error("WoT policy did not approve peer")
+	}
+	rejected := &DiscoveredPeer{Record: *record, Rejected: true}
+	if applyTrust(rejected, structs.TrustTOFU, 0) {
+		t.Error("Rejected peer approved")
+	}
+	if !applyTrust(&DiscoveredPeer{Record: *record}, structs.TrustTOFU, 0) {
+		t.Error("TOFU policy did not approve peer")
+	}
+	hub := structs.NewPeerRecord("http://hub.onion/", true, structs.TrustManual, 300, pubKey, privKey)
+	_, entry = mergeRecord(peers, hub, "voucherC", 60)
+	if peer, ok := discoveredPeer(entry); !ok || peer.IsHub {
+		t.Error("Hub flag of discovered record trusted")
+	}
+	entry.IsHub = true
+	if peer, ok := discoveredPeer(entry); !ok || !peer.IsHub {
+		t.Error("Hub flag of operator ignored")
+	}
+}
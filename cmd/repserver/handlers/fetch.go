@@ -1,63 +1,91 @@
 package handlers
 
 import (
-	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 
+	"github.com/repbin/repbin/cmd/repserver/messagestore"
 	"github.com/repbin/repbin/cmd/repserver/stat"
 	log "github.com/repbin/repbin/deferconsole"
 	"github.com/repbin/repbin/message"
 	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/repproto/structs"
 )
 
 // Fetch returns a single message.
 func (ms MessageServer) Fetch(w http.ResponseWriter, r *http.Request) {
-	var messageID *[message.MessageIDSize]byte
 	w.Header().Set("Content-Type", "text/plain; charset=us-ascii")
-	getValues := r.URL.Query()
+	_, data, err := ms.fetch(r.URL.Query())
+	if err != nil {
+		io.WriteString(w, "ERROR: "+err.Error()+"\n")
+		return
+	}
+	io.WriteString(w, "SUCCESS: Data follows\n")
+	_, err = w.Write(data)
+	if err != nil {
+		log.Debugf("Write: %s\n", err)
+		return
+	}
+}
+
+// fetch verifies the parameters of a fetch request and returns the message.
+func (ms MessageServer) fetch(getValues url.Values) (id *[message.MessageIDSize]byte, data []byte, err error) {
+	defer func() {
+		if err != nil {
+			stat.Fetches.With(errorCode(err)).Inc()
+		} else {
+			stat.Fetches.With("ok").Inc()
+		}
+	}()
+	var messageID *[message.MessageIDSize]byte
+	hops := ms.ReadThroughHops
 	if getValues != nil {
 		if v, ok := getValues["messageid"]; ok {
 			t := utils.B58decode(v[0])
 			if len(t) < message.MessageIDSize || len(t) > message.MessageIDSize {
-				io.WriteString(w, "ERROR: Bad parameter\n")
-				return
+				return nil, nil, newAPIError(structs.CodeBadParam, "Bad parameter")
 			}
 			messageID = new([message.MessageIDSize]byte)
 			copy(messageID[:], t)
 		}
+		if _, ok := getValues["auth"]; ok {
+			hops = 0 // Requests of peers only forward with an explicit hop limit
+		}
+		if v, ok := getValues["hops"]; ok {
+			if t, err := strconv.Atoi(v[0]); err == nil {
+				hops = t
+			}
+			if hops > ms.ReadThroughHops {
+				hops = ms.ReadThroughHops
+			}
+		}
 		if ms.HubOnly {
 			if v, ok := getValues["auth"]; ok {
 				err := ms.AuthenticatePeer(v[0])
 				if err != nil {
-					io.WriteString(w, fmt.Sprintf("Error: %s", err))
-					return
+					return nil, nil, err
 				}
 			} else {
-				io.WriteString(w, "ERROR: Missing param\n")
-				return
+				return nil, nil, errMissingParam
 			}
 		}
 	}
 	if messageID == nil {
-		io.WriteString(w, "ERROR: Missing parameter\n")
-		return
+		return nil, nil, newAPIError(structs.CodeMissingParam, "Missing parameter")
 	}
-	data, err := ms.DB.Fetch(messageID)
-	if err != nil {
-		log.Debugf("Fetch: %s\n", err)
-		io.WriteString(w, "ERROR: No data")
-		return
+	data, err = ms.DB.Fetch(messageID)
+	if err != nil && ms.ReadThrough && hops > 0 {
+		data, err = ms.fetchReadThrough(messageID, hops-1)
+		if err == messagestore.ErrDeleted {
+			return nil, nil, storeError(err)
+		}
 	}
-	io.WriteString(w, "SUCCESS: Data follows\n")
-	_, err = w.Write(data)
 	if err != nil {
-		log.Debugf("Write: %s\n", err)
-		return
+		log.Debugf("Fetch: %s\n", err)
+		return nil, nil, newAPIError(structs.CodeNotFound, "No data")
 	}
 	log.Debugf("Fetch OK: %s\n", utils.B58encode(messageID[:]))
-	if ms.Stat {
-		stat.Input <- stat.Fetch
-	}
-	return
+	return messageID, data, nil
 }
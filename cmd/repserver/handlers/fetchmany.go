@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/repbin/repbin/cmd/repserver/stat"
+	log "github.com/repbin/repbin/deferconsole"
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+// maxFetchMany is the maximum number of messages per fetchmany request.
+const maxFetchMany = 100
+
+// FetchMany returns several messages. Each message follows a line "MESSAGE: <messageID> <length>",
+// missing messages are reported by a line "MISSING: <messageID>".
+func (ms MessageServer) FetchMany(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=us-ascii")
+	messageIDs, err := ms.fetchManyParams(r.URL.Query())
+	if err != nil {
+		io.WriteString(w, "ERROR: "+err.Error()+"\n")
+		return
+	}
+	io.WriteString(w, "SUCCESS: Data follows\n")
+	ms.fetchMany(messageIDs, func(messageID *[message.MessageIDSize]byte, data []byte) error {
+		if data == nil {
+			_, err := io.WriteString(w, "MISSING: "+utils.B58encode(messageID[:])+"\n")
+			return err
+		}
+		if _, err := io.WriteString(w, "MESSAGE: "+utils.B58encode(messageID[:])+" "+strconv.Itoa(len(data))+"\n"); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	})
+}
+
+// FetchManyV2 returns several messages as JSON lines, one response per message. Missing messages
+// are answered with an error.
+func (ms MessageServer) FetchManyV2(w http.ResponseWriter, r *http.Request) {
+	messageIDs, err := ms.fetchManyParams(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	ms.fetchMany(messageIDs, func(messageID *[message.MessageIDSize]byte, data []byte) error {
+		resp := &structs.APIResponse{Version: structs.APIVersion, MessageID: utils.B58encode(messageID[:])}
+		if data == nil {
+			resp.Error = &structs.APIError{Code: structs.CodeNotFound, Message: "No data"}
+		} else {
+			resp.Data = string(data)
+		}
+		return enc.Encode(resp)
+	})
+}
+
+// fetchManyParams verifies the parameters of a fetchmany request and returns the message IDs.
+// The IDs are given comma separated in "messageids".
+func (ms MessageServer) fetchManyParams(getValues url.Values) ([]*[message.MessageIDSize]byte, error) {
+	var messageIDs []*[message.MessageIDSize]byte
+	if getValues == nil || getValues.Get("messageids") == "" {
+		return nil, errMissingParam
+	}
+	if ms.HubOnly {
+		auth := getValues.Get("auth")
+		if auth == "" {
+			return nil, errMissingParam
+		}
+		if err := ms.AuthenticatePeer(auth); err != nil {
+			return nil, err
+		}
+	}
+	ids := strings.Split(getValues.Get("messageids"), ",")
+	if len(ids) > maxFetchMany {
+		return nil, errBadParam
+	}
+	for _, id := range ids {
+		t := utils.B58decode(id)
+		if len(t) != message.MessageIDSize {
+			return nil, errBadParam
+		}
+		messageID := new([message.MessageIDSize]byte)
+		copy(messageID[:], t)
+		messageIDs = append(messageIDs, messageID)
+	}
+	return messageIDs, nil
+}
+
+// fetchMany reads the messages and calls write for each. data is nil for missing messages. It
+// stops when write fails.
+func (ms MessageServer) fetchMany(messageIDs []*[message.MessageIDSize]byte, write func(messageID *[message.MessageIDSize]byte, data []byte) error) {
+	for _, messageID := range messageIDs {
+		data, err := ms.DB.Fetch(messageID)
+		if err != nil {
+			stat.Fetches.With(structs.CodeNotFound).Inc()
+			data = nil
+		} else {
+			stat.Fetches.With("ok").Inc()
+		}
+		if err := write(messageID, data); err != nil {
+			log.Debugf("FetchMany: %s\n", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"sync"
+
+	"github.com/repbin/repbin/cmd/repserver/messagestore"
+	log "github.com/repbin/repbin/deferconsole"
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/repproto"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+// importResult is the result of importing an index entry of a peer.
+type importResult int
+
+const (
+	importPending  importResult = iota // Not started before the deadline
+	importKnown                        // Stored already, or skipped because of its distance
+	importAdded                        // Downloaded, verified and stored
+	importFailed                       // Download failed, tried again on the next fetch run
+	importRejected                     // Rejected for good. Counted as error, skipped like known
+)
+
+// importMessages downloads, verifies and stores the index entries of a peer with FetchWorkers
+// concurrent workers. posts contains the messages downloaded already. Entries that are not
+// started before deadline stay pending. The results are in the order of messages.
+func (ms MessageServer) importMessages(peer Peer, auth string, messages []*structs.MessageStruct, posts map[[message.MessageIDSize]byte][]byte, deadline int64) []importResult {
+	var wg sync.WaitGroup
+	results := make([]importResult, len(messages))
+	jobs := make(chan int)
+	workers := ms.FetchWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(messages) {
+		workers = len(messages)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = ms.importMessage(peer, auth, messages[i], posts, deadline)
+			}
+		}()
+	}
+	for i := range messages {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// importMessage downloads, verifies and stores a single index entry of a peer.
+func (ms MessageServer) importMessage(peer Peer, auth string, msg *structs.MessageStruct, posts map[[message.MessageIDSize]byte][]byte, deadline int64) importResult {
+	if deadline <= CurrentTime() { // We worked for long enough
+		return importPending
+	}
+	log.Debugf("Fetching.  %d  %s\n", msg.Counter, utils.B58encode(msg.MessageID[:]))
+	if ms.DB.MessageExists(msg.MessageID) {
+		log.Debugf("fetch from peer: exists %s %s\n", utils.B58encode(msg.MessageID[:]), peer.URL)
+		return importKnown
+	}
+	if ms.tooFar(msg.Distance) || ms.relayed(peer, msg.Distance) {
+		log.Debugf("fetch from peer: skipped, distance %d %s %s\n", msg.Distance, utils.B58encode(msg.MessageID[:]), peer.URL)
+		return importKnown
+	}
+	var err error
+	if data, ok := posts[msg.MessageID]; ok {
+		err = ms.importPost(data, msg)
+	} else {
+		err = ms.FetchPost(peer.URL, auth, msg)
+	}
+	switch err {
+	case nil:
+		log.Debugf("fetch from peer: added %s %s distance: %d\n", utils.B58encode(msg.MessageID[:]), peer.URL, msg.Distance+1)
+		return importAdded
+	case messagestore.ErrDuplicate:
+		log.Debugf("fetch from peer: exists now %s %s\n", utils.B58encode(msg.MessageID[:]), peer.URL)
+		return importKnown
+	}
+	log.Debugf("Fetch err: %s %s\n", peer.URL, err)
+	if rejected(err, msg) {
+		return importRejected
+	}
+	return importFailed
+}
+
+// rejected returns true if err rejects the message of an index entry permanently, trying again
+// cannot succeed. Transport, proxy and database errors are not permanent.
+func rejected(err error, msg *structs.MessageStruct) bool {
+	switch err {
+	case messagestore.ErrDeleted, messagestore.ErrPostLimit, ErrBadMessageID, ErrBadPost:
+		return true
+	}
+	// The peer expired the message since it sent the index
+	return repproto.IsNotFound(err) && msg.ExpireTime <= uint64(CurrentTime())
+}
+
+// tombstoneDone returns true if the result err of adding a tombstone of a peer is final: it was
+// stored, was known already or does not verify, or the message of an expiry record is not stored.
+func tombstoneDone(err error) bool {
+	switch err {
+	case nil, messagestore.ErrDuplicate, messagestore.ErrTombstone, messagestore.ErrNotFound:
+		return true
+	}
+	return false
+}
+
+// tombstonePosition returns the position of the last tombstone of index that is done, starting
+// with last, and false if tombstones remain that must be fetched again. errs contains the results
+// of the tombstones that were added, in order. Without the positions of the tombstones the
+// position only advances if all of them are done.
+func tombstonePosition(index *repproto.GlobalIndex, errs []error, last uint64) (uint64, bool) {
+	for i := range index.Tombstones {
+		if i >= len(errs) || !tombstoneDone(errs[i]) {
+			return last, false
+		}
+		if len(index.Positions) == len(index.Tombstones) {
+			last = index.Positions[i]
+		}
+	}
+	if index.NextTombstone > 0 {
+		last = index.NextTombstone - 1
+	}
+	return last, true
+}
+
+// importedPosition returns the counter of the last message of the contiguous prefix of messages
+// that was imported, known or rejected, starting with position, and false if messages remain that
+// must be tried again. A failure thus never skips messages.
+func importedPosition(messages []*structs.MessageStruct, results []importResult, position uint64) (uint64, bool) {
+	for i, msg := range messages {
+		if results[i] == importPending || results[i] == importFailed {
+			return position, false
+		}
+		position = msg.Counter
+	}
+	return position, true
+}
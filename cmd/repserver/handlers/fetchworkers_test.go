@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/repbin/repbin/cmd/repserver/messagestore"
+	"github.com/repbin/repbin/utils/repproto"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+func TestImportedPosition(t *testing.T) {
+	messages := []*structs.MessageStruct{{Counter: 11}, {Counter: 12}, {Counter: 15}, {Counter: 16}}
+	tests := []struct {
+		results  []importResult
+		position uint64
+		complete bool
+	}{
+		{[]importResult{importAdded, importKnown, importAdded, importKnown}, 16, true},
+		{[]importResult{importAdded, importFailed, importAdded, importAdded}, 11, false},
+		{[]importResult{importAdded, importKnown, importAdded, importPending}, 15, false},
+		{[]importResult{importFailed, importAdded, importAdded, importAdded}, 10, false},
+	}
+	for i, test := range tests {
+		position, complete := importedPosition(messages, test.results, 10)
+		if position != test.position || complete != test.complete {
+			t.Errorf("%d: Bad position %d %t, expected %d %t", i, position, complete, test.position, test.complete)
+		}
+	}
+}
+
+func TestImportRejected(t *testing.T) {
+	now := uint64(CurrentTime())
+	messages := []*structs.MessageStruct{{Counter: 11}, {Counter: 12, ExpireTime: now - 10}, {Counter: 13}, {Counter: 14, ExpireTime: now + 3600}}
+	results := make([]importResult, len(messages))
+	for i, err := range []error{messagestore.ErrDeleted, &repproto.ServerError{Code: structs.CodeNotFound}, messagestore.ErrPostLimit, &repproto.ServerError{Message: "No data"}} {
+		results[i] = importFailed
+		if rejected(err, messages[i]) {
+			results[i] = importRejected
+		}
+	}
+	position, complete := importedPosition(messages, results, 10)
+	if position != 13 || complete {
+		t.Errorf("Bad position %d %t, expected 13 false", position, complete)
+	}
+	if rejected(errors.New("socks connect failed"), messages[0]) {
+		t.Error("Transport error rejected")
+	}
+	// Corrupt and truncated messages fail before the store is used
+	var ms MessageServer
+	for _, data := range [][]byte{[]byte("AAAA"), []byte("not base64!"), nil} {
+		if err := ms.addPost(data, 0, 1); !rejected(err, messages[0]) {
+			t.Errorf("Corrupt message not rejected: %q %v", data, err)
+		}
+	}
+}
+
+func TestTombstonePosition(t *testing.T) {
+	tombstones := []*structs.TombstoneStruct{{}, {}, {}}
+	transient := errors.New("database is locked")
+	tests := []struct {
+		positions []uint64
+		errs      []error
+		last      uint64
+		complete  bool
+	}{
+		{[]uint64{11, 12, 15}, []error{nil, messagestore.ErrDuplicate, messagestore.ErrTombstone}, 19, true},
+		{[]uint64{11, 12, 15}, []error{nil, transient}, 11, false},
+		{[]uint64{11, 12, 15}, []error{transient}, 10, false},
+		{nil, []error{nil, nil, transient}, 10, false},
+		{nil, []error{nil, messagestore.ErrNotFound, nil}, 19, true},
+	}
+	for i, test := range tests {
+		index := &repproto.GlobalIndex{Tombstones: tombstones, Positions: test.positions, NextTombstone: 20}
+		last, complete := tombstonePosition(index, test.errs, 10)
+		if last != test.last || complete != test.complete {
+			t.Errorf("%d: Bad position %d %t, expected %d %t", i, last, complete, test.last, test.complete)
+		}
+	}
+}
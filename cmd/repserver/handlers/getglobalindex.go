@@ -1,71 +1,65 @@
 package handlers
 
 import (
-	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
-	"strings"
 
+	"github.com/repbin/repbin/cmd/repserver/stat"
 	log "github.com/repbin/repbin/deferconsole"
-	"github.com/repbin/repbin/message"
 	"github.com/repbin/repbin/utils"
 	"github.com/repbin/repbin/utils/keyproof"
+	"github.com/repbin/repbin/utils/repproto/structs"
 )
 
 // GetGlobalIndex returns the global index.
 func (ms MessageServer) GetGlobalIndex(w http.ResponseWriter, r *http.Request) {
-	var pubKey *message.Curve25519Key
+	w.Header().Set("Content-Type", "text/plain; charset=us-ascii")
+	messages, more, err := ms.globalIndex(r.URL.Query())
+	if err != nil {
+		io.WriteString(w, "ERROR: "+err.Error()+"\n")
+		return
+	}
+	writeIndex(w, messages, more)
+}
+
+// globalIndex verifies the parameters of a global index request and returns the index entries.
+func (ms MessageServer) globalIndex(getValues url.Values) (messages [][]byte, more bool, err error) {
+	stat.IndexRequests.With("global").Inc()
 	start := int64(0)
 	count := int64(10)
-	w.Header().Set("Content-Type", "text/plain; charset=us-ascii")
-	getValues := r.URL.Query()
-	if getValues != nil {
-		if v, ok := getValues["start"]; ok {
-			t, err := strconv.Atoi(v[0])
-			if err == nil {
-				start = int64(t)
-			}
-		}
-		if v, ok := getValues["count"]; ok {
-			t, err := strconv.Atoi(v[0])
-			if err == nil {
-				count = int64(t)
-				if count > ms.MaxIndexGlobal {
-					count = ms.MaxIndexGlobal
-				}
-			}
+	if getValues == nil {
+		return nil, false, errMissingParam
+	}
+	if v, ok := getValues["start"]; ok {
+		t, err := strconv.Atoi(v[0])
+		if err == nil {
+			start = int64(t)
 		}
-		if v, ok := getValues["auth"]; ok {
-			err := ms.AuthenticatePeer(v[0])
-			if err != nil {
-				io.WriteString(w, fmt.Sprintf("Error: %s", err))
-				return
+	}
+	if v, ok := getValues["count"]; ok {
+		t, err := strconv.Atoi(v[0])
+		if err == nil {
+			count = int64(t)
+			if count > ms.MaxIndexGlobal {
+				count = ms.MaxIndexGlobal
 			}
-		} else {
-			io.WriteString(w, "ERROR: Missing param\n")
-			return
 		}
-	} else {
-		io.WriteString(w, "ERROR: Missing param\n")
-		return
 	}
-	messages, found, err := ms.DB.GetGlobalIndex(start, count)
-	if err != nil && err != ErrNoMore {
-		log.Debugf("List:GetIndex: %s\n", err)
-		log.Debugf("List:GetIndex: Key %s\n", utils.B58encode(pubKey[:]))
-		io.WriteString(w, "ERROR: List failed\n")
-		return
+	v, ok := getValues["auth"]
+	if !ok {
+		return nil, false, errMissingParam
 	}
-	io.WriteString(w, "SUCCESS: Data follows\n")
-	for _, msg := range messages {
-		io.WriteString(w, "IDX: "+strings.Trim(string(msg), " \t\n\r")+"\n")
+	if err := ms.AuthenticatePeer(v[0]); err != nil {
+		return nil, false, err
 	}
-	if int64(found) < count {
-		io.WriteString(w, "CMD: Exceeded\n")
-	} else {
-		io.WriteString(w, "CMD: Continue\n")
+	messages, found, err := ms.DB.GetGlobalIndex(start, count)
+	if err != nil && err != ErrNoMore {
+		log.Debugf("List:GetGlobalIndex: %s\n", err)
+		return nil, false, newAPIError(structs.CodeInternal, "List failed")
 	}
+	return messages, int64(found) >= count, nil
 }
 
 // AuthenticatePeer verifies an existing authStr and matches it to the known peers.
@@ -73,21 +67,21 @@ func (ms MessageServer) AuthenticatePeer(authStr string) error {
 	var counterSig [keyproof.ProofTokenSignedSize]byte
 	var auth []byte
 	if len(authStr) > keyproof.ProofTokenSignedMax {
-		return fmt.Errorf("Bad param")
+		return errBadParam
 	}
 	auth = utils.B58decode(authStr)
 	if len(auth) > keyproof.ProofTokenSignedSize {
-		return fmt.Errorf("Bad param")
+		return errBadParam
 	}
 	copy(counterSig[:], auth)
 	ok, timestamp := keyproof.VerifyCounterSig(&counterSig, ms.TokenPubKey)
 	if !ok {
 		log.Debugs("List:Auth no verify\n")
-		return fmt.Errorf("Authentication failed: No match")
+		return newAPIError(structs.CodeAuthFailed, "Authentication failed: No match")
 	}
 	now := CurrentTime()
 	if enforceTimeOuts && (timestamp < now-ms.MaxAuthTokenAge-ms.MaxTimeSkew || timestamp > now+ms.MaxAuthTokenAge+ms.MaxTimeSkew) {
-		return fmt.Errorf("Authentication failed: Timeout")
+		return newAPIError(structs.CodeAuthExpired, "Authentication failed: Timeout")
 	}
 	return nil
 }
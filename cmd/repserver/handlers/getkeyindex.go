@@ -3,23 +3,44 @@ package handlers
 import (
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 
+	"github.com/repbin/repbin/cmd/repserver/stat"
 	log "github.com/repbin/repbin/deferconsole"
 	"github.com/repbin/repbin/message"
 	"github.com/repbin/repbin/utils"
 	"github.com/repbin/repbin/utils/keyauth"
+	"github.com/repbin/repbin/utils/repproto/structs"
 )
 
 // GetKeyIndex returns the index for a key.
 func (ms MessageServer) GetKeyIndex(w http.ResponseWriter, r *http.Request) {
-	var pubKey *message.Curve25519Key
-	var auth []byte
-	start := int64(0)
-	count := int64(10)
 	w.Header().Set("Content-Type", "text/plain; charset=us-ascii")
-	getValues := r.URL.Query()
+	messages, more, err := ms.keyIndex(r.URL.Query())
+	if err != nil {
+		io.WriteString(w, "ERROR: "+err.Error()+"\n")
+		return
+	}
+	writeIndex(w, messages, more)
+}
+
+// keyIndex verifies the parameters of a key index request and returns the index entries.
+func (ms MessageServer) keyIndex(getValues url.Values) (messages [][]byte, more bool, err error) {
+	stat.IndexRequests.With("key").Inc()
+	pubKey, start, count, err := ms.keyIndexParams(getValues)
+	if err != nil {
+		return nil, false, err
+	}
+	return ms.readKeyIndex(pubKey, start, count)
+}
+
+// keyIndexParams verifies the parameters of a key index request, including authentication for hidden keys.
+func (ms MessageServer) keyIndexParams(getValues url.Values) (pubKey *message.Curve25519Key, start, count int64, err error) {
+	var auth []byte
+	start = int64(0)
+	count = int64(10)
 	if getValues != nil {
 		if v, ok := getValues["start"]; ok {
 			t, err := strconv.Atoi(v[0])
@@ -38,75 +59,83 @@ func (ms MessageServer) GetKeyIndex(w http.ResponseWriter, r *http.Request) {
 		}
 		if v, ok := getValues["key"]; ok {
 			if len(v[0]) > message.Curve25519KeySize*10 {
-				io.WriteString(w, "ERROR: Bad param\n")
-				return
+				return nil, 0, 0, errBadParam
 			}
 			t := utils.B58decode(v[0])
 			if len(t) != message.Curve25519KeySize {
-				io.WriteString(w, "ERROR: Bad param\n")
-				return
+				return nil, 0, 0, errBadParam
 			}
 			pubKey = new(message.Curve25519Key)
 			copy(pubKey[:], t)
 			if v, ok := getValues["auth"]; ok {
 				if len(v[0]) > keyauth.AnswerSize*10 {
-					io.WriteString(w, "ERROR: Bad param\n")
-					return
+					return nil, 0, 0, errBadParam
 				}
 				auth = utils.B58decode(v[0])
 				if len(auth) != keyauth.AnswerSize {
-					io.WriteString(w, "ERROR: Bad param\n")
-					return
+					return nil, 0, 0, errBadParam
 				}
 			}
-		} else {
-			io.WriteString(w, "ERROR: Missing param\n")
-			return
 		}
-	} else {
-		io.WriteString(w, "ERROR: Missing param\n")
-		return
 	}
 	if pubKey == nil {
-		io.WriteString(w, "ERROR: Missing param\n")
-		return
+		return nil, 0, 0, errMissingParam
 	}
 	if message.KeyIsHidden(pubKey) {
 		if auth == nil {
 			log.Debugs("List:Auth missing\n")
-			io.WriteString(w, "ERROR: Authentication required\n")
-			return
-		}
-		answer := [keyauth.AnswerSize]byte{}
-		copy(answer[:], auth)
-		now := uint64(CurrentTime() + ms.TimeSkew)
-		if !keyauth.VerifyTime(&answer, now, ms.TimeGrace) {
-			log.Debugs("List:Auth timeout\n")
-			io.WriteString(w, "ERROR: Authentication failed: Timeout\n")
-			return
+			return nil, 0, 0, newAPIError(structs.CodeAuthRequired, "Authentication required")
 		}
-		privK := [32]byte(*ms.authPrivKey)
-		testK := [32]byte(*pubKey)
-		if !keyauth.Verify(&answer, &privK, &testK) {
-			log.Debugs("List:Auth no verify\n")
-			io.WriteString(w, "ERROR: Authentication failed: No Match\n")
-			return
+		if err := ms.verifyKeyAuth(auth, pubKey, "", nil); err != nil {
+			return nil, 0, 0, err
 		}
 	}
+	return pubKey, start, count, nil
+}
+
+// verifyKeyAuth verifies that auth proves the knowledge of the private key of pubKey. If
+// operation is not empty the proof must be bound to the operation and id. An empty operation
+// and id verify plain answers.
+func (ms MessageServer) verifyKeyAuth(auth []byte, pubKey *message.Curve25519Key, operation string, id []byte) error {
+	if len(auth) != keyauth.AnswerSize {
+		return errBadParam
+	}
+	answer := [keyauth.AnswerSize]byte{}
+	copy(answer[:], auth)
+	now := uint64(CurrentTime() + ms.TimeSkew)
+	if !keyauth.VerifyTime(&answer, now, ms.TimeGrace) {
+		log.Debugs("Auth timeout\n")
+		return newAPIError(structs.CodeAuthExpired, "Authentication failed: Timeout")
+	}
+	privK := [32]byte(*ms.authPrivKey)
+	testK := [32]byte(*pubKey)
+	if !keyauth.VerifyOperation(&answer, &privK, &testK, operation, id) {
+		log.Debugs("Auth no verify\n")
+		return newAPIError(structs.CodeAuthFailed, "Authentication failed: No Match")
+	}
+	return nil
+}
+
+// readKeyIndex returns count index entries for pubKey, beginning with counter start.
+func (ms MessageServer) readKeyIndex(pubKey *message.Curve25519Key, start, count int64) (messages [][]byte, more bool, err error) {
 	messages, found, err := ms.DB.GetIndex(pubKey, start, count)
 	if err != nil && err != ErrNoMore {
 		log.Debugf("List:GetIndex: %s\n", err)
 		log.Debugf("List:GetIndex: Key %s\n", utils.B58encode(pubKey[:]))
-		io.WriteString(w, "ERROR: List failed\n")
-		return
+		return nil, false, newAPIError(structs.CodeInternal, "List failed")
 	}
+	return messages, int64(found) >= count, nil
+}
+
+// writeIndex writes index entries in text format.
+func writeIndex(w io.Writer, messages [][]byte, more bool) {
 	io.WriteString(w, "SUCCESS: Data follows\n")
 	for _, msg := range messages {
 		io.WriteString(w, "IDX: "+strings.Trim(string(msg), " \t\n\r")+"\n")
 	}
-	if int64(found) < count {
-		io.WriteString(w, "CMD: Exceeded\n")
-	} else {
+	if more {
 		io.WriteString(w, "CMD: Continue\n")
+	} else {
+		io.WriteString(w, "CMD: Exceeded\n")
 	}
 }
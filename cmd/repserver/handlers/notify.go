@@ -3,64 +3,79 @@ package handlers
 import (
 	"io"
 	"net/http"
+	"net/url"
 
+	"github.com/agl/ed25519"
 	log "github.com/repbin/repbin/deferconsole"
 	"github.com/repbin/repbin/utils"
 	"github.com/repbin/repbin/utils/keyproof"
+	"github.com/repbin/repbin/utils/repproto/structs"
 )
 
 // GetNotify receives notifications.
 func (ms MessageServer) GetNotify(w http.ResponseWriter, r *http.Request) {
-	var proof [keyproof.ProofTokenSize]byte
 	w.Header().Set("Content-Type", "text/plain; charset=us-ascii")
-	getValues := r.URL.Query()
-	if getValues != nil {
-		if v, ok := getValues["auth"]; ok {
-			if len(v[0]) > keyproof.ProofTokenMax {
-				io.WriteString(w, "ERROR: Bad Param\n")
-				return
-			}
-			auth := utils.B58decode(v[0])
-			if auth == nil || len(auth) > keyproof.ProofTokenSize {
-				io.WriteString(w, "ERROR: Bad Param\n")
-				return
-			}
-			copy(proof[:], auth)
-			ok, timeStamp, senderPubKey := keyproof.VerifyProofToken(&proof, ms.TokenPubKey)
-			if !ok {
-				io.WriteString(w, "ERROR: Authentication failure\n")
-				if senderPubKey == nil {
-					log.Errorf("VerifyProofToken failed: (proof) %s\n", utils.B58encode(proof[:]))
-				} else {
-					log.Errorf("VerifyProofToken failed: (pubkey) %s\n", utils.B58encode(senderPubKey[:]))
-				}
-				return
-			}
-			// verify that we know the peer
-			url := ms.PeerURL(senderPubKey)
-			if url == "" {
-				io.WriteString(w, "ERROR: Bad peer\n")
-				log.Errorf("Notify, bad peer: %s\n", utils.B58encode(senderPubKey[:]))
-				return
-			}
-			now := CurrentTime()
-			// Test too old, too young
-			if enforceTimeOuts && (now > timeStamp+DefaultAuthTokenAge+ms.MaxTimeSkew || now < timeStamp-DefaultAuthTokenAge-ms.MaxTimeSkew) {
-				io.WriteString(w, "ERROR: Authentication expired\n")
-				log.Errorf("VerifyProofToken replay by %s\n", url)
-				return
-			}
-			ok, signedToken := keyproof.CounterSignToken(&proof, ms.TokenPubKey, ms.TokenPrivKey)
-			if !ok {
-				io.WriteString(w, "ERROR: Authentication failure\n")
-				return
-			}
-			ms.DB.UpdatePeerAuthToken(senderPubKey, signedToken)
-			log.Debugf("Notified by %s\n", url)
-			io.WriteString(w, "SUCCESS: Notified\n")
-			return
+	if err := ms.notify(r.URL.Query()); err != nil {
+		io.WriteString(w, "ERROR: "+err.Error()+"\n")
+		return
+	}
+	io.WriteString(w, "SUCCESS: Notified\n")
+}
+
+// notify verifies a notification and stores the counter-signed token of the peer.
+func (ms MessageServer) notify(getValues url.Values) error {
+	if getValues == nil {
+		return newAPIError(structs.CodeMissingParam, "Missing Param")
+	}
+	v, ok := getValues["auth"]
+	if !ok {
+		return newAPIError(structs.CodeMissingParam, "Missing Param")
+	}
+	proof, senderPubKey, url, err := ms.verifyPeerProof(v[0])
+	if err != nil {
+		return err
+	}
+	ok, signedToken := keyproof.CounterSignToken(proof, ms.TokenPubKey, ms.TokenPrivKey)
+	if !ok {
+		return newAPIError(structs.CodeAuthFailed, "Authentication failure")
+	}
+	ms.DB.UpdatePeerAuthToken(senderPubKey, signedToken)
+	log.Debugf("Notified by %s\n", url)
+	return nil
+}
+
+// verifyPeerProof verifies a proof token signed by a known peer for this server. It returns the
+// token, the public key and URL of the peer.
+func (ms MessageServer) verifyPeerProof(auth string) (*[keyproof.ProofTokenSize]byte, *[ed25519.PublicKeySize]byte, string, error) {
+	proof := new([keyproof.ProofTokenSize]byte)
+	if len(auth) > keyproof.ProofTokenMax {
+		return nil, nil, "", newAPIError(structs.CodeBadParam, "Bad Param")
+	}
+	d := utils.B58decode(auth)
+	if d == nil || len(d) > keyproof.ProofTokenSize {
+		return nil, nil, "", newAPIError(structs.CodeBadParam, "Bad Param")
+	}
+	copy(proof[:], d)
+	ok, timeStamp, senderPubKey := keyproof.VerifyProofToken(proof, ms.TokenPubKey)
+	if !ok {
+		if senderPubKey == nil {
+			log.Errorf("VerifyProofToken failed: (proof) %s\n", utils.B58encode(proof[:]))
+		} else {
+			log.Errorf("VerifyProofToken failed: (pubkey) %s\n", utils.B58encode(senderPubKey[:]))
 		}
+		return nil, nil, "", newAPIError(structs.CodeAuthFailed, "Authentication failure")
+	}
+	// verify that we know the peer
+	url := ms.PeerURL(senderPubKey)
+	if url == "" {
+		log.Errorf("Notify, bad peer: %s\n", utils.B58encode(senderPubKey[:]))
+		return nil, nil, "", newAPIError(structs.CodeBadPeer, "Bad peer")
+	}
+	now := CurrentTime()
+	// Test too old, too young
+	if enforceTimeOuts && (now > timeStamp+DefaultAuthTokenAge+ms.MaxTimeSkew || now < timeStamp-DefaultAuthTokenAge-ms.MaxTimeSkew) {
+		log.Errorf("VerifyProofToken replay by %s\n", url)
+		return nil, nil, "", newAPIError(structs.CodeAuthExpired, "Authentication expired")
 	}
-	io.WriteString(w, "ERROR: Missing Param\n")
-	return
+	return proof, senderPubKey, url, nil
 }
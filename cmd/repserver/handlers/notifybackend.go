@@ -5,10 +5,12 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/agl/ed25519"
 	"github.com/repbin/repbin/cmd/repserver/messagestore"
+	"github.com/repbin/repbin/cmd/repserver/stat"
 	log "github.com/repbin/repbin/deferconsole"
 	"github.com/repbin/repbin/message"
 	"github.com/repbin/repbin/utils"
@@ -53,10 +55,11 @@ func init() {
 	peerURLsMutex = new(sync.Mutex)
 }
 
-// LoadPeers from file.
+// LoadPeers from file and adds the approved discovered peers.
 func (ms MessageServer) LoadPeers() {
 	var prePeerlist PeerListEncoded
 	var myPeerURLs []string
+	var hasHub int32
 	myPeers := make(PeerList)
 	peersEncoded, err := ioutil.ReadFile(ms.PeerFile)
 	if err != nil {
@@ -81,29 +84,47 @@ func (ms MessageServer) LoadPeers() {
 	if ms.AddToPeer && !ms.HubOnly {
 		myPeerURLs = append(myPeerURLs, ms.URL)
 	}
+	addPeer := func(peer Peer) {
+		myPeers[peer.PubKey] = peer
+		if peer.IsHub {
+			hasHub = 1
+		} else {
+			myPeerURLs = append(myPeerURLs, peer.URL)
+		}
+		// Create Peer Index File
+		ms.DB.TouchPeer(&peer.PubKey)
+	}
 	for _, p := range prePeerlist {
 		if p.URL != exampleURL {
 			var tkey [ed25519.PublicKeySize]byte
 			t := utils.B58decode(p.PubKey)
 			copy(tkey[:], t)
 			if *ms.TokenPubKey != tkey || debug {
-				myPeers[tkey] = Peer{
+				addPeer(Peer{
 					URL:    p.URL,
 					PubKey: tkey,
-				}
-				if !p.IsHub {
-					myPeerURLs = append(myPeerURLs, p.URL)
-				}
-				// Create Peer Index File
-				ms.DB.TouchPeer(&tkey)
+					IsHub:  p.IsHub,
+				})
 			}
 		}
 	}
+	// Approved discovered peers, the peer file takes precedence
+	for _, entry := range ms.loadDiscoveredPeers() {
+		peer, ok := discoveredPeer(entry)
+		if !ok || peer.PubKey == *ms.TokenPubKey {
+			continue
+		}
+		if _, exists := myPeers[peer.PubKey]; !exists {
+			addPeer(peer)
+		}
+	}
 	defer ms.setPeerURLs(myPeerURLs)
+	atomic.StoreInt32(&peersHaveHub, hasHub)
+	ms.setTopologyStat(myPeers)
 	systemPeersMutex.Lock()
 	defer systemPeersMutex.Unlock()
 	systemPeers = myPeers
-	log.Debugs("Peers loaded\n")
+	log.Debugf("Peers loaded, role: %s\n", ms.Role())
 }
 
 func (ms MessageServer) setPeerURLs(urls []string) {
@@ -133,11 +154,14 @@ func (ms MessageServer) PeerURL(PubKey *[ed25519.PublicKeySize]byte) string {
 	return peer.URL
 }
 
-// NotifyPeers runs notification for all peers.
+// NotifyPeers runs notification for all peers. Leaves only notify hubs.
 func (ms MessageServer) NotifyPeers() {
 	systemPeersMutex.Lock()
 	defer systemPeersMutex.Unlock()
 	for peerPubKey, peer := range systemPeers {
+		if !ms.syncWith(peer) {
+			continue
+		}
 		peerPubKeyTemp := new([ed25519.PublicKeySize]byte) // We need to create a new pointer
 		copy(peerPubKeyTemp[:], peerPubKey[:])
 		go ms.notifyPeer(peerPubKeyTemp, peer.URL)
@@ -145,6 +169,10 @@ func (ms MessageServer) NotifyPeers() {
 }
 
 func (ms MessageServer) notifyPeer(PubKey *[ed25519.PublicKeySize]byte, url string) {
+	if peerStat := ms.DB.GetPeerStat(PubKey); peerStat != nil && !peerAvailable(peerStat, CurrentTime()) {
+		log.Debugf("Notify skipped, %s: %s\n", healthState(peerStat), url)
+		return
+	}
 	rand.Seed(time.Now().UnixNano())
 	maxSleep := ms.NotifyDuration - int64(timeout)
 	if maxSleep > 0 {
@@ -159,21 +187,27 @@ func (ms MessageServer) notifyPeer(PubKey *[ed25519.PublicKeySize]byte, url stri
 	// Write result
 	if err != nil {
 		log.Debugf("Notify error: %s\n", err)
+		stat.NotifyFailures.With(url).Inc()
 		ms.DB.UpdatePeerNotification(PubKey, true)
+		if !localError(err) {
+			ms.peerFailed(PubKey, url, "notify", err)
+		}
 	} else {
 		log.Debugf("Notified peer: %s\n", url)
 		ms.DB.UpdatePeerNotification(PubKey, false)
+		ms.peerSucceeded(PubKey, url)
 	}
 }
 
-// FetchPeers checks the peers for new messages, and downloads them.
+// FetchPeers checks the peers for new messages, and downloads them. Leaves only fetch from hubs.
 func (ms MessageServer) FetchPeers() {
 	systemPeersMutex.Lock()
 	defer systemPeersMutex.Unlock()
-	for peerPubKey, peer := range systemPeers {
-		peerPubKeyTemp := new([ed25519.PublicKeySize]byte) // We need to create a new pointer
-		copy(peerPubKeyTemp[:], peerPubKey[:])
-		go ms.fetchPeer(peerPubKeyTemp, peer.URL)
+	for _, peer := range systemPeers {
+		if !ms.syncWith(peer) {
+			continue
+		}
+		go ms.fetchPeer(peer)
 	}
 }
 
@@ -183,15 +217,20 @@ func format(t uint64) string {
 }
 
 // fetchPeer downloads new messages from peer.
-func (ms MessageServer) fetchPeer(PubKey *[ed25519.PublicKeySize]byte, url string) {
+func (ms MessageServer) fetchPeer(peer Peer) {
 	// Get token, lastpos from database
-	var doUpdate bool
+	var doUpdate, reached bool
+	PubKey, url := &peer.PubKey, peer.URL
 	log.Debugf("fetch from peer: %s\n", url)
 	peerStat := ms.DB.GetPeerStat(PubKey)
 	if peerStat == nil { // Errors are ignored
 		log.Debugf("fetch from peer: not found %s\n", url)
 		return
 	}
+	if !peerAvailable(peerStat, CurrentTime()) {
+		log.Debugf("fetch from peer: %s %s\n", healthState(peerStat), url)
+		return
+	}
 	if peerStat.LastNotifyFrom == 0 { // We never heard from him
 		log.Debugf("fetch from peer: no notification %s\n", url)
 		return
@@ -220,6 +259,19 @@ func (ms MessageServer) fetchPeer(PubKey *[ed25519.PublicKeySize]byte, url strin
 		time.Sleep(time.Duration(sleeptime) * time.Second)
 	}
 	doUpdate = true
+	// Reconcile on first fetch and after long pauses, then continue with the global index
+	if ms.Reconcile && (peerStat.LastPosition == 0 || peerStat.LastFetch < uint64(startDate-(ms.FetchDuration*4))) {
+		position, failed, err := ms.reconcilePeer(peer, utils.B58encode(peerStat.AuthToken[:]), startDate+ms.FetchDuration)
+		peerStat.ErrorCount += failed
+		if err != nil {
+			log.Debugf("Reconcile err: %s %s\n", url, err)
+		} else {
+			log.Debugf("Reconcile done: %s position: %d\n", url, position)
+			if position > peerStat.LastPosition {
+				peerStat.LastPosition = position
+			}
+		}
+	}
 FetchLoop:
 	for {
 		// Make GetIndex call
@@ -230,51 +282,61 @@ FetchLoop:
 		}
 		log.Debugf("GlobalIndex fetch: %s next: %d max: %d\n", url, nextPosition, ms.FetchMax)
 		authtoken := utils.B58encode(peerStat.AuthToken[:])
-		messages, more, err := proto.GetGlobalIndex(url, authtoken, nextPosition, int(ms.FetchMax))
+		index, err := proto.GetGlobalIndexTombstones(url, authtoken, nextPosition, int(ms.FetchMax), peerStat.LastTombstone+1)
 		if err != nil {
 			peerStat.ErrorCount++
 			log.Debugf("GlobalIndex err: %s\n", err)
 			// Only hit on proxy errors
-			errstr := err.Error()
-			if len(errstr) >= 6 && errstr[0:6] == "proxy:" {
+			if localError(err) {
 				doUpdate = false
+			} else {
+				ms.peerFailed(PubKey, url, "fetch", err)
 			}
 			break FetchLoop
 		}
+		reached = true
+		// Tombstones first, they refuse messages of the same list
+		var tombstoneErrs []error
+		for _, ts := range index.Tombstones {
+			err := ms.addPeerTombstone(ts, peer)
+			tombstoneErrs = append(tombstoneErrs, err)
+			if !tombstoneDone(err) {
+				break // Fetched again on the next run
+			}
+		}
+		lastTombstone, tombstonesComplete := tombstonePosition(index, tombstoneErrs, peerStat.LastTombstone)
+		peerStat.LastTombstone = lastTombstone
+		if !tombstonesComplete {
+			log.Debugf("Tombstones incomplete: %s position: %d\n", url, peerStat.LastTombstone)
+			peerStat.ErrorCount++
+			doUpdate = false
+		}
+		messages, more := index.Messages, index.More
 		for _, msg := range messages {
 			log.Debugf("Index: %d  Message: %s\n", msg.Counter, utils.B58encode(msg.MessageID[:]))
 		}
-	MessageLoop:
-		for _, msg := range messages {
-			log.Debugf("Fetching.  %d  %s\n", msg.Counter, utils.B58encode(msg.MessageID[:]))
-			if startDate+ms.FetchDuration <= CurrentTime() { // We worked for long enough
-				log.Debugf("FetchDuration timeout\n")
-				break MessageLoop
-			}
-			// Check if message exists
-			if ms.DB.MessageExists(msg.MessageID) {
-				peerStat.LastPosition = msg.Counter
-				log.Debugf("fetch from peer: exists %s %s\n", utils.B58encode(msg.MessageID[:]), url)
-				continue MessageLoop // Message exists.
-			}
-			// Add message
-			err := ms.FetchPost(url, authtoken, msg.MessageID, msg.ExpireTime)
-			if err == nil || err == messagestore.ErrDuplicate {
+		posts := ms.FetchPosts(url, authtoken, ms.wanted(peer, messages))
+		results := ms.importMessages(peer, authtoken, messages, posts, startDate+ms.FetchDuration)
+		for _, result := range results {
+			switch result {
+			case importAdded:
 				// Reduce fetch.ErrorCount when downloads are successful
-				log.Debugf("fetch from peer: exists now %s %s\n", utils.B58encode(msg.MessageID[:]), url)
 				if peerStat.ErrorCount >= 2 {
 					peerStat.ErrorCount -= 2
 				}
-			} else if err != nil {
+				ms.relayNotify()
+			case importRejected:
+				peerStat.ErrorCount++
+			case importFailed:
 				peerStat.ErrorCount++
-				log.Debugf("Fetch err: %s %s\n", url, err)
 				doUpdate = false
-				// LastPosition will only advance if future downloads work
-				continue MessageLoop
 			}
-			log.Debugf("fetch from peer: added %s %s\n", utils.B58encode(msg.MessageID[:]), url)
-			peerStat.LastPosition = msg.Counter
-			ms.notifyChan <- true
+		}
+		position, complete := importedPosition(messages, results, peerStat.LastPosition)
+		peerStat.LastPosition = position
+		if !complete {
+			log.Debugf("Sync incomplete: %s position: %d\n", url, peerStat.LastPosition)
+			break FetchLoop
 		}
 		if !more { // No more messages
 			log.Debugf("Sync done. No More.\n")
@@ -285,39 +347,107 @@ FetchLoop:
 			break FetchLoop
 		}
 	}
+	if reached {
+		ms.peerSucceeded(PubKey, url)
+	}
 	// Write peer update
 	log.Debugf("fetch from peer: cycle done %s\n", url)
 	if doUpdate {
-		ms.DB.UpdatePeerFetchStat(PubKey, uint64(CurrentTime()), peerStat.LastPosition, peerStat.ErrorCount)
-	} else {
-		ms.DB.UpdatePeerFetchStat(PubKey, peerStat.LastFetch, peerStat.LastPosition, peerStat.ErrorCount)
+		peerStat.LastFetch = uint64(CurrentTime())
 	}
+	ms.DB.UpdatePeerFetchStat(PubKey, peerStat.LastFetch, peerStat.LastPosition, peerStat.ErrorCount)
+	ms.DB.UpdatePeerTombstone(PubKey, peerStat.LastTombstone)
+	stat.PeerLastPosition.With(url).Set(float64(peerStat.LastPosition))
+	stat.PeerErrors.With(url).Set(float64(peerStat.ErrorCount))
+	stat.PeerLastFetch.With(url).Set(float64(peerStat.LastFetch))
 }
 
-// FetchPost fetches a post from a peer and adds it.
-func (ms MessageServer) FetchPost(url, auth string, msgID [message.MessageIDSize]byte, expireRequest uint64) error {
+// FetchPost fetches a post from a peer and adds it. entry is the index entry of the peer, the
+// post is stored with the distance of the entry plus one.
+func (ms MessageServer) FetchPost(url, auth string, entry *structs.MessageStruct) error {
 	// Fetch the post
 	proto := repproto.New(ms.SocksProxy, "")
-	data, err := proto.GetSpecificAuth(url, auth, msgID[:])
+	data, err := proto.GetSpecificAuth(url, auth, entry.MessageID[:])
 	// data, err := proto.GetSpecific(url, msgID[:])
 	if err != nil {
 		return err
 	}
+	return ms.importPost(data, entry)
+}
+
+// FetchPosts downloads the posts of entries from a peer with fetchmany calls. Posts the peer did
+// not return are missing from the result, it is empty if the peer does not support fetchmany.
+func (ms MessageServer) FetchPosts(url, auth string, entries []*structs.MessageStruct) map[[message.MessageIDSize]byte][]byte {
+	posts := make(map[[message.MessageIDSize]byte][]byte)
+	if len(entries) < 2 {
+		return posts
+	}
+	messageIDs := make([][]byte, 0, len(entries))
+	for _, entry := range entries {
+		messageIDs = append(messageIDs, entry.MessageID[:])
+	}
+	proto := repproto.New(ms.SocksProxy, "")
+	err := proto.GetMany(url, auth, messageIDs, func(messageID, data []byte) error {
+		var id [message.MessageIDSize]byte
+		copy(id[:], messageID)
+		posts[id] = data
+		return nil
+	})
+	if err != nil {
+		log.Debugf("FetchMany err: %s %s\n", url, err)
+	}
+	log.Debugf("FetchMany: %s %d of %d\n", url, len(posts), len(entries))
+	return posts
+}
+
+// wanted returns the index entries of a peer that are missing locally and may be fetched.
+func (ms MessageServer) wanted(peer Peer, messages []*structs.MessageStruct) []*structs.MessageStruct {
+	var entries []*structs.MessageStruct
+	for _, msg := range messages {
+		if !ms.DB.MessageExists(msg.MessageID) && !ms.tooFar(msg.Distance) && !ms.relayed(peer, msg.Distance) {
+			entries = append(entries, msg)
+		}
+	}
+	return entries
+}
+
+// importPost verifies a post fetched from a peer and adds it. entry is the index entry of the
+// peer, the post is stored with the distance of the entry plus one.
+func (ms MessageServer) importPost(data []byte, entry *structs.MessageStruct) error {
+	if err := ms.addPost(data, entry.ExpireTime, entry.Distance+1); err != nil {
+		return err
+	}
+	// Replication diagnostics
+	stat.ImportDistance.Observe(float64(entry.Distance + 1))
+	if now := uint64(CurrentTime()); entry.PostTime > 0 && entry.PostTime <= now {
+		stat.ImportDelay.Observe(float64(now - entry.PostTime))
+	}
+	return nil
+}
+
+// tooFar returns true if a message at distance in the index of a peer exceeds MaxDistance when fetched.
+func (ms MessageServer) tooFar(distance uint64) bool {
+	return ms.MaxDistance > 0 && distance >= ms.MaxDistance
+}
+
+// addPost verifies a post fetched from a peer and adds it with the given distance. Returns
+// ErrBadPost if the post is corrupt or does not verify.
+func (ms MessageServer) addPost(data []byte, expireRequest, distance uint64) error {
 	// Verify and use it
 	signheader, err := message.Base64Message(data).GetSignHeader()
 	if err != nil {
 		log.Debugf("Bad fetch:GetSignHeader: %s\n", err)
-		return err
+		return ErrBadPost
 	}
 	details, err := message.VerifySignature(*signheader, ms.MinHashCashBits)
 	if err != nil {
 		log.Debugf("Bad fetch:VerifySignature: %s\n", err)
-		return err
+		return ErrBadPost
 	}
 	constantRecipientPub, MessageID, err := deferVerify(data)
 	if err != nil {
 		log.Debugf("Bad fetch:deferVerify: %s\n", err)
-		return err
+		return ErrBadPost
 	}
 	if *MessageID != details.MsgID {
 		log.Debugs("Bad fetch:MessageID\n")
@@ -331,6 +461,7 @@ func (ms MessageServer) FetchPost(url, auth string, msgID [message.MessageIDSize
 		Sync:                   false,
 		Hidden:                 false,
 		ExpireRequest:          expireRequest,
+		Distance:               distance,
 	}
 	if message.KeyIsSync(constantRecipientPub) {
 		msgStruct.Sync = true
@@ -347,3 +478,24 @@ func (ms MessageServer) FetchPost(url, auth string, msgID [message.MessageIDSize
 	sigStruct.MaxMessagesPosted, sigStruct.MaxMessagesRetained, sigStruct.ExpireTarget = ms.calcLimits(details.HashCashBits)
 	return ms.DB.Put(msgStruct, sigStruct, data)
 }
+
+// addPeerTombstone stores a tombstone fetched from a peer and deletes the message. An expiry
+// record of the peer expires the local copy of the message.
+func (ms MessageServer) addPeerTombstone(ts *structs.TombstoneStruct, peer Peer) error {
+	var err error
+	if ts.AuthType == structs.TombstoneExpire {
+		err = ms.DB.AddExpiry(ts, &peer.PubKey)
+	} else {
+		err = ms.DB.AddTombstone(ts, false)
+	}
+	url := peer.URL
+	switch err {
+	case nil:
+		log.Debugf("fetch from peer: deleted %s %s\n", utils.B58encode(ts.MessageID[:]), url)
+		ms.relayNotify()
+	case messagestore.ErrDuplicate, messagestore.ErrNotFound:
+	default:
+		log.Debugf("fetch from peer: tombstone %s %s %s\n", utils.B58encode(ts.MessageID[:]), url, err)
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agl/ed25519"
+	"github.com/repbin/repbin/cmd/repserver/stat"
+	log "github.com/repbin/repbin/deferconsole"
+	"github.com/repbin/repbin/utils/repproto"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+// Health states of peers.
+const (
+	HealthOK          = "healthy"     // The last contact succeeded
+	HealthBackoff     = "backoff"     // The peer failed and is contacted again after a delay
+	HealthQuarantined = "quarantined" // The peer failed repeatedly and is only probed
+)
+
+var healthStates = []string{HealthOK, HealthBackoff, HealthQuarantined}
+
+var healthMutex = new(sync.Mutex) // serializes health updates
+
+// healthState returns the health state of a peer.
+func healthState(peerStat *structs.PeerStruct) string {
+	switch {
+	case peerStat.QuarantinedSince != 0:
+		return HealthQuarantined
+	case peerStat.FailCount > 0:
+		return HealthBackoff
+	}
+	return HealthOK
+}
+
+// peerAvailable returns true if notifications and fetches may be sent to the peer.
+func peerAvailable(peerStat *structs.PeerStruct, now int64) bool {
+	return peerStat.QuarantinedSince == 0 && peerStat.RetryAfter <= uint64(now)
+}
+
+// backoff returns the time to wait after consecutive failures. It doubles from base up to max.
+func backoff(failures uint64, base, max int64) int64 {
+	if failures == 0 {
+		return 0
+	}
+	wait := base
+	for i := uint64(1); i < failures && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	return wait
+}
+
+// localError returns true if err was caused by the local proxy and not by the peer.
+func localError(err error) bool {
+	return strings.HasPrefix(err.Error(), "proxy:")
+}
+
+// errorReason returns the error message without the query of request URLs, which may contain
+// authentication tokens.
+func errorReason(err error) string {
+	if e, ok := err.(*url.Error); ok {
+		u := e.URL
+		if i := strings.Index(u, "?"); i >= 0 {
+			u = u[:i]
+		}
+		return e.Op + " " + u + ": " + e.Err.Error()
+	}
+	return err.Error()
+}
+
+// peerFailed records a failure to reach a peer. The peer is quarantined after PeerQuarantineAfter
+// consecutive failures, otherwise it is retried after an exponential backoff.
+func (ms MessageServer) peerFailed(pubKey *[ed25519.PublicKeySize]byte, peerURL, operation string, err error) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	peerStat := ms.DB.GetPeerStat(pubKey)
+	if peerStat == nil {
+		return
+	}
+	now := uint64(CurrentTime())
+	peerStat.FailCount++
+	peerStat.LastError = operation + ": " + errorReason(err)
+	peerStat.LastErrorTime = now
+	if peerStat.QuarantinedSince == 0 && ms.PeerQuarantineAfter > 0 && peerStat.FailCount >= uint64(ms.PeerQuarantineAfter) {
+		peerStat.QuarantinedSince = now
+		stat.PeerQuarantines.Inc()
+		log.Errorf("Peer quarantined: %s after %d failures, %s\n", peerURL, peerStat.FailCount, peerStat.LastError)
+	}
+	if peerStat.QuarantinedSince != 0 {
+		peerStat.RetryAfter = now + uint64(ms.PeerBackoffMax) // Next probe
+	} else {
+		peerStat.RetryAfter = now + uint64(backoff(peerStat.FailCount, ms.FetchDuration, ms.PeerBackoffMax))
+		log.Debugf("Peer backoff: %s %d failures, retry %s, %s\n", peerURL, peerStat.FailCount, format(peerStat.RetryAfter), peerStat.LastError)
+	}
+	ms.DB.UpdatePeerHealth(pubKey, peerStat.FailCount, peerStat.RetryAfter, peerStat.QuarantinedSince, peerStat.LastError, peerStat.LastErrorTime)
+	setHealthStat(peerURL, peerStat)
+}
+
+// peerSucceeded records a successful contact with a peer and ends backoff and quarantine.
+func (ms MessageServer) peerSucceeded(pubKey *[ed25519.PublicKeySize]byte, url string) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	peerStat := ms.DB.GetPeerStat(pubKey)
+	if peerStat == nil || healthState(peerStat) == HealthOK {
+		return
+	}
+	if peerStat.QuarantinedSince != 0 {
+		log.Printf("Peer recovered from quarantine: %s\n", url)
+	} else {
+		log.Debugf("Peer recovered: %s after %d failures\n", url, peerStat.FailCount)
+	}
+	peerStat.FailCount, peerStat.RetryAfter, peerStat.QuarantinedSince = 0, 0, 0
+	// The last error is kept for diagnostics
+	ms.DB.UpdatePeerHealth(pubKey, 0, 0, 0, peerStat.LastError, peerStat.LastErrorTime)
+	setHealthStat(url, peerStat)
+}
+
+// setHealthStat updates the health metrics of a peer.
+func setHealthStat(url string, peerStat *structs.PeerStruct) {
+	state := healthState(peerStat)
+	for _, s := range healthStates {
+		if s == state {
+			stat.PeerHealth.With(url, s).Set(1)
+		} else {
+			stat.PeerHealth.Delete(url, s)
+		}
+	}
+	stat.PeerFailures.With(url).Set(float64(peerStat.FailCount))
+}
+
+// CheckPeerHealth updates the health metrics of all peers and probes the quarantined peers
+// that are due by calling /id.
+func (ms MessageServer) CheckPeerHealth() {
+	var peers []Peer
+	systemPeersMutex.Lock()
+	for _, peer := range systemPeers {
+		peers = append(peers, peer)
+	}
+	systemPeersMutex.Unlock()
+	now := uint64(CurrentTime())
+	for _, peer := range peers {
+		peerStat := ms.DB.GetPeerStat(&peer.PubKey)
+		if peerStat == nil {
+			continue
+		}
+		setHealthStat(peer.URL, peerStat)
+		if peerStat.QuarantinedSince != 0 && peerStat.RetryAfter <= now {
+			go ms.probePeer(peer)
+		}
+	}
+}
+
+// probePeer tests if a quarantined peer is reachable again.
+func (ms MessageServer) probePeer(peer Peer) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	proto := repproto.New(ms.SocksProxy, "")
+	_, err := proto.IDContext(ctx, peer.URL)
+	if err != nil {
+		if localError(err) {
+			return
+		}
+		stat.PeerProbes.With("failed").Inc()
+		log.Debugf("Probe failed: %s %s\n", peer.URL, err)
+		ms.peerFailed(&peer.PubKey, peer.URL, "probe", err)
+		return
+	}
+	stat.PeerProbes.With("ok").Inc()
+	ms.peerSucceeded(&peer.PubKey, peer.URL)
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+func TestBackoff(t *testing.T) {
+	expect := []int64{0, 600, 1200, 2400, 4800, 9600, 19200, 21600, 21600}
+	for failures, wait := range expect {
+		if b := backoff(uint64(failures), 600, 21600); b != wait {
+			t.Errorf("Bad backoff for %d failures: %d != %d", failures, b, wait)
+		}
+	}
+	if b := backoff(1000, 600, 21600); b != 21600 {
+		t.Errorf("Backoff not limited: %d", b)
+	}
+}
+
+func TestPeerHealthState(t *testing.T) {
+	peerStat := new(structs.PeerStruct)
+	if healthState(peerStat) != HealthOK || !peerAvailable(peerStat, 10) {
+		t.Error("New peer not healthy")
+	}
+	peerStat.FailCount, peerStat.RetryAfter = 2, 20
+	if healthState(peerStat) != HealthBackoff || peerAvailable(peerStat, 10) || !peerAvailable(peerStat, 20) {
+		t.Error("Backoff not applied")
+	}
+	peerStat.QuarantinedSince = 5
+	if healthState(peerStat) != HealthQuarantined || peerAvailable(peerStat, 30) {
+		t.Error("Quarantined peer available")
+	}
+	if !localError(errors.New("proxy: connection refused")) || localError(errors.New("Server error: Bad peer")) {
+		t.Error("Proxy errors not detected")
+	}
+	err := &url.Error{Op: "Get", URL: "http://example.onion/notify?auth=secret", Err: errors.New("refused")}
+	if reason := errorReason(err); reason != "Get http://example.onion/notify: refused" {
+		t.Errorf("Bad reason: %s", reason)
+	}
+}
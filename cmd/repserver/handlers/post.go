@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"github.com/repbin/repbin/cmd/repserver/stat"
@@ -27,31 +28,47 @@ func deferVerify(d []byte) (*message.Curve25519Key, *[message.MessageIDSize]byte
 
 // ProcessPost verifies and adds a post to the database.
 func (ms MessageServer) ProcessPost(postdata io.ReadCloser, oneTime bool, expireRequest uint64) string {
+	_, err := ms.processPost(postdata, oneTime, expireRequest)
+	if err != nil {
+		return fmt.Sprintf("ERROR: %s\n", err)
+	}
+	return "SUCCESS: Connection close\n"
+}
+
+// processPost verifies and adds a post to the database. It returns the ID of the message.
+func (ms MessageServer) processPost(postdata io.ReadCloser, oneTime bool, expireRequest uint64) (id *[message.MessageIDSize]byte, err error) {
+	defer func() {
+		if err != nil {
+			stat.PostsRejected.With(errorCode(err)).Inc()
+		} else {
+			stat.PostsAccepted.Inc()
+		}
+	}()
 	data, err := utils.MaxRead(ms.MaxPostSize, postdata)
 	if err != nil {
-		return "ERROR: Message too big\n"
+		return nil, newAPIError(structs.CodeTooBig, "Message too big")
 	}
 	if len(data) < ms.MinPostSize {
-		return "ERROR: Message too small\n"
+		return nil, newAPIError(structs.CodeTooSmall, "Message too small")
 	}
 	signheader, err := message.Base64Message(data).GetSignHeader()
 	if err != nil {
 		log.Debugf("Post:GetSignHeader: %s\n", err)
-		return "ERROR: Sign Header\n"
+		return nil, newAPIError(structs.CodeBadMessage, "Sign Header")
 	}
 	details, err := message.VerifySignature(*signheader, ms.MinHashCashBits)
 	if err != nil {
 		log.Debugf("Post:VerifySignature: %s\n", err)
-		return "ERROR: HashCash\n"
+		return nil, newAPIError(structs.CodeHashCash, "HashCash")
 	}
 	constantRecipientPub, MessageID, err := deferVerify(data)
 	if err != nil {
 		log.Debugf("Post:deferVerify: %s\n", err)
-		return "ERROR: Verify\n"
+		return nil, newAPIError(structs.CodeBadMessage, "Verify")
 	}
 	if *MessageID != details.MsgID {
 		log.Debugs("Post:MessageID\n")
-		return "ERROR: MessageID\n"
+		return nil, newAPIError(structs.CodeBadMessage, "MessageID")
 	}
 	msgStruct := &structs.MessageStruct{
 		MessageID:              *MessageID,
@@ -86,35 +103,36 @@ func (ms MessageServer) ProcessPost(postdata io.ReadCloser, oneTime bool, expire
 	ms.RandomSleep()
 	if err != nil {
 		log.Debugf("Post:MessageDB: %s\n", err)
-		return fmt.Sprintf("ERROR: %s\n", err)
+		return nil, storeError(err)
 	}
 	log.Debugf("Post:Added: %s\n", utils.B58encode(MessageID[:]))
-	if ms.Stat {
-		stat.Input <- stat.Post
-	}
-	return "SUCCESS: Connection close\n"
+	return MessageID, nil
 }
 
 // GenPostHandler returns a handler for message posting.
 func (ms MessageServer) GenPostHandler(oneTime bool) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var expireRequest uint64
 		w.Header().Set("Content-Type", "text/plain; charset=us-ascii")
 		if r.Method != "POST" {
 			io.WriteString(w, "ERROR: Bad Method\n")
 			return
 		}
-		getValues := r.URL.Query()
-		if getValues != nil {
-			if v, ok := getValues["expire"]; ok {
-				expire, err := strconv.Atoi(v[0])
-				if err != nil {
-					expireRequest = uint64(expire)
-				}
-			}
-		}
-		res := ms.ProcessPost(r.Body, oneTime, expireRequest)
+		res := ms.ProcessPost(r.Body, oneTime, expireParam(r.URL.Query()))
 		io.WriteString(w, res)
 		return
 	}
 }
+
+// expireParam returns the expire request of a post.
+func expireParam(getValues url.Values) uint64 {
+	var expireRequest uint64
+	if getValues != nil {
+		if v, ok := getValues["expire"]; ok {
+			expire, err := strconv.Atoi(v[0])
+			if err != nil {
+				expireRequest = uint64(expire)
+			}
+		}
+	}
+	return expireRequest
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/agl/ed25519"
+	"github.com/repbin/repbin/cmd/repserver/messagestore"
+	"github.com/repbin/repbin/cmd/repserver/stat"
+	log "github.com/repbin/repbin/deferconsole"
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/keyproof"
+	"github.com/repbin/repbin/utils/repproto"
+)
+
+// readThroughMaxMisses is the maximum number of entries in the negative cache.
+const readThroughMaxMisses = 10000
+
+// readThrough limits the concurrent lookups of missing messages at peers and caches misses.
+type readThrough struct {
+	workers chan struct{}
+	mutex   sync.Mutex
+	misses  map[[message.MessageIDSize]byte]int64 // time until which a miss is cached
+}
+
+func newReadThrough(workers int) *readThrough {
+	if workers < 1 {
+		workers = 1
+	}
+	return &readThrough{
+		workers: make(chan struct{}, workers),
+		misses:  make(map[[message.MessageIDSize]byte]int64),
+	}
+}
+
+// cached returns true if a lookup of messageID failed recently.
+func (rt *readThrough) cached(messageID *[message.MessageIDSize]byte, now int64) bool {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	until, ok := rt.misses[*messageID]
+	if ok && until < now {
+		delete(rt.misses, *messageID)
+		return false
+	}
+	return ok
+}
+
+// miss caches a failed lookup until the given time.
+func (rt *readThrough) miss(messageID *[message.MessageIDSize]byte, now, until int64) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if len(rt.misses) >= readThroughMaxMisses {
+		for id, t := range rt.misses {
+			if t < now {
+				delete(rt.misses, id)
+			}
+		}
+		if len(rt.misses) >= readThroughMaxMisses {
+			rt.misses = make(map[[message.MessageIDSize]byte]int64)
+		}
+	}
+	rt.misses[*messageID] = until
+}
+
+// authPeer is a peer that can be asked for messages and peer records.
+type authPeer struct {
+	pubKey [ed25519.PublicKeySize]byte
+	url    string
+	auth   string
+}
+
+// authPeers returns the peers that gave us an authentication token and are not quarantined.
+func (ms MessageServer) authPeers() []authPeer {
+	var candidates []authPeer
+	var peers []authPeer
+	var noToken [keyproof.ProofTokenSignedSize]byte
+	systemPeersMutex.Lock()
+	for pubKey, peer := range systemPeers {
+		candidates = append(candidates, authPeer{pubKey: pubKey, url: peer.URL})
+	}
+	systemPeersMutex.Unlock()
+	for _, peer := range candidates {
+		peerStat := ms.DB.GetPeerStat(&peer.pubKey)
+		if peerStat == nil || peerStat.AuthToken == noToken || peerStat.QuarantinedSince != 0 {
+			continue
+		}
+		peer.auth = utils.B58encode(peerStat.AuthToken[:])
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// fetchReadThrough asks the peers for a message that is not stored locally. The message is
+// verified and stored before it is returned. Peers may forward the request at most hops times.
+func (ms MessageServer) fetchReadThrough(messageID *[message.MessageIDSize]byte, hops int) ([]byte, error) {
+	now := CurrentTime()
+	if ms.readThrough.cached(messageID, now) {
+		stat.ReadThrough.With("cached").Inc()
+		return nil, messagestore.ErrNotFound
+	}
+	select {
+	case ms.readThrough.workers <- struct{}{}:
+		defer func() { <-ms.readThrough.workers }()
+	default:
+		stat.ReadThrough.With("busy").Inc()
+		return nil, messagestore.ErrNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(ms.ReadThroughTimeout)*time.Second)
+	defer cancel()
+	proto := repproto.New(ms.SocksProxy, "")
+	for _, peer := range ms.authPeers() {
+		data, err := proto.GetSpecificAuthHopsContext(ctx, peer.url, peer.auth, messageID[:], hops)
+		if err == nil {
+			err = ms.addPost(data, 0, 1) // The distance at the peer is unknown
+		}
+		if err == nil || err == messagestore.ErrDuplicate {
+			if data, err := ms.DB.Fetch(messageID); err == nil {
+				log.Debugf("Read-through: %s %s\n", utils.B58encode(messageID[:]), peer.url)
+				stat.ReadThrough.With("found").Inc()
+				ms.relayNotify()
+				return data, nil
+			}
+		}
+		if err == messagestore.ErrDeleted {
+			stat.ReadThrough.With("deleted").Inc()
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	ms.readThrough.miss(messageID, now, now+ms.ReadThroughCache)
+	stat.ReadThrough.With("miss").Inc()
+	return nil, messagestore.ErrNotFound
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/repbin/repbin/message"
+)
+
+func TestReadThroughCache(t *testing.T) {
+	rt := newReadThrough(1)
+	var id [message.MessageIDSize]byte
+	if rt.cached(&id, 10) {
+		t.Error("Miss cached without lookup")
+	}
+	rt.miss(&id, 10, 20)
+	if !rt.cached(&id, 15) {
+		t.Error("Miss not cached")
+	}
+	if rt.cached(&id, 21) {
+		t.Error("Miss cached too long")
+	}
+	for i := 0; i < readThroughMaxMisses+1; i++ {
+		id[0], id[1] = byte(i), byte(i>>8)
+		rt.miss(&id, 10, 20)
+	}
+	if len(rt.misses) > readThroughMaxMisses {
+		t.Errorf("Cache not limited: %d", len(rt.misses))
+	}
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/repbin/repbin/cmd/repserver/messagestore"
+	"github.com/repbin/repbin/cmd/repserver/stat"
+	log "github.com/repbin/repbin/deferconsole"
+	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/repproto"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+// maxReconcileRequest is the maximum size of a reconcile request body.
+const maxReconcileRequest = 65536
+
+var (
+	// ErrReconcileIncomplete is returned if reconciliation stopped before all differences were resolved.
+	ErrReconcileIncomplete = errors.New("server: Reconciliation incomplete")
+	// ErrReconcileRange is returned if a peer returned an invalid range.
+	ErrReconcileRange = errors.New("server: Bad reconcile range")
+)
+
+// ReconcileV2 compares the ranges posted by a peer with the own message set and returns the
+// ranges that differ.
+func (ms MessageServer) ReconcileV2(w http.ResponseWriter, r *http.Request) {
+	ranges, next, err := ms.reconcile(r)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	writeJSON(w, &structs.APIResponse{Ranges: ranges, Next: next})
+}
+
+// reconcile verifies a reconcile request. Equal ranges are skipped. Differing ranges are returned
+// with their entries if they are small enough, otherwise split into subranges.
+func (ms MessageServer) reconcile(r *http.Request) (ranges []structs.ReconcileRange, next uint64, err error) {
+	if r.Method != "POST" {
+		return nil, 0, newAPIError(structs.CodeBadMethod, "Bad Method")
+	}
+	auth := r.URL.Query().Get("auth")
+	if auth == "" {
+		return nil, 0, errMissingParam
+	}
+	if err := ms.AuthenticatePeer(auth); err != nil {
+		return nil, 0, err
+	}
+	request := new(structs.ReconcileRequest)
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxReconcileRequest)).Decode(request); err != nil {
+		return nil, 0, errBadParam
+	}
+	if len(request.Ranges) > structs.ReconcileMaxRanges {
+		return nil, 0, errBadParam
+	}
+	// Read head first, messages added later are fetched from the global index
+	head, err := ms.DB.GlobalIndexHead()
+	if err != nil {
+		log.Debugf("Reconcile:GlobalIndexHead: %s\n", err)
+		return nil, 0, newAPIError(structs.CodeInternal, "Reconcile failed")
+	}
+	for _, peerRange := range request.Ranges {
+		if !structs.ValidReconcilePrefix(peerRange.Prefix) {
+			return nil, 0, errBadParam
+		}
+		own, messages, err := ms.reconcileRange(peerRange.Prefix)
+		if err != nil {
+			log.Debugf("Reconcile:SyncIDs: %s\n", err)
+			return nil, 0, newAPIError(structs.CodeInternal, "Reconcile failed")
+		}
+		if own.Equal(peerRange) {
+			continue
+		}
+		if own.Count <= structs.ReconcileLeafSize {
+			ranges = append(ranges, own.WithEntries(messages))
+			continue
+		}
+		subRanges, subMessages := structs.SplitReconcileRange(own.Prefix, messages)
+		for i, subRange := range subRanges {
+			if subRange.Count <= structs.ReconcileLeafSize {
+				subRange = subRange.WithEntries(subMessages[i])
+			}
+			ranges = append(ranges, subRange)
+		}
+	}
+	return ranges, head + 1, nil
+}
+
+// reconcileRange returns the own range for prefix and its messages.
+func (ms MessageServer) reconcileRange(prefix string) (structs.ReconcileRange, []*structs.MessageStruct, error) {
+	messages, err := ms.DB.SyncIDs(prefix)
+	if err != nil {
+		return structs.ReconcileRange{}, nil, err
+	}
+	return structs.NewReconcileRange(prefix, messages), messages, nil
+}
+
+// reconcilePeer downloads the messages of a peer that are missing locally. Instead of walking the
+// global index of the peer the message sets are compared by range fingerprints. Messages that
+// expired locally remain known and are not downloaded again. Returns the position in the global
+// index of the peer up to which all messages are known locally, and the number of failed downloads.
+// Messages rejected permanently are counted as failed but do not hold the position.
+func (ms MessageServer) reconcilePeer(peer Peer, authtoken string, deadline int64) (position uint64, failed uint64, err error) {
+	url := peer.URL
+	proto := repproto.New(ms.SocksProxy, "")
+	root, _, err := ms.reconcileRange("")
+	if err != nil {
+		return 0, 0, err
+	}
+	var next uint64
+	var incomplete bool
+	pending := []structs.ReconcileRange{root}
+	for len(pending) > 0 {
+		if deadline <= CurrentTime() {
+			return 0, failed, ErrReconcileIncomplete
+		}
+		batch := pending
+		if len(batch) > structs.ReconcileMaxRanges {
+			batch = batch[:structs.ReconcileMaxRanges]
+		}
+		pending = pending[len(batch):]
+		log.Debugf("Reconcile: %s ranges: %d\n", url, len(batch))
+		ranges, head, err := proto.Reconcile(url, authtoken, batch)
+		if err != nil {
+			return 0, failed, err
+		}
+		if next == 0 {
+			next = head
+		}
+		for _, peerRange := range ranges {
+			if !structs.ValidReconcilePrefix(peerRange.Prefix) {
+				return 0, failed, ErrReconcileRange
+			}
+			if peerRange.Count > structs.ReconcileLeafSize {
+				own, _, err := ms.reconcileRange(peerRange.Prefix)
+				if err != nil {
+					return 0, failed, err
+				}
+				if !own.Equal(peerRange) {
+					pending = append(pending, own)
+				}
+				continue
+			}
+			messages := peerRange.MessageStructs()
+			if messages == nil || uint64(len(messages)) != peerRange.Count {
+				return 0, failed, ErrReconcileRange
+			}
+			wanted := ms.wanted(peer, messages)
+			posts := ms.FetchPosts(url, authtoken, wanted)
+			for _, msg := range wanted {
+				if deadline <= CurrentTime() {
+					return 0, failed, ErrReconcileIncomplete
+				}
+				var err error
+				if data, ok := posts[msg.MessageID]; ok {
+					err = ms.importPost(data, msg)
+				} else {
+					err = ms.FetchPost(url, authtoken, msg)
+				}
+				if err != nil && err != messagestore.ErrDuplicate {
+					log.Debugf("Reconcile fetch err: %s %s\n", url, err)
+					failed++
+					if !rejected(err, msg) {
+						incomplete = true
+					}
+					continue
+				}
+				log.Debugf("Reconcile: added %s %s\n", utils.B58encode(msg.MessageID[:]), url)
+				stat.ReconciledMessages.Inc()
+				ms.relayNotify()
+			}
+		}
+	}
+	if incomplete {
+		return 0, failed, ErrReconcileIncomplete
+	}
+	if next == 0 {
+		return 0, failed, nil
+	}
+	return next - 1, failed, nil
+}
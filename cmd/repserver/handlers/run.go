@@ -23,6 +23,7 @@ func (ms MessageServer) RunServer() {
 
 	// Peering
 	ms.notifyChan = make(chan bool, 3)
+	ms.readThrough = newReadThrough(ms.ReadThroughWorkers)
 	// Load peers
 	ms.LoadPeers()
 	// Start statistics goroutine
@@ -31,6 +32,10 @@ func (ms MessageServer) RunServer() {
 	}
 	// Start timers
 	go ms.notifyWatch()
+	go ms.DB.UpdateBlobSize()
+	if ms.AdminListen != "" {
+		go ms.runAdmin()
+	}
 	// static file handler
 	httpHandlers := http.NewServeMux()
 
@@ -38,6 +43,7 @@ func (ms MessageServer) RunServer() {
 	httpHandlers.HandleFunc("/id", ms.ServeID)
 	if !ms.HubOnly {
 		httpHandlers.HandleFunc("/keyindex", ms.GetKeyIndex)
+		httpHandlers.HandleFunc("/keyindex/watch", ms.WatchKeyIndex)
 		httpHandlers.HandleFunc("/post", ms.GenPostHandler(false))
 		if ms.EnableOneTimeHandler {
 			httpHandlers.HandleFunc("/local/post", ms.GenPostHandler(true))
@@ -48,13 +54,48 @@ func (ms MessageServer) RunServer() {
 	}
 	httpHandlers.HandleFunc("/globalindex", ms.GetGlobalIndex)
 	httpHandlers.HandleFunc("/fetch", ms.Fetch)
+	httpHandlers.HandleFunc("/fetchmany", ms.FetchMany)
 	httpHandlers.HandleFunc("/notify", ms.GetNotify)
+	// JSON protocol
+	httpHandlers.HandleFunc("/v2/id", ms.ServeID)
+	if !ms.HubOnly {
+		httpHandlers.HandleFunc("/v2/keyindex", ms.GetKeyIndexV2)
+		httpHandlers.HandleFunc("/v2/keyindex/watch", ms.WatchKeyIndexV2)
+		httpHandlers.HandleFunc("/v2/post", ms.GenPostHandlerV2(false))
+		if ms.EnableOneTimeHandler {
+			httpHandlers.HandleFunc("/v2/local/post", ms.GenPostHandlerV2(true))
+		}
+	}
+	httpHandlers.HandleFunc("/v2/globalindex", ms.GetGlobalIndexV2)
+	httpHandlers.HandleFunc("/v2/reconcile", ms.ReconcileV2)
+	httpHandlers.HandleFunc("/v2/fetch", ms.FetchV2)
+	httpHandlers.HandleFunc("/v2/fetchmany", ms.FetchManyV2)
+	httpHandlers.HandleFunc("/v2/notify", ms.GetNotifyV2)
+	httpHandlers.HandleFunc("/v2/peers", ms.PeersV2)
+	httpHandlers.HandleFunc("/v2/snapshot", ms.SnapshotV2)
 	httpServer := &http.Server{
 		Addr:           "127.0.0.1:" + strconv.Itoa(ms.ListenPort),
 		Handler:        httpHandlers,
-		ReadTimeout:    90 * time.Second,
-		WriteTimeout:   90 * time.Second,
+		ReadTimeout:    HTTPTimeout * time.Second,
+		WriteTimeout:   HTTPTimeout * time.Second,
 		MaxHeaderBytes: 1 << 20,
 	}
 	httpServer.ListenAndServe()
 }
+
+// runAdmin starts the admin HTTP handlers. They must not be reachable from the network.
+func (ms MessageServer) runAdmin() {
+	adminHandlers := http.NewServeMux()
+	adminHandlers.Handle("/metrics", stat.Default)
+	adminHandlers.HandleFunc("/peers", ms.ServePeers)
+	adminServer := &http.Server{
+		Addr:           ms.AdminListen,
+		Handler:        adminHandlers,
+		ReadTimeout:    HTTPTimeout * time.Second,
+		WriteTimeout:   HTTPTimeout * time.Second,
+		MaxHeaderBytes: 1 << 20,
+	}
+	if err := adminServer.ListenAndServe(); err != nil {
+		log.Errorf("Admin listener: %s\n", err)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/repbin/repbin/message"
 	"github.com/repbin/repbin/utils"
 	"github.com/repbin/repbin/utils/keyauth"
+	"github.com/repbin/repbin/utils/repproto/structs"
 )
 
 // Version of this release
@@ -43,12 +44,20 @@ const (
 	DefaultFetchDuration = 600 // every 10min
 	// DefaultFetchMax is the number of messages to fetch from a peer per call
 	DefaultFetchMax = 30
+	// DefaultFetchWorkers is the number of messages downloaded and verified concurrently per peer
+	DefaultFetchWorkers = 4
 	// DefaultListenPort is the port on which to listen for HTTP connections
 	DefaultListenPort = 8080
 	// DefaultStepLimit is the extra number of bits to overcome for boost to apply in hashcash limits calculation
 	DefaultStepLimit = 2
 	// DefaultExpireDuration is the time between expire runs
 	DefaultExpireDuration = 3600
+	// DefaultExpireBatchSize is the number of expired messages deleted per transaction
+	DefaultExpireBatchSize = 500
+	// DefaultExpireBudget is the maximum time in seconds an expire run spends deleting messages
+	DefaultExpireBudget = 60
+	// DefaultMaxBlobMismatches is the number of orphaned blobs and messages without blob the server starts with
+	DefaultMaxBlobMismatches = 100
 	// DefaultMaxTimeSkew is the maximum time skew to allow and use
 	DefaultMaxTimeSkew = 86400
 	// DefaultMinStoreTime is the minimum time to store a message, in seconds
@@ -61,11 +70,37 @@ const (
 	DefaultMaxAgeSigners = int64(31536000)
 	// DefaultMaxAgeRecipients defines when to delete recipients that are not active anymore
 	DefaultMaxAgeRecipients = int64(31536000)
+	// DefaultWatchTimeout is the maximum time in seconds a key index watch waits for new messages
+	DefaultWatchTimeout = 60
+	// HTTPTimeout is the read and write timeout of the HTTP listeners, in seconds
+	HTTPTimeout = 90
+	// MaxWatchTimeout is the largest WatchTimeout that leaves time to write the reply before HTTPTimeout
+	MaxWatchTimeout = HTTPTimeout - 10
+	// DefaultReadThroughWorkers is the maximum number of concurrent read-through lookups
+	DefaultReadThroughWorkers = 4
+	// DefaultReadThroughHops is the number of servers a read-through lookup may pass
+	DefaultReadThroughHops = 1
+	// DefaultReadThroughCache is the time in seconds a failed read-through lookup is cached
+	DefaultReadThroughCache = 300
+	// DefaultReadThroughTimeout is the maximum time in seconds of a read-through lookup
+	DefaultReadThroughTimeout = 60
+	// DefaultPeerQuarantineAfter is the number of consecutive failures after which a peer is quarantined
+	DefaultPeerQuarantineAfter = 8
+	// DefaultPeerBackoffMax is the maximum time in seconds between attempts to reach a failing peer
+	DefaultPeerBackoffMax = 21600
+	// DefaultPeerTrust is the trust policy for discovered peers
+	DefaultPeerTrust = structs.TrustManual
+	// DefaultPeerTrustThreshold is the number of peers that must announce a discovered peer under the "wot" policy
+	DefaultPeerTrustThreshold = 2
+	// DefaultPeerRecordAge is the maximum age in seconds of peer records accepted from peers
+	DefaultPeerRecordAge = 604800
 )
 
 var (
 	// ErrBadMessageID .
 	ErrBadMessageID = errors.New("server: MessageID unexpected")
+	// ErrBadPost is returned if a message fetched from a peer is corrupt or does not verify
+	ErrBadPost = errors.New("server: Fetched message does not verify")
 	// ErrNoMore .
 	ErrNoMore = errors.New("fileback: No more entries")
 )
@@ -99,10 +134,15 @@ type MessageServer struct {
 	MaxIndexKey          int64  // Maximum entries from key index
 	MaxAuthTokenAge      int64  // Maximum age of peer authentication token
 	PeerFile             string // File containing the peer information
+	DiscoveredFile       string // File containing the discovered peers
 	NotifyDuration       int64  // Time between notifications
 	FetchDuration        int64  // Time between fetches
 	FetchMax             int    // Maximum messages to fetch per call to peer
+	FetchWorkers         int    // Number of messages downloaded and verified concurrently per peer
 	ExpireDuration       int64  // Time between expire runs
+	ExpireBatchSize      int    // Number of expired messages deleted per transaction
+	ExpireBudget         int64  // Maximum time an expire run spends deleting messages. 0 for no limit
+	MaxBlobMismatches    int    // Orphaned blobs and messages without blob accepted on start. Negative for no limit
 	SocksProxy           string // Socks5 proxy
 	EnableDeleteHandler  bool   // should the delete handler be enabled?
 	EnableOneTimeHandler bool   // should the one-time message handler be enabled?
@@ -115,6 +155,21 @@ type MessageServer struct {
 	Stat                 bool   // calculate and show server usage statistics
 	MaxAgeSigners        int64
 	MaxAgeRecipients     int64
+	WatchTimeout         int64  // Maximum time a key index watch waits for messages
+	AdminListen          string // Address of the admin listener (metrics). Disabled if empty
+	Reconcile            bool   // Reconcile the message sets with peers before walking their global index
+	MaxDistance          uint64 // Do not fetch messages from peers that traveled this many hops. 0 for no limit
+	ReadThrough          bool   // Ask peers for messages that are not stored locally
+	ReadThroughWorkers   int    // Maximum number of concurrent read-through lookups
+	ReadThroughHops      int    // Number of servers a read-through lookup may pass
+	ReadThroughCache     int64  // Time in seconds a failed read-through lookup is cached
+	ReadThroughTimeout   int64  // Maximum time in seconds of a read-through lookup
+	PeerQuarantineAfter  int    // Consecutive failures after which a peer is quarantined. 0 to never quarantine
+	PeerBackoffMax       int64  // Maximum time in seconds between attempts to reach a failing peer
+	PeerTrust            string // Trust policy for discovered peers (off, manual, tofu, wot)
+	PeerTrustThreshold   int    // Number of peers that must announce a discovered peer under the "wot" policy
+
+	readThrough *readThrough // Read-through state, created by RunServer
 
 	notifyChan chan bool // Notification channel. Write to notify system about new message
 }
@@ -124,10 +179,12 @@ type ServerInfo struct {
 	Time            int64
 	AuthPubKey      string
 	AuthChallenge   string
-	MaxPostSize     int64    // Maximum post size
-	MinPostSize     int      // Minimum post size
-	MinHashCashBits byte     // Minimum hashcash bits required
-	Peers           []string // list of known peers
+	MaxPostSize     int64               // Maximum post size
+	MinPostSize     int                 // Minimum post size
+	MinHashCashBits byte                // Minimum hashcash bits required
+	Peers           []string            // list of known peers
+	APIVersion      int                 // Highest protocol version supported, served under /v2/
+	PeerRecord      *structs.PeerRecord `json:",omitempty"` // Signed peer record of the server
 }
 
 // New returns a MessageServer.
@@ -140,6 +197,7 @@ func New(driver, url, path string, pubKey, privKey []byte) (*MessageServer, erro
 	}
 	ms.path = path
 	ms.PeerFile = path + "peers.config"
+	ms.DiscoveredFile = path + "discovered.config"
 	ms.AddToPeer = DefaultAddToPeer
 	ms.MaxTimeSkew = DefaultMaxTimeSkew
 	ms.MinPostSize = MinPostSize
@@ -153,15 +211,32 @@ func New(driver, url, path string, pubKey, privKey []byte) (*MessageServer, erro
 	ms.NotifyDuration = DefaultNotifyDuration
 	ms.FetchDuration = DefaultFetchDuration
 	ms.FetchMax = DefaultFetchMax
+	ms.FetchWorkers = DefaultFetchWorkers
 	ms.ExpireDuration = DefaultExpireDuration
+	ms.ExpireBatchSize = DefaultExpireBatchSize
+	ms.ExpireBudget = DefaultExpireBudget
+	ms.MaxBlobMismatches = DefaultMaxBlobMismatches
 	ms.StepLimit = DefaultStepLimit
 	ms.ListenPort = DefaultListenPort
 	ms.MinStoreTime = DefaultMinStoreTime
 	ms.MaxStoreTime = DefaultMaxStoreTime
 	ms.MaxAgeSigners = DefaultMaxAgeSigners
 	ms.MaxAgeRecipients = DefaultMaxAgeRecipients
+	ms.WatchTimeout = DefaultWatchTimeout
+	ms.Reconcile = true
+	ms.ReadThroughWorkers = DefaultReadThroughWorkers
+	ms.ReadThroughHops = DefaultReadThroughHops
+	ms.ReadThroughCache = DefaultReadThroughCache
+	ms.ReadThroughTimeout = DefaultReadThroughTimeout
+	ms.PeerQuarantineAfter = DefaultPeerQuarantineAfter
+	ms.PeerBackoffMax = DefaultPeerBackoffMax
+	ms.PeerTrust = DefaultPeerTrust
+	ms.PeerTrustThreshold = DefaultPeerTrustThreshold
 	messagestore.MaxAgeRecipients = DefaultMaxAgeRecipients
 	messagestore.MaxAgeSigners = DefaultMaxAgeSigners
+	messagestore.ExpireBatchSize = DefaultExpireBatchSize
+	messagestore.ExpireBudget = DefaultExpireBudget
+	messagestore.MaxBlobMismatches = DefaultMaxBlobMismatches
 	ms.EnablePeerHandler = true
 
 	ms.authPrivKey, err = message.GenLongTermKey(true, false)
@@ -182,6 +257,7 @@ func New(driver, url, path string, pubKey, privKey []byte) (*MessageServer, erro
 			return nil, err
 		}
 	}
+	ms.DB.SetPeeringKey(ms.TokenPubKey, ms.TokenPrivKey)
 	ms.InfoStruct = new(ServerInfo)
 	ms.InfoStruct.MinHashCashBits = ms.MinHashCashBits
 	ms.InfoStruct.MinPostSize = ms.MinPostSize
@@ -235,10 +311,12 @@ func (ms MessageServer) ServeID(w http.ResponseWriter, r *http.Request) {
 		MaxPostSize:     int64(messagestore.MaxMessageSize),
 		MinPostSize:     ms.InfoStruct.MinPostSize,
 		MinHashCashBits: ms.InfoStruct.MinHashCashBits,
+		APIVersion:      structs.APIVersion,
 	}
 	if ms.EnablePeerHandler {
 		info.Peers = ms.getPeerURLs()
 	}
+	info.PeerRecord = ms.ownPeerRecord()
 	ms.RandomSleep()
 	w.Header().Set("Content-Type", "text/json")
 	b, err := json.Marshal(info)
@@ -49,6 +49,13 @@ func TestGenPostHandler(t *testing.T) {
 	http.HandleFunc("/fetch", ms.Fetch)
 	http.HandleFunc("/notify", ms.GetNotify)
 	http.HandleFunc("/delete", ms.Delete)
+	http.HandleFunc("/v2/id", ms.ServeID)
+	http.HandleFunc("/v2/keyindex", ms.GetKeyIndexV2)
+	http.HandleFunc("/v2/globalindex", ms.GetGlobalIndexV2)
+	http.HandleFunc("/v2/post", ms.GenPostHandlerV2(false))
+	http.HandleFunc("/v2/local/post", ms.GenPostHandlerV2(true))
+	http.HandleFunc("/v2/fetch", ms.FetchV2)
+	http.HandleFunc("/v2/notify", ms.GetNotifyV2)
 	go http.ListenAndServe(":8080", nil)
 	time.Sleep(time.Second / 100)
 	if !testing.Short() {
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/repbin/repbin/cmd/repserver/messagestore"
+	"github.com/repbin/repbin/cmd/repserver/stat"
+	log "github.com/repbin/repbin/deferconsole"
+	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/keyproof"
+	"github.com/repbin/repbin/utils/repproto"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+const (
+	// snapshotBatch is the number of global index entries read per query for snapshots.
+	snapshotBatch = 100
+	// snapshotBudget is the time in seconds a snapshot is written before it is ended with More,
+	// to stay below the write timeout of the server.
+	snapshotBudget = 60
+)
+
+var (
+	// ErrUnknownPeer is returned if the bootstrap peer is not in the peer list.
+	ErrUnknownPeer = errors.New("server: Unknown peer")
+)
+
+// SnapshotV2 streams a gzip compressed snapshot of the live messages to a peer, beginning with
+// global index position start. The peer authenticates with a proof token like for notifications.
+// One-time messages are not included since reading them deletes them.
+func (ms MessageServer) SnapshotV2(w http.ResponseWriter, r *http.Request) {
+	getValues := r.URL.Query()
+	auth := getValues.Get("auth")
+	if auth == "" {
+		writeJSONError(w, errMissingParam)
+		return
+	}
+	_, _, url, err := ms.verifyPeerProof(auth)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	start, _ := strconv.ParseInt(getValues.Get("start"), 10, 64)
+	head, err := ms.DB.GlobalIndexHead()
+	if err != nil {
+		log.Debugf("Snapshot:GlobalIndexHead: %s\n", err)
+		writeJSONError(w, newAPIError(structs.CodeInternal, "Snapshot failed"))
+		return
+	}
+	log.Printf("Snapshot: %s start: %d head: %d\n", url, start, head)
+	w.Header().Set("Content-Type", "application/gzip")
+	gz := gzip.NewWriter(w)
+	defer gz.Close()
+	enc := json.NewEncoder(gz)
+	if err := enc.Encode(&structs.SnapshotRecord{Version: structs.SnapshotVersion, Head: head}); err != nil {
+		return
+	}
+	next, more, count := ms.writeSnapshot(enc, start, head, time.Now().Add(snapshotBudget*time.Second))
+	if next < 0 {
+		return // Aborted
+	}
+	enc.Encode(&structs.SnapshotRecord{End: true, Next: uint64(next), More: more})
+	log.Printf("Snapshot done: %s messages: %d next: %d more: %t\n", url, count, next, more)
+}
+
+// writeSnapshot writes the messages from start up to head until deadline. It returns the start
+// parameter to continue the snapshot, or -1 if the snapshot could not be written.
+func (ms MessageServer) writeSnapshot(enc *json.Encoder, start int64, head uint64, deadline time.Time) (next int64, more bool, count int) {
+	next = start
+	for {
+		if time.Now().After(deadline) {
+			return next, true, count
+		}
+		messages, _, err := ms.DB.GetGlobalIndex(next, snapshotBatch)
+		if err != nil && err != ErrNoMore {
+			log.Debugf("Snapshot:GetGlobalIndex: %s\n", err)
+			return -1, false, count
+		}
+		if len(messages) == 0 {
+			return next, false, count
+		}
+		for _, msg := range messages {
+			msgStruct := structs.MessageStructDecode(msg)
+			if msgStruct == nil {
+				continue
+			}
+			if msgStruct.Counter > head {
+				return next, false, count
+			}
+			next = int64(msgStruct.Counter) + 1
+			if msgStruct.OneTime {
+				continue
+			}
+			data, err := ms.DB.Fetch(&msgStruct.MessageID)
+			if err != nil {
+				continue // Expired or deleted meanwhile
+			}
+			entry := structs.NewIndexEntry(msgStruct)
+			if err := enc.Encode(&structs.SnapshotRecord{Message: &entry, Data: string(data)}); err != nil {
+				log.Debugf("Snapshot: %s\n", err)
+				return -1, false, count
+			}
+			stat.SnapshotMessages.With("exported").Inc()
+			count++
+		}
+		if len(messages) < snapshotBatch {
+			return next, false, count
+		}
+	}
+}
+
+// Bootstrap imports the snapshot of the peer with URL peerURL. The messages are verified like
+// fetched ones. The position of the peer is set to the end of the snapshot so that the following
+// synchronization continues there. Messages that are rejected for good are skipped, a transient
+// failure interrupts the bootstrap. An interrupted bootstrap resumes at the last imported position.
+func (ms MessageServer) Bootstrap(peerURL string) error {
+	var peer *Peer
+	ms.LoadPeers()
+	systemPeersMutex.Lock()
+	for _, p := range systemPeers {
+		if p.URL == peerURL {
+			p := p
+			peer = &p
+		}
+	}
+	systemPeersMutex.Unlock()
+	if peer == nil {
+		return ErrUnknownPeer
+	}
+	peerStat := ms.DB.GetPeerStat(&peer.PubKey)
+	if peerStat == nil {
+		return ErrUnknownPeer
+	}
+	start := peerStat.LastPosition
+	if start != 0 {
+		start++
+	}
+	var records, imported, failed int
+	proto := repproto.New(ms.SocksProxy, "")
+	for {
+		auth := keyproof.SignProofToken(CurrentTime()+ms.TimeSkew, &peer.PubKey, ms.TokenPubKey, ms.TokenPrivKey)
+		snapshot, err := proto.Snapshot(peerURL, utils.B58encode(auth[:]), start)
+		if err != nil {
+			return err
+		}
+		log.Printf("Bootstrap: %s start: %d head: %d\n", peerURL, start, snapshot.Head)
+		for {
+			record, err := snapshot.Next()
+			if err != nil {
+				snapshot.Close()
+				log.Printf("Bootstrap interrupted: %s imported: %d failed: %d\n", peerURL, imported, failed)
+				return err
+			}
+			if record == nil {
+				break
+			}
+			switch err := ms.importRecord(record); {
+			case err == nil:
+				imported++
+				stat.SnapshotMessages.With("imported").Inc()
+			case err == messagestore.ErrDuplicate:
+			case rejected(err, record.Message.MessageStruct()):
+				failed++
+				peerStat.ErrorCount++
+				log.Debugf("Bootstrap: %s %s\n", record.Message.MessageID, err)
+			default:
+				// Not advancing, the next bootstrap resumes at this message
+				snapshot.Close()
+				peerStat.ErrorCount++
+				ms.DB.UpdatePeerFetchStat(&peer.PubKey, peerStat.LastFetch, peerStat.LastPosition, peerStat.ErrorCount)
+				log.Printf("Bootstrap interrupted: %s imported: %d failed: %d position: %d\n", peerURL, imported, failed, peerStat.LastPosition)
+				return err
+			}
+			// Progress is saved to resume interrupted imports
+			peerStat.LastPosition = record.Message.Counter
+			if records++; records%snapshotBatch == 0 {
+				ms.DB.UpdatePeerFetchStat(&peer.PubKey, peerStat.LastFetch, peerStat.LastPosition, peerStat.ErrorCount)
+			}
+		}
+		snapshot.Close()
+		if snapshot.Resume > 0 {
+			peerStat.LastPosition = snapshot.Resume - 1
+		}
+		ms.DB.UpdatePeerFetchStat(&peer.PubKey, peerStat.LastFetch, peerStat.LastPosition, peerStat.ErrorCount)
+		if !snapshot.More {
+			break
+		}
+		start = snapshot.Resume
+	}
+	// Hand off to the synchronization at the snapshot position, without reconciliation
+	peerStat.LastFetch = uint64(CurrentTime())
+	ms.DB.UpdatePeerFetchStat(&peer.PubKey, peerStat.LastFetch, peerStat.LastPosition, peerStat.ErrorCount)
+	log.Printf("Bootstrap done: %s imported: %d failed: %d position: %d\n", peerURL, imported, failed, peerStat.LastPosition)
+	return nil
+}
+
+// importRecord verifies the message of a snapshot record and adds it.
+func (ms MessageServer) importRecord(record *structs.SnapshotRecord) error {
+	entry := record.Message.MessageStruct()
+	if entry == nil {
+		return ErrBadMessageID
+	}
+	data := []byte(record.Data)
+	_, messageID, err := deferVerify(data)
+	if err != nil {
+		return ErrBadPost
+	}
+	if *messageID != entry.MessageID {
+		return ErrBadMessageID
+	}
+	if ms.tooFar(entry.Distance) {
+		return nil
+	}
+	return ms.addPost(data, entry.ExpireTime, entry.Distance+1)
+}
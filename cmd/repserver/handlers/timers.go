@@ -3,26 +3,23 @@ package handlers
 import (
 	"time"
 
-	"github.com/repbin/repbin/cmd/repserver/stat"
 	log "github.com/repbin/repbin/deferconsole"
 )
 
 // FetchRun is called when it is time to update peer information and load messages.
 func (ms MessageServer) FetchRun() {
 	ms.LoadPeers()
+	ms.CheckPeerHealth()
 	ms.FetchPeers()
+	go ms.DiscoverPeers()
 }
 
 func (ms MessageServer) notifyWatch() {
 	var lastNotify, lastMessage int64
+	var expireMore bool // expired messages left by the last expire run
 	notifyTick := time.Tick(time.Duration(ms.NotifyDuration) * time.Second)
 	fetchTick := time.Tick(time.Duration(ms.FetchDuration) * time.Second)
 	expireTick := time.Tick(time.Duration(ms.ExpireDuration) * time.Second)
-	var statTick <-chan time.Time
-	if ms.Stat {
-		// show statistics once a minute
-		statTick = time.Tick(60 * time.Second)
-	}
 	for {
 		select {
 		case <-notifyTick:
@@ -32,14 +29,16 @@ func (ms MessageServer) notifyWatch() {
 				lastNotify = CurrentTime()
 				ms.NotifyPeers()
 			}
+			if expireMore {
+				log.Debugs("Expire run continued.\n")
+				expireMore = ms.DB.ExpireFromIndex()
+			}
 		case <-fetchTick:
 			log.Debugs("Fetch run started.\n")
 			ms.FetchRun()
 		case <-expireTick:
 			log.Debugs("Expire run started.\n")
-			ms.DB.ExpireFromIndex()
-		case <-statTick:
-			stat.Input <- stat.Show
+			expireMore = ms.DB.ExpireFromIndex()
 		case <-ms.notifyChan:
 			log.Debugs("Notification reason\n")
 			lastMessage = CurrentTime()
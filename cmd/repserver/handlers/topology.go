@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/repbin/repbin/cmd/repserver/stat"
+	"github.com/repbin/repbin/utils"
+)
+
+// Roles in the replication topology.
+const (
+	RoleHub  = "hub"  // Syncs with all peers and relays notifications
+	RoleLeaf = "leaf" // Syncs only with hubs
+	RoleMesh = "mesh" // Syncs with all peers, no hubs are configured
+)
+
+var roles = []string{RoleHub, RoleLeaf, RoleMesh}
+
+var peersHaveHub int32 // 1 if a peer of the current peer list is a hub
+
+// Role returns the role of the server. Servers that are not hubs are leaves if at least one
+// of their peers is a hub.
+func (ms MessageServer) Role() string {
+	if ms.HubOnly {
+		return RoleHub
+	}
+	if atomic.LoadInt32(&peersHaveHub) == 1 {
+		return RoleLeaf
+	}
+	return RoleMesh
+}
+
+// peerRole returns the role of a peer as seen from this server.
+func (ms MessageServer) peerRole(peer Peer) string {
+	if peer.IsHub {
+		return RoleHub
+	}
+	if ms.Role() == RoleMesh {
+		return RoleMesh
+	}
+	return RoleLeaf
+}
+
+// syncWith returns true if notifications are sent to and messages are fetched from the peer.
+func (ms MessageServer) syncWith(peer Peer) bool {
+	return ms.Role() != RoleLeaf || peer.IsHub
+}
+
+// relayed returns true if a message in the index of peer at distance was fetched by the peer
+// from other servers. Hubs fetch only the messages posted to leaves, the others arrive from the other hubs.
+func (ms MessageServer) relayed(peer Peer, distance uint64) bool {
+	return distance > 0 && ms.Role() == RoleHub && !peer.IsHub
+}
+
+// relayNotify records that messages were fetched from a peer. Leaves do not relay them, the hubs
+// notify each other.
+func (ms MessageServer) relayNotify() {
+	if ms.Role() != RoleLeaf {
+		ms.notifyChan <- true
+	}
+}
+
+// setTopologyStat updates the role metrics of the server and peers.
+func (ms MessageServer) setTopologyStat(peers PeerList) {
+	role := ms.Role()
+	for _, r := range roles {
+		if r == role {
+			stat.ServerRole.With(r).Set(1)
+		} else {
+			stat.ServerRole.Delete(r)
+		}
+	}
+	for _, peer := range peers {
+		role := ms.peerRole(peer)
+		for _, r := range roles {
+			if r == role {
+				stat.PeerRole.With(peer.URL, r).Set(1)
+			} else {
+				stat.PeerRole.Delete(peer.URL, r)
+			}
+		}
+	}
+}
+
+// PeerInfo is the state of a peer as shown by the admin listener.
+type PeerInfo struct {
+	PubKey         string
+	URL            string
+	Role           string
+	Sync           bool   // Notifications are sent to and messages fetched from the peer
+	LastNotifySend uint64 // Time of the last notification sent to the peer
+	LastNotifyFrom uint64 // Time of the last notification from the peer
+	LastFetch      uint64 // Time of the last fetch from the peer
+	LastPosition   uint64 // Position in the global index of the peer
+	ErrorCount     uint64
+	Health         string // Health state of the peer
+	FailCount      uint64 // Consecutive failures to reach the peer
+	RetryAfter     uint64 // Time before which the peer is not contacted, next probe if quarantined
+	LastError      string // Reason of the last failure
+	LastErrorTime  uint64 // Time of the last failure
+}
+
+// TopologyInfo is the topology as shown by the admin listener.
+type TopologyInfo struct {
+	Role       string
+	Peers      []PeerInfo
+	Discovered []*DiscoveredPeer // Peers learned from other peers, approved or not
+}
+
+// ServePeers shows the role of the server and the state of its peers.
+func (ms MessageServer) ServePeers(w http.ResponseWriter, r *http.Request) {
+	var peers []Peer
+	systemPeersMutex.Lock()
+	for _, peer := range systemPeers {
+		peers = append(peers, peer)
+	}
+	systemPeersMutex.Unlock()
+	info := &TopologyInfo{Role: ms.Role(), Peers: make([]PeerInfo, 0, len(peers))}
+	for _, peer := range peers {
+		pubKey := peer.PubKey
+		peerInfo := PeerInfo{
+			PubKey: utils.B58encode(pubKey[:]),
+			URL:    peer.URL,
+			Role:   ms.peerRole(peer),
+			Sync:   ms.syncWith(peer),
+		}
+		if peerStat := ms.DB.GetPeerStat(&pubKey); peerStat != nil {
+			peerInfo.LastNotifySend = peerStat.LastNotifySend
+			peerInfo.LastNotifyFrom = peerStat.LastNotifyFrom
+			peerInfo.LastFetch = peerStat.LastFetch
+			peerInfo.LastPosition = peerStat.LastPosition
+			peerInfo.ErrorCount = peerStat.ErrorCount
+			peerInfo.Health = healthState(peerStat)
+			peerInfo.FailCount = peerStat.FailCount
+			peerInfo.RetryAfter = peerStat.RetryAfter
+			peerInfo.LastError = peerStat.LastError
+			peerInfo.LastErrorTime = peerStat.LastErrorTime
+		}
+		info.Peers = append(info.Peers, peerInfo)
+	}
+	discoveryMutex.Lock()
+	info.Discovered = discoveredPeers
+	b, _ := json.MarshalIndent(info, "", "    ")
+	discoveryMutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+	w.Write([]byte("\n"))
+}
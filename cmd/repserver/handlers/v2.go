@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/repbin/repbin/cmd/repserver/messagestore"
+	log "github.com/repbin/repbin/deferconsole"
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+var (
+	errBadParam     = newAPIError(structs.CodeBadParam, "Bad param")
+	errMissingParam = newAPIError(structs.CodeMissingParam, "Missing param")
+)
+
+// newAPIError returns an error with code. The message is used for text replies.
+func newAPIError(code, msg string) error {
+	return &structs.APIError{Code: code, Message: msg}
+}
+
+// errorCode returns the code of an API error.
+func errorCode(err error) string {
+	if apiErr, ok := err.(*structs.APIError); ok {
+		return apiErr.Code
+	}
+	return structs.CodeInternal
+}
+
+// storeError converts errors from the messagestore.
+func storeError(err error) error {
+	switch err {
+	case messagestore.ErrDuplicate:
+		return newAPIError(structs.CodeDuplicate, err.Error())
+	case messagestore.ErrPostLimit:
+		return newAPIError(structs.CodePostLimit, err.Error())
+	case messagestore.ErrDeleted:
+		return newAPIError(structs.CodeDeleted, err.Error())
+	case messagestore.ErrTombstone:
+		return newAPIError(structs.CodeAuthFailed, err.Error())
+	case messagestore.ErrNotFound:
+		return newAPIError(structs.CodeNotFound, err.Error())
+	case messagestore.ErrWatchBusy:
+		return newAPIError(structs.CodeBusy, err.Error())
+	}
+	return newAPIError(structs.CodeInternal, err.Error())
+}
+
+// writeJSON writes a v2 response.
+func writeJSON(w http.ResponseWriter, resp *structs.APIResponse) {
+	resp.Version = structs.APIVersion
+	w.Header().Set("Content-Type", "application/json")
+	b, err := json.Marshal(resp)
+	if err != nil {
+		log.Debugf("JSON: %s\n", err)
+		return
+	}
+	w.Write(b)
+	w.Write([]byte("\n"))
+}
+
+// writeJSONError writes err as v2 response.
+func writeJSONError(w http.ResponseWriter, err error) {
+	apiErr, ok := err.(*structs.APIError)
+	if !ok {
+		apiErr = &structs.APIError{Code: structs.CodeInternal, Message: err.Error()}
+	}
+	writeJSON(w, &structs.APIResponse{Error: apiErr})
+}
+
+// writeJSONIndex writes index entries as v2 response.
+func writeJSONIndex(w http.ResponseWriter, messages [][]byte, more bool, start uint64) {
+	writeJSON(w, newJSONIndex(messages, more, start))
+}
+
+// newJSONIndex returns the v2 response for index entries.
+func newJSONIndex(messages [][]byte, more bool, start uint64) *structs.APIResponse {
+	resp := &structs.APIResponse{
+		Messages: make([]structs.IndexEntry, 0, len(messages)),
+		Next:     start,
+		More:     more,
+	}
+	for _, msg := range messages {
+		ms := structs.MessageStructDecode(msg)
+		if ms == nil {
+			continue
+		}
+		resp.Messages = append(resp.Messages, structs.NewIndexEntry(ms))
+		resp.Next = ms.Counter + 1
+	}
+	return resp
+}
+
+// startParam returns the start parameter of an index request.
+func startParam(r *http.Request) uint64 {
+	start, _ := strconv.ParseUint(r.URL.Query().Get("start"), 10, 64)
+	return start
+}
+
+// GetKeyIndexV2 returns the index for a key as JSON.
+func (ms MessageServer) GetKeyIndexV2(w http.ResponseWriter, r *http.Request) {
+	messages, more, err := ms.keyIndex(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	writeJSONIndex(w, messages, more, startParam(r))
+}
+
+// GetGlobalIndexV2 returns the global index as JSON. If the tombstones parameter is given the
+// tombstones beginning at that position are included.
+func (ms MessageServer) GetGlobalIndexV2(w http.ResponseWriter, r *http.Request) {
+	getValues := r.URL.Query()
+	messages, more, err := ms.globalIndex(getValues)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	if _, ok := getValues["tombstones"]; !ok {
+		writeJSONIndex(w, messages, more, startParam(r))
+		return
+	}
+	tombstoneStart, _ := strconv.ParseUint(getValues.Get("tombstones"), 10, 64)
+	tombstones, positions, next, err := ms.DB.ListTombstones(tombstoneStart, ms.MaxIndexGlobal)
+	if err != nil {
+		log.Debugf("List:ListTombstones: %s\n", err)
+		writeJSONError(w, newAPIError(structs.CodeInternal, "List failed"))
+		return
+	}
+	resp := newJSONIndex(messages, more || int64(len(tombstones)) >= ms.MaxIndexGlobal, startParam(r))
+	resp.NextTombstone = next
+	resp.TombstoneIDs = positions
+	for _, ts := range tombstones {
+		resp.Tombstones = append(resp.Tombstones, utils.B58encode(ts.Encode()))
+	}
+	writeJSON(w, resp)
+}
+
+// FetchV2 returns a single message as JSON.
+func (ms MessageServer) FetchV2(w http.ResponseWriter, r *http.Request) {
+	messageID, data, err := ms.fetch(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	writeJSON(w, &structs.APIResponse{
+		MessageID: utils.B58encode(messageID[:]),
+		Data:      string(data),
+	})
+}
+
+// GenPostHandlerV2 returns a handler for message posting that replies with JSON.
+func (ms MessageServer) GenPostHandlerV2(oneTime bool) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var messageID *[message.MessageIDSize]byte
+		var err error
+		if r.Method != "POST" {
+			writeJSONError(w, newAPIError(structs.CodeBadMethod, "Bad Method"))
+			return
+		}
+		messageID, err = ms.processPost(r.Body, oneTime, expireParam(r.URL.Query()))
+		if err != nil {
+			writeJSONError(w, err)
+			return
+		}
+		writeJSON(w, &structs.APIResponse{MessageID: utils.B58encode(messageID[:])})
+	}
+}
+
+// GetNotifyV2 receives notifications and replies with JSON.
+func (ms MessageServer) GetNotifyV2(w http.ResponseWriter, r *http.Request) {
+	if err := ms.notify(r.URL.Query()); err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	writeJSON(w, &structs.APIResponse{})
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/repbin/repbin/cmd/repserver/stat"
+)
+
+// WatchKeyIndex returns the index entries for a key with a counter above "since". If there
+// are none it waits up to WatchTimeout seconds for new messages.
+func (ms MessageServer) WatchKeyIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=us-ascii")
+	messages, more, _, err := ms.watchKeyIndex(r)
+	if err != nil {
+		io.WriteString(w, "ERROR: "+err.Error()+"\n")
+		return
+	}
+	writeIndex(w, messages, more)
+}
+
+// WatchKeyIndexV2 is WatchKeyIndex with a JSON reply.
+func (ms MessageServer) WatchKeyIndexV2(w http.ResponseWriter, r *http.Request) {
+	messages, more, start, err := ms.watchKeyIndex(r)
+	if err != nil {
+		writeJSONError(w, err)
+		return
+	}
+	writeJSONIndex(w, messages, more, start)
+}
+
+// watchKeyIndex waits for index entries of a key. The request is verified only once, the
+// authentication of hidden keys may expire while waiting.
+func (ms MessageServer) watchKeyIndex(r *http.Request) (messages [][]byte, more bool, start uint64, err error) {
+	stat.IndexRequests.With("watch").Inc()
+	getValues := r.URL.Query()
+	since, _ := strconv.ParseUint(getValues.Get("since"), 10, 64)
+	start = since + 1
+	getValues.Set("start", strconv.FormatUint(start, 10))
+	pubKey, _, count, err := ms.keyIndexParams(getValues)
+	if err != nil {
+		return nil, false, start, err
+	}
+	timeout := time.NewTimer(time.Duration(ms.WatchTimeout) * time.Second)
+	defer timeout.Stop()
+	for {
+		// Register before reading to not miss messages stored in between
+		wake, release, err := ms.DB.Watch(pubKey)
+		if err != nil {
+			return nil, false, start, storeError(err)
+		}
+		messages, more, err = ms.readKeyIndex(pubKey, int64(start), count)
+		if err != nil || len(messages) > 0 {
+			release()
+			return messages, more, start, err
+		}
+		select {
+		case <-wake:
+			release()
+		case <-timeout.C:
+			release()
+			return nil, false, start, nil
+		case <-r.Context().Done():
+			release()
+			return nil, false, start, r.Context().Err()
+		}
+	}
+}
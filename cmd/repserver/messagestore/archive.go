@@ -0,0 +1,466 @@
+package messagestore
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/agl/ed25519"
+	"github.com/repbin/repbin/cmd/repserver/messagestore/sql"
+	log "github.com/repbin/repbin/deferconsole"
+	"github.com/repbin/repbin/hashcash"
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/keyproof"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+// ArchiveVersion is the version of the store archive format
+const ArchiveVersion = 1
+
+// maxArchiveLine is the maximum size of a line of an archive
+const maxArchiveLine = 1024 * 1024
+
+var (
+	// ErrArchiveVersion is returned if an archive has an unknown version
+	ErrArchiveVersion = errors.New("messagestore: Unsupported archive version")
+	// ErrArchiveTruncated is returned if an archive has no trailer
+	ErrArchiveTruncated = errors.New("messagestore: Archive truncated")
+	// ErrArchiveChecksum is returned if the checksum or record count of an archive do not match
+	ErrArchiveChecksum = errors.New("messagestore: Archive checksum mismatch")
+	// ErrArchiveRecord is returned for records that fail validation
+	ErrArchiveRecord = errors.New("messagestore: Invalid archive record")
+	// ErrStoreNotEmpty is returned if an archive is imported into a store that contains messages or signers
+	ErrStoreNotEmpty = errors.New("messagestore: Store is not empty")
+)
+
+// archiveRecord is a line of a store archive. Archives are gzip compressed JSON lines:
+// a header with Version, one record per signer, recipient counter, message, peer, known
+// message and tombstone, and a trailer with End set. The trailer contains the number of
+// records and the SHA256 of all lines before it.
+type archiveRecord struct {
+	Version   int               `json:",omitempty"` // Header: ArchiveVersion
+	Created   int64             `json:",omitempty"` // Header: Time of export
+	Signer    string            `json:",omitempty"` // Encoded SignerStruct
+	Counter   *archiveCounter   `json:",omitempty"`
+	Message   *archiveMessage   `json:",omitempty"`
+	Peer      *archivePeer      `json:",omitempty"`
+	Known     *archiveKnown     `json:",omitempty"`
+	Tombstone *archiveTombstone `json:",omitempty"`
+	End       bool              `json:",omitempty"` // Trailer
+	Records   uint64            `json:",omitempty"` // Trailer: Number of records between header and trailer
+	Checksum  string            `json:",omitempty"` // Trailer: SHA256 of all previous lines
+}
+
+// archiveCounter is the message counter of a recipient
+type archiveCounter struct {
+	Receiver string
+	Counter  uint64
+	LastTime int64
+}
+
+// archiveMessage is a message with its blob
+type archiveMessage struct {
+	structs.IndexEntry
+	Index     uint64 `json:",omitempty"` // Position in the global index
+	EntryTime uint64 `json:",omitempty"` // Time of the global index entry
+	Data      string
+}
+
+// archivePeer is a peer with its synchronization state
+type archivePeer struct {
+	PubKey           string
+	AuthToken        string
+	LastNotifySend   uint64
+	LastNotifyFrom   uint64
+	LastFetch        uint64
+	ErrorCount       uint64
+	LastPosition     uint64
+	LastTombstone    uint64
+	FailCount        uint64
+	RetryAfter       uint64
+	QuarantinedSince uint64
+	LastError        string `json:",omitempty"`
+	LastErrorTime    uint64
+}
+
+// archiveKnown is a known message
+type archiveKnown struct {
+	MessageID string
+	EntryTime int64
+}
+
+// archiveTombstone is a tombstone with its position in the tombstone list
+type archiveTombstone struct {
+	ID         uint64
+	Tombstone  string // Encoded TombstoneStruct
+	Authorized bool
+	EntryTime  int64
+	ExpireTime int64
+}
+
+// ArchiveReport contains the number of records exported or imported
+type ArchiveReport struct {
+	Signers    int
+	Counters   int
+	Messages   int
+	Peers      int
+	Known      int
+	Tombstones int
+	Rejected   int // Records that failed validation on import
+}
+
+func (r *ArchiveReport) String() string {
+	return fmt.Sprintf("signers: %d counters: %d messages: %d peers: %d known: %d tombstones: %d rejected: %d",
+		r.Signers, r.Counters, r.Messages, r.Peers, r.Known, r.Tombstones, r.Rejected)
+}
+
+// archiveWriter writes the lines of an archive and calculates the checksum
+type archiveWriter struct {
+	w       io.Writer
+	hash    hash.Hash
+	records uint64
+}
+
+func (aw *archiveWriter) write(record *archiveRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	aw.hash.Write(line)
+	aw.records++
+	_, err = aw.w.Write(line)
+	return err
+}
+
+// Export writes all messages with their blobs, signers, recipient counters, peers, known
+// messages and tombstones to w. The store must not be used during the export.
+func (store Store) Export(w io.Writer) (*ArchiveReport, error) {
+	report := new(ArchiveReport)
+	gz := gzip.NewWriter(w)
+	aw := &archiveWriter{w: gz, hash: sha256.New()}
+	if err := aw.write(&archiveRecord{Version: ArchiveVersion, Created: CurrentTime()}); err != nil {
+		return nil, err
+	}
+	err := store.db.ListSigners(func(signerStruct *structs.SignerStruct) error {
+		report.Signers++
+		return aw.write(&archiveRecord{Signer: string(signerStruct.Encode())})
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = store.db.ListMessageCounters(func(receiver *message.Curve25519Key, counter uint64, lastTime int64) error {
+		report.Counters++
+		return aw.write(&archiveRecord{Counter: &archiveCounter{
+			Receiver: utils.B58encode(receiver[:]),
+			Counter:  counter,
+			LastTime: lastTime,
+		}})
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = store.db.ListIndexedMessages(func(msg *structs.MessageStruct, index, entryTime uint64) error {
+		data, err := store.blobs.GetBlob(&msg.MessageID)
+		if err != nil {
+			log.Errorf("Export, GetBlob: %s %s\n", err, utils.B58encode(msg.MessageID[:]))
+			return nil
+		}
+		report.Messages++
+		return aw.write(&archiveRecord{Message: &archiveMessage{
+			IndexEntry: structs.NewIndexEntry(msg),
+			Index:      index,
+			EntryTime:  entryTime,
+			Data:       string(data),
+		}})
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = store.db.ListPeers(func(pubkey *[ed25519.PublicKeySize]byte, peer *structs.PeerStruct) error {
+		report.Peers++
+		return aw.write(&archiveRecord{Peer: &archivePeer{
+			PubKey:           utils.B58encode(pubkey[:]),
+			AuthToken:        utils.B58encode(peer.AuthToken[:]),
+			LastNotifySend:   peer.LastNotifySend,
+			LastNotifyFrom:   peer.LastNotifyFrom,
+			LastFetch:        peer.LastFetch,
+			ErrorCount:       peer.ErrorCount,
+			LastPosition:     peer.LastPosition,
+			LastTombstone:    peer.LastTombstone,
+			FailCount:        peer.FailCount,
+			RetryAfter:       peer.RetryAfter,
+			QuarantinedSince: peer.QuarantinedSince,
+			LastError:        peer.LastError,
+			LastErrorTime:    peer.LastErrorTime,
+		}})
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = store.db.ListKnownMessages(func(messageID *[message.MessageIDSize]byte, entryTime int64) error {
+		report.Known++
+		return aw.write(&archiveRecord{Known: &archiveKnown{
+			MessageID: utils.B58encode(messageID[:]),
+			EntryTime: entryTime,
+		}})
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = store.db.ListAllTombstones(func(id uint64, ts *structs.TombstoneStruct, authorized bool, entryTime, expireTime int64) error {
+		report.Tombstones++
+		return aw.write(&archiveRecord{Tombstone: &archiveTombstone{
+			ID:         id,
+			Tombstone:  utils.B58encode(ts.Encode()),
+			Authorized: authorized,
+			EntryTime:  entryTime,
+			ExpireTime: expireTime,
+		}})
+	})
+	if err != nil {
+		return nil, err
+	}
+	trailer := &archiveRecord{End: true, Records: aw.records - 1, Checksum: hex.EncodeToString(aw.hash.Sum(nil))}
+	if err := aw.write(trailer); err != nil {
+		return nil, err
+	}
+	return report, gz.Close()
+}
+
+// readArchive calls fn for each record between header and trailer of the archive in r.
+// The header and trailer are checked, an error is returned after all records were read
+// if the checksum does not match.
+func readArchive(r io.Reader, fn func(record *archiveRecord) error) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxArchiveLine)
+	h := sha256.New()
+	var records uint64
+	header := true
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		record := new(archiveRecord)
+		if err := json.Unmarshal(line, record); err != nil {
+			return err
+		}
+		if record.End {
+			if record.Records != records-1 || record.Checksum != hex.EncodeToString(h.Sum(nil)) {
+				return ErrArchiveChecksum
+			}
+			return nil
+		}
+		h.Write(line)
+		h.Write([]byte{'\n'})
+		records++
+		if header {
+			if record.Version != ArchiveVersion {
+				return ErrArchiveVersion
+			}
+			header = false
+			continue
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return ErrArchiveTruncated
+}
+
+// Import reads an archive written by Export into the store. The archive is verified
+// completely before the first record is imported. Every record is validated, messages
+// like a fetched message with at least minBits of hashcash. Records that fail
+// validation are skipped. The store must be empty and must not be used during the
+// import.
+func (store Store) Import(r io.ReadSeeker, minBits byte) (*ArchiveReport, error) {
+	if err := readArchive(r, func(*archiveRecord) error { return nil }); err != nil {
+		return nil, err
+	}
+	if err := store.db.ListMessages(func(*sql.ExpireMessage) error { return ErrStoreNotEmpty }); err != nil {
+		return nil, err
+	}
+	if err := store.db.ListSigners(func(*structs.SignerStruct) error { return ErrStoreNotEmpty }); err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	report := new(ArchiveReport)
+	err := readArchive(r, func(record *archiveRecord) error {
+		var err error
+		switch {
+		case record.Signer != "":
+			if err = store.importSigner(record.Signer); err == nil {
+				report.Signers++
+			}
+		case record.Counter != nil:
+			if err = store.importCounter(record.Counter); err == nil {
+				report.Counters++
+			}
+		case record.Message != nil:
+			if err = store.importMessage(record.Message, minBits); err == nil {
+				report.Messages++
+			}
+		case record.Peer != nil:
+			if err = store.importPeer(record.Peer); err == nil {
+				report.Peers++
+			}
+		case record.Known != nil:
+			if err = store.importKnown(record.Known); err == nil {
+				report.Known++
+			}
+		case record.Tombstone != nil:
+			if err = store.importTombstone(record.Tombstone); err == nil {
+				report.Tombstones++
+			}
+		default:
+			err = ErrArchiveRecord
+		}
+		if err != nil {
+			report.Rejected++
+			log.Errorf("Import: %s\n", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	if err := store.db.ResetGlobalIndexSequence(); err != nil {
+		return report, err
+	}
+	if err := store.db.ResetTombstoneSequence(); err != nil {
+		return report, err
+	}
+	store.UpdateBlobSize()
+	return report, nil
+}
+
+func (store Store) importSigner(encoded string) error {
+	signerStruct := structs.SignerStructDecode(structs.SignerStructEncoded(encoded))
+	if signerStruct == nil {
+		return ErrArchiveRecord
+	}
+	if ok, _ := hashcash.TestNonce(signerStruct.PublicKey[:], signerStruct.Nonce[:], signerStruct.Bits); !ok {
+		return fmt.Errorf("signer %s: %s", utils.B58encode(signerStruct.PublicKey[:]), message.ErrHashCash)
+	}
+	if _, err := store.db.InsertSigner(signerStruct); err != nil {
+		return err
+	}
+	return store.db.SetSignerCounters(&signerStruct.PublicKey, signerStruct.MessagesPosted, signerStruct.MessagesRetained)
+}
+
+func (store Store) importCounter(counter *archiveCounter) error {
+	receiver := utils.B58decode(counter.Receiver)
+	if len(receiver) != message.Curve25519KeySize || counter.Counter == 0 {
+		return ErrArchiveRecord
+	}
+	var key message.Curve25519Key
+	copy(key[:], receiver)
+	return store.db.ImportMessageCounter(&key, counter.Counter, counter.LastTime)
+}
+
+func (store Store) importMessage(am *archiveMessage, minBits byte) error {
+	msgStruct := am.MessageStruct()
+	if msgStruct == nil || msgStruct.Counter == 0 {
+		return ErrArchiveRecord
+	}
+	data := []byte(am.Data)
+	if err := verifyBlob(&msgStruct.MessageID, &msgStruct.SignerPub, data, minBits); err != nil {
+		return fmt.Errorf("message %s: %s", am.MessageID, err)
+	}
+	if receiver := messageReceiver(data); receiver == nil || *receiver != msgStruct.ReceiverConstantPubKey {
+		return fmt.Errorf("message %s: %s", am.MessageID, ErrArchiveRecord)
+	}
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	storeID, err := tx.ImportMessage(msgStruct, am.Index, am.EntryTime)
+	if err == nil {
+		if txBlobs, ok := store.blobs.(txBlobStore); ok {
+			err = txBlobs.InsertBlobTx(tx, storeID, &msgStruct.MessageID, &msgStruct.SignerPub, msgStruct.OneTime, data)
+		} else {
+			err = store.blobs.InsertBlob(storeID, &msgStruct.MessageID, &msgStruct.SignerPub, msgStruct.OneTime, data)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store Store) importPeer(ap *archivePeer) error {
+	var pubkey [ed25519.PublicKeySize]byte
+	peer := &structs.PeerStruct{
+		LastNotifySend:   ap.LastNotifySend,
+		LastNotifyFrom:   ap.LastNotifyFrom,
+		LastFetch:        ap.LastFetch,
+		ErrorCount:       ap.ErrorCount,
+		LastPosition:     ap.LastPosition,
+		LastTombstone:    ap.LastTombstone,
+		FailCount:        ap.FailCount,
+		RetryAfter:       ap.RetryAfter,
+		QuarantinedSince: ap.QuarantinedSince,
+		LastError:        ap.LastError,
+		LastErrorTime:    ap.LastErrorTime,
+	}
+	d := utils.B58decode(ap.PubKey)
+	if len(d) != ed25519.PublicKeySize {
+		return ErrArchiveRecord
+	}
+	copy(pubkey[:], d)
+	d = utils.B58decode(ap.AuthToken)
+	if len(d) > keyproof.ProofTokenSignedSize {
+		return ErrArchiveRecord
+	}
+	copy(peer.AuthToken[keyproof.ProofTokenSignedSize-len(d):], d)
+	return store.db.ImportPeer(&pubkey, peer)
+}
+
+func (store Store) importKnown(known *archiveKnown) error {
+	d := utils.B58decode(known.MessageID)
+	if len(d) != message.MessageIDSize {
+		return ErrArchiveRecord
+	}
+	var messageID [message.MessageIDSize]byte
+	copy(messageID[:], d)
+	return store.db.ImportKnownMessage(&messageID, known.EntryTime)
+}
+
+func (store Store) importTombstone(at *archiveTombstone) error {
+	ts := structs.TombstoneDecode(utils.B58decode(at.Tombstone))
+	if ts == nil || at.ID == 0 {
+		return ErrArchiveRecord
+	}
+	if !ts.Verify() {
+		return fmt.Errorf("tombstone %s: %s", utils.B58encode(ts.MessageID[:]), ErrTombstone)
+	}
+	return store.db.ImportTombstone(at.ID, ts, at.Authorized, at.EntryTime, at.ExpireTime)
+}
+
+// messageReceiver returns the constant public key of the recipient of a message
+func messageReceiver(data []byte) *message.Curve25519Key {
+	var keyHeader [message.KeyHeaderSize]byte
+	msg, err := message.Base64Message(data).Decode()
+	if err != nil || len(msg) < message.SignHeaderSize+message.KeyHeaderSize {
+		return nil
+	}
+	copy(keyHeader[:], msg[message.SignHeaderSize:message.SignHeaderSize+message.KeyHeaderSize])
+	_, recKeys, _ := message.ParseKeyHeader(&keyHeader)
+	return recKeys.ConstantPubKey
+}
@@ -0,0 +1,193 @@
+package messagestore
+
+import (
+	"errors"
+	"path"
+
+	"github.com/repbin/repbin/cmd/repserver/messagestore/pack"
+	"github.com/repbin/repbin/cmd/repserver/messagestore/sql"
+	"github.com/repbin/repbin/cmd/repserver/stat"
+	log "github.com/repbin/repbin/deferconsole"
+	"github.com/repbin/repbin/message"
+)
+
+const (
+	// BlobFS stores each blob in a file below the storage path
+	BlobFS = "fs"
+	// BlobDB stores blobs in the database
+	BlobDB = "db"
+	// BlobPack stores blobs in append-only pack files below the storage path
+	BlobPack = "pack"
+)
+
+const packDir = "packs"
+
+// ErrBlobBackend is returned if an unknown blob storage backend is selected
+var ErrBlobBackend = errors.New("messagestore: Unknown blob storage backend")
+
+// BlobStore stores message blobs.
+type BlobStore interface {
+	// InsertBlob stores the blob of a message. id is the numeric ID of the message in the database
+	InsertBlob(id uint64, messageID *[message.MessageIDSize]byte, signer *[message.SignerPubKeySize]byte, onetime bool, data []byte) error
+	// GetBlob returns the blob of a message
+	GetBlob(messageID *[message.MessageIDSize]byte) ([]byte, error)
+	// DeleteBlob removes the blob of a message
+	DeleteBlob(messageID *[message.MessageIDSize]byte) error
+	// List calls fn for the messageID of each stored blob
+	List(fn func(messageID *[message.MessageIDSize]byte) error) error
+	// Compact reclaims space of deleted blobs, if the backend needs it
+	Compact() error
+	// Size returns the number of bytes used by the blobs
+	Size() (int64, error)
+	// Close the blob store
+	Close() error
+}
+
+// txBlobStore is implemented by blob stores that keep the blobs in the message
+// database. Their blobs are written and deleted within the message transaction.
+type txBlobStore interface {
+	InsertBlobTx(tx *sql.Tx, id uint64, messageID *[message.MessageIDSize]byte, signer *[message.SignerPubKeySize]byte, onetime bool, data []byte) error
+	DeleteBlobTx(tx *sql.Tx, messageID *[message.MessageIDSize]byte) error
+}
+
+// SelectBlobStore selects the blob storage backend (BlobFS, BlobDB or BlobPack).
+func (store *Store) SelectBlobStore(backend string) error {
+	var blobs BlobStore
+	switch backend {
+	case BlobFS:
+		if store.dir == "" {
+			return ErrBlobBackend
+		}
+		blobs = fsBlobStore{db: store.db}
+	case BlobDB:
+		blobs = dbBlobStore{db: store.db}
+	case BlobPack:
+		if store.dir == "" {
+			return ErrBlobBackend
+		}
+		p, err := pack.Open(path.Join(store.dir, packDir))
+		if err != nil {
+			return err
+		}
+		blobs = packBlobStore{p: p}
+	default:
+		return ErrBlobBackend
+	}
+	if store.blobs != nil {
+		store.blobs.Close()
+	}
+	store.blobs = blobs
+	return nil
+}
+
+// UpdateBlobSize updates the blob store size statistic.
+func (store Store) UpdateBlobSize() {
+	size, err := store.blobs.Size()
+	if err != nil {
+		log.Errorf("messagestore, blob size: %s\n", err)
+		return
+	}
+	stat.BlobStoreBytes.Set(float64(size))
+}
+
+// fsBlobStore stores blobs in the filesystem.
+type fsBlobStore struct {
+	db *sql.MessageDB
+}
+
+func (bs fsBlobStore) InsertBlob(id uint64, messageID *[message.MessageIDSize]byte, signer *[message.SignerPubKeySize]byte, onetime bool, data []byte) error {
+	return bs.db.InsertBlobFS(messageID, data)
+}
+
+func (bs fsBlobStore) GetBlob(messageID *[message.MessageIDSize]byte) ([]byte, error) {
+	return bs.db.ReadBlobFS(messageID)
+}
+
+func (bs fsBlobStore) DeleteBlob(messageID *[message.MessageIDSize]byte) error {
+	return bs.db.DeleteBlobFS(messageID)
+}
+
+func (bs fsBlobStore) List(fn func(messageID *[message.MessageIDSize]byte) error) error {
+	return bs.db.ListBlobsFS(fn)
+}
+
+func (bs fsBlobStore) Compact() error { return nil }
+
+func (bs fsBlobStore) Size() (int64, error) { return bs.db.BlobSizeFS() }
+
+func (bs fsBlobStore) Close() error { return nil }
+
+// dbBlobStore stores blobs in the database.
+type dbBlobStore struct {
+	db *sql.MessageDB
+}
+
+func (bs dbBlobStore) InsertBlob(id uint64, messageID *[message.MessageIDSize]byte, signer *[message.SignerPubKeySize]byte, onetime bool, data []byte) error {
+	return bs.db.InsertBlobDB(id, messageID, signer, onetime, data)
+}
+
+func (bs dbBlobStore) GetBlob(messageID *[message.MessageIDSize]byte) ([]byte, error) {
+	mb, err := bs.db.GetBlobDB(messageID)
+	if err != nil {
+		return nil, err
+	}
+	return mb.Data, nil
+}
+
+func (bs dbBlobStore) DeleteBlob(messageID *[message.MessageIDSize]byte) error {
+	return bs.db.DeleteBlobDB(messageID)
+}
+
+func (bs dbBlobStore) InsertBlobTx(tx *sql.Tx, id uint64, messageID *[message.MessageIDSize]byte, signer *[message.SignerPubKeySize]byte, onetime bool, data []byte) error {
+	return tx.InsertBlobDB(id, messageID, signer, onetime, data)
+}
+
+func (bs dbBlobStore) DeleteBlobTx(tx *sql.Tx, messageID *[message.MessageIDSize]byte) error {
+	return tx.DeleteBlobDB(messageID)
+}
+
+func (bs dbBlobStore) List(fn func(messageID *[message.MessageIDSize]byte) error) error {
+	return bs.db.ListBlobsDB(fn)
+}
+
+func (bs dbBlobStore) Compact() error { return nil }
+
+func (bs dbBlobStore) Size() (int64, error) { return bs.db.BlobSizeDB() }
+
+func (bs dbBlobStore) Close() error { return nil }
+
+// packBlobStore stores blobs in pack files.
+type packBlobStore struct {
+	p *pack.Store
+}
+
+func (bs packBlobStore) InsertBlob(id uint64, messageID *[message.MessageIDSize]byte, signer *[message.SignerPubKeySize]byte, onetime bool, data []byte) error {
+	return bs.p.Insert(messageID, data)
+}
+
+func (bs packBlobStore) GetBlob(messageID *[message.MessageIDSize]byte) ([]byte, error) {
+	return bs.p.Get(messageID)
+}
+
+func (bs packBlobStore) DeleteBlob(messageID *[message.MessageIDSize]byte) error {
+	return bs.p.Delete(messageID)
+}
+
+func (bs packBlobStore) List(fn func(messageID *[message.MessageIDSize]byte) error) error {
+	ids, err := bs.p.List()
+	if err != nil {
+		return err
+	}
+	for i := range ids {
+		if err := fn(&ids[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bs packBlobStore) Compact() error { return bs.p.Compact() }
+
+func (bs packBlobStore) Size() (int64, error) { return bs.p.Size(), nil }
+
+func (bs packBlobStore) Close() error { return bs.p.Close() }
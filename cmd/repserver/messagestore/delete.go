@@ -0,0 +1,63 @@
+package messagestore
+
+import (
+	"github.com/repbin/repbin/cmd/repserver/messagestore/sql"
+	log "github.com/repbin/repbin/deferconsole"
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+)
+
+// deleteMessage deletes a message and removes it from the signer's retained messages
+// in one transaction. Blobs outside of the database are deleted after the commit, a
+// blob left behind is removed by Scrub. Returns ErrNotFound if the message has
+// already been deleted.
+func (store Store) deleteMessage(messageID *[message.MessageIDSize]byte, signer *[message.SignerPubKeySize]byte) error {
+	if err := store.deleteMessageRow(messageID, signer); err != nil {
+		return err
+	}
+	if _, isTx := store.blobs.(txBlobStore); !isTx {
+		if err := store.blobs.DeleteBlob(messageID); err != nil {
+			log.Errorf("messagestore, delete blob: %s %s\n", err, utils.B58encode(messageID[:]))
+		}
+	}
+	return nil
+}
+
+// deleteMessageRow runs the database part of deleteMessage.
+func (store Store) deleteMessageRow(messageID *[message.MessageIDSize]byte, signer *[message.SignerPubKeySize]byte) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := store.deleteMessageTx(tx, messageID, signer); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoModify {
+			return ErrNotFound
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteMessageTx deletes a message with its global index entry and database blob within
+// tx and removes it from the signer's retained messages. Returns sql.ErrNoModify if the
+// message does not exist.
+func (store Store) deleteMessageTx(tx *sql.Tx, messageID *[message.MessageIDSize]byte, signer *[message.SignerPubKeySize]byte) error {
+	// Not every database cascades the delete to the global index
+	if err := tx.DeleteGlobalIndexByID(messageID); err != nil {
+		return err
+	}
+	if err := tx.DeleteMessageByID(messageID); err != nil {
+		return err
+	}
+	// The signer may have expired already
+	if err := tx.DelMessage(signer); err != nil && err != sql.ErrNoModify {
+		return err
+	}
+	if txBlobs, ok := store.blobs.(txBlobStore); ok {
+		if err := txBlobs.DeleteBlobTx(tx, messageID); err != nil && err != sql.ErrNoModify {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,30 +1,47 @@
 package messagestore
 
 import (
+	"time"
+
+	"github.com/agl/ed25519"
+	"github.com/repbin/repbin/cmd/repserver/messagestore/sql"
+	"github.com/repbin/repbin/cmd/repserver/stat"
 	log "github.com/repbin/repbin/deferconsole"
+	"github.com/repbin/repbin/message"
 	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/repproto/structs"
 )
 
-// ExpireFromIndex reads the expire index and expires messages as they are recorded
-func (store Store) ExpireFromIndex() {
-	// ExpireRun
-	delMessages, err := store.db.SelectMessageExpire(CurrentTime())
-	if err != nil {
-		log.Errorf("ExpireFromIndex, SelectMessageExpire: %s\n", err)
-		return
-	}
-	for _, msg := range delMessages {
-		err := store.db.DeleteBlob(&msg.MessageID)
-		if err != nil {
-			log.Errorf("ExpireFromIndex, DeleteBlob: %s %s\n", err, utils.B58encode(msg.MessageID[:]))
-			continue
-		}
-		err = store.db.DeleteMessageByID(&msg.MessageID)
-		if err != nil {
-			log.Errorf("ExpireFromIndex, DeleteMessageByID: %s %s\n", err, utils.B58encode(msg.MessageID[:]))
-		}
+// ExpireBatchSize is the number of expired messages deleted per transaction
+var ExpireBatchSize = 500
+
+// ExpireBudget is the maximum time in seconds an expire run spends deleting messages.
+// Messages left are deleted by the next run. 0 for no limit
+var ExpireBudget = int64(60)
+
+// SetPeeringKey sets the key that signs the expiry records of the server. Without a key no
+// expiry records are written.
+func (store *Store) SetPeeringKey(publicKey *[ed25519.PublicKeySize]byte, privateKey *[ed25519.PrivateKeySize]byte) {
+	store.peeringPub, store.peeringPriv = publicKey, privateKey
+}
+
+// expireRecord returns the expiry record of messageID, nil if the peering key is not set.
+func (store Store) expireRecord(messageID *[message.MessageIDSize]byte) *structs.TombstoneStruct {
+	if store.peeringPriv == nil {
+		return nil
 	}
-	_, _, err = store.db.ExpireSigners(MaxAgeSigners)
+	return structs.NewExpireTombstone(messageID, uint64(CurrentTime()), store.peeringPub, store.peeringPriv)
+}
+
+// ExpireFromIndex deletes expired messages and expires signers, recipients, known
+// messages and tombstones. Returns true if expired messages are left because the run
+// exceeded ExpireBudget.
+func (store Store) ExpireFromIndex() bool {
+	// ExpireRun
+	stat.ExpireRuns.Inc()
+	defer stat.ExpireDuration.Since(time.Now())
+	more := store.expireMessages(CurrentTime())
+	_, _, err := store.db.ExpireSigners(MaxAgeSigners)
 	if err != nil {
 		log.Errorf("ExpireFromIndex, ExpireSigners: %s\n", err)
 	}
@@ -36,4 +53,105 @@ func (store Store) ExpireFromIndex() {
 	if err != nil {
 		log.Errorf("ExpireFromIndex, ForgetMessages: %s\n", err)
 	}
+	err = store.db.ExpireTombstones(CurrentTime())
+	if err != nil {
+		log.Errorf("ExpireFromIndex, ExpireTombstones: %s\n", err)
+	}
+	err = store.blobs.Compact()
+	if err != nil {
+		log.Errorf("ExpireFromIndex, Compact: %s\n", err)
+	}
+	store.UpdateBlobSize()
+	return more
+}
+
+// expireMessages deletes the messages that expired before now in batches of
+// ExpireBatchSize. Returns true if it stopped because ExpireBudget was exceeded.
+func (store Store) expireMessages(now int64) bool {
+	var deadline time.Time
+	if ExpireBudget > 0 {
+		deadline = time.Now().Add(time.Duration(ExpireBudget) * time.Second)
+	}
+	var after uint64
+	for {
+		batch, err := store.db.SelectMessageExpireBatch(now, after, ExpireBatchSize)
+		if err != nil {
+			log.Errorf("ExpireFromIndex, SelectMessageExpireBatch: %s\n", err)
+			return false
+		}
+		if len(batch) == 0 {
+			return false
+		}
+		after = batch[len(batch)-1].ID
+		store.expireBatch(batch)
+		if len(batch) < ExpireBatchSize {
+			return false
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			log.Printf("ExpireFromIndex: budget exceeded, continuing in next run\n")
+			return true
+		}
+	}
+}
+
+// expireBatch deletes the messages of batch in one transaction. If the transaction
+// fails, the messages are deleted one by one.
+func (store Store) expireBatch(batch []sql.ExpireMessage) {
+	n, err := store.expireBatchTx(batch)
+	if err != nil {
+		log.Errorf("ExpireFromIndex, expireBatchTx: %s\n", err)
+		n = 0
+		for i := range batch {
+			one, err := store.expireBatchTx(batch[i : i+1])
+			if err != nil {
+				log.Errorf("ExpireFromIndex, expireBatchTx: %s %s\n", err, utils.B58encode(batch[i].MessageID[:]))
+				continue
+			}
+			n += one
+		}
+	}
+	stat.ExpiredMessages.Add(uint64(n))
+}
+
+// expireBatchTx deletes the messages of batch within a transaction. Deleted messages
+// stay known until MaxAgeRecipients has passed, peers cannot deliver them again. If the
+// peering key is set an expiry record is stored for every message, it is listed to the
+// peers with the tombstones. Blobs outside of the database are deleted after the commit.
+// Returns the number of messages deleted.
+func (store Store) expireBatchTx(batch []sql.ExpireMessage) (int, error) {
+	var deleted []*[message.MessageIDSize]byte
+	tx, err := store.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	for i := range batch {
+		msg := &batch[i]
+		err := store.deleteMessageTx(tx, &msg.MessageID, &msg.SignerPub)
+		if err == sql.ErrNoModify {
+			// Deleted concurrently
+			continue
+		}
+		if err == nil {
+			err = tx.RememberMessage(&msg.MessageID)
+		}
+		if ts := store.expireRecord(&msg.MessageID); err == nil && ts != nil {
+			err = tx.InsertTombstone(ts, CurrentTime()+TombstoneMaxAge)
+		}
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		deleted = append(deleted, &msg.MessageID)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if _, isTx := store.blobs.(txBlobStore); !isTx {
+		for _, messageID := range deleted {
+			if err := store.blobs.DeleteBlob(messageID); err != nil {
+				log.Errorf("ExpireFromIndex, DeleteBlob: %s %s\n", err, utils.B58encode(messageID[:]))
+			}
+		}
+	}
+	return len(deleted), nil
 }
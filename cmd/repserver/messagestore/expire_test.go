@@ -0,0 +1,97 @@
+package messagestore
+
+import (
+	"crypto/rand"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/agl/ed25519"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+func testExpireStore(t *testing.T, name string) *Store {
+	dbFile := path.Join(os.TempDir(), "db.test-"+name)
+	os.Remove(dbFile)
+	store := New("sqlite3", dbFile, "", 10)
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+	store.SetPeeringKey(pubKey, privKey)
+	return store
+}
+
+func testExpirePut(t *testing.T, store *Store, id byte, expireRequest uint64) *structs.MessageStruct {
+	msg := &structs.MessageStruct{
+		MessageID:              [message.MessageIDSize]byte{id},
+		ReceiverConstantPubKey: message.Curve25519Key{id},
+		SignerPub:              [message.SignerPubKeySize]byte{id},
+		ExpireRequest:          expireRequest,
+	}
+	signer := &structs.SignerStruct{
+		PublicKey:           msg.SignerPub,
+		Bits:                8,
+		MaxMessagesPosted:   5,
+		MaxMessagesRetained: 5,
+	}
+	if err := store.Put(msg, signer, []byte("data")); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+	return msg
+}
+
+func TestExpireRecord(t *testing.T) {
+	now := uint64(CurrentTime())
+	origin, peer := testExpireStore(t, "expire-origin"), testExpireStore(t, "expire-peer")
+	defer os.Remove(path.Join(os.TempDir(), "db.test-expire-origin"))
+	defer os.Remove(path.Join(os.TempDir(), "db.test-expire-peer"))
+	msg := testExpirePut(t, origin, 0x01, now)
+	if more := origin.expireMessages(int64(now) + 10); more {
+		t.Error("Expire run not complete")
+	}
+	if !origin.MessageExists(msg.MessageID) {
+		t.Error("Expired message not remembered")
+	}
+	ts, err := origin.GetTombstone(&msg.MessageID)
+	if err != nil {
+		t.Fatalf("Expiry record not stored: %s", err)
+	}
+	if ts.AuthType != structs.TombstoneExpire || ts.PublicKey != *origin.peeringPub || !ts.Verify() {
+		t.Error("Bad expiry record")
+	}
+	tombstones, _, _, err := origin.ListTombstones(0, 10)
+	if err != nil || len(tombstones) != 1 {
+		t.Errorf("Expiry record not listed: %d %v", len(tombstones), err)
+	}
+	// The peer expires its copy only for the origin and if it was not requested longer
+	testExpirePut(t, peer, 0x01, now-10)
+	kept := testExpirePut(t, peer, 0x02, now+3600)
+	if err := peer.AddExpiry(ts, peer.peeringPub); err != ErrTombstone {
+		t.Errorf("Expiry record of other key accepted: %v", err)
+	}
+	if err := peer.AddTombstone(ts, false); err != ErrTombstone {
+		t.Errorf("Expiry record accepted as tombstone: %v", err)
+	}
+	if err := peer.AddExpiry(ts, origin.peeringPub); err != nil {
+		t.Fatalf("AddExpiry: %s", err)
+	}
+	if _, err := peer.Fetch(&msg.MessageID); err == nil {
+		t.Error("Copy not expired")
+	}
+	if ts2, err := peer.GetTombstone(&msg.MessageID); err != nil || ts2.PublicKey != *peer.peeringPub {
+		t.Errorf("Peer did not store own expiry record: %v", err)
+	}
+	if err := peer.AddExpiry(ts, origin.peeringPub); err != ErrNotFound {
+		t.Errorf("Second expiry must not find message: %v", err)
+	}
+	other := structs.NewExpireTombstone(&kept.MessageID, now, origin.peeringPub, origin.peeringPriv)
+	if err := peer.AddExpiry(other, origin.peeringPub); err != ErrTombstone {
+		t.Errorf("Copy requested longer expired: %v", err)
+	}
+	if _, err := peer.Fetch(&kept.MessageID); err != nil {
+		t.Errorf("Copy requested longer deleted: %s", err)
+	}
+}
@@ -1,17 +1,34 @@
 package messagestore
 
-import "github.com/repbin/repbin/message"
+import (
+	"time"
+
+	"github.com/repbin/repbin/cmd/repserver/stat"
+	log "github.com/repbin/repbin/deferconsole"
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+)
 
 // Fetch a message from storage, delete if it is a one-time message
 func (store Store) Fetch(messageID *[message.MessageIDSize]byte) ([]byte, error) {
-	mb, err := store.db.GetBlob(messageID)
+	defer stat.DBDuration.With("fetch").Since(time.Now())
+	_, msg, err := store.db.SelectMessageByID(messageID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	data, err := store.blobs.GetBlob(messageID)
 	if err != nil {
 		return nil, ErrNotFound
 	}
-	if mb.OneTime {
-		store.db.DelMessage(&mb.SignerPublicKey)
-		store.db.DeleteMessageByID(&mb.MessageID)
-		store.db.DeleteBlob(&mb.MessageID)
+	if msg.OneTime {
+		// Only the fetch that deletes the message may return it
+		if err := store.deleteMessage(&msg.MessageID, &msg.SignerPub); err != nil {
+			if err != ErrNotFound {
+				log.Errorf("messagestore, burn message: %s %s\n", err, utils.B58encode(msg.MessageID[:]))
+			}
+			return nil, ErrNotFound
+		}
+		stat.OneTimeBurns.Inc()
 	}
-	return mb.Data, nil
+	return data, nil
 }
@@ -1,11 +1,16 @@
 package messagestore
 
 import (
+	"time"
+
+	"github.com/repbin/repbin/cmd/repserver/stat"
 	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils/repproto/structs"
 )
 
 // GetIndex returns the index for a key
 func (store Store) GetIndex(index *message.Curve25519Key, start int64, count int64) ([][]byte, int, error) {
+	defer stat.DBDuration.With("index").Since(time.Now())
 	ret, i, err := store.db.GetKeyIndex(index, start, count)
 	if err != nil {
 		return nil, 0, ErrNotFound
@@ -15,9 +20,22 @@ func (store Store) GetIndex(index *message.Curve25519Key, start int64, count int
 
 // GetGlobalIndex returns the global index
 func (store Store) GetGlobalIndex(start int64, count int64) ([][]byte, int, error) {
+	defer stat.DBDuration.With("globalindex").Since(time.Now())
 	ret, i, err := store.db.GetGlobalIndex(start, count)
 	if err != nil {
 		return nil, 0, ErrNotFound
 	}
 	return ret, i, nil
 }
+
+// SyncIDs returns the unexpired messages in the global index whose hex MessageID starts with prefix.
+// Only MessageID, ExpireTime, PostTime and Distance are set
+func (store Store) SyncIDs(prefix string) ([]*structs.MessageStruct, error) {
+	defer stat.DBDuration.With("syncids").Since(time.Now())
+	return store.db.SelectSyncIDs(prefix, CurrentTime())
+}
+
+// GlobalIndexHead returns the highest entry of the global index
+func (store Store) GlobalIndexHead() (uint64, error) {
+	return store.db.GlobalIndexHead()
+}
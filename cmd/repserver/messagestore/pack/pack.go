@@ -0,0 +1,451 @@
+// Package pack implements append-only pack files for message blobs.
+//
+// Blobs are appended to the current pack file, each prefixed by a record header
+// containing a flag byte, the message ID and the length of the blob. Deleted blobs
+// are only flagged, their space is reclaimed by Compact. The offset index is kept
+// in memory and rebuilt from the record headers on Open.
+//
+// Inserts are synced to disk before they return. Delete flags are not synced: a flag
+// lost in a crash revives the blob, which then has no message and is reported by
+// the message store's scrub.
+package pack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/repbin/repbin/message"
+)
+
+// Version of this release
+const Version = "0.0.1 very alpha"
+
+const (
+	flagLive    = byte(0x01)
+	flagDeleted = byte(0x00)
+	// HeaderSize is the size of the record header: flag, messageID, length
+	HeaderSize = 1 + message.MessageIDSize + 4
+	packSuffix = ".pack"
+)
+
+var (
+	// ErrNotFound is returned if a blob is not in the store
+	ErrNotFound = errors.New("pack: Blob not found")
+	// ErrCorrupt is returned if a pack file contains an invalid record
+	ErrCorrupt = errors.New("pack: Corrupt pack file")
+	// ErrClosed is returned if the store has been closed
+	ErrClosed = errors.New("pack: Store closed")
+)
+
+// MaxPackSize is the size after which a new pack file is started
+var MaxPackSize = int64(64 * 1024 * 1024)
+
+// CompactRatio is the fraction of deleted bytes at which a pack file is compacted
+var CompactRatio = 0.5
+
+type location struct {
+	pack   uint64 // number of pack file
+	offset int64  // offset of record header
+	length uint32 // length of data
+}
+
+type packFile struct {
+	file    *os.File
+	size    int64 // bytes in file
+	deleted int64 // bytes used by deleted records
+}
+
+// Store is a pack file blob store.
+type Store struct {
+	dir          string
+	mutex        sync.RWMutex
+	compactMutex sync.Mutex // only one Compact at a time
+	index        map[[message.MessageIDSize]byte]location
+	packs        map[uint64]*packFile
+	active       uint64 // pack file to append to
+	closed       bool
+}
+
+// Open opens the pack files in dir, creating dir if necessary.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		dir:   dir,
+		index: make(map[[message.MessageIDSize]byte]location),
+		packs: make(map[uint64]*packFile),
+	}
+	numbers, err := s.listPacks()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range numbers {
+		if err := s.loadPack(n); err != nil {
+			s.closeFiles()
+			return nil, err
+		}
+		s.active = n
+	}
+	if len(numbers) == 0 {
+		if err := s.newPack(1); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// packName returns the filename of pack file n.
+func (s *Store) packName(n uint64) string {
+	return path.Join(s.dir, fmt.Sprintf("%016d%s", n, packSuffix))
+}
+
+// listPacks returns the numbers of existing pack files in ascending order.
+func (s *Store) listPacks() ([]uint64, error) {
+	d, err := os.Open(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	numbers := make([]uint64, 0, len(names))
+	for _, name := range names {
+		if !strings.HasSuffix(name, packSuffix) {
+			continue
+		}
+		var n uint64
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, packSuffix), "%d", &n); err != nil {
+			continue
+		}
+		numbers = append(numbers, n)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers, nil
+}
+
+// newPack creates pack file n and makes it the active pack.
+func (s *Store) newPack(n uint64) error {
+	f, err := os.OpenFile(s.packName(n), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	s.packs[n] = &packFile{file: f}
+	s.active = n
+	return nil
+}
+
+// loadPack reads the record headers of pack file n into the index. A truncated
+// record at the end of the file (interrupted write) is cut off.
+func (s *Store) loadPack(n uint64) error {
+	var header [HeaderSize]byte
+	var messageID [message.MessageIDSize]byte
+	f, err := os.OpenFile(s.packName(n), os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	pf := &packFile{file: f, size: fi.Size()}
+	offset := int64(0)
+	for offset < pf.size {
+		if _, err := f.ReadAt(header[:], offset); err != nil {
+			if err == io.EOF {
+				break
+			}
+			f.Close()
+			return err
+		}
+		length := binary.BigEndian.Uint32(header[1+message.MessageIDSize:])
+		if offset+HeaderSize+int64(length) > pf.size {
+			break
+		}
+		switch header[0] {
+		case flagLive:
+			copy(messageID[:], header[1:1+message.MessageIDSize])
+			if _, ok := s.index[messageID]; ok {
+				// Duplicate left by an interrupted compaction, keep the first copy
+				if _, err := f.WriteAt([]byte{flagDeleted}, offset); err != nil {
+					f.Close()
+					return err
+				}
+				pf.deleted += HeaderSize + int64(length)
+			} else {
+				s.index[messageID] = location{pack: n, offset: offset, length: length}
+			}
+		case flagDeleted:
+			pf.deleted += HeaderSize + int64(length)
+		default:
+			f.Close()
+			return ErrCorrupt
+		}
+		offset += HeaderSize + int64(length)
+	}
+	if offset < pf.size {
+		// Cut off incomplete record
+		if err := f.Truncate(offset); err != nil {
+			f.Close()
+			return err
+		}
+		pf.size = offset
+	}
+	s.packs[n] = pf
+	return nil
+}
+
+// closeFiles closes all pack files.
+func (s *Store) closeFiles() error {
+	var err error
+	for _, pf := range s.packs {
+		if e := pf.file.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// writeRecord appends a record to pack file n and returns its location.
+func (s *Store) writeRecord(n uint64, messageID *[message.MessageIDSize]byte, data []byte) (location, error) {
+	pf := s.packs[n]
+	record := make([]byte, HeaderSize+len(data))
+	record[0] = flagLive
+	copy(record[1:], messageID[:])
+	binary.BigEndian.PutUint32(record[1+message.MessageIDSize:], uint32(len(data)))
+	copy(record[HeaderSize:], data)
+	if _, err := pf.file.WriteAt(record, pf.size); err != nil {
+		// Remove partial write
+		pf.file.Truncate(pf.size)
+		return location{}, err
+	}
+	loc := location{pack: n, offset: pf.size, length: uint32(len(data))}
+	pf.size += int64(len(record))
+	return loc, nil
+}
+
+// Insert adds a blob. Blobs are addressed by their message ID, inserting an existing blob is a no-op.
+func (s *Store) Insert(messageID *[message.MessageIDSize]byte, data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if _, ok := s.index[*messageID]; ok {
+		return nil
+	}
+	if s.packs[s.active].size >= MaxPackSize {
+		if err := s.newPack(s.active + 1); err != nil {
+			return err
+		}
+	}
+	loc, err := s.writeRecord(s.active, messageID, data)
+	if err != nil {
+		return err
+	}
+	if err := s.packs[s.active].file.Sync(); err != nil {
+		s.packs[s.active].file.Truncate(loc.offset)
+		s.packs[s.active].size = loc.offset
+		return err
+	}
+	s.index[*messageID] = loc
+	return nil
+}
+
+// Get returns the blob for messageID.
+func (s *Store) Get(messageID *[message.MessageIDSize]byte) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	loc, ok := s.index[*messageID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	data := make([]byte, loc.length)
+	if _, err := s.packs[loc.pack].file.ReadAt(data, loc.offset+HeaderSize); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Delete flags the blob for messageID as deleted. The flag is not synced to disk.
+func (s *Store) Delete(messageID *[message.MessageIDSize]byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	loc, ok := s.index[*messageID]
+	if !ok {
+		return ErrNotFound
+	}
+	pf := s.packs[loc.pack]
+	if _, err := pf.file.WriteAt([]byte{flagDeleted}, loc.offset); err != nil {
+		return err
+	}
+	pf.deleted += HeaderSize + int64(loc.length)
+	delete(s.index, *messageID)
+	return nil
+}
+
+// Len returns the number of blobs in the store.
+func (s *Store) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.index)
+}
+
+// List returns the messageIDs of all blobs in the store.
+func (s *Store) List() ([][message.MessageIDSize]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	ids := make([][message.MessageIDSize]byte, 0, len(s.index))
+	for messageID := range s.index {
+		ids = append(ids, messageID)
+	}
+	return ids, nil
+}
+
+// Size returns the number of bytes in all pack files.
+func (s *Store) Size() int64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var size int64
+	for _, pf := range s.packs {
+		size += pf.size
+	}
+	return size
+}
+
+// Compact rewrites pack files of which at least CompactRatio is occupied by deleted blobs.
+// Live blobs are appended to the active pack file and the old pack file is removed.
+// Blobs are read without holding the store lock, the lock is only taken to append a
+// blob and update its index entry.
+func (s *Store) Compact() error {
+	s.compactMutex.Lock()
+	defer s.compactMutex.Unlock()
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrClosed
+	}
+	numbers := make([]uint64, 0, len(s.packs))
+	for n, pf := range s.packs {
+		if pf.size == 0 || float64(pf.deleted)/float64(pf.size) < CompactRatio {
+			continue
+		}
+		numbers = append(numbers, n)
+		if n == s.active {
+			// Never copy into the file that is compacted
+			if err := s.newPack(s.active + 1); err != nil {
+				s.mutex.Unlock()
+				return err
+			}
+		}
+	}
+	s.mutex.Unlock()
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	for _, n := range numbers {
+		if err := s.compactPack(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactPack moves the live blobs of pack file n to the active pack and removes n.
+// Pack n is not the active pack, so only Delete and compactPack modify it.
+func (s *Store) compactPack(n uint64) error {
+	type record struct {
+		messageID [message.MessageIDSize]byte
+		loc       location
+	}
+	s.mutex.RLock()
+	if s.closed {
+		s.mutex.RUnlock()
+		return ErrClosed
+	}
+	pf := s.packs[n]
+	records := make([]record, 0, len(s.index)/len(s.packs))
+	for messageID, loc := range s.index {
+		if loc.pack == n {
+			records = append(records, record{messageID: messageID, loc: loc})
+		}
+	}
+	s.mutex.RUnlock()
+	for i := range records {
+		data := make([]byte, records[i].loc.length)
+		_, readErr := pf.file.ReadAt(data, records[i].loc.offset+HeaderSize)
+		if err := s.moveRecord(&records[i].messageID, records[i].loc, data, readErr); err != nil {
+			return err
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if err := s.packs[s.active].file.Sync(); err != nil {
+		return err
+	}
+	pf.file.Close()
+	delete(s.packs, n)
+	return os.Remove(s.packName(n))
+}
+
+// moveRecord appends data read from loc to the active pack and points the index entry of
+// messageID to it, unless the blob has been deleted since it was read.
+func (s *Store) moveRecord(messageID *[message.MessageIDSize]byte, loc location, data []byte, readErr error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if cur, ok := s.index[*messageID]; !ok || cur != loc {
+		// Deleted while reading
+		return nil
+	}
+	if readErr != nil {
+		return readErr
+	}
+	if s.packs[s.active].size >= MaxPackSize {
+		if err := s.newPack(s.active + 1); err != nil {
+			return err
+		}
+	}
+	newLoc, err := s.writeRecord(s.active, messageID, data)
+	if err != nil {
+		return err
+	}
+	s.index[*messageID] = newLoc
+	return nil
+}
+
+// Close syncs and closes all pack files.
+func (s *Store) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if pf, ok := s.packs[s.active]; ok {
+		pf.file.Sync()
+	}
+	return s.closeFiles()
+}
@@ -0,0 +1,170 @@
+package pack
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/repbin/repbin/message"
+)
+
+func testBlob(i byte) (*[message.MessageIDSize]byte, []byte) {
+	messageID := &[message.MessageIDSize]byte{i, 0x01, 0x02}
+	return messageID, bytes.Repeat([]byte{i, 0xff, 0x01, 0x03}, 1024)
+}
+
+func TestPack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repbinpack")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(max int64) { MaxPackSize = max }(MaxPackSize)
+	MaxPackSize = 3 * (HeaderSize + 4096)
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	for i := byte(0); i < 10; i++ {
+		messageID, data := testBlob(i)
+		if err := s.Insert(messageID, data); err != nil {
+			t.Fatalf("Insert: %s", err)
+		}
+	}
+	messageID, data := testBlob(3)
+	if err := s.Insert(messageID, data); err != nil {
+		t.Errorf("Duplicate insert: %s", err)
+	}
+	if s.Len() != 10 {
+		t.Errorf("Bad length: %d", s.Len())
+	}
+	if len(s.packs) != 4 {
+		t.Errorf("Bad pack count: %d", len(s.packs))
+	}
+	for i := byte(0); i < 8; i++ {
+		messageID, _ := testBlob(i)
+		if err := s.Delete(messageID); err != nil {
+			t.Fatalf("Delete: %s", err)
+		}
+	}
+	if _, err := s.Get(messageID); err != ErrNotFound {
+		t.Errorf("Deleted blob returned: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Reopen: %s", err)
+	}
+	if s.Len() != 2 {
+		t.Errorf("Bad length after reopen: %d", s.Len())
+	}
+	ids, err := s.List()
+	if err != nil {
+		t.Fatalf("List: %s", err)
+	}
+	if len(ids) != 2 {
+		t.Errorf("Bad list length: %d", len(ids))
+	}
+	for _, id := range ids {
+		if id[0] < 8 {
+			t.Errorf("Deleted blob listed: %d", id[0])
+		}
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatalf("Compact: %s", err)
+	}
+	if len(s.packs) != 1 {
+		t.Errorf("Bad pack count after compaction: %d", len(s.packs))
+	}
+	for i := byte(8); i < 10; i++ {
+		messageID, data := testBlob(i)
+		res, err := s.Get(messageID)
+		if err != nil {
+			t.Fatalf("Get: %s", err)
+		}
+		if !bytes.Equal(res, data) {
+			t.Errorf("Data mismatch: %d", i)
+		}
+	}
+	s.Close()
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Reopen: %s", err)
+	}
+	defer s.Close()
+	if s.Len() != 2 {
+		t.Errorf("Bad length after compaction: %d", s.Len())
+	}
+}
+
+func TestCompactConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repbinpack")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(max int64) { MaxPackSize = max }(MaxPackSize)
+	MaxPackSize = 3 * (HeaderSize + 4096)
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer s.Close()
+	for i := byte(0); i < 30; i++ {
+		messageID, data := testBlob(i)
+		if err := s.Insert(messageID, data); err != nil {
+			t.Fatalf("Insert: %s", err)
+		}
+	}
+	for i := byte(0); i < 30; i += 3 {
+		messageID, _ := testBlob(i)
+		if err := s.Delete(messageID); err != nil {
+			t.Fatalf("Delete: %s", err)
+		}
+	}
+	// A blob deleted after it has been read is not moved
+	messageID, data := testBlob(1)
+	loc := s.index[*messageID]
+	if err := s.Delete(messageID); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if err := s.moveRecord(messageID, loc, data, nil); err != nil {
+		t.Errorf("moveRecord: %s", err)
+	}
+	if _, err := s.Get(messageID); err != ErrNotFound {
+		t.Errorf("Deleted blob moved: %v", err)
+	}
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := byte(2); i < 30; i += 3 {
+			messageID, data := testBlob(i)
+			if res, err := s.Get(messageID); err != nil || !bytes.Equal(res, data) {
+				t.Errorf("Get during compaction: %d %v", i, err)
+			}
+			if err := s.Delete(messageID); err != nil {
+				t.Errorf("Delete during compaction: %s", err)
+			}
+			messageID, data = testBlob(i + 100)
+			if err := s.Insert(messageID, data); err != nil {
+				t.Errorf("Insert during compaction: %s", err)
+			}
+		}
+	}()
+	if err := s.Compact(); err != nil {
+		t.Fatalf("Compact: %s", err)
+	}
+	<-done
+	if s.Len() != 19 {
+		t.Errorf("Bad length after compaction: %d", s.Len())
+	}
+	for i := byte(4); i < 30; i += 3 {
+		messageID, data := testBlob(i)
+		if res, err := s.Get(messageID); err != nil || !bytes.Equal(res, data) {
+			t.Errorf("Get after compaction: %d %v", i, err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package messagestore
 
 import (
 	"github.com/agl/ed25519"
+	"github.com/repbin/repbin/cmd/repserver/messagestore/sql"
 	log "github.com/repbin/repbin/deferconsole"
 	"github.com/repbin/repbin/utils"
 	"github.com/repbin/repbin/utils/keyproof"
@@ -24,6 +25,14 @@ func (store Store) UpdatePeerFetchStat(pubkey *[ed25519.PublicKeySize]byte, last
 	}
 }
 
+// UpdatePeerTombstone records the position of the last tombstone fetched from the peer
+func (store Store) UpdatePeerTombstone(pubkey *[ed25519.PublicKeySize]byte, lastTombstone uint64) {
+	err := store.db.UpdatePeerTombstone(pubkey, lastTombstone)
+	if err != nil && err != sql.ErrNoModify {
+		log.Errorf("UpdatePeerTombstone: %s, %s\n", err, utils.B58encode(pubkey[:]))
+	}
+}
+
 // UpdatePeerNotification updates the peer stat after notification send
 func (store Store) UpdatePeerNotification(pubkey *[ed25519.PublicKeySize]byte, hasError bool) {
 	err := store.db.UpdatePeerNotification(pubkey, hasError)
@@ -32,6 +41,14 @@ func (store Store) UpdatePeerNotification(pubkey *[ed25519.PublicKeySize]byte, h
 	}
 }
 
+// UpdatePeerHealth records the health of the peer
+func (store Store) UpdatePeerHealth(pubkey *[ed25519.PublicKeySize]byte, failCount, retryAfter, quarantinedSince uint64, lastError string, lastErrorTime uint64) {
+	err := store.db.UpdatePeerHealth(pubkey, failCount, retryAfter, quarantinedSince, lastError, lastErrorTime)
+	if err != nil {
+		log.Errorf("UpdatePeerHealth: %s, %s\n", err, utils.B58encode(pubkey[:]))
+	}
+}
+
 // GetPeerStat returns the last entry of peer statistics for pubkey
 func (store Store) GetPeerStat(pubkey *[ed25519.PublicKeySize]byte) *structs.PeerStruct {
 	st, err := store.db.SelectPeer(pubkey)
@@ -14,3 +14,12 @@ func (store Store) PreExpire(messageID *[message.MessageIDSize]byte, pubkey *mes
 	}
 	return ErrNotFound
 }
+
+// Recipient returns the constant public key of the recipient of a message
+func (store Store) Recipient(messageID *[message.MessageIDSize]byte) (*message.Curve25519Key, error) {
+	_, message, err := store.db.SelectMessageByID(messageID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return &message.ReceiverConstantPubKey, nil
+}
@@ -1,6 +1,10 @@
 package messagestore
 
 import (
+	"time"
+
+	"github.com/repbin/repbin/cmd/repserver/messagestore/sql"
+	"github.com/repbin/repbin/cmd/repserver/stat"
 	log "github.com/repbin/repbin/deferconsole"
 	"github.com/repbin/repbin/message"
 	"github.com/repbin/repbin/utils/repproto/structs"
@@ -11,12 +15,17 @@ func (store Store) MessageExists(messageID [message.MessageIDSize]byte) bool {
 	return store.db.MessageKnown(&messageID)
 }
 
-// Put stores a message in the message store WITHOUT notifying the notify backend
+// Put stores a message in the message store WITHOUT notifying the notify backend.
+// The message, the signer and its quota are written in one transaction.
 func (store Store) Put(msgStruct *structs.MessageStruct, signerStruct *structs.SignerStruct, message []byte) error {
+	defer stat.DBDuration.With("put").Since(time.Now())
 	// Check if message exists
 	if store.db.MessageKnown(&msgStruct.MessageID) {
 		return ErrDuplicate
 	}
+	if store.deleted(msgStruct) {
+		return ErrDeleted
+	}
 	// Check if signer exists, load last from signer
 	_, signerLoaded, _ := store.db.SelectSigner(&signerStruct.PublicKey)
 	if signerLoaded != nil {
@@ -27,46 +36,75 @@ func (store Store) Put(msgStruct *structs.MessageStruct, signerStruct *structs.S
 			signerStruct.MaxMessagesPosted = signerLoaded.MaxMessagesPosted
 			signerStruct.MaxMessagesRetained = signerLoaded.MaxMessagesRetained
 		}
-		// Update post data
-		signerStruct.MessagesPosted = signerLoaded.MessagesPosted
-		signerStruct.MessagesRetained = signerLoaded.MessagesRetained
-	}
-	// Verify signer
-	if signerStruct.MessagesPosted >= signerStruct.MaxMessagesPosted {
-		return ErrPostLimit
-	}
-	if signerStruct.MessagesRetained >= signerStruct.MaxMessagesRetained {
-		// Retention is changed by expiring messages
-		return ErrPostLimit
 	}
 	msgStruct.PostTime = uint64(CurrentTime())
 	msgStruct.ExpireTime = uint64(uint64(CurrentTime()) + signerStruct.ExpireTarget)
 	if msgStruct.ExpireTime < msgStruct.ExpireRequest {
 		msgStruct.ExpireTime = msgStruct.ExpireRequest
 	}
-	// Update signer
-	err := store.db.InsertOrUpdateSigner(signerStruct)
+	tx, err := store.db.Begin()
 	if err != nil {
+		log.Errorf("messagestore, begin transaction: %s", err)
+		return err
+	}
+	if err := store.putTx(tx, msgStruct, signerStruct, message); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Errorf("messagestore, commit message: %s", err)
+		if _, isTx := store.blobs.(txBlobStore); !isTx {
+			store.blobs.DeleteBlob(&msgStruct.MessageID)
+		}
+		return err
+	}
+	store.watch.notify(&msgStruct.ReceiverConstantPubKey)
+	return nil
+}
+
+// putTx writes message, signer and blob within tx. Blobs outside of the database
+// are written before the commit, a blob left behind by a failed commit is removed
+// by Scrub.
+func (store Store) putTx(tx *sql.Tx, msgStruct *structs.MessageStruct, signerStruct *structs.SignerStruct, message []byte) error {
+	// Update signer. MySQL reports unchanged rows as not modified
+	err := tx.InsertOrUpdateSigner(signerStruct)
+	if err != nil && err != sql.ErrNoModify {
 		log.Errorf("messagestore, update signer: %s", err)
+		return err
+	}
+	// Count message against the signer's limits
+	err = tx.AddMessage(&signerStruct.PublicKey)
+	if err == sql.ErrNoModify {
+		return ErrPostLimit
 	}
-	// Write message. Message is prefixed by encoded messageStruct
-	storeID, err := store.db.InsertMessage(msgStruct)
 	if err != nil {
-		log.Errorf("messagestore, write message (DB): %s", err)
+		log.Errorf("messagestore, update signer stats: %s", err)
 		return err
 	}
-	store.db.LearnMessage(&msgStruct.MessageID)
-	err = store.db.InsertBlob(storeID, &msgStruct.MessageID, &signerStruct.PublicKey, msgStruct.OneTime, message)
+	storeID, err := tx.InsertMessage(msgStruct)
 	if err != nil {
-		log.Errorf("messagestore, write message (Blob): %s", err)
+		log.Errorf("messagestore, write message (DB): %s", err)
+		return err
+	}
+	if err := tx.LearnMessage(&msgStruct.MessageID); err != nil {
+		log.Errorf("messagestore, learn message: %s", err)
 		return err
 	}
 	if !msgStruct.OneTime && msgStruct.Sync {
-		err := store.db.AddToGlobalIndex(storeID)
-		if err != nil {
+		if err := tx.AddToGlobalIndex(storeID); err != nil {
 			log.Errorf("messagestore, globalindex append: %s", err)
+			return err
 		}
 	}
+	if txBlobs, ok := store.blobs.(txBlobStore); ok {
+		err = txBlobs.InsertBlobTx(tx, storeID, &msgStruct.MessageID, &signerStruct.PublicKey, msgStruct.OneTime, message)
+	} else {
+		err = store.blobs.InsertBlob(storeID, &msgStruct.MessageID, &signerStruct.PublicKey, msgStruct.OneTime, message)
+	}
+	if err != nil {
+		log.Errorf("messagestore, write message (Blob): %s", err)
+		return err
+	}
 	return nil
 }
 
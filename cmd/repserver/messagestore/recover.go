@@ -0,0 +1,85 @@
+package messagestore
+
+import (
+	"errors"
+
+	"github.com/repbin/repbin/cmd/repserver/messagestore/sql"
+	log "github.com/repbin/repbin/deferconsole"
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+)
+
+// ErrBlobMismatch is returned by CheckBlobs if too many blobs and messages do not match
+var ErrBlobMismatch = errors.New("messagestore: Too many blobs and messages do not match, run --scrub")
+
+// MaxBlobMismatches is the number of orphaned blobs and messages without blob accepted by
+// CheckBlobs. More indicate a wrong database or blob directory. Negative for no limit.
+var MaxBlobMismatches = 100
+
+// messageSigners maps messageIDs to the public key of the signer
+type messageSigners map[[message.MessageIDSize]byte][message.SignerPubKeySize]byte
+
+// listMessages returns all messages in the database
+func (store Store) listMessages() (messageSigners, error) {
+	messages := make(messageSigners)
+	err := store.db.ListMessages(func(msg *sql.ExpireMessage) error {
+		messages[msg.MessageID] = msg.SignerPub
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// blobMismatches returns the blobs that are not in messages and the messages that
+// have no blob
+func (store Store) blobMismatches(messages messageSigners) ([][message.MessageIDSize]byte, messageSigners, error) {
+	var orphans [][message.MessageIDSize]byte
+	seen := make(map[[message.MessageIDSize]byte]bool, len(messages))
+	err := store.blobs.List(func(messageID *[message.MessageIDSize]byte) error {
+		if _, ok := messages[*messageID]; ok {
+			seen[*messageID] = true
+		} else {
+			orphans = append(orphans, *messageID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	dangling := make(messageSigners)
+	for messageID, signer := range messages {
+		if !seen[messageID] {
+			dangling[messageID] = signer
+		}
+	}
+	return orphans, dangling, nil
+}
+
+// CheckBlobs reports blobs that have no message in the database and messages that have
+// no blob. Blobs are left behind if the server stops between writing a blob and committing
+// its message, or between deleting a message and deleting its blob. Messages without blob
+// indicate lost storage. Nothing is deleted, Scrub with repair removes them. Returns the
+// number of orphaned blobs and messages without blob, and ErrBlobMismatch if there are more
+// than MaxBlobMismatches together.
+func (store Store) CheckBlobs() (int, int, error) {
+	messages, err := store.listMessages()
+	if err != nil {
+		return 0, 0, err
+	}
+	orphans, dangling, err := store.blobMismatches(messages)
+	if err != nil {
+		return 0, 0, err
+	}
+	for i := range orphans {
+		log.Debugf("CheckBlobs, orphaned blob: %s\n", utils.B58encode(orphans[i][:]))
+	}
+	for messageID := range dangling {
+		log.Debugf("CheckBlobs, message without blob: %s\n", utils.B58encode(messageID[:]))
+	}
+	if MaxBlobMismatches >= 0 && len(orphans)+len(dangling) > MaxBlobMismatches {
+		return len(orphans), len(dangling), ErrBlobMismatch
+	}
+	return len(orphans), len(dangling), nil
+}
@@ -0,0 +1,153 @@
+package messagestore
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/repbin/repbin/deferconsole"
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+)
+
+var (
+	// ErrBadMessageID is returned if the blob of a message does not match its MessageID
+	ErrBadMessageID = errors.New("messagestore: Blob does not match MessageID")
+	// ErrBadSigner is returned if the blob of a message is not signed by the signer of the message
+	ErrBadSigner = errors.New("messagestore: Blob does not match signer")
+)
+
+// ScrubReport contains the problems found by Scrub
+type ScrubReport struct {
+	Messages      int   // Messages checked
+	Corrupt       int   // Messages whose blob fails verification
+	Dangling      int   // Messages without blob
+	OrphanedBlobs int   // Blobs without message
+	GlobalIndex   int64 // Global index entries without message
+	Signers       int   // Signers whose retained messages do not match their messages
+	Repaired      int64 // Problems repaired
+}
+
+// Problems returns the number of problems found
+func (r *ScrubReport) Problems() int64 {
+	return int64(r.Corrupt+r.Dangling+r.OrphanedBlobs+r.Signers) + r.GlobalIndex
+}
+
+func (r *ScrubReport) String() string {
+	return fmt.Sprintf("messages: %d corrupt: %d without blob: %d orphaned blobs: %d orphaned global index: %d signers: %d repaired: %d",
+		r.Messages, r.Corrupt, r.Dangling, r.OrphanedBlobs, r.GlobalIndex, r.Signers, r.Repaired)
+}
+
+// verifyBlob verifies signature, hashcash and MessageID of a blob.
+func verifyBlob(messageID *[message.MessageIDSize]byte, signer *[message.SignerPubKeySize]byte, data []byte, minBits byte) error {
+	signheader, err := message.Base64Message(data).GetSignHeader()
+	if err != nil {
+		return err
+	}
+	details, err := message.VerifySignature(*signheader, minBits)
+	if err != nil {
+		return err
+	}
+	msg, err := message.Base64Message(data).Decode()
+	if err != nil {
+		return err
+	}
+	if details.MsgID != *messageID || *message.CalcMessageID(msg) != *messageID {
+		return ErrBadMessageID
+	}
+	if details.PublicKey != *signer {
+		return ErrBadSigner
+	}
+	return nil
+}
+
+// Scrub checks the integrity of the store. The blob of every message is verified
+// against its MessageID, signature and hashcash (at least minBits). Messages without
+// blob, blobs without message, global index entries without message and signers whose
+// retained counter is wrong are reported. If repair is true, bad messages, orphaned
+// blobs and global index entries are deleted and the counters are corrected. Scrub
+// must not run while the store is used.
+func (store Store) Scrub(minBits byte, repair bool) (*ScrubReport, error) {
+	report := new(ScrubReport)
+	messages, err := store.listMessages()
+	if err != nil {
+		return nil, err
+	}
+	orphans, dangling, err := store.blobMismatches(messages)
+	if err != nil {
+		return nil, err
+	}
+	for messageID, signer := range messages {
+		report.Messages++
+		if _, ok := dangling[messageID]; ok {
+			report.Dangling++
+			log.Printf("Scrub: message without blob: %s\n", utils.B58encode(messageID[:]))
+			if repair {
+				if err := store.deleteMessageRow(&messageID, &signer); err != nil {
+					log.Errorf("Scrub, deleteMessageRow: %s %s\n", err, utils.B58encode(messageID[:]))
+					continue
+				}
+				report.Repaired++
+			}
+			continue
+		}
+		data, err := store.blobs.GetBlob(&messageID)
+		if err == nil {
+			err = verifyBlob(&messageID, &signer, data, minBits)
+		}
+		if err == nil {
+			continue
+		}
+		report.Corrupt++
+		log.Printf("Scrub: corrupt message: %s %s\n", utils.B58encode(messageID[:]), err)
+		if repair {
+			if err := store.deleteMessage(&messageID, &signer); err != nil {
+				log.Errorf("Scrub, deleteMessage: %s %s\n", err, utils.B58encode(messageID[:]))
+				continue
+			}
+			report.Repaired++
+		}
+	}
+	for i := range orphans {
+		report.OrphanedBlobs++
+		log.Printf("Scrub: orphaned blob: %s\n", utils.B58encode(orphans[i][:]))
+		if repair {
+			if err := store.blobs.DeleteBlob(&orphans[i]); err != nil {
+				log.Errorf("Scrub, DeleteBlob: %s %s\n", err, utils.B58encode(orphans[i][:]))
+				continue
+			}
+			report.Repaired++
+		}
+	}
+	if report.GlobalIndex, err = store.db.GlobalIndexOrphans(); err != nil {
+		return report, err
+	}
+	if report.GlobalIndex > 0 {
+		log.Printf("Scrub: global index entries without message: %d\n", report.GlobalIndex)
+		if repair {
+			n, err := store.db.DeleteGlobalIndexOrphans()
+			if err != nil {
+				return report, err
+			}
+			report.Repaired += n
+		}
+	}
+	signers, err := store.db.SelectSignerRetained()
+	if err != nil {
+		return report, err
+	}
+	for i := range signers {
+		report.Signers++
+		log.Printf("Scrub: signer %s retains %d messages, recorded: %d\n", utils.B58encode(signers[i].PublicKey[:]), signers[i].Messages, signers[i].Retained)
+		if repair {
+			if err := store.db.SetSignerRetained(&signers[i].PublicKey, signers[i].Messages); err != nil {
+				log.Errorf("Scrub, SetSignerRetained: %s\n", err)
+				continue
+			}
+			report.Repaired++
+		}
+	}
+	if repair && report.Repaired > 0 {
+		store.UpdateBlobSize()
+	}
+	return report, nil
+}
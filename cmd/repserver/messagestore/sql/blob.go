@@ -1,9 +1,12 @@
 package sql
 
 import (
+	"encoding/hex"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/repbin/repbin/message"
 )
@@ -35,7 +38,7 @@ func (db *MessageDB) InsertBlob(id uint64, messageID *[message.MessageIDSize]byt
 
 // InsertBlobDB inserts a blob into the database
 func (db *MessageDB) InsertBlobDB(id uint64, messageID *[message.MessageIDSize]byte, signer *[message.SignerPubKeySize]byte, onetime bool, data []byte) error {
-	_, err := db.messageBlobInsertQ.Exec(id, toHex(messageID[:]), toHex(signer[:]), onetime, data)
+	_, err := db.messageBlobInsertQ.Exec(id, toHex(messageID[:]), toHex(signer[:]), boolToInt(onetime), data)
 	return err
 }
 
@@ -95,8 +98,7 @@ func (db *MessageDB) GetBlobFS(messageID *[message.MessageIDSize]byte) (*Message
 	if err != nil {
 		return nil, err
 	}
-	_, filename := db.messageIDToFilename(messageID)
-	mb.Data, err = ioutil.ReadFile(filename)
+	mb.Data, err = db.ReadBlobFS(messageID)
 	if err != nil {
 		return nil, err
 	}
@@ -107,6 +109,12 @@ func (db *MessageDB) GetBlobFS(messageID *[message.MessageIDSize]byte) (*Message
 	return mb, nil
 }
 
+// ReadBlobFS reads the data of a blob from the filesystem
+func (db *MessageDB) ReadBlobFS(messageID *[message.MessageIDSize]byte) ([]byte, error) {
+	_, filename := db.messageIDToFilename(messageID)
+	return ioutil.ReadFile(filename)
+}
+
 // GetBlobDB returns the blob identified by messageID from the database
 func (db *MessageDB) GetBlobDB(messageID *[message.MessageIDSize]byte) (*MessageBlob, error) {
 	var messageIDT, signerPubT string
@@ -127,3 +135,84 @@ func (db *MessageDB) GetBlobDB(messageID *[message.MessageIDSize]byte) (*Message
 	mb.OneTime = intToBool(onetimeT)
 	return mb, nil
 }
+
+// BlobSizeFS returns the number of bytes of the blobs stored in the filesystem
+func (db *MessageDB) BlobSizeFS() (int64, error) {
+	var size int64
+	err := filepath.Walk(db.dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(db.dir, name)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if info.IsDir() {
+			// Only descend into blob directories
+			if rel != "." && (len(parts) > 2 || len(parts[len(parts)-1]) != 3) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if len(parts) == 3 && len(parts[2]) == message.MessageIDSize*2-6 {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// ListBlobsFS calls fn for the messageID of each blob stored in the filesystem
+func (db *MessageDB) ListBlobsFS(fn func(messageID *[message.MessageIDSize]byte) error) error {
+	return filepath.Walk(db.dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(db.dir, name)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if info.IsDir() {
+			if rel != "." && (len(parts) > 2 || len(parts[len(parts)-1]) != 3) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if len(parts) != 3 || len(parts[2]) != message.MessageIDSize*2-6 {
+			return nil
+		}
+		mid, err := hex.DecodeString(parts[0] + parts[1] + parts[2])
+		if err != nil {
+			return nil
+		}
+		return fn(sliceToMessageID(mid))
+	})
+}
+
+// ListBlobsDB calls fn for the messageID of each blob stored in the database
+func (db *MessageDB) ListBlobsDB(fn func(messageID *[message.MessageIDSize]byte) error) error {
+	rows, err := db.db.Query(db.queries["messageBlobList"])
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var messageIDT string
+		if err := rows.Scan(&messageIDT); err != nil {
+			return err
+		}
+		if err := fn(sliceToMessageID(fromHex(messageIDT))); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// BlobSizeDB returns the number of bytes of the blobs stored in the database
+func (db *MessageDB) BlobSizeDB() (int64, error) {
+	var size int64
+	err := db.db.QueryRow(db.queries["messageBlobSize"]).Scan(&size)
+	return size, err
+}
@@ -42,63 +42,8 @@ var testMessageBlob = &MessageBlob{
 	Data:            bytes.Repeat([]byte{0x00, 0xff, 0x01, 0x03, 0x07, 0x13, 0x23, 0x40}, 65536),
 }
 
-func TestBlobMysql(t *testing.T) {
-	if !testing.Short() {
-		dir := "" //path.Join(os.TempDir(), "repbinmsg")
-		db, err := newMySQLForTest(dir, 100)
-		if err != nil {
-			t.Fatalf("New Mysql: %s", err)
-		}
-		defer db.Close()
-		testMessageBlob.ID, err = db.InsertMessage(testBlobMessage)
-		if err != nil {
-			t.Errorf("InsertMessage: %s", err)
-		}
-		err = db.InsertBlobStruct(testMessageBlob)
-		if err != nil {
-			t.Errorf("InsertBlobStruct: %s", err)
-		}
-		testBlobRes, err := db.GetBlobDB(&testMessageBlob.MessageID)
-		if err != nil {
-			t.Errorf("GetBlob: %s", err)
-		}
-
-		if testMessageBlob.ID != testBlobRes.ID {
-			t.Errorf("ID mismatch: %d != %d", testMessageBlob.ID, testBlobRes.ID)
-		}
-		if testMessageBlob.MessageID != testBlobRes.MessageID {
-			t.Errorf("MessageID mismatch: %x != %x", testMessageBlob.MessageID, testBlobRes.MessageID)
-		}
-		if testMessageBlob.SignerPublicKey != testBlobRes.SignerPublicKey {
-			t.Errorf("SignerPublicKey mismatch: %x != %x", testMessageBlob.SignerPublicKey, testBlobRes.SignerPublicKey)
-		}
-		if testMessageBlob.OneTime != testBlobRes.OneTime {
-			t.Errorf("OneTime mismatch: %t != %t", testMessageBlob.OneTime, testBlobRes.OneTime)
-		}
-		if !bytes.Equal(testMessageBlob.Data, testBlobRes.Data) {
-			t.Errorf("Data mismatch: %d != %d", len(testMessageBlob.Data), len(testBlobRes.Data))
-		}
-
-		err = db.DeleteBlobDB(&testMessageBlob.MessageID)
-		if err != nil {
-			t.Errorf("DeleteBlob: %s", err)
-		}
-		_, err = db.GetBlobDB(&testMessageBlob.MessageID)
-		if err == nil {
-			t.Error("GetBlob must fail on deleted message")
-		}
-	}
-}
-
-func TestBlobSQLite(t *testing.T) {
-	dir := "" //path.Join(os.TempDir(), "repbinmsg")
-	dbFile := path.Join(os.TempDir(), "db.test-blob")
-	db, err := New("sqlite3", dbFile, dir, 100)
-	if err != nil {
-		t.Fatalf("New sqlite3: %s", err)
-	}
-	defer os.Remove(dbFile)
-	defer db.Close()
+func testBlobDB(t *testing.T, db *MessageDB) {
+	var err error
 	testMessageBlob.ID, err = db.InsertMessage(testBlobMessage)
 	if err != nil {
 		t.Errorf("InsertMessage: %s", err)
@@ -127,6 +72,13 @@ func TestBlobSQLite(t *testing.T) {
 	if !bytes.Equal(testMessageBlob.Data, testBlobRes.Data) {
 		t.Errorf("Data mismatch: %d != %d", len(testMessageBlob.Data), len(testBlobRes.Data))
 	}
+	size, err := db.BlobSizeDB()
+	if err != nil {
+		t.Errorf("BlobSizeDB: %s", err)
+	}
+	if size < int64(len(testMessageBlob.Data)) {
+		t.Errorf("BlobSizeDB too small: %d", size)
+	}
 
 	err = db.DeleteBlobDB(&testMessageBlob.MessageID)
 	if err != nil {
@@ -138,6 +90,42 @@ func TestBlobSQLite(t *testing.T) {
 	}
 }
 
+func TestBlobMysql(t *testing.T) {
+	if !testing.Short() {
+		dir := "" //path.Join(os.TempDir(), "repbinmsg")
+		db, err := newMySQLForTest(dir, 100)
+		if err != nil {
+			t.Fatalf("New Mysql: %s", err)
+		}
+		defer db.Close()
+		testBlobDB(t, db)
+	}
+}
+
+func TestBlobSQLite(t *testing.T) {
+	dir := "" //path.Join(os.TempDir(), "repbinmsg")
+	dbFile := path.Join(os.TempDir(), "db.test-blob")
+	db, err := New("sqlite3", dbFile, dir, 100)
+	if err != nil {
+		t.Fatalf("New sqlite3: %s", err)
+	}
+	defer os.Remove(dbFile)
+	defer db.Close()
+	testBlobDB(t, db)
+}
+
+func TestBlobPostgres(t *testing.T) {
+	if os.Getenv(postgresTestEnv) == "" {
+		t.Skip("Set " + postgresTestEnv + " to test postgres")
+	}
+	db, err := newPostgresForTest("", 100)
+	if err != nil {
+		t.Fatalf("New Postgres: %s", err)
+	}
+	defer db.Close()
+	testBlobDB(t, db)
+}
+
 func TestBlobDirSQLite(t *testing.T) {
 	dir := path.Join(os.TempDir(), "repbinmsg")
 	dbFile := path.Join(os.TempDir(), "db.test-blobdir")
@@ -175,6 +163,13 @@ func TestBlobDirSQLite(t *testing.T) {
 	if !bytes.Equal(testMessageBlob.Data, testBlobRes.Data) {
 		t.Errorf("Data mismatch: %d != %d", len(testMessageBlob.Data), len(testBlobRes.Data))
 	}
+	size, err := db.BlobSizeFS()
+	if err != nil {
+		t.Errorf("BlobSizeFS: %s", err)
+	}
+	if size < int64(len(testMessageBlob.Data)) {
+		t.Errorf("BlobSizeFS too small: %d", size)
+	}
 
 	err = db.DeleteBlobFS(&testMessageBlob.MessageID)
 	if err != nil {
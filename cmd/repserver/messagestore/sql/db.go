@@ -9,7 +9,6 @@ import (
 	"errors"
 	"io"
 	"os"
-	"path"
 	"sync"
 	"time"
 
@@ -31,6 +30,8 @@ func init() {
 var (
 	// ErrNoModify is returned if a row was not modified
 	ErrNoModify = errors.New("storage: Row not modified")
+	// ErrDriver is returned if the database driver is not supported
+	ErrDriver = errors.New("storage: Unsupported database driver")
 )
 
 // CurrentTime returns the current time in UTC
@@ -68,15 +69,24 @@ type MessageDB struct {
 	deleteMessageQ         *sql.Stmt
 	updateExpireMessageQ   *sql.Stmt
 	selectExpireMessageQ   *sql.Stmt
+	selectExpireBatchQ     *sql.Stmt
 	globalIndexAddQ        *sql.Stmt
 	getKeyIndexQ           *sql.Stmt
 	getGlobalIndexQ        *sql.Stmt
+	getSyncIDsQ            *sql.Stmt
+	getGlobalIndexHeadQ    *sql.Stmt
 	messageBlobInsertQ     *sql.Stmt
 	messageBlobSelectQ     *sql.Stmt
 	messageBlobDeleteQ     *sql.Stmt
 	messageExistInsertQ    *sql.Stmt
 	messageExistSelectQ    *sql.Stmt
 	messageExistExpireQ    *sql.Stmt
+	tombstoneInsertQ       *sql.Stmt
+	tombstoneSelectQ       *sql.Stmt
+	tombstoneListQ         *sql.Stmt
+	tombstoneExpireQ       *sql.Stmt
+	peerUpdateTombstoneQ   *sql.Stmt
+	peerUpdateHealthQ      *sql.Stmt
 }
 
 // New returns a new message database. driver is the database driver to use,
@@ -87,15 +97,14 @@ type MessageDB struct {
 func New(driver, url, dir string, shards int) (*MessageDB, error) {
 	var db *sql.DB
 	var err error
-	if driver == "sqlite3" {
-		if err := os.MkdirAll(path.Dir(url), 0700); err != nil {
-			return nil, err
-		}
-	}
-	db, err = sql.Open(driver, url)
+	db, err = openDB(driver, url)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkSchema(db, driver); err != nil {
+		db.Close()
+		return nil, err
+	}
 	mdb := &MessageDB{
 		NumShards: uint64(shards),
 		queries:   queries[driver],
@@ -103,28 +112,6 @@ func New(driver, url, dir string, shards int) (*MessageDB, error) {
 		dir:       dir,
 		driver:    driver,
 	}
-
-	if _, err := mdb.db.Exec(mdb.queries["SignerCreate"]); err != nil {
-		return nil, err
-	}
-	if _, err := mdb.db.Exec(mdb.queries["PeerCreate"]); err != nil {
-		return nil, err
-	}
-	if _, err := mdb.db.Exec(mdb.queries["MessageCreate"]); err != nil {
-		return nil, err
-	}
-	if _, err := mdb.db.Exec(mdb.queries["GlobalIndexCreate"]); err != nil {
-		return nil, err
-	}
-	if _, err := mdb.db.Exec(mdb.queries["messageBlobCreate"]); err != nil {
-		return nil, err
-	}
-	if _, err := mdb.db.Exec(mdb.queries["MessageCounterCreate"]); err != nil {
-		return nil, err
-	}
-	if _, err := mdb.db.Exec(mdb.queries["messageExistCreate"]); err != nil {
-		return nil, err
-	}
 	if mdb.signerInsertQ, err = mdb.db.Prepare(mdb.queries["SignerInsert"]); err != nil {
 		return nil, err
 	}
@@ -194,6 +181,9 @@ func New(driver, url, dir string, shards int) (*MessageDB, error) {
 	if mdb.selectExpireMessageQ, err = mdb.db.Prepare(mdb.queries["SelectExpireMessage"]); err != nil {
 		return nil, err
 	}
+	if mdb.selectExpireBatchQ, err = mdb.db.Prepare(mdb.queries["SelectExpireBatch"]); err != nil {
+		return nil, err
+	}
 
 	if mdb.globalIndexAddQ, err = mdb.db.Prepare(mdb.queries["globalIndexAdd"]); err != nil {
 		return nil, err
@@ -204,6 +194,12 @@ func New(driver, url, dir string, shards int) (*MessageDB, error) {
 	if mdb.getGlobalIndexQ, err = mdb.db.Prepare(mdb.queries["getGlobalIndex"]); err != nil {
 		return nil, err
 	}
+	if mdb.getSyncIDsQ, err = mdb.db.Prepare(mdb.queries["getSyncIDs"]); err != nil {
+		return nil, err
+	}
+	if mdb.getGlobalIndexHeadQ, err = mdb.db.Prepare(mdb.queries["getGlobalIndexHead"]); err != nil {
+		return nil, err
+	}
 
 	if mdb.messageBlobInsertQ, err = mdb.db.Prepare(mdb.queries["messageBlobInsert"]); err != nil {
 		return nil, err
@@ -225,7 +221,26 @@ func New(driver, url, dir string, shards int) (*MessageDB, error) {
 		return nil, err
 	}
 
-	if driver == "mysql" {
+	if mdb.tombstoneInsertQ, err = mdb.db.Prepare(mdb.queries["tombstoneInsert"]); err != nil {
+		return nil, err
+	}
+	if mdb.tombstoneSelectQ, err = mdb.db.Prepare(mdb.queries["tombstoneSelect"]); err != nil {
+		return nil, err
+	}
+	if mdb.tombstoneListQ, err = mdb.db.Prepare(mdb.queries["tombstoneList"]); err != nil {
+		return nil, err
+	}
+	if mdb.tombstoneExpireQ, err = mdb.db.Prepare(mdb.queries["tombstoneExpire"]); err != nil {
+		return nil, err
+	}
+	if mdb.peerUpdateTombstoneQ, err = mdb.db.Prepare(mdb.queries["UpdateTombstonePeer"]); err != nil {
+		return nil, err
+	}
+	if mdb.peerUpdateHealthQ, err = mdb.db.Prepare(mdb.queries["UpdateHealthPeer"]); err != nil {
+		return nil, err
+	}
+
+	if mdb.queries["UpdateOrInsertSigner"] != "" {
 		if mdb.signerUpdateInsertQ, err = mdb.db.Prepare(mdb.queries["UpdateOrInsertSigner"]); err != nil {
 			return nil, err
 		}
@@ -306,6 +321,30 @@ func sliceToMessageID(d []byte) *[message.MessageIDSize]byte {
 	return r
 }
 
+// insertID executes an insert statement and returns the ID of the new row. Postgres
+// does not support LastInsertId, its queries return the ID instead.
+func (db *MessageDB) insertID(stmt *sql.Stmt, args ...interface{}) (int64, error) {
+	var id int64
+	if db.driver == "postgres" {
+		if err := stmt.QueryRow(args...).Scan(&id); err != nil {
+			return 0, err
+		}
+		return id, nil
+	}
+	res, err := stmt.Exec(args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, ErrNoModify
+	}
+	return res.LastInsertId()
+}
+
 func boolToInt(b bool) int {
 	if b {
 		return 1
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 
 	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils/repproto/structs"
 )
 
 // AddToGlobalIndex adds a message to the global index
@@ -40,3 +41,62 @@ func genIndex(rows *sql.Rows, err error) ([][]byte, int, error) {
 	}
 	return ret, i, nil
 }
+
+// SelectSyncIDs returns the unexpired messages in the global index whose hex MessageID starts
+// with prefix, in ascending order of MessageID. Only MessageID, ExpireTime, PostTime and Distance are set
+func (db *MessageDB) SelectSyncIDs(prefix string, now int64) ([]*structs.MessageStruct, error) {
+	var ret []*structs.MessageStruct
+	// getSyncIDs: SELECT m.MessageID, m.ExpireTime, m.PostTime, m.Distance FROM message AS m, globalindex AS i WHERE i.Message=m.ID AND m.MessageID>=? AND m.MessageID<? AND m.ExpireTime>? ORDER BY m.MessageID ASC;
+	rows, err := db.getSyncIDsQ.Query(prefix, prefix+"g", now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var messageIDt string
+		msg := new(structs.MessageStruct)
+		if err := rows.Scan(&messageIDt, &msg.ExpireTime, &msg.PostTime, &msg.Distance); err != nil {
+			return nil, err
+		}
+		copy(msg.MessageID[:], fromHex(messageIDt))
+		ret = append(ret, msg)
+	}
+	return ret, rows.Err()
+}
+
+// GlobalIndexHead returns the highest entry of the global index, or zero if it is empty
+func (db *MessageDB) GlobalIndexHead() (uint64, error) {
+	var head uint64
+	// getGlobalIndexHead: SELECT COALESCE(MAX(ID), 0) FROM globalindex;
+	if err := db.getGlobalIndexHeadQ.QueryRow().Scan(&head); err != nil {
+		return 0, err
+	}
+	return head, nil
+}
+
+// GlobalIndexOrphans returns the number of global index entries that have no message
+func (db *MessageDB) GlobalIndexOrphans() (int64, error) {
+	var count int64
+	err := db.db.QueryRow(db.queries["globalIndexOrphans"]).Scan(&count)
+	return count, err
+}
+
+// DeleteGlobalIndexOrphans deletes global index entries that have no message. Returns the
+// number of deleted entries
+func (db *MessageDB) DeleteGlobalIndexOrphans() (int64, error) {
+	res, err := db.db.Exec(db.queries["globalIndexDeleteOrphans"])
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ResetGlobalIndexSequence makes new global index entries follow the imported ones, for
+// databases that do not do this themselves
+func (db *MessageDB) ResetGlobalIndexSequence() error {
+	if db.queries["globalIndexSequence"] == "" {
+		return nil
+	}
+	_, err := db.db.Exec(db.queries["globalIndexSequence"])
+	return err
+}
@@ -82,55 +82,7 @@ var testIndexMessage3 = &structs.MessageStruct{
 	Hidden:        false,
 }
 
-func TestIndexMysql(t *testing.T) {
-	if !testing.Short() {
-		dir := path.Join(os.TempDir(), "repbinmsg")
-		db, err := newMySQLForTest(dir, 100)
-		if err != nil {
-			t.Fatalf("New Mysql: %s", err)
-		}
-		defer db.Close()
-		id, err := db.InsertMessage(testIndexMessage)
-		if err != nil {
-			t.Errorf("InsertMessage: %s", err)
-		}
-		err = db.AddToGlobalIndex(id)
-		if err != nil {
-			t.Errorf("AddToGlobalIndex: %s", err)
-		}
-		id, _ = db.InsertMessage(testIndexMessage2)
-		db.AddToGlobalIndex(id)
-		id, _ = db.InsertMessage(testIndexMessage3)
-		db.AddToGlobalIndex(id)
-		l, i, err := db.GetKeyIndex(&testIndexMessage.ReceiverConstantPubKey, 0, 10)
-		if err != nil {
-			t.Errorf("GetKeyIndex: %s", err)
-		}
-		if i < 1 {
-			t.Error("GetKeyIndex: None found!!!")
-		}
-		l, i, err = db.GetGlobalIndex(0, 10)
-		if err != nil {
-			t.Errorf("GetGlobalIndex: %s", err)
-		}
-		if i < 1 {
-			t.Error("GetGlobalIndex: None found!!!")
-		}
-		// spew.Dump(l)
-		_ = l
-	}
-
-}
-
-func TestIndexSQLite(t *testing.T) {
-	dir := path.Join(os.TempDir(), "repbinmsg")
-	dbFile := path.Join(os.TempDir(), "db.test-index")
-	db, err := New("sqlite3", dbFile, dir, 100)
-	if err != nil {
-		t.Fatalf("New sqlite3: %s", err)
-	}
-	defer os.Remove(dbFile)
-	defer db.Close()
+func testIndex(t *testing.T, db *MessageDB) {
 	id, err := db.InsertMessage(testIndexMessage)
 	if err != nil {
 		t.Errorf("InsertMessage: %s", err)
@@ -158,5 +110,61 @@ func TestIndexSQLite(t *testing.T) {
 		t.Error("GetGlobalIndex: None found!!!")
 	}
 	_ = l
+	head, err := db.GlobalIndexHead()
+	if err != nil {
+		t.Errorf("GlobalIndexHead: %s", err)
+	}
+	if head < 3 {
+		t.Errorf("GlobalIndexHead: %d", head)
+	}
+	sync, err := db.SelectSyncIDs("", CurrentTime())
+	if err != nil {
+		t.Errorf("SelectSyncIDs: %s", err)
+	}
+	if len(sync) < 3 {
+		t.Errorf("SelectSyncIDs: %d found", len(sync))
+	}
+	sync, err = db.SelectSyncIDs(toHex(testIndexMessage2.MessageID[:]), CurrentTime())
+	if err != nil {
+		t.Errorf("SelectSyncIDs prefix: %s", err)
+	}
+	if len(sync) != 1 || sync[0].MessageID != testIndexMessage2.MessageID || sync[0].ExpireTime != testIndexMessage2.ExpireTime || sync[0].Distance != testIndexMessage2.Distance {
+		t.Errorf("SelectSyncIDs prefix: %d found", len(sync))
+	}
+}
 
+func TestIndexMysql(t *testing.T) {
+	if !testing.Short() {
+		dir := path.Join(os.TempDir(), "repbinmsg")
+		db, err := newMySQLForTest(dir, 100)
+		if err != nil {
+			t.Fatalf("New Mysql: %s", err)
+		}
+		defer db.Close()
+		testIndex(t, db)
+	}
+}
+
+func TestIndexSQLite(t *testing.T) {
+	dir := path.Join(os.TempDir(), "repbinmsg")
+	dbFile := path.Join(os.TempDir(), "db.test-index")
+	db, err := New("sqlite3", dbFile, dir, 100)
+	if err != nil {
+		t.Fatalf("New sqlite3: %s", err)
+	}
+	defer os.Remove(dbFile)
+	defer db.Close()
+	testIndex(t, db)
+}
+
+func TestIndexPostgres(t *testing.T) {
+	if os.Getenv(postgresTestEnv) == "" {
+		t.Skip("Set " + postgresTestEnv + " to test postgres")
+	}
+	db, err := newPostgresForTest(path.Join(os.TempDir(), "repbinmsg"), 100)
+	if err != nil {
+		t.Fatalf("New Postgres: %s", err)
+	}
+	defer db.Close()
+	testIndex(t, db)
 }
@@ -22,3 +22,28 @@ func (db *MessageDB) MessageKnown(mid *[message.MessageIDSize]byte) bool {
 func (db *MessageDB) ForgetMessages(expireTime int64) error {
 	return updateConvertNilError(db.messageExistExpireQ.Exec(expireTime))
 }
+
+// ListKnownMessages calls fn for each known message and the time it was learned
+func (db *MessageDB) ListKnownMessages(fn func(mid *[message.MessageIDSize]byte, entryTime int64) error) error {
+	rows, err := db.db.Query(db.queries["messageExistList"])
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var messageIDT string
+		var entryTime int64
+		if err := rows.Scan(&messageIDT, &entryTime); err != nil {
+			return err
+		}
+		if err := fn(sliceToMessageID(fromHex(messageIDT)), entryTime); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ImportKnownMessage records a message to be known since entryTime. Known messages are ignored
+func (db *MessageDB) ImportKnownMessage(mid *[message.MessageIDSize]byte, entryTime int64) error {
+	return db.suppressDuplicateError(updateConvertNilError(db.messageExistInsertQ.Exec(toHex(mid[:]), entryTime)))
+}
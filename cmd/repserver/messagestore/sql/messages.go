@@ -44,7 +44,7 @@ func (db *MessageDB) ExpireMessageCounter(maxAge int64) error {
 func (db *MessageDB) InsertMessage(msg *structs.MessageStruct) (uint64, error) {
 	var err error
 	msg.Counter, err = db.messageNextCounter(&msg.ReceiverConstantPubKey)
-	res, err := db.insertMessageQ.Exec(
+	n, err := db.insertID(db.insertMessageQ,
 		msg.Counter,
 		toHex(msg.MessageID[:]),
 		toHex(msg.ReceiverConstantPubKey[:]),
@@ -60,17 +60,6 @@ func (db *MessageDB) InsertMessage(msg *structs.MessageStruct) (uint64, error) {
 	if err != nil {
 		return 0, err
 	}
-	n, err := res.RowsAffected()
-	if err != nil {
-		return 0, err
-	}
-	if n < 1 {
-		return 0, ErrNoModify
-	}
-	n, err = res.LastInsertId()
-	if err != nil {
-		return 0, err
-	}
 	return uint64(n), nil
 }
 
@@ -79,12 +68,12 @@ type scanAble interface {
 	Scan(dest ...interface{}) error
 }
 
-func scanMessage(a scanAble) (uint64, *structs.MessageStruct, error) {
+func scanMessage(a scanAble, extra ...interface{}) (uint64, *structs.MessageStruct, error) {
 	var messageIDT, receiverConstantPubKeyT, signerPubT string
 	var oneTimeT, syncT, hiddenT int
 	var id uint64
 	s := new(structs.MessageStruct)
-	if err := a.Scan(
+	if err := a.Scan(append([]interface{}{
 		&id,
 		&s.Counter,
 		&messageIDT,
@@ -97,7 +86,7 @@ func scanMessage(a scanAble) (uint64, *structs.MessageStruct, error) {
 		&oneTimeT,
 		&syncT,
 		&hiddenT,
-	); err != nil {
+	}, extra...)...); err != nil {
 		return 0, nil, err
 	}
 	s.MessageID = *sliceToMessageID(fromHex(messageIDT))
@@ -126,6 +115,7 @@ func (db *MessageDB) SetMessageExpireByID(mid *[message.MessageIDSize]byte, expi
 
 // ExpireMessage contains data necessary for expiring a message
 type ExpireMessage struct {
+	ID        uint64 // Set by SelectMessageExpireBatch only
 	MessageID [message.MessageIDSize]byte
 	SignerPub [message.SignerPubKeySize]byte
 }
@@ -151,3 +141,97 @@ func (db *MessageDB) SelectMessageExpire(now int64) ([]ExpireMessage, error) {
 	}
 	return res, nil
 }
+
+// SelectMessageExpireBatch returns at most count messages that have expired, beginning after
+// the message with the ID after, in the order of their ID
+func (db *MessageDB) SelectMessageExpireBatch(now int64, after uint64, count int) ([]ExpireMessage, error) {
+	var res []ExpireMessage
+	rows, err := db.selectExpireBatchQ.Query(now, after, count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uint64
+		var messageIDT, signerPubT string
+		if err := rows.Scan(&id, &messageIDT, &signerPubT); err != nil {
+			return nil, err
+		}
+		res = append(res, ExpireMessage{
+			ID:        id,
+			MessageID: *sliceToMessageID(fromHex(messageIDT)),
+			SignerPub: *sliceToEDPublicKey(fromHex(signerPubT)),
+		})
+	}
+	return res, rows.Err()
+}
+
+// ListMessages calls fn for each message in the database
+func (db *MessageDB) ListMessages(fn func(msg *ExpireMessage) error) error {
+	rows, err := db.db.Query(db.queries["SelectMessageList"])
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var messageIDT, signerPubT string
+		if err := rows.Scan(&messageIDT, &signerPubT); err != nil {
+			return err
+		}
+		err := fn(&ExpireMessage{
+			MessageID: *sliceToMessageID(fromHex(messageIDT)),
+			SignerPub: *sliceToEDPublicKey(fromHex(signerPubT)),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ListIndexedMessages calls fn for each message in the database in the order of insertion.
+// index and entryTime are the position and time of the message in the global index, or 0
+func (db *MessageDB) ListIndexedMessages(fn func(msg *structs.MessageStruct, index, entryTime uint64) error) error {
+	rows, err := db.db.Query(db.queries["SelectMessageIndexList"])
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var index, entryTime uint64
+		_, msg, err := scanMessage(rows, &index, &entryTime)
+		if err != nil {
+			return err
+		}
+		if err := fn(msg, index, entryTime); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ListMessageCounters calls fn for the counter of each recipient
+func (db *MessageDB) ListMessageCounters(fn func(receiver *message.Curve25519Key, counter uint64, lastTime int64) error) error {
+	rows, err := db.db.Query(db.queries["ListMessageCounter"])
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var receiverT string
+		var counter uint64
+		var lastTime int64
+		if err := rows.Scan(&receiverT, &counter, &lastTime); err != nil {
+			return err
+		}
+		if err := fn(sliceToCurve25519Key(fromHex(receiverT)), counter, lastTime); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ImportMessageCounter writes the counter of a recipient
+func (db *MessageDB) ImportMessageCounter(receiver *message.Curve25519Key, counter uint64, lastTime int64) error {
+	return updateConvertNilError(db.db.Exec(db.queries["ImportMessageCounter"], toHex(receiver[:]), counter, lastTime))
+}
@@ -0,0 +1,269 @@
+package sql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+)
+
+var (
+	// ErrSchemaOutdated is returned if the database schema requires migration
+	ErrSchemaOutdated = errors.New("storage: Database schema outdated, migration required")
+	// ErrSchemaNewer is returned if the database schema is newer than this release
+	ErrSchemaNewer = errors.New("storage: Database schema is newer than this release")
+)
+
+// AutoMigrate controls if New applies pending migrations. Databases without
+// a schema version (new or created before migrations existed) are always migrated.
+var AutoMigrate = false
+
+// migration is a versioned schema change. Statements are executed in order.
+type migration struct {
+	version     int
+	description string
+	statements  map[string][]string // statements per driver
+}
+
+// migrations must be ordered by version. Released migrations must never be changed,
+// schema changes are added as new migrations at the end.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "Initial schema",
+		statements: driverQueries(
+			"SignerCreate",
+			"PeerCreate",
+			"MessageCreate",
+			"GlobalIndexCreate",
+			"messageBlobCreate",
+			"MessageCounterCreate",
+			"messageExistCreate",
+		),
+	},
+	{
+		version:     2,
+		description: "Deletion tombstones",
+		statements: driverQueries(
+			"TombstoneCreate",
+			"PeerTombstoneAdd",
+		),
+	},
+	{
+		version:     3,
+		description: "Peer health",
+		statements: driverQueries(
+			"PeerFailCountAdd",
+			"PeerRetryAfterAdd",
+			"PeerQuarantinedSinceAdd",
+			"PeerLastErrorAdd",
+			"PeerLastErrorTimeAdd",
+		),
+	},
+	{
+		version:     4,
+		description: "Tombstones per key",
+		statements: driverQueries(
+			"TombstoneKeyStep1",
+			"TombstoneKeyStep2",
+			"TombstoneKeyStep3",
+			"TombstoneKeyStep4",
+			"TombstoneKeyStep5",
+		),
+	},
+}
+
+// driverQueries returns the named queries for all drivers.
+func driverQueries(names ...string) map[string][]string {
+	r := make(map[string][]string)
+	for driver, q := range queries {
+		for _, name := range names {
+			r[driver] = append(r[driver], q[name])
+		}
+	}
+	return r
+}
+
+// MigrationStatus describes the state of a migration.
+type MigrationStatus struct {
+	Version     int
+	Description string
+	Applied     bool
+	AppliedAt   int64 // time of migration, 0 if not applied
+}
+
+// LatestSchema returns the schema version of this release.
+func LatestSchema() int {
+	return migrations[len(migrations)-1].version
+}
+
+// openDB opens the database.
+func openDB(driver, url string) (*sql.DB, error) {
+	if _, ok := queries[driver]; !ok {
+		return nil, ErrDriver
+	}
+	if driver == "sqlite3" {
+		if err := os.MkdirAll(path.Dir(url), 0700); err != nil {
+			return nil, err
+		}
+	}
+	return sql.Open(driver, url)
+}
+
+// appliedMigrations creates the schema_version table if necessary and returns the versions
+// applied to the database and their time of migration.
+func appliedMigrations(db *sql.DB, driver string) (map[int]int64, error) {
+	if _, err := db.Exec(queries[driver]["SchemaVersionCreate"]); err != nil {
+		return nil, err
+	}
+	return readMigrations(db, driver)
+}
+
+// readMigrations returns the versions applied to the database and their time of migration
+// without modifying the database. A missing schema_version table means no versions.
+func readMigrations(db *sql.DB, driver string) (map[int]int64, error) {
+	var exists int
+	if err := db.QueryRow(queries[driver]["SchemaVersionExists"]).Scan(&exists); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]int64)
+	if exists == 0 {
+		return applied, nil
+	}
+	rows, err := db.Query(queries[driver]["SchemaVersionSelect"])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// schemaVersion returns the highest applied version.
+func schemaVersion(applied map[int]int64) int {
+	current := 0
+	for version := range applied {
+		if version > current {
+			current = version
+		}
+	}
+	return current
+}
+
+// migrate applies all pending migrations, each in its own transaction. Note
+// that MySQL commits schema changes implicitly.
+func migrate(db *sql.DB, driver string) ([]int, error) {
+	var done []int
+	applied, err := appliedMigrations(db, driver)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return done, err
+		}
+		for _, q := range m.statements[driver] {
+			if q == "" {
+				// Step not needed by this driver
+				continue
+			}
+			if _, err := tx.Exec(q); err != nil {
+				tx.Rollback()
+				return done, fmt.Errorf("storage: Migration %d failed: %s", m.version, err)
+			}
+		}
+		if _, err := tx.Exec(queries[driver]["SchemaVersionInsert"], m.version, m.description, CurrentTime()); err != nil {
+			tx.Rollback()
+			return done, err
+		}
+		if err := tx.Commit(); err != nil {
+			return done, err
+		}
+		done = append(done, m.version)
+	}
+	return done, nil
+}
+
+// checkSchema verifies the schema version of the database and migrates it if
+// permitted by AutoMigrate.
+func checkSchema(db *sql.DB, driver string) error {
+	applied, err := appliedMigrations(db, driver)
+	if err != nil {
+		return err
+	}
+	current := schemaVersion(applied)
+	if current > LatestSchema() {
+		return ErrSchemaNewer
+	}
+	if current == LatestSchema() {
+		return nil
+	}
+	if current != 0 && !AutoMigrate {
+		return ErrSchemaOutdated
+	}
+	_, err = migrate(db, driver)
+	return err
+}
+
+// Migrate applies all pending migrations to the database and returns the
+// versions applied.
+func Migrate(driver, url string) ([]int, error) {
+	db, err := openDB(driver, url)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	applied, err := appliedMigrations(db, driver)
+	if err != nil {
+		return nil, err
+	}
+	if schemaVersion(applied) > LatestSchema() {
+		return nil, ErrSchemaNewer
+	}
+	return migrate(db, driver)
+}
+
+// SchemaStatus returns the schema version of the database and the status of all
+// migrations known to this release. The database is not modified, a missing SQLite
+// database is not created.
+func SchemaStatus(driver, url string) (int, []MigrationStatus, error) {
+	if _, ok := queries[driver]; !ok {
+		return 0, nil, ErrDriver
+	}
+	applied := make(map[int]int64)
+	if _, err := os.Stat(url); driver != "sqlite3" || err == nil {
+		db, err := sql.Open(driver, url)
+		if err != nil {
+			return 0, nil, err
+		}
+		defer db.Close()
+		if applied, err = readMigrations(db, driver); err != nil {
+			return 0, nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return 0, nil, err
+	}
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.version]
+		status = append(status, MigrationStatus{
+			Version:     m.version,
+			Description: m.description,
+			Applied:     ok,
+			AppliedAt:   appliedAt,
+		})
+	}
+	return schemaVersion(applied), status, nil
+}
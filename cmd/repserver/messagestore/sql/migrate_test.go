@@ -0,0 +1,93 @@
+package sql
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestMigrateSQLite(t *testing.T) {
+	dir := path.Join(os.TempDir(), "repbinmsg")
+	dbFile := path.Join(os.TempDir(), "db.test-migrate")
+	os.Remove(dbFile)
+	defer os.Remove(dbFile)
+	// Status must not create the database or the schema_version table
+	if current, _, err := SchemaStatus("sqlite3", dbFile); err != nil || current != 0 {
+		t.Errorf("SchemaStatus of missing database: %d %v", current, err)
+	}
+	if _, err := os.Stat(dbFile); !os.IsNotExist(err) {
+		t.Errorf("SchemaStatus created database: %v", err)
+	}
+	sdb, err := openDB("sqlite3", dbFile)
+	if err != nil {
+		t.Fatalf("openDB: %s", err)
+	}
+	if _, err := sdb.Exec(queries["sqlite3"]["SignerCreate"]); err != nil {
+		t.Fatalf("SignerCreate: %s", err)
+	}
+	if current, _, err := SchemaStatus("sqlite3", dbFile); err != nil || current != 0 {
+		t.Errorf("SchemaStatus of unversioned database: %d %v", current, err)
+	}
+	var tables int
+	if err := sdb.QueryRow(queries["sqlite3"]["SchemaVersionExists"]).Scan(&tables); err != nil || tables != 0 {
+		t.Errorf("SchemaStatus created schema_version: %d %v", tables, err)
+	}
+	sdb.Close()
+	db, err := New("sqlite3", dbFile, dir, 100)
+	if err != nil {
+		t.Fatalf("New sqlite3: %s", err)
+	}
+	db.Close()
+	current, status, err := SchemaStatus("sqlite3", dbFile)
+	if err != nil {
+		t.Fatalf("SchemaStatus: %s", err)
+	}
+	if current != LatestSchema() {
+		t.Errorf("Schema not migrated: %d != %d", current, LatestSchema())
+	}
+	for _, s := range status {
+		if !s.Applied || s.AppliedAt == 0 {
+			t.Errorf("Migration not applied: %d", s.Version)
+		}
+	}
+	applied, err := Migrate("sqlite3", dbFile)
+	if err != nil {
+		t.Fatalf("Migrate: %s", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("Migrations applied twice: %v", applied)
+	}
+
+	defer func(m []migration, auto bool) {
+		migrations = m
+		AutoMigrate = auto
+	}(migrations, AutoMigrate)
+	migrations = append(migrations[:len(migrations):len(migrations)], migration{
+		version:     LatestSchema() + 1,
+		description: "Test migration",
+		statements: map[string][]string{
+			"sqlite3": []string{`CREATE TABLE IF NOT EXISTS migratetest (ID INTEGER);`},
+		},
+	})
+	AutoMigrate = false
+	if _, err := New("sqlite3", dbFile, dir, 100); err != ErrSchemaOutdated {
+		t.Errorf("Outdated schema not detected: %v", err)
+	}
+	applied, err = Migrate("sqlite3", dbFile)
+	if err != nil {
+		t.Fatalf("Migrate: %s", err)
+	}
+	if len(applied) != 1 || applied[0] != LatestSchema() {
+		t.Errorf("Bad migrations applied: %v", applied)
+	}
+	db, err = New("sqlite3", dbFile, dir, 100)
+	if err != nil {
+		t.Fatalf("New after migration: %s", err)
+	}
+	db.Close()
+
+	migrations = migrations[:len(migrations)-1]
+	if _, err := New("sqlite3", dbFile, dir, 100); err != ErrSchemaNewer {
+		t.Errorf("Newer schema not detected: %v", err)
+	}
+}
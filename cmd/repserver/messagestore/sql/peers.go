@@ -1,6 +1,8 @@
 package sql
 
 import (
+	"strings"
+
 	"github.com/agl/ed25519"
 	"github.com/repbin/repbin/utils/keyproof"
 	"github.com/repbin/repbin/utils/repproto/structs"
@@ -25,6 +27,10 @@ func (db *MessageDB) suppressDuplicateError(err error) error {
 		if err.Error()[0:25] == "UNIQUE constraint failed:" {
 			return nil
 		}
+	} else if db.driver == "postgres" {
+		if strings.HasPrefix(err.Error(), "pq: duplicate key value") {
+			return nil
+		}
 	}
 	return err
 }
@@ -48,6 +54,14 @@ func (db *MessageDB) UpdatePeerNotification(pubkey *[ed25519.PublicKeySize]byte,
 	return updateConvertNilError(db.peerUpdateNotifyQ.Exec(CurrentTime(), errorDif, toHex(pubkey[:])))
 }
 
+// UpdatePeerHealth records the health of the peer. lastError is truncated to 255 bytes
+func (db *MessageDB) UpdatePeerHealth(pubkey *[ed25519.PublicKeySize]byte, failCount, retryAfter, quarantinedSince uint64, lastError string, lastErrorTime uint64) error {
+	if len(lastError) > 255 {
+		lastError = lastError[:255]
+	}
+	return updateConvertNilError(db.peerUpdateHealthQ.Exec(failCount, retryAfter, quarantinedSince, lastError, lastErrorTime, toHex(pubkey[:])))
+}
+
 // UpdatePeerToken records the next authentication token for this peer
 func (db *MessageDB) UpdatePeerToken(pubkey *[ed25519.PublicKeySize]byte, signedToken *[keyproof.ProofTokenSignedSize]byte) error {
 	return updateConvertNilError(db.peerUpdateTokenQ.Exec(CurrentTime(), toHex(signedToken[:]), toHex(pubkey[:])))
@@ -64,6 +78,12 @@ func (db *MessageDB) SelectPeer(pubkey *[ed25519.PublicKeySize]byte) (*structs.P
 		&r.LastFetch,
 		&r.ErrorCount,
 		&r.LastPosition,
+		&r.LastTombstone,
+		&r.FailCount,
+		&r.RetryAfter,
+		&r.QuarantinedSince,
+		&r.LastError,
+		&r.LastErrorTime,
 	)
 	if err != nil {
 		return nil, err
@@ -71,3 +91,60 @@ func (db *MessageDB) SelectPeer(pubkey *[ed25519.PublicKeySize]byte) (*structs.P
 	r.AuthToken = *sliceToProofTokenSigned(fromHex(authtokenT))
 	return r, nil
 }
+
+// ListPeers calls fn for each peer in the database
+func (db *MessageDB) ListPeers(fn func(pubkey *[ed25519.PublicKeySize]byte, peer *structs.PeerStruct) error) error {
+	var pubkeys [][ed25519.PublicKeySize]byte
+	rows, err := db.db.Query(db.queries["SelectPeerList"])
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var pubkeyT string
+		if err := rows.Scan(&pubkeyT); err != nil {
+			rows.Close()
+			return err
+		}
+		pubkeys = append(pubkeys, *sliceToEDPublicKey(fromHex(pubkeyT)))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range pubkeys {
+		peer, err := db.SelectPeer(&pubkeys[i])
+		if err != nil {
+			return err
+		}
+		if err := fn(&pubkeys[i], peer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportPeer writes a peer with all its fields
+func (db *MessageDB) ImportPeer(pubkey *[ed25519.PublicKeySize]byte, peer *structs.PeerStruct) error {
+	if err := db.TouchPeer(pubkey); err != nil {
+		return err
+	}
+	lastError := peer.LastError
+	if len(lastError) > 255 {
+		lastError = lastError[:255]
+	}
+	return updateConvertNilError(db.db.Exec(db.queries["ImportPeer"],
+		toHex(peer.AuthToken[:]),
+		peer.LastNotifySend,
+		peer.LastNotifyFrom,
+		peer.LastFetch,
+		peer.ErrorCount,
+		peer.LastPosition,
+		peer.LastTombstone,
+		peer.FailCount,
+		peer.RetryAfter,
+		peer.QuarantinedSince,
+		lastError,
+		peer.LastErrorTime,
+		toHex(pubkey[:]),
+	))
+}
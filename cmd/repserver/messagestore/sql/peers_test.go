@@ -33,70 +33,8 @@ func diff(a, b int64) int64 {
 	return b - a
 }
 
-func TestPeersMysql(t *testing.T) {
-	if !testing.Short() {
-		dir := path.Join(os.TempDir(), "repbinmsg")
-		db, err := newMySQLForTest(dir, 100)
-		if err != nil {
-			t.Fatalf("New Mysql: %s", err)
-		}
-		defer db.Close()
-		// ===== PEERS TESTS =====
-		err = db.InsertPeer(testPeerPubKey)
-		if err != nil {
-			t.Fatalf("InsertPeer: %s", err)
-		}
-		err = db.TouchPeer(testPeerPubKey)
-		if err != nil {
-			t.Fatalf("TouchPeer: %s", err)
-		}
-		err = db.UpdatePeerStats(testPeerPubKey, 1, 2, 3)
-		if err != nil {
-			t.Fatalf("UpdatePeerStats: %s", err)
-		}
-		err = db.UpdatePeerNotification(testPeerPubKey, true)
-		if err != nil {
-			t.Fatalf("UpdatePeerNotification: %s", err)
-		}
-		err = db.UpdatePeerToken(testPeerPubKey, testSignedToken)
-		if err != nil {
-			t.Fatalf("UpdatePeerToken: %s", err)
-		}
-		peerData, err := db.SelectPeer(testPeerPubKey)
-		if err != nil {
-			t.Fatalf("SelectPeer: %s", err)
-		}
-		if peerData.AuthToken != *testSignedToken {
-			t.Error("Token no match")
-		}
-		if peerData.ErrorCount != 4 {
-			t.Errorf("Errorcount bad: %d != %d", 4, peerData.ErrorCount)
-		}
-		if peerData.LastFetch != 1 {
-			t.Errorf("LastFetch bad: %d != %d", 1, peerData.LastFetch)
-		}
-		if peerData.LastPosition != 2 {
-			t.Errorf("LastPosition bad: %d != %d", 2, peerData.LastPosition)
-		}
-		now := CurrentTime()
-		if diff(now, int64(peerData.LastNotifyFrom)) > 1 {
-			t.Errorf("LastNotifyFrom: %d != %d", now, peerData.LastNotifyFrom)
-		}
-		if diff(now, int64(peerData.LastNotifySend)) > 1 {
-			t.Errorf("LastNotifySend: %d != %d", now, peerData.LastNotifySend)
-		}
-	}
-}
-
-func TestPeersSQLite(t *testing.T) {
-	dir := path.Join(os.TempDir(), "repbinmsg")
-	dbFile := path.Join(os.TempDir(), "db.test-peers")
-	db, err := New("sqlite3", dbFile, dir, 100)
-	if err != nil {
-		t.Fatalf("New sqlite3: %s", err)
-	}
-	defer os.Remove(dbFile)
-	defer db.Close()
+func testPeers(t *testing.T, db *MessageDB) {
+	var err error
 	// ===== PEERS TESTS =====
 	err = db.InsertPeer(testPeerPubKey)
 	if err != nil {
@@ -118,6 +56,14 @@ func TestPeersSQLite(t *testing.T) {
 	if err != nil {
 		t.Fatalf("UpdatePeerToken: %s", err)
 	}
+	err = db.UpdatePeerTombstone(testPeerPubKey, 5)
+	if err != nil {
+		t.Fatalf("UpdatePeerTombstone: %s", err)
+	}
+	err = db.UpdatePeerHealth(testPeerPubKey, 6, 7, 8, "fetch: timeout", 9)
+	if err != nil {
+		t.Fatalf("UpdatePeerHealth: %s", err)
+	}
 	peerData, err := db.SelectPeer(testPeerPubKey)
 	if err != nil {
 		t.Fatalf("SelectPeer: %s", err)
@@ -134,6 +80,15 @@ func TestPeersSQLite(t *testing.T) {
 	if peerData.LastPosition != 2 {
 		t.Errorf("LastPosition bad: %d != %d", 2, peerData.LastPosition)
 	}
+	if peerData.LastTombstone != 5 {
+		t.Errorf("LastTombstone bad: %d != %d", 5, peerData.LastTombstone)
+	}
+	if peerData.FailCount != 6 || peerData.RetryAfter != 7 || peerData.QuarantinedSince != 8 || peerData.LastErrorTime != 9 {
+		t.Errorf("Health bad: %d %d %d %d", peerData.FailCount, peerData.RetryAfter, peerData.QuarantinedSince, peerData.LastErrorTime)
+	}
+	if peerData.LastError != "fetch: timeout" {
+		t.Errorf("LastError bad: %s", peerData.LastError)
+	}
 	now := CurrentTime()
 	if diff(now, int64(peerData.LastNotifyFrom)) > 1 {
 		t.Errorf("LastNotifyFrom: %d != %d", now, peerData.LastNotifyFrom)
@@ -142,3 +97,39 @@ func TestPeersSQLite(t *testing.T) {
 		t.Errorf("LastNotifySend: %d != %d", now, peerData.LastNotifySend)
 	}
 }
+
+func TestPeersMysql(t *testing.T) {
+	if !testing.Short() {
+		dir := path.Join(os.TempDir(), "repbinmsg")
+		db, err := newMySQLForTest(dir, 100)
+		if err != nil {
+			t.Fatalf("New Mysql: %s", err)
+		}
+		defer db.Close()
+		testPeers(t, db)
+	}
+}
+
+func TestPeersSQLite(t *testing.T) {
+	dir := path.Join(os.TempDir(), "repbinmsg")
+	dbFile := path.Join(os.TempDir(), "db.test-peers")
+	db, err := New("sqlite3", dbFile, dir, 100)
+	if err != nil {
+		t.Fatalf("New sqlite3: %s", err)
+	}
+	defer os.Remove(dbFile)
+	defer db.Close()
+	testPeers(t, db)
+}
+
+func TestPeersPostgres(t *testing.T) {
+	if os.Getenv(postgresTestEnv) == "" {
+		t.Skip("Set " + postgresTestEnv + " to test postgres")
+	}
+	db, err := newPostgresForTest(path.Join(os.TempDir(), "repbinmsg"), 100)
+	if err != nil {
+		t.Fatalf("New Postgres: %s", err)
+	}
+	defer db.Close()
+	testPeers(t, db)
+}
@@ -6,9 +6,12 @@ import (
 	"github.com/agl/ed25519"
 	"github.com/repbin/repbin/hashcash"
 	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils/repproto/structs"
 )
 
 var (
+	// queries per driver. The *Create queries are the initial schema (migration 1)
+	// and must not be changed, schema changes go into migrations.
 	queries = map[string]map[string]string{
 		"mysql": map[string]string{
 			"SignerCreate": `CREATE TABLE IF NOT EXISTS signer (
@@ -54,7 +57,7 @@ var (
                 ;`,
 			"DelMessageSigner": `UPDATE signer
                 SET MessagesRetained=MessagesRetained-1
-                WHERE PublicKey=? 
+                WHERE PublicKey=? AND MessagesRetained>0
                 ;`,
 			"PrepareExpireSigner": `UPDATE signer SET LastMessageDeleted=? WHERE 
                 MessagesRetained<=0 AND LastMessageDeleted=0
@@ -62,6 +65,16 @@ var (
 			"DeleteExpireSigner": `DELETE FROM signer 
                 WHERE LastMessageDeleted!=0 AND LastMessageDeleted<?
                 ;`,
+			"SelectSignerRetained": `SELECT s.PublicKey, s.MessagesRetained, COUNT(m.ID)
+                FROM signer AS s LEFT JOIN message AS m ON m.SignerPub=s.PublicKey
+                GROUP BY s.ID, s.PublicKey, s.MessagesRetained HAVING s.MessagesRetained!=COUNT(m.ID)
+                ;`,
+			"SetSignerRetained": `UPDATE signer SET MessagesRetained=? WHERE PublicKey=?;`,
+			"SelectSignerList": `SELECT ID, PublicKey, Nonce, Bits, MessagesPosted,
+                    MessagesRetained, MaxMessagesPosted, MaxMessagesRetained, ExpireTarget FROM
+                    signer ORDER BY ID
+                ;`,
+			"SetSignerCounters": `UPDATE signer SET MessagesPosted=?, MessagesRetained=? WHERE PublicKey=?;`,
 			"PeerCreate": `CREATE TABLE IF NOT EXISTS peer (
                     ID BIGINT UNSIGNED NOT NULL PRIMARY KEY AUTO_INCREMENT,
                     PublicKey VARCHAR(` + strconv.FormatInt(ed25519.PublicKeySize*2, 10) + `) NOT NULL,
@@ -77,7 +90,12 @@ var (
 			"UpdateStatPeer":   `UPDATE peer SET LastFetch=?, LastPosition=?, ErrorCount=? WHERE PublicKey=?;`,
 			"UpdateNotifyPeer": `UPDATE peer SET LastNotifySend=?, ErrorCount=ErrorCount+? WHERE PublicKey=?;`,
 			"UpdateTokenPeer":  `UPDATE peer SET LastNotifyFrom=?, Authtoken=? WHERE PublicKey=?;`,
-			"SelectPeer":       `SELECT AuthToken, LastNotifySend, LastNotifyFrom, LastFetch, ErrorCount, LastPosition FROM peer WHERE PublicKey=?;`,
+			"SelectPeer":       `SELECT AuthToken, LastNotifySend, LastNotifyFrom, LastFetch, ErrorCount, LastPosition, LastTombstone, FailCount, RetryAfter, QuarantinedSince, LastError, LastErrorTime FROM peer WHERE PublicKey=?;`,
+			"SelectPeerList":   `SELECT PublicKey FROM peer;`,
+			"ImportPeer": `UPDATE peer SET AuthToken=?, LastNotifySend=?, LastNotifyFrom=?, LastFetch=?,
+                    ErrorCount=?, LastPosition=?, LastTombstone=?, FailCount=?, RetryAfter=?,
+                    QuarantinedSince=?, LastError=?, LastErrorTime=? WHERE PublicKey=?
+                ;`,
 			"MessageCreate": `CREATE TABLE IF NOT EXISTS message (
                     ID BIGINT UNSIGNED NOT NULL PRIMARY KEY AUTO_INCREMENT,
                     Counter BIGINT UNSIGNED NOT NULL DEFAULT 0,
@@ -105,6 +123,13 @@ var (
 			"DeleteMessage":       `DELETE FROM message WHERE MessageID=?;`,
 			"UpdateExpireMessage": `UPDATE message SET ExpireTime=? WHERE MessageID=?;`,
 			"SelectExpireMessage": `SELECT MessageID, SignerPub FROM message WHERE ExpireTime<?;`,
+			"SelectExpireBatch":   `SELECT ID, MessageID, SignerPub FROM message WHERE ExpireTime<? AND ID>? ORDER BY ID LIMIT ?;`,
+			"SelectMessageList":   `SELECT MessageID, SignerPub FROM message;`,
+			"SelectMessageIndexList": `SELECT m.ID, m.Counter, m.MessageID, m.ReceiverConstantPubKey, m.SignerPub,
+                    m.PostTime, m.ExpireTime, m.ExpireRequest, m.Distance, m.OneTime, m.Sync, m.Hidden,
+                    COALESCE(i.ID, 0), COALESCE(i.EntryTime, 0)
+                    FROM message AS m LEFT JOIN globalindex AS i ON i.Message=m.ID ORDER BY m.ID
+                ;`,
 			"MessageCounterCreate": `CREATE TABLE IF NOT EXISTS messageCounter (
                 ReceiverConstantPubKey VARCHAR(` + strconv.FormatInt(message.Curve25519KeySize*2, 10) + `) NOT NULL,
                 Counter BIGINT UNSIGNED NOT NULL DEFAULT 1,
@@ -116,6 +141,8 @@ var (
 			"IncreaseMessageCounter": `UPDATE messageCounter SET Counter=Counter+1, LastTime=? WHERE ReceiverConstantPubKey=?;`,
 			"InsertMessageCounter":   `INSERT INTO messageCounter (ReceiverConstantPubKey, LastTime) VALUES (?,? );`,
 			"ExpireMessageCounter":   `DELETE FROM messageCounter WHERE LastTime<?;`,
+			"ListMessageCounter":     `SELECT ReceiverConstantPubKey, Counter, LastTime FROM messageCounter;`,
+			"ImportMessageCounter":   `INSERT INTO messageCounter (ReceiverConstantPubKey, Counter, LastTime) VALUES (?, ?, ?);`,
 			"GlobalIndexCreate": `CREATE TABLE IF NOT EXISTS globalindex (
                     ID BIGINT UNSIGNED NOT NULL PRIMARY KEY AUTO_INCREMENT,
                     Message BIGINT UNSIGNED NOT NULL,
@@ -133,6 +160,15 @@ var (
                     FROM message AS m, globalindex AS i
                     WHERE i.ID>=? AND i.Message=m.ID ORDER BY i.ID ASC LIMIT ?
                 ;`,
+			"getSyncIDs": `SELECT m.MessageID, m.ExpireTime, m.PostTime, m.Distance FROM message AS m, globalindex AS i
+                    WHERE i.Message=m.ID AND m.MessageID>=? AND m.MessageID<? AND m.ExpireTime>?
+                    ORDER BY m.MessageID ASC
+                ;`,
+			"getGlobalIndexHead":       `SELECT COALESCE(MAX(ID), 0) FROM globalindex;`,
+			"globalIndexOrphans":       `SELECT COUNT(*) FROM globalindex WHERE Message NOT IN (SELECT ID FROM message);`,
+			"globalIndexDeleteOrphans": `DELETE FROM globalindex WHERE Message NOT IN (SELECT ID FROM message);`,
+			"globalIndexImport":        `INSERT INTO globalindex (ID, Message, EntryTime) VALUES (?, ?, ?);`,
+			"globalIndexDeleteMessage": `DELETE FROM globalindex WHERE Message IN (SELECT ID FROM message WHERE MessageID=?);`,
 			"messageBlobCreate": `CREATE TABLE IF NOT EXISTS messageblob (
                     Message BIGINT UNSIGNED NOT NULL,
                     MessageID VARCHAR(` + strconv.FormatInt(message.MessageIDSize*2, 10) + `) NOT NULL,
@@ -146,6 +182,8 @@ var (
                     (Message, MessageID, SignerPub, OneTime, Data) VALUES
                     (?, ?, ?, ?, ?);`,
 			"messageBlobSelect": `SELECT Message, MessageID, SignerPub, OneTime, Data FROM messageblob WHERE MessageID=?;`,
+			"messageBlobSize":   `SELECT COALESCE(SUM(LENGTH(Data)), 0) FROM messageblob;`,
+			"messageBlobList":   `SELECT MessageID FROM messageblob;`,
 			"messageBlobDelete": `DELETE FROM messageblob WHERE MessageID=?;`,
 			"messageExistCreate": `CREATE TABLE IF NOT EXISTS messageexists (
                     MessageID VARCHAR(` + strconv.FormatInt(message.MessageIDSize*2, 10) + `) NOT NULL,
@@ -155,6 +193,46 @@ var (
 			"messageExistInsert": `INSERT INTO messageexists (MessageID, EntryTime) VALUES (?, ?);`,
 			"messageExistSelect": `SELECT MessageID FROM messageexists WHERE MessageID=?;`,
 			"messageExistExpire": `DELETE FROM messageexists WHERE EntryTime<=?;`,
+			"messageExistList":   `SELECT MessageID, EntryTime FROM messageexists;`,
+			"messageExistTouch":  `UPDATE messageexists SET EntryTime=? WHERE MessageID=?;`,
+			"TombstoneCreate": `CREATE TABLE IF NOT EXISTS tombstone (
+                    ID BIGINT UNSIGNED NOT NULL PRIMARY KEY AUTO_INCREMENT,
+                    MessageID VARCHAR(` + strconv.FormatInt(message.MessageIDSize*2, 10) + `) NOT NULL,
+                    Tombstone VARCHAR(` + strconv.FormatInt(structs.TombstoneSize*2, 10) + `) NOT NULL,
+                    EntryTime BIGINT UNSIGNED NOT NULL DEFAULT 0,
+                    ExpireTime BIGINT UNSIGNED NOT NULL DEFAULT 0,
+                    UNIQUE KEY MessageID(MessageID)
+                );`,
+			"PeerTombstoneAdd":        `ALTER TABLE peer ADD COLUMN LastTombstone BIGINT UNSIGNED NOT NULL DEFAULT 0;`,
+			"tombstoneInsert":         `INSERT INTO tombstone (MessageID, AuthKey, Authorized, Tombstone, EntryTime, ExpireTime) VALUES (?, ?, ?, ?, ?, ?);`,
+			"tombstoneSelect":         `SELECT Tombstone FROM tombstone WHERE MessageID=? AND Authorized=1;`,
+			"tombstoneList":           `SELECT ID, Tombstone FROM tombstone WHERE ID>=? AND Authorized=1 ORDER BY ID ASC LIMIT ?;`,
+			"tombstoneExpire":         `DELETE FROM tombstone WHERE ExpireTime<?;`,
+			"tombstoneSelectPending":  `SELECT Tombstone FROM tombstone WHERE MessageID=? AND Authorized=0;`,
+			"tombstoneDeletePending":  `DELETE FROM tombstone WHERE MessageID=? AND Authorized=0;`,
+			"tombstoneDelete":         `DELETE FROM tombstone WHERE MessageID=? AND AuthKey=?;`,
+			"tombstoneListAll":        `SELECT ID, Authorized, Tombstone, EntryTime, ExpireTime FROM tombstone ORDER BY ID ASC;`,
+			"tombstoneImport":         `INSERT INTO tombstone (ID, MessageID, AuthKey, Authorized, Tombstone, EntryTime, ExpireTime) VALUES (?, ?, ?, ?, ?, ?, ?);`,
+			"TombstoneKeyStep1":       `ALTER TABLE tombstone ADD COLUMN AuthKey VARCHAR(66) NOT NULL DEFAULT '';`,
+			"TombstoneKeyStep2":       `ALTER TABLE tombstone ADD COLUMN Authorized TINYINT UNSIGNED NOT NULL DEFAULT 1;`,
+			"TombstoneKeyStep3":       `UPDATE tombstone SET AuthKey=SUBSTR(Tombstone, 65, 66);`,
+			"TombstoneKeyStep4":       `ALTER TABLE tombstone DROP INDEX MessageID;`,
+			"TombstoneKeyStep5":       `ALTER TABLE tombstone ADD UNIQUE KEY MessageAuthKey(MessageID, AuthKey);`,
+			"UpdateTombstonePeer":     `UPDATE peer SET LastTombstone=? WHERE PublicKey=?;`,
+			"PeerFailCountAdd":        `ALTER TABLE peer ADD COLUMN FailCount BIGINT UNSIGNED NOT NULL DEFAULT 0;`,
+			"PeerRetryAfterAdd":       `ALTER TABLE peer ADD COLUMN RetryAfter BIGINT UNSIGNED NOT NULL DEFAULT 0;`,
+			"PeerQuarantinedSinceAdd": `ALTER TABLE peer ADD COLUMN QuarantinedSince BIGINT UNSIGNED NOT NULL DEFAULT 0;`,
+			"PeerLastErrorAdd":        `ALTER TABLE peer ADD COLUMN LastError VARCHAR(255) NOT NULL DEFAULT '';`,
+			"PeerLastErrorTimeAdd":    `ALTER TABLE peer ADD COLUMN LastErrorTime BIGINT UNSIGNED NOT NULL DEFAULT 0;`,
+			"UpdateHealthPeer":        `UPDATE peer SET FailCount=?, RetryAfter=?, QuarantinedSince=?, LastError=?, LastErrorTime=? WHERE PublicKey=?;`,
+			"SchemaVersionCreate": `CREATE TABLE IF NOT EXISTS schema_version (
+                    Version INT NOT NULL PRIMARY KEY,
+                    Description VARCHAR(255) NOT NULL DEFAULT '',
+                    AppliedAt BIGINT UNSIGNED NOT NULL DEFAULT 0
+                );`,
+			"SchemaVersionSelect": `SELECT Version, AppliedAt FROM schema_version ORDER BY Version ASC;`,
+			"SchemaVersionExists": `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'schema_version';`,
+			"SchemaVersionInsert": `INSERT INTO schema_version (Version, Description, AppliedAt) VALUES (?, ?, ?);`,
 		},
 		"sqlite3": map[string]string{
 			"SignerCreate": `CREATE TABLE IF NOT EXISTS signer (
@@ -192,7 +270,7 @@ var (
                 ;`,
 			"DelMessageSigner": `UPDATE signer
                 SET MessagesRetained=MessagesRetained-1
-                WHERE PublicKey=? 
+                WHERE PublicKey=? AND MessagesRetained>0
                 ;`,
 			"PrepareExpireSigner": `UPDATE signer SET LastMessageDeleted=? WHERE 
                 MessagesRetained<=0 AND LastMessageDeleted=0
@@ -200,6 +278,16 @@ var (
 			"DeleteExpireSigner": `DELETE FROM signer 
                 WHERE LastMessageDeleted!=0 AND LastMessageDeleted<?
                 ;`,
+			"SelectSignerRetained": `SELECT s.PublicKey, s.MessagesRetained, COUNT(m.ID)
+                FROM signer AS s LEFT JOIN message AS m ON m.SignerPub=s.PublicKey
+                GROUP BY s.ID, s.PublicKey, s.MessagesRetained HAVING s.MessagesRetained!=COUNT(m.ID)
+                ;`,
+			"SetSignerRetained": `UPDATE signer SET MessagesRetained=? WHERE PublicKey=?;`,
+			"SelectSignerList": `SELECT ID, PublicKey, Nonce, Bits, MessagesPosted,
+                    MessagesRetained, MaxMessagesPosted, MaxMessagesRetained, ExpireTarget FROM
+                    signer ORDER BY ID
+                ;`,
+			"SetSignerCounters": `UPDATE signer SET MessagesPosted=?, MessagesRetained=? WHERE PublicKey=?;`,
 			"PeerCreate": `CREATE TABLE IF NOT EXISTS peer (
                     ID INTEGER PRIMARY KEY AUTOINCREMENT,
                     PublicKey VARCHAR(` + strconv.FormatInt(ed25519.PublicKeySize*2, 10) + `) NOT NULL,
@@ -215,7 +303,12 @@ var (
 			"UpdateStatPeer":   `UPDATE peer SET LastFetch=?, LastPosition=?, ErrorCount=? WHERE PublicKey=?;`,
 			"UpdateNotifyPeer": `UPDATE peer SET LastNotifySend=?, ErrorCount=ErrorCount+? WHERE PublicKey=?;`,
 			"UpdateTokenPeer":  `UPDATE peer SET LastNotifyFrom=?, Authtoken=? WHERE PublicKey=?;`,
-			"SelectPeer":       `SELECT AuthToken, LastNotifySend, LastNotifyFrom, LastFetch, ErrorCount, LastPosition FROM peer WHERE PublicKey=?;`,
+			"SelectPeer":       `SELECT AuthToken, LastNotifySend, LastNotifyFrom, LastFetch, ErrorCount, LastPosition, LastTombstone, FailCount, RetryAfter, QuarantinedSince, LastError, LastErrorTime FROM peer WHERE PublicKey=?;`,
+			"SelectPeerList":   `SELECT PublicKey FROM peer;`,
+			"ImportPeer": `UPDATE peer SET AuthToken=?, LastNotifySend=?, LastNotifyFrom=?, LastFetch=?,
+                    ErrorCount=?, LastPosition=?, LastTombstone=?, FailCount=?, RetryAfter=?,
+                    QuarantinedSince=?, LastError=?, LastErrorTime=? WHERE PublicKey=?
+                ;`,
 			"MessageCreate": `CREATE TABLE IF NOT EXISTS message (
                     ID INTEGER PRIMARY KEY AUTOINCREMENT,
                     Counter BIGINT UNSIGNED NOT NULL DEFAULT 0,
@@ -232,6 +325,22 @@ var (
                     UNIQUE (Counter, ReceiverConstantPubKey),
                     UNIQUE (MessageID)
                 );`,
+			// SQLite cannot change constraints, the tombstone table is rebuilt
+			"TombstoneKeyStep1": `CREATE TABLE tombstone_new (
+                    ID INTEGER PRIMARY KEY AUTOINCREMENT,
+                    MessageID VARCHAR(` + strconv.FormatInt(message.MessageIDSize*2, 10) + `) NOT NULL,
+                    AuthKey VARCHAR(66) NOT NULL DEFAULT '',
+                    Authorized INT NOT NULL DEFAULT 1,
+                    Tombstone VARCHAR(` + strconv.FormatInt(structs.TombstoneSize*2, 10) + `) NOT NULL,
+                    EntryTime BIGINT UNSIGNED NOT NULL DEFAULT 0,
+                    ExpireTime BIGINT UNSIGNED NOT NULL DEFAULT 0,
+                    UNIQUE (MessageID, AuthKey)
+                );`,
+			"TombstoneKeyStep2": `INSERT INTO tombstone_new (ID, MessageID, AuthKey, Tombstone, EntryTime, ExpireTime)
+                    SELECT ID, MessageID, SUBSTR(Tombstone, 65, 66), Tombstone, EntryTime, ExpireTime FROM tombstone;`,
+			"TombstoneKeyStep3": `DROP TABLE tombstone;`,
+			"TombstoneKeyStep4": `ALTER TABLE tombstone_new RENAME TO tombstone;`,
+			"TombstoneKeyStep5": ``,
 			"InsertMessage": `INSERT INTO message
                     (Counter, MessageID, ReceiverConstantPubKey, SignerPub,
                     PostTime, ExpireTime, ExpireRequest, Distance, OneTime, Sync, Hidden)
@@ -243,6 +352,13 @@ var (
 			"DeleteMessage":       `DELETE FROM message WHERE MessageID=?;`,
 			"UpdateExpireMessage": `UPDATE message SET ExpireTime=? WHERE MessageID=?;`,
 			"SelectExpireMessage": `SELECT MessageID, SignerPub FROM message WHERE ExpireTime<?;`,
+			"SelectExpireBatch":   `SELECT ID, MessageID, SignerPub FROM message WHERE ExpireTime<? AND ID>? ORDER BY ID LIMIT ?;`,
+			"SelectMessageList":   `SELECT MessageID, SignerPub FROM message;`,
+			"SelectMessageIndexList": `SELECT m.ID, m.Counter, m.MessageID, m.ReceiverConstantPubKey, m.SignerPub,
+                    m.PostTime, m.ExpireTime, m.ExpireRequest, m.Distance, m.OneTime, m.Sync, m.Hidden,
+                    COALESCE(i.ID, 0), COALESCE(i.EntryTime, 0)
+                    FROM message AS m LEFT JOIN globalindex AS i ON i.Message=m.ID ORDER BY m.ID
+                ;`,
 			"MessageCounterCreate": `CREATE TABLE IF NOT EXISTS messageCounter (
                 ReceiverConstantPubKey VARCHAR(` + strconv.FormatInt(message.Curve25519KeySize*2, 10) + `) NOT NULL,
                 Counter BIGINT UNSIGNED NOT NULL DEFAULT 1,
@@ -254,6 +370,8 @@ var (
 			"IncreaseMessageCounter": `UPDATE messageCounter SET Counter=Counter+1, LastTime=? WHERE ReceiverConstantPubKey=?;`,
 			"InsertMessageCounter":   `INSERT INTO messageCounter (ReceiverConstantPubKey, LastTime) VALUES (?,? );`,
 			"ExpireMessageCounter":   `DELETE FROM messageCounter WHERE LastTime<?;`,
+			"ListMessageCounter":     `SELECT ReceiverConstantPubKey, Counter, LastTime FROM messageCounter;`,
+			"ImportMessageCounter":   `INSERT INTO messageCounter (ReceiverConstantPubKey, Counter, LastTime) VALUES (?, ?, ?);`,
 			"GlobalIndexCreate": `CREATE TABLE IF NOT EXISTS globalindex (
                     ID INTEGER PRIMARY KEY AUTOINCREMENT,
                     Message BIGINT UNSIGNED NOT NULL,
@@ -271,6 +389,15 @@ var (
                     FROM message AS m, globalindex AS i
                     WHERE i.ID>=? AND i.Message=m.ID ORDER BY i.ID ASC LIMIT ?
                 ;`,
+			"getSyncIDs": `SELECT m.MessageID, m.ExpireTime, m.PostTime, m.Distance FROM message AS m, globalindex AS i
+                    WHERE i.Message=m.ID AND m.MessageID>=? AND m.MessageID<? AND m.ExpireTime>?
+                    ORDER BY m.MessageID ASC
+                ;`,
+			"getGlobalIndexHead":       `SELECT COALESCE(MAX(ID), 0) FROM globalindex;`,
+			"globalIndexOrphans":       `SELECT COUNT(*) FROM globalindex WHERE Message NOT IN (SELECT ID FROM message);`,
+			"globalIndexDeleteOrphans": `DELETE FROM globalindex WHERE Message NOT IN (SELECT ID FROM message);`,
+			"globalIndexImport":        `INSERT INTO globalindex (ID, Message, EntryTime) VALUES (?, ?, ?);`,
+			"globalIndexDeleteMessage": `DELETE FROM globalindex WHERE Message IN (SELECT ID FROM message WHERE MessageID=?);`,
 			"messageBlobCreate": `CREATE TABLE IF NOT EXISTS messageblob (
                     Message BIGINT UNSIGNED NOT NULL,
                     MessageID VARCHAR(` + strconv.FormatInt(message.MessageIDSize*2, 10) + `) NOT NULL,
@@ -284,6 +411,8 @@ var (
                     (Message, MessageID, SignerPub, OneTime, Data) VALUES
                     (?, ?, ?, ?, ?);`,
 			"messageBlobSelect": `SELECT Message, MessageID, SignerPub, OneTime, Data FROM messageblob WHERE MessageID=?;`,
+			"messageBlobSize":   `SELECT COALESCE(SUM(LENGTH(Data)), 0) FROM messageblob;`,
+			"messageBlobList":   `SELECT MessageID FROM messageblob;`,
 			"messageBlobDelete": `DELETE FROM messageblob WHERE MessageID=?;`,
 			"messageExistCreate": `CREATE TABLE IF NOT EXISTS messageexists (
                     MessageID VARCHAR(` + strconv.FormatInt(message.MessageIDSize*2, 10) + `) NOT NULL,
@@ -293,6 +422,264 @@ var (
 			"messageExistInsert": `INSERT INTO messageexists (MessageID, EntryTime) VALUES (?, ?);`,
 			"messageExistSelect": `SELECT MessageID FROM messageexists WHERE MessageID=?;`,
 			"messageExistExpire": `DELETE FROM messageexists WHERE EntryTime<=?;`,
+			"messageExistList":   `SELECT MessageID, EntryTime FROM messageexists;`,
+			"messageExistTouch":  `UPDATE messageexists SET EntryTime=? WHERE MessageID=?;`,
+			"TombstoneCreate": `CREATE TABLE IF NOT EXISTS tombstone (
+                    ID INTEGER PRIMARY KEY AUTOINCREMENT,
+                    MessageID VARCHAR(` + strconv.FormatInt(message.MessageIDSize*2, 10) + `) NOT NULL,
+                    Tombstone VARCHAR(` + strconv.FormatInt(structs.TombstoneSize*2, 10) + `) NOT NULL,
+                    EntryTime BIGINT UNSIGNED NOT NULL DEFAULT 0,
+                    ExpireTime BIGINT UNSIGNED NOT NULL DEFAULT 0,
+                    UNIQUE (MessageID)
+                );`,
+			"PeerTombstoneAdd":        `ALTER TABLE peer ADD COLUMN LastTombstone BIGINT UNSIGNED NOT NULL DEFAULT 0;`,
+			"tombstoneInsert":         `INSERT INTO tombstone (MessageID, AuthKey, Authorized, Tombstone, EntryTime, ExpireTime) VALUES (?, ?, ?, ?, ?, ?);`,
+			"tombstoneSelect":         `SELECT Tombstone FROM tombstone WHERE MessageID=? AND Authorized=1;`,
+			"tombstoneList":           `SELECT ID, Tombstone FROM tombstone WHERE ID>=? AND Authorized=1 ORDER BY ID ASC LIMIT ?;`,
+			"tombstoneExpire":         `DELETE FROM tombstone WHERE ExpireTime<?;`,
+			"tombstoneSelectPending":  `SELECT Tombstone FROM tombstone WHERE MessageID=? AND Authorized=0;`,
+			"tombstoneDeletePending":  `DELETE FROM tombstone WHERE MessageID=? AND Authorized=0;`,
+			"tombstoneDelete":         `DELETE FROM tombstone WHERE MessageID=? AND AuthKey=?;`,
+			"tombstoneListAll":        `SELECT ID, Authorized, Tombstone, EntryTime, ExpireTime FROM tombstone ORDER BY ID ASC;`,
+			"tombstoneImport":         `INSERT INTO tombstone (ID, MessageID, AuthKey, Authorized, Tombstone, EntryTime, ExpireTime) VALUES (?, ?, ?, ?, ?, ?, ?);`,
+			"UpdateTombstonePeer":     `UPDATE peer SET LastTombstone=? WHERE PublicKey=?;`,
+			"PeerFailCountAdd":        `ALTER TABLE peer ADD COLUMN FailCount BIGINT UNSIGNED NOT NULL DEFAULT 0;`,
+			"PeerRetryAfterAdd":       `ALTER TABLE peer ADD COLUMN RetryAfter BIGINT UNSIGNED NOT NULL DEFAULT 0;`,
+			"PeerQuarantinedSinceAdd": `ALTER TABLE peer ADD COLUMN QuarantinedSince BIGINT UNSIGNED NOT NULL DEFAULT 0;`,
+			"PeerLastErrorAdd":        `ALTER TABLE peer ADD COLUMN LastError VARCHAR(255) NOT NULL DEFAULT '';`,
+			"PeerLastErrorTimeAdd":    `ALTER TABLE peer ADD COLUMN LastErrorTime BIGINT UNSIGNED NOT NULL DEFAULT 0;`,
+			"UpdateHealthPeer":        `UPDATE peer SET FailCount=?, RetryAfter=?, QuarantinedSince=?, LastError=?, LastErrorTime=? WHERE PublicKey=?;`,
+			"SchemaVersionCreate": `CREATE TABLE IF NOT EXISTS schema_version (
+                    Version INT NOT NULL PRIMARY KEY,
+                    Description VARCHAR(255) NOT NULL DEFAULT '',
+                    AppliedAt BIGINT UNSIGNED NOT NULL DEFAULT 0
+                );`,
+			"SchemaVersionSelect": `SELECT Version, AppliedAt FROM schema_version ORDER BY Version ASC;`,
+			"SchemaVersionExists": `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version';`,
+			"SchemaVersionInsert": `INSERT INTO schema_version (Version, Description, AppliedAt) VALUES (?, ?, ?);`,
+		},
+		"postgres": map[string]string{
+			"SignerCreate": `CREATE TABLE IF NOT EXISTS signer (
+                    ID BIGSERIAL PRIMARY KEY,
+                    PublicKey VARCHAR(` + strconv.FormatInt(message.SignerPubKeySize*2, 10) + `) NOT NULL,
+                    Nonce VARCHAR(` + strconv.FormatInt(hashcash.NonceSize*2, 10) + `) NOT NULL,
+                    Bits INT NOT NULL DEFAULT 0,
+                    MessagesPosted BIGINT NOT NULL DEFAULT 0,
+                    MessagesRetained BIGINT NOT NULL DEFAULT 0,
+                    MaxMessagesPosted BIGINT NOT NULL DEFAULT 0,
+                    MaxMessagesRetained BIGINT NOT NULL DEFAULT 0,
+                    ExpireTarget BIGINT NOT NULL DEFAULT 0,
+                    LastMessageDeleted BIGINT NOT NULL DEFAULT 0,
+                    UNIQUE (PublicKey)
+                );`,
+			"SignerInsert": `INSERT INTO signer (PublicKey,Nonce,Bits,
+                    MaxMessagesPosted,MaxMessagesRetained,ExpireTarget) VALUES
+                    ($1,$2,$3,$4,$5,$6) RETURNING ID
+                ;`,
+			"SelectSignerPublicKey": `SELECT ID, PublicKey, Nonce, Bits, MessagesPosted,
+                    MessagesRetained, MaxMessagesPosted, MaxMessagesRetained, ExpireTarget FROM
+                    signer WHERE PublicKey=$1
+                ;`,
+			"SelectSignerID": `SELECT ID, PublicKey, Nonce, Bits, MessagesPosted,
+                    MessagesRetained, MaxMessagesPosted, MaxMessagesRetained, ExpireTarget FROM
+                    signer WHERE ID=$1
+                ;`,
+			"UpdateSigner": `UPDATE signer SET Nonce=$1, Bits=$2, MaxMessagesPosted=$3,
+                    MaxMessagesRetained=$4, ExpireTarget=$5 WHERE PublicKey=$6
+                ;`,
+			"UpdateOrInsertSigner": `INSERT INTO signer
+                    (PublicKey, Nonce, Bits, MaxMessagesPosted, MaxMessagesRetained, ExpireTarget)
+                    VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (PublicKey) DO UPDATE SET
+                    Nonce=EXCLUDED.Nonce,
+                    Bits=EXCLUDED.Bits,
+                    MaxMessagesPosted=EXCLUDED.MaxMessagesPosted,
+                    MaxMessagesRetained=EXCLUDED.MaxMessagesRetained,
+                    ExpireTarget=EXCLUDED.ExpireTarget
+                ;`,
+			"AddMessageSigner": `UPDATE signer
+                SET MessagesPosted=MessagesPosted+1, MessagesRetained=MessagesRetained+1, LastMessageDeleted=0
+                WHERE PublicKey=$1 AND MaxMessagesRetained>MessagesRetained AND MaxMessagesPosted>MessagesPosted
+                ;`,
+			"DelMessageSigner": `UPDATE signer
+                SET MessagesRetained=MessagesRetained-1
+                WHERE PublicKey=$1 AND MessagesRetained>0
+                ;`,
+			"PrepareExpireSigner": `UPDATE signer SET LastMessageDeleted=$1 WHERE
+                MessagesRetained<=0 AND LastMessageDeleted=0
+                ;`,
+			"DeleteExpireSigner": `DELETE FROM signer
+                WHERE LastMessageDeleted!=0 AND LastMessageDeleted<$1
+                ;`,
+			"SelectSignerRetained": `SELECT s.PublicKey, s.MessagesRetained, COUNT(m.ID)
+                FROM signer AS s LEFT JOIN message AS m ON m.SignerPub=s.PublicKey
+                GROUP BY s.ID, s.PublicKey, s.MessagesRetained HAVING s.MessagesRetained!=COUNT(m.ID)
+                ;`,
+			"SetSignerRetained": `UPDATE signer SET MessagesRetained=$1 WHERE PublicKey=$2;`,
+			"SelectSignerList": `SELECT ID, PublicKey, Nonce, Bits, MessagesPosted,
+                    MessagesRetained, MaxMessagesPosted, MaxMessagesRetained, ExpireTarget FROM
+                    signer ORDER BY ID
+                ;`,
+			"SetSignerCounters": `UPDATE signer SET MessagesPosted=$1, MessagesRetained=$2 WHERE PublicKey=$3;`,
+			"PeerCreate": `CREATE TABLE IF NOT EXISTS peer (
+                    ID BIGSERIAL PRIMARY KEY,
+                    PublicKey VARCHAR(` + strconv.FormatInt(ed25519.PublicKeySize*2, 10) + `) NOT NULL,
+                    AuthToken TEXT NOT NULL DEFAULT '',
+                    LastNotifySend BIGINT NOT NULL DEFAULT 0,
+                    LastNotifyFrom BIGINT NOT NULL DEFAULT 0,
+                    LastFetch BIGINT NOT NULL DEFAULT 0,
+                    ErrorCount BIGINT NOT NULL DEFAULT 0,
+                    LastPosition BIGINT NOT NULL DEFAULT 0,
+                    UNIQUE (PublicKey)
+                );`,
+			"InsertPeer":       `INSERT INTO peer (PublicKey) VALUES ($1);`,
+			"UpdateStatPeer":   `UPDATE peer SET LastFetch=$1, LastPosition=$2, ErrorCount=$3 WHERE PublicKey=$4;`,
+			"UpdateNotifyPeer": `UPDATE peer SET LastNotifySend=$1, ErrorCount=ErrorCount+$2 WHERE PublicKey=$3;`,
+			"UpdateTokenPeer":  `UPDATE peer SET LastNotifyFrom=$1, Authtoken=$2 WHERE PublicKey=$3;`,
+			"SelectPeer":       `SELECT AuthToken, LastNotifySend, LastNotifyFrom, LastFetch, ErrorCount, LastPosition, LastTombstone, FailCount, RetryAfter, QuarantinedSince, LastError, LastErrorTime FROM peer WHERE PublicKey=$1;`,
+			"SelectPeerList":   `SELECT PublicKey FROM peer;`,
+			"ImportPeer": `UPDATE peer SET AuthToken=$1, LastNotifySend=$2, LastNotifyFrom=$3, LastFetch=$4,
+                    ErrorCount=$5, LastPosition=$6, LastTombstone=$7, FailCount=$8, RetryAfter=$9,
+                    QuarantinedSince=$10, LastError=$11, LastErrorTime=$12 WHERE PublicKey=$13
+                ;`,
+			"MessageCreate": `CREATE TABLE IF NOT EXISTS message (
+                    ID BIGSERIAL PRIMARY KEY,
+                    Counter BIGINT NOT NULL DEFAULT 0,
+                    MessageID VARCHAR(` + strconv.FormatInt(message.MessageIDSize*2, 10) + `) NOT NULL,
+                    ReceiverConstantPubKey VARCHAR(` + strconv.FormatInt(message.Curve25519KeySize*2, 10) + `) NOT NULL,
+                    SignerPub VARCHAR(` + strconv.FormatInt(message.SignerPubKeySize*2, 10) + `) NOT NULL,
+                    PostTime BIGINT NOT NULL DEFAULT 0,
+                    ExpireTime BIGINT NOT NULL DEFAULT 0,
+                    ExpireRequest BIGINT NOT NULL DEFAULT 0,
+                    Distance BIGINT NOT NULL DEFAULT 0,
+                    OneTime SMALLINT NOT NULL DEFAULT 1,
+                    Sync SMALLINT NOT NULL DEFAULT 0,
+                    Hidden SMALLINT NOT NULL DEFAULT 1,
+                    UNIQUE (Counter, ReceiverConstantPubKey),
+                    UNIQUE (MessageID)
+                );`,
+			"InsertMessage": `INSERT INTO message
+                    (Counter, MessageID, ReceiverConstantPubKey, SignerPub,
+                    PostTime, ExpireTime, ExpireRequest, Distance, OneTime, Sync, Hidden)
+                    VALUES ( $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING ID
+                ;`,
+			"SelectMessage": `SELECT ID, Counter, MessageID, ReceiverConstantPubKey, SignerPub,
+                    PostTime, ExpireTime, ExpireRequest, Distance, OneTime, Sync, Hidden FROM message
+                    WHERE MessageID=$1;`,
+			"DeleteMessage":       `DELETE FROM message WHERE MessageID=$1;`,
+			"UpdateExpireMessage": `UPDATE message SET ExpireTime=$1 WHERE MessageID=$2;`,
+			"SelectExpireMessage": `SELECT MessageID, SignerPub FROM message WHERE ExpireTime<$1;`,
+			"SelectExpireBatch":   `SELECT ID, MessageID, SignerPub FROM message WHERE ExpireTime<$1 AND ID>$2 ORDER BY ID LIMIT $3;`,
+			"SelectMessageList":   `SELECT MessageID, SignerPub FROM message;`,
+			"SelectMessageIndexList": `SELECT m.ID, m.Counter, m.MessageID, m.ReceiverConstantPubKey, m.SignerPub,
+                    m.PostTime, m.ExpireTime, m.ExpireRequest, m.Distance, m.OneTime, m.Sync, m.Hidden,
+                    COALESCE(i.ID, 0), COALESCE(i.EntryTime, 0)
+                    FROM message AS m LEFT JOIN globalindex AS i ON i.Message=m.ID ORDER BY m.ID
+                ;`,
+			"MessageCounterCreate": `CREATE TABLE IF NOT EXISTS messageCounter (
+                ReceiverConstantPubKey VARCHAR(` + strconv.FormatInt(message.Curve25519KeySize*2, 10) + `) NOT NULL,
+                Counter BIGINT NOT NULL DEFAULT 1,
+                LastTime BIGINT NOT NULL DEFAULT 0,
+                UNIQUE (ReceiverConstantPubKey),
+                UNIQUE (ReceiverConstantPubKey, Counter)
+                );`,
+			"NextMessageCounter":     `SELECT MAX(Counter) FROM messageCounter WHERE ReceiverConstantPubKey=$1;`,
+			"IncreaseMessageCounter": `UPDATE messageCounter SET Counter=Counter+1, LastTime=$1 WHERE ReceiverConstantPubKey=$2;`,
+			"InsertMessageCounter":   `INSERT INTO messageCounter (ReceiverConstantPubKey, LastTime) VALUES ($1,$2 );`,
+			"ExpireMessageCounter":   `DELETE FROM messageCounter WHERE LastTime<$1;`,
+			"ListMessageCounter":     `SELECT ReceiverConstantPubKey, Counter, LastTime FROM messageCounter;`,
+			"ImportMessageCounter":   `INSERT INTO messageCounter (ReceiverConstantPubKey, Counter, LastTime) VALUES ($1, $2, $3);`,
+			"GlobalIndexCreate": `CREATE TABLE IF NOT EXISTS globalindex (
+                    ID BIGSERIAL PRIMARY KEY,
+                    Message BIGINT NOT NULL,
+                    EntryTime BIGINT NOT NULL DEFAULT 0,
+                    UNIQUE (Message),
+                    FOREIGN KEY (Message) REFERENCES message(ID) ON DELETE CASCADE
+                );`,
+			"globalIndexAdd": `INSERT INTO globalindex (Message, EntryTime) VALUES ($1, $2);`,
+			"getKeyIndex": `SELECT ID, Counter, MessageID, ReceiverConstantPubKey, SignerPub,
+                    PostTime, ExpireTime, ExpireRequest, Distance, OneTime, Sync, Hidden FROM message
+                    WHERE ReceiverConstantPubKey=$1 AND Counter>=$2 ORDER BY Counter ASC LIMIT $3
+                ;`,
+			"getGlobalIndex": `SELECT m.ID, i.ID, m.MessageID, m.ReceiverConstantPubKey, m.SignerPub,
+                    m.PostTime, m.ExpireTime, m.ExpireRequest, m.Distance, m.OneTime, m.Sync, m.Hidden
+                    FROM message AS m, globalindex AS i
+                    WHERE i.ID>=$1 AND i.Message=m.ID ORDER BY i.ID ASC LIMIT $2
+                ;`,
+			"getSyncIDs": `SELECT m.MessageID, m.ExpireTime, m.PostTime, m.Distance FROM message AS m, globalindex AS i
+                    WHERE i.Message=m.ID AND m.MessageID>=$1 AND m.MessageID<$2 AND m.ExpireTime>$3
+                    ORDER BY m.MessageID ASC
+                ;`,
+			"getGlobalIndexHead":       `SELECT COALESCE(MAX(ID), 0) FROM globalindex;`,
+			"globalIndexOrphans":       `SELECT COUNT(*) FROM globalindex WHERE Message NOT IN (SELECT ID FROM message);`,
+			"globalIndexDeleteOrphans": `DELETE FROM globalindex WHERE Message NOT IN (SELECT ID FROM message);`,
+			"globalIndexImport":        `INSERT INTO globalindex (ID, Message, EntryTime) VALUES ($1, $2, $3);`,
+			"globalIndexDeleteMessage": `DELETE FROM globalindex WHERE Message IN (SELECT ID FROM message WHERE MessageID=$1);`,
+			"globalIndexSequence":      `SELECT setval(pg_get_serial_sequence('globalindex', 'id'), (SELECT COALESCE(MAX(ID), 1) FROM globalindex));`,
+			"messageBlobCreate": `CREATE TABLE IF NOT EXISTS messageblob (
+                    Message BIGINT NOT NULL,
+                    MessageID VARCHAR(` + strconv.FormatInt(message.MessageIDSize*2, 10) + `) NOT NULL,
+                    SignerPub VARCHAR(` + strconv.FormatInt(message.SignerPubKeySize*2, 10) + `) NOT NULL,
+                    OneTime INT NOT NULL DEFAULT 0,
+                    DATA BYTEA,
+                    UNIQUE (Message),
+                    FOREIGN KEY (Message) REFERENCES message(ID) ON DELETE CASCADE
+                );`,
+			"messageBlobInsert": `INSERT INTO messageblob
+                    (Message, MessageID, SignerPub, OneTime, Data) VALUES
+                    ($1, $2, $3, $4, $5);`,
+			"messageBlobSelect": `SELECT Message, MessageID, SignerPub, OneTime, Data FROM messageblob WHERE MessageID=$1;`,
+			"messageBlobSize":   `SELECT COALESCE(SUM(LENGTH(Data)), 0) FROM messageblob;`,
+			"messageBlobList":   `SELECT MessageID FROM messageblob;`,
+			"messageBlobDelete": `DELETE FROM messageblob WHERE MessageID=$1;`,
+			"messageExistCreate": `CREATE TABLE IF NOT EXISTS messageexists (
+                    MessageID VARCHAR(` + strconv.FormatInt(message.MessageIDSize*2, 10) + `) NOT NULL,
+                    EntryTime BIGINT NOT NULL DEFAULT 0,
+                    UNIQUE (MessageID)
+                );`,
+			"messageExistInsert": `INSERT INTO messageexists (MessageID, EntryTime) VALUES ($1, $2);`,
+			"messageExistSelect": `SELECT MessageID FROM messageexists WHERE MessageID=$1;`,
+			"messageExistExpire": `DELETE FROM messageexists WHERE EntryTime<=$1;`,
+			"messageExistList":   `SELECT MessageID, EntryTime FROM messageexists;`,
+			"messageExistTouch":  `UPDATE messageexists SET EntryTime=$1 WHERE MessageID=$2;`,
+			"TombstoneCreate": `CREATE TABLE IF NOT EXISTS tombstone (
+                    ID BIGSERIAL PRIMARY KEY,
+                    MessageID VARCHAR(` + strconv.FormatInt(message.MessageIDSize*2, 10) + `) NOT NULL,
+                    Tombstone VARCHAR(` + strconv.FormatInt(structs.TombstoneSize*2, 10) + `) NOT NULL,
+                    EntryTime BIGINT NOT NULL DEFAULT 0,
+                    ExpireTime BIGINT NOT NULL DEFAULT 0,
+                    UNIQUE (MessageID)
+                );`,
+			"PeerTombstoneAdd":        `ALTER TABLE peer ADD COLUMN LastTombstone BIGINT NOT NULL DEFAULT 0;`,
+			"tombstoneInsert":         `INSERT INTO tombstone (MessageID, AuthKey, Authorized, Tombstone, EntryTime, ExpireTime) VALUES ($1, $2, $3, $4, $5, $6);`,
+			"tombstoneSelect":         `SELECT Tombstone FROM tombstone WHERE MessageID=$1 AND Authorized=1;`,
+			"tombstoneList":           `SELECT ID, Tombstone FROM tombstone WHERE ID>=$1 AND Authorized=1 ORDER BY ID ASC LIMIT $2;`,
+			"tombstoneExpire":         `DELETE FROM tombstone WHERE ExpireTime<$1;`,
+			"tombstoneSelectPending":  `SELECT Tombstone FROM tombstone WHERE MessageID=$1 AND Authorized=0;`,
+			"tombstoneDeletePending":  `DELETE FROM tombstone WHERE MessageID=$1 AND Authorized=0;`,
+			"tombstoneDelete":         `DELETE FROM tombstone WHERE MessageID=$1 AND AuthKey=$2;`,
+			"tombstoneListAll":        `SELECT ID, Authorized, Tombstone, EntryTime, ExpireTime FROM tombstone ORDER BY ID ASC;`,
+			"tombstoneImport":         `INSERT INTO tombstone (ID, MessageID, AuthKey, Authorized, Tombstone, EntryTime, ExpireTime) VALUES ($1, $2, $3, $4, $5, $6, $7);`,
+			"tombstoneSequence":       `SELECT setval(pg_get_serial_sequence('tombstone', 'id'), (SELECT COALESCE(MAX(ID), 1) FROM tombstone));`,
+			"TombstoneKeyStep1":       `ALTER TABLE tombstone ADD COLUMN AuthKey VARCHAR(66) NOT NULL DEFAULT '';`,
+			"TombstoneKeyStep2":       `ALTER TABLE tombstone ADD COLUMN Authorized SMALLINT NOT NULL DEFAULT 1;`,
+			"TombstoneKeyStep3":       `UPDATE tombstone SET AuthKey=SUBSTR(Tombstone, 65, 66);`,
+			"TombstoneKeyStep4":       `ALTER TABLE tombstone DROP CONSTRAINT tombstone_messageid_key;`,
+			"TombstoneKeyStep5":       `ALTER TABLE tombstone ADD CONSTRAINT tombstone_messageid_authkey_key UNIQUE (MessageID, AuthKey);`,
+			"UpdateTombstonePeer":     `UPDATE peer SET LastTombstone=$1 WHERE PublicKey=$2;`,
+			"PeerFailCountAdd":        `ALTER TABLE peer ADD COLUMN FailCount BIGINT NOT NULL DEFAULT 0;`,
+			"PeerRetryAfterAdd":       `ALTER TABLE peer ADD COLUMN RetryAfter BIGINT NOT NULL DEFAULT 0;`,
+			"PeerQuarantinedSinceAdd": `ALTER TABLE peer ADD COLUMN QuarantinedSince BIGINT NOT NULL DEFAULT 0;`,
+			"PeerLastErrorAdd":        `ALTER TABLE peer ADD COLUMN LastError VARCHAR(255) NOT NULL DEFAULT '';`,
+			"PeerLastErrorTimeAdd":    `ALTER TABLE peer ADD COLUMN LastErrorTime BIGINT NOT NULL DEFAULT 0;`,
+			"UpdateHealthPeer":        `UPDATE peer SET FailCount=$1, RetryAfter=$2, QuarantinedSince=$3, LastError=$4, LastErrorTime=$5 WHERE PublicKey=$6;`,
+			"SchemaVersionCreate": `CREATE TABLE IF NOT EXISTS schema_version (
+                    Version INT NOT NULL PRIMARY KEY,
+                    Description VARCHAR(255) NOT NULL DEFAULT '',
+                    AppliedAt BIGINT NOT NULL DEFAULT 0
+                );`,
+			"SchemaVersionSelect": `SELECT Version, AppliedAt FROM schema_version ORDER BY Version ASC;`,
+			"SchemaVersionExists": `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'schema_version';`,
+			"SchemaVersionInsert": `INSERT INTO schema_version (Version, Description, AppliedAt) VALUES ($1, $2, $3);`,
 		},
 	}
 )
@@ -1,8 +1,6 @@
 package sql
 
 import (
-	"database/sql"
-
 	"github.com/repbin/repbin/message"
 	"github.com/repbin/repbin/utils/repproto/structs"
 )
@@ -10,7 +8,7 @@ import (
 // InsertSigner writes a new signer to database and returns its unique ID
 func (db *MessageDB) InsertSigner(signerStruct *structs.SignerStruct) (int64, error) {
 	// mdb.signerInsertQ(PublicKey,Nonce,Bits,MaxMessagesPosted,MaxMessagesRetained,ExpireTarget)
-	res, err := db.signerInsertQ.Exec(
+	return db.insertID(db.signerInsertQ,
 		toHex(signerStruct.PublicKey[:]),
 		toHex(signerStruct.Nonce[:]),
 		signerStruct.Bits,
@@ -18,10 +16,6 @@ func (db *MessageDB) InsertSigner(signerStruct *structs.SignerStruct) (int64, er
 		signerStruct.MaxMessagesRetained,
 		signerStruct.ExpireTarget,
 	)
-	if err != nil {
-		return 0, err
-	}
-	return res.LastInsertId()
 }
 
 // UpdateSigner updates the signer
@@ -39,7 +33,7 @@ func (db *MessageDB) UpdateSigner(signerStruct *structs.SignerStruct) error {
 // InsertOrUpdateSigner inserts or updates a signer
 func (db *MessageDB) InsertOrUpdateSigner(signerStruct *structs.SignerStruct) error {
 	var err error
-	if db.driver == "mysql" {
+	if db.signerUpdateInsertQ != nil {
 		// Use single query
 		return updateConvertNilError(db.signerUpdateInsertQ.Exec(
 			toHex(signerStruct.PublicKey[:]),
@@ -57,7 +51,7 @@ func (db *MessageDB) InsertOrUpdateSigner(signerStruct *structs.SignerStruct) er
 	return err
 }
 
-func parseSigner(row *sql.Row) (int64, *structs.SignerStruct, error) {
+func parseSigner(row scanAble) (int64, *structs.SignerStruct, error) {
 	var dbID int64
 	var pubkeyT, nonceT string
 	st := new(structs.SignerStruct)
@@ -122,3 +116,60 @@ func (db *MessageDB) ExpireSigners(maxAge int64) (int64, int64, error) {
 	}
 	return prepared, deleted, nil
 }
+
+// SignerRetained contains the retained messages of a signer as recorded and as counted
+type SignerRetained struct {
+	PublicKey [message.SignerPubKeySize]byte
+	Retained  int64 // MessagesRetained of the signer
+	Messages  int64 // Messages in the database
+}
+
+// SelectSignerRetained returns the signers whose retained messages do not match the
+// messages in the database
+func (db *MessageDB) SelectSignerRetained() ([]SignerRetained, error) {
+	var res []SignerRetained
+	rows, err := db.db.Query(db.queries["SelectSignerRetained"])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pubkeyT string
+		var sr SignerRetained
+		if err := rows.Scan(&pubkeyT, &sr.Retained, &sr.Messages); err != nil {
+			return nil, err
+		}
+		sr.PublicKey = *sliceToSignerPubKey(fromHex(pubkeyT))
+		res = append(res, sr)
+	}
+	return res, rows.Err()
+}
+
+// SetSignerRetained sets the retained messages of the signer
+func (db *MessageDB) SetSignerRetained(pk *[message.SignerPubKeySize]byte, retained int64) error {
+	return updateConvertNilError(db.db.Exec(db.queries["SetSignerRetained"], retained, toHex(pk[:])))
+}
+
+// ListSigners calls fn for each signer in the database
+func (db *MessageDB) ListSigners(fn func(signerStruct *structs.SignerStruct) error) error {
+	rows, err := db.db.Query(db.queries["SelectSignerList"])
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		_, st, err := parseSigner(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(st); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// SetSignerCounters sets the posted and retained messages of the signer
+func (db *MessageDB) SetSignerCounters(pk *[message.SignerPubKeySize]byte, posted, retained uint64) error {
+	return updateConvertNilError(db.db.Exec(db.queries["SetSignerCounters"], posted, retained, toHex(pk[:])))
+}
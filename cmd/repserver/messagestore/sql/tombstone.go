@@ -0,0 +1,152 @@
+package sql
+
+import (
+	"github.com/agl/ed25519"
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+// tombstoneAuthKey returns the AuthType and PublicKey of a tombstone, tombstones are unique per message and key
+func tombstoneAuthKey(ts *structs.TombstoneStruct) string {
+	return toHex(append([]byte{ts.AuthType}, ts.PublicKey[:]...))
+}
+
+// InsertTombstone stores a tombstone that is kept until expireTime. Only authorized tombstones,
+// verified against the message, are listed. Returns an error if a tombstone with the same key
+// exists for the message
+func (db *MessageDB) InsertTombstone(ts *structs.TombstoneStruct, expireTime int64, authorized bool) error {
+	return updateConvertNilError(db.tombstoneInsertQ.Exec(toHex(ts.MessageID[:]), tombstoneAuthKey(ts), boolToInt(authorized), toHex(ts.Encode()), CurrentTime(), expireTime))
+}
+
+// AuthorizeTombstone marks a stored tombstone as authorized. It is moved to the end of the list
+// so that peers that have listed the position already receive it
+func (db *MessageDB) AuthorizeTombstone(ts *structs.TombstoneStruct, expireTime int64) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(db.queries["tombstoneDelete"], toHex(ts.MessageID[:]), tombstoneAuthKey(ts)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(db.queries["tombstoneInsert"], toHex(ts.MessageID[:]), tombstoneAuthKey(ts), 1, toHex(ts.Encode()), CurrentTime(), expireTime); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SelectPendingTombstones returns the tombstones of a message that are not authorized
+func (db *MessageDB) SelectPendingTombstones(mid *[message.MessageIDSize]byte) ([]*structs.TombstoneStruct, error) {
+	var ret []*structs.TombstoneStruct
+	rows, err := db.db.Query(db.queries["tombstoneSelectPending"], toHex(mid[:]))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tombstoneT string
+		if err := rows.Scan(&tombstoneT); err != nil {
+			return nil, err
+		}
+		if ts := structs.TombstoneDecode(fromHex(tombstoneT)); ts != nil {
+			ret = append(ret, ts)
+		}
+	}
+	return ret, rows.Err()
+}
+
+// DeletePendingTombstones deletes the tombstones of a message that are not authorized
+func (db *MessageDB) DeletePendingTombstones(mid *[message.MessageIDSize]byte) error {
+	_, err := db.db.Exec(db.queries["tombstoneDeletePending"], toHex(mid[:]))
+	return err
+}
+
+// SelectTombstone returns an authorized tombstone of a message
+func (db *MessageDB) SelectTombstone(mid *[message.MessageIDSize]byte) (*structs.TombstoneStruct, error) {
+	var tombstoneT string
+	if err := db.tombstoneSelectQ.QueryRow(toHex(mid[:])).Scan(&tombstoneT); err != nil {
+		return nil, err
+	}
+	ts := structs.TombstoneDecode(fromHex(tombstoneT))
+	if ts == nil {
+		return nil, ErrNoModify
+	}
+	return ts, nil
+}
+
+// ListTombstones returns at most count authorized tombstones beginning with position start, and
+// their positions
+func (db *MessageDB) ListTombstones(start uint64, count int64) ([]*structs.TombstoneStruct, []uint64, error) {
+	var ret []*structs.TombstoneStruct
+	var positions []uint64
+	rows, err := db.tombstoneListQ.Query(start, count)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var position uint64
+		var tombstoneT string
+		if err := rows.Scan(&position, &tombstoneT); err != nil {
+			return nil, nil, err
+		}
+		if ts := structs.TombstoneDecode(fromHex(tombstoneT)); ts != nil {
+			ret = append(ret, ts)
+			positions = append(positions, position)
+		}
+	}
+	return ret, positions, rows.Err()
+}
+
+// ListAllTombstones calls fn for each tombstone, authorized or pending, in the order of their position
+func (db *MessageDB) ListAllTombstones(fn func(id uint64, ts *structs.TombstoneStruct, authorized bool, entryTime, expireTime int64) error) error {
+	rows, err := db.db.Query(db.queries["tombstoneListAll"])
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uint64
+		var authorized int
+		var tombstoneT string
+		var entryTime, expireTime int64
+		if err := rows.Scan(&id, &authorized, &tombstoneT, &entryTime, &expireTime); err != nil {
+			return err
+		}
+		ts := structs.TombstoneDecode(fromHex(tombstoneT))
+		if ts == nil {
+			continue
+		}
+		if err := fn(id, ts, intToBool(authorized), entryTime, expireTime); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ImportTombstone stores a tombstone at position id
+func (db *MessageDB) ImportTombstone(id uint64, ts *structs.TombstoneStruct, authorized bool, entryTime, expireTime int64) error {
+	return updateConvertNilError(db.db.Exec(db.queries["tombstoneImport"], id, toHex(ts.MessageID[:]), tombstoneAuthKey(ts), boolToInt(authorized), toHex(ts.Encode()), entryTime, expireTime))
+}
+
+// ResetTombstoneSequence makes new tombstones follow the imported ones, for databases that do
+// not do this themselves
+func (db *MessageDB) ResetTombstoneSequence() error {
+	if db.queries["tombstoneSequence"] == "" {
+		return nil
+	}
+	_, err := db.db.Exec(db.queries["tombstoneSequence"])
+	return err
+}
+
+// ExpireTombstones deletes tombstones that expired before now
+func (db *MessageDB) ExpireTombstones(now int64) error {
+	_, err := db.tombstoneExpireQ.Exec(now)
+	return err
+}
+
+// UpdatePeerTombstone records the position of the last tombstone fetched from the peer
+func (db *MessageDB) UpdatePeerTombstone(pubkey *[ed25519.PublicKeySize]byte, lastTombstone uint64) error {
+	return updateConvertNilError(db.peerUpdateTombstoneQ.Exec(lastTombstone, toHex(pubkey[:])))
+}
@@ -0,0 +1,137 @@
+package sql
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+var testTombstone = &structs.TombstoneStruct{
+	MessageID: *sliceToMessageID([]byte(
+		strconv.Itoa(
+			int(
+				CurrentTime(),
+			),
+		) + "MessageTOMB",
+	)),
+	AuthType: structs.TombstoneSigner,
+	Time:     uint64(CurrentTime()),
+}
+
+func testTombstones(t *testing.T, db *MessageDB) {
+	// ===== TOMBSTONE TESTS =====
+	_, positions, err := db.ListTombstones(0, 10)
+	if err != nil {
+		t.Fatalf("ListTombstones: %s", err)
+	}
+	var last uint64
+	if len(positions) > 0 {
+		last = positions[len(positions)-1]
+	}
+	// Pending tombstones are neither selected nor listed
+	pendingTombstone := *testTombstone
+	pendingTombstone.AuthType = structs.TombstoneRecipient
+	if err := db.InsertTombstone(&pendingTombstone, CurrentTime()+10, false); err != nil {
+		t.Fatalf("InsertTombstone pending: %s", err)
+	}
+	if _, err := db.SelectTombstone(&testTombstone.MessageID); err == nil {
+		t.Error("SelectTombstone returned pending tombstone")
+	}
+	pending, err := db.SelectPendingTombstones(&testTombstone.MessageID)
+	if err != nil {
+		t.Fatalf("SelectPendingTombstones: %s", err)
+	}
+	if len(pending) != 1 || *pending[0] != pendingTombstone {
+		t.Errorf("SelectPendingTombstones bad result: %d", len(pending))
+	}
+	if err := db.InsertTombstone(testTombstone, CurrentTime()+10, true); err != nil {
+		t.Fatalf("InsertTombstone: %s", err)
+	}
+	if err := db.InsertTombstone(testTombstone, CurrentTime()+10, true); err == nil {
+		t.Error("InsertTombstone must fail for duplicates")
+	}
+	ts, err := db.SelectTombstone(&testTombstone.MessageID)
+	if err != nil {
+		t.Fatalf("SelectTombstone: %s", err)
+	}
+	if *ts != *testTombstone {
+		t.Error("SelectTombstone returned wrong tombstone")
+	}
+	list, positions, err := db.ListTombstones(last+1, 10)
+	if err != nil {
+		t.Fatalf("ListTombstones: %s", err)
+	}
+	if len(list) != 1 || len(positions) != 1 || positions[0] <= last || *list[0] != *testTombstone {
+		t.Fatalf("ListTombstones bad result: %d %v", len(list), positions)
+	}
+	next := positions[0]
+	// Authorized tombstones are listed after the tombstones known before
+	if err := db.AuthorizeTombstone(&pendingTombstone, CurrentTime()+10); err != nil {
+		t.Fatalf("AuthorizeTombstone: %s", err)
+	}
+	list, positions, err = db.ListTombstones(next+1, 10)
+	if err != nil {
+		t.Fatalf("ListTombstones: %s", err)
+	}
+	if len(list) != 1 || len(positions) != 1 || positions[0] <= next || *list[0] != pendingTombstone {
+		t.Errorf("ListTombstones authorized bad result: %d %v", len(list), positions)
+	}
+	if pending, _ := db.SelectPendingTombstones(&testTombstone.MessageID); len(pending) != 0 {
+		t.Errorf("Authorized tombstone still pending: %d", len(pending))
+	}
+	pendingTombstone.PublicKey[0] ^= 0xff
+	if err := db.InsertTombstone(&pendingTombstone, CurrentTime()+10, false); err != nil {
+		t.Fatalf("InsertTombstone pending: %s", err)
+	}
+	if err := db.DeletePendingTombstones(&testTombstone.MessageID); err != nil {
+		t.Fatalf("DeletePendingTombstones: %s", err)
+	}
+	if pending, _ := db.SelectPendingTombstones(&testTombstone.MessageID); len(pending) != 0 {
+		t.Errorf("Pending tombstone not deleted: %d", len(pending))
+	}
+	if err := db.ExpireTombstones(CurrentTime() + 20); err != nil {
+		t.Fatalf("ExpireTombstones: %s", err)
+	}
+	if _, err := db.SelectTombstone(&testTombstone.MessageID); err == nil {
+		t.Error("Tombstone not expired")
+	}
+}
+
+func TestTombstonesMysql(t *testing.T) {
+	if !testing.Short() {
+		dir := path.Join(os.TempDir(), "repbinmsg")
+		db, err := newMySQLForTest(dir, 100)
+		if err != nil {
+			t.Fatalf("New Mysql: %s", err)
+		}
+		defer db.Close()
+		testTombstones(t, db)
+	}
+}
+
+func TestTombstonesSQLite(t *testing.T) {
+	dir := path.Join(os.TempDir(), "repbinmsg")
+	dbFile := path.Join(os.TempDir(), "db.test-tombstones")
+	db, err := New("sqlite3", dbFile, dir, 100)
+	if err != nil {
+		t.Fatalf("New sqlite3: %s", err)
+	}
+	defer os.Remove(dbFile)
+	defer db.Close()
+	testTombstones(t, db)
+}
+
+func TestTombstonesPostgres(t *testing.T) {
+	if os.Getenv(postgresTestEnv) == "" {
+		t.Skip("Set " + postgresTestEnv + " to test postgres")
+	}
+	db, err := newPostgresForTest(path.Join(os.TempDir(), "repbinmsg"), 100)
+	if err != nil {
+		t.Fatalf("New Postgres: %s", err)
+	}
+	defer db.Close()
+	testTombstones(t, db)
+}
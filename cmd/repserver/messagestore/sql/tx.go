@@ -0,0 +1,229 @@
+package sql
+
+import (
+	"database/sql"
+
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+// Tx is a transaction on the message database. The prepared statements of the
+// database are bound to the transaction, shard locks taken during the
+// transaction are held until Commit or Rollback.
+type Tx struct {
+	db     *MessageDB
+	tx     *sql.Tx
+	shards [][]byte
+}
+
+// Begin starts a transaction
+func (db *MessageDB) Begin() (*Tx, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{db: db, tx: tx}, nil
+}
+
+// Commit the transaction
+func (tx *Tx) Commit() error {
+	defer tx.unlockShards()
+	return tx.tx.Commit()
+}
+
+// Rollback the transaction
+func (tx *Tx) Rollback() error {
+	defer tx.unlockShards()
+	return tx.tx.Rollback()
+}
+
+// lockShard locks shard s until the end of the transaction
+func (tx *Tx) lockShard(s []byte) {
+	tx.db.LockShard(s)
+	tx.shards = append(tx.shards, s)
+}
+
+func (tx *Tx) unlockShards() {
+	for _, s := range tx.shards {
+		tx.db.UnlockShard(s)
+	}
+	tx.shards = nil
+}
+
+// InsertOrUpdateSigner inserts or updates a signer
+func (tx *Tx) InsertOrUpdateSigner(signerStruct *structs.SignerStruct) error {
+	var err error
+	if tx.db.signerUpdateInsertQ != nil {
+		return updateConvertNilError(tx.tx.Stmt(tx.db.signerUpdateInsertQ).Exec(
+			toHex(signerStruct.PublicKey[:]),
+			toHex(signerStruct.Nonce[:]),
+			signerStruct.Bits,
+			signerStruct.MaxMessagesPosted,
+			signerStruct.MaxMessagesRetained,
+			signerStruct.ExpireTarget,
+		))
+	}
+	err = updateConvertNilError(tx.tx.Stmt(tx.db.signerUpdateQ).Exec(
+		toHex(signerStruct.Nonce[:]),
+		signerStruct.Bits,
+		signerStruct.MaxMessagesPosted,
+		signerStruct.MaxMessagesRetained,
+		signerStruct.ExpireTarget,
+		toHex(signerStruct.PublicKey[:]),
+	))
+	if err == ErrNoModify {
+		_, err = tx.db.insertID(tx.tx.Stmt(tx.db.signerInsertQ),
+			toHex(signerStruct.PublicKey[:]),
+			toHex(signerStruct.Nonce[:]),
+			signerStruct.Bits,
+			signerStruct.MaxMessagesPosted,
+			signerStruct.MaxMessagesRetained,
+			signerStruct.ExpireTarget,
+		)
+	}
+	return err
+}
+
+// AddMessage adds a message to the signer stats. Returns ErrNoModify if the signer
+// has reached its post or retention limit. The check and the update are a single
+// statement, concurrent posts cannot exceed the limits
+func (tx *Tx) AddMessage(pk *[message.SignerPubKeySize]byte) error {
+	return updateConvertNilError(tx.tx.Stmt(tx.db.signerAddMessageQ).Exec(toHex(pk[:])))
+}
+
+// DelMessage deletes a message from the signer stats
+func (tx *Tx) DelMessage(pk *[message.SignerPubKeySize]byte) error {
+	return updateConvertNilError(tx.tx.Stmt(tx.db.signerDelMessageQ).Exec(toHex(pk[:])))
+}
+
+// messageNextCounter returns the next message counter for the receiver. The shard
+// of the receiver stays locked until the transaction ends
+func (tx *Tx) messageNextCounter(receiver *message.Curve25519Key) (uint64, error) {
+	var id sql.NullInt64
+	tx.lockShard(receiver[:])
+	now := CurrentTime()
+	rec := toHex(receiver[:])
+	err := tx.tx.Stmt(tx.db.nextMessageCounterQ).QueryRow(rec).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	if id.Valid {
+		_, err = tx.tx.Stmt(tx.db.incrMessageCounterQ).Exec(now, rec)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(id.Int64) + 1, nil
+	}
+	_, err = tx.tx.Stmt(tx.db.insertMessageCounterQ).Exec(rec, now)
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// InsertMessage inserts a message struct into the database
+func (tx *Tx) InsertMessage(msg *structs.MessageStruct) (uint64, error) {
+	var err error
+	msg.Counter, err = tx.messageNextCounter(&msg.ReceiverConstantPubKey)
+	if err != nil {
+		return 0, err
+	}
+	n, err := tx.db.insertID(tx.tx.Stmt(tx.db.insertMessageQ),
+		msg.Counter,
+		toHex(msg.MessageID[:]),
+		toHex(msg.ReceiverConstantPubKey[:]),
+		toHex(msg.SignerPub[:]),
+		msg.PostTime,
+		msg.ExpireTime,
+		msg.ExpireRequest,
+		msg.Distance,
+		boolToInt(msg.OneTime),
+		boolToInt(msg.Sync),
+		boolToInt(msg.Hidden),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(n), nil
+}
+
+// DeleteMessageByID deletes a message by messageid
+func (tx *Tx) DeleteMessageByID(mid *[message.MessageIDSize]byte) error {
+	return updateConvertNilError(tx.tx.Stmt(tx.db.deleteMessageQ).Exec(toHex(mid[:])))
+}
+
+// DeleteGlobalIndexByID removes a message from the global index. Must be called before the
+// message is deleted
+func (tx *Tx) DeleteGlobalIndexByID(mid *[message.MessageIDSize]byte) error {
+	_, err := tx.tx.Exec(tx.db.queries["globalIndexDeleteMessage"], toHex(mid[:]))
+	return err
+}
+
+// LearnMessage records a message to be known
+func (tx *Tx) LearnMessage(mid *[message.MessageIDSize]byte) error {
+	return updateConvertNilError(tx.tx.Stmt(tx.db.messageExistInsertQ).Exec(toHex(mid[:]), CurrentTime()))
+}
+
+// RememberMessage records a message to be known from now on. The time of a known message
+// is updated
+func (tx *Tx) RememberMessage(mid *[message.MessageIDSize]byte) error {
+	now := CurrentTime()
+	err := updateConvertNilError(tx.tx.Exec(tx.db.queries["messageExistTouch"], now, toHex(mid[:])))
+	if err == ErrNoModify {
+		// MySQL reports unchanged rows as not modified
+		return tx.db.suppressDuplicateError(updateConvertNilError(tx.tx.Stmt(tx.db.messageExistInsertQ).Exec(toHex(mid[:]), now)))
+	}
+	return err
+}
+
+// InsertTombstone stores an authorized tombstone that is kept until expireTime. A tombstone with
+// the same key for the message is replaced
+func (tx *Tx) InsertTombstone(ts *structs.TombstoneStruct, expireTime int64) error {
+	if _, err := tx.tx.Exec(tx.db.queries["tombstoneDelete"], toHex(ts.MessageID[:]), tombstoneAuthKey(ts)); err != nil {
+		return err
+	}
+	return updateConvertNilError(tx.tx.Exec(tx.db.queries["tombstoneInsert"], toHex(ts.MessageID[:]), tombstoneAuthKey(ts), 1, toHex(ts.Encode()), CurrentTime(), expireTime))
+}
+
+// AddToGlobalIndex adds a message to the global index
+func (tx *Tx) AddToGlobalIndex(id uint64) error {
+	return updateConvertNilError(tx.tx.Stmt(tx.db.globalIndexAddQ).Exec(id, CurrentTime()))
+}
+
+// InsertBlobDB inserts a blob into the database
+func (tx *Tx) InsertBlobDB(id uint64, messageID *[message.MessageIDSize]byte, signer *[message.SignerPubKeySize]byte, onetime bool, data []byte) error {
+	_, err := tx.tx.Stmt(tx.db.messageBlobInsertQ).Exec(id, toHex(messageID[:]), toHex(signer[:]), boolToInt(onetime), data)
+	return err
+}
+
+// DeleteBlobDB deletes a message blob by MessageID from the database
+func (tx *Tx) DeleteBlobDB(messageID *[message.MessageIDSize]byte) error {
+	return updateConvertNilError(tx.tx.Stmt(tx.db.messageBlobDeleteQ).Exec(toHex(messageID[:])))
+}
+
+// ImportMessage inserts a message struct with its counter. If index is not 0 the message
+// is added to the global index at position index
+func (tx *Tx) ImportMessage(msg *structs.MessageStruct, index, entryTime uint64) (uint64, error) {
+	n, err := tx.db.insertID(tx.tx.Stmt(tx.db.insertMessageQ),
+		msg.Counter,
+		toHex(msg.MessageID[:]),
+		toHex(msg.ReceiverConstantPubKey[:]),
+		toHex(msg.SignerPub[:]),
+		msg.PostTime,
+		msg.ExpireTime,
+		msg.ExpireRequest,
+		msg.Distance,
+		boolToInt(msg.OneTime),
+		boolToInt(msg.Sync),
+		boolToInt(msg.Hidden),
+	)
+	if err != nil {
+		return 0, err
+	}
+	if index != 0 {
+		if _, err := tx.tx.Exec(tx.db.queries["globalIndexImport"], index, n, entryTime); err != nil {
+			return 0, err
+		}
+	}
+	return uint64(n), nil
+}
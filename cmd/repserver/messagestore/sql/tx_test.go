@@ -0,0 +1,371 @@
+package sql
+
+import (
+	"crypto/rand"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+
+	"github.com/agl/ed25519"
+	"github.com/repbin/repbin/hashcash"
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+func testTxData() (*structs.SignerStruct, *structs.MessageStruct) {
+	now := strconv.Itoa(int(CurrentTime()))
+	signer := &structs.SignerStruct{
+		PublicKey:           *sliceToSignerPubKey([]byte(now + "TxSigner")),
+		Nonce:               [hashcash.NonceSize]byte{0x01, 0x02},
+		Bits:                8,
+		MaxMessagesPosted:   1,
+		MaxMessagesRetained: 5,
+		ExpireTarget:        1000,
+	}
+	msg := &structs.MessageStruct{
+		ReceiverConstantPubKey: *sliceToCurve25519Key([]byte(now + "TxReceiver")),
+		MessageID:              *sliceToMessageID([]byte(now + "TxMessage")),
+		SignerPub:              *sliceToEDPublicKey(signer.PublicKey[:]),
+		PostTime:               uint64(CurrentTime()),
+		ExpireTime:             uint64(CurrentTime() + 1000),
+		Sync:                   true,
+	}
+	return signer, msg
+}
+
+func putTx(t *testing.T, db *MessageDB, signer *structs.SignerStruct, msg *structs.MessageStruct) *Tx {
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %s", err)
+	}
+	if err := tx.InsertOrUpdateSigner(signer); err != nil && err != ErrNoModify {
+		t.Fatalf("InsertOrUpdateSigner: %s", err)
+	}
+	if err := tx.AddMessage(&signer.PublicKey); err != nil {
+		t.Fatalf("AddMessage: %s", err)
+	}
+	id, err := tx.InsertMessage(msg)
+	if err != nil {
+		t.Fatalf("InsertMessage: %s", err)
+	}
+	if err := tx.LearnMessage(&msg.MessageID); err != nil {
+		t.Fatalf("LearnMessage: %s", err)
+	}
+	if err := tx.AddToGlobalIndex(id); err != nil {
+		t.Fatalf("AddToGlobalIndex: %s", err)
+	}
+	if err := tx.InsertBlobDB(id, &msg.MessageID, &signer.PublicKey, false, []byte("data")); err != nil {
+		t.Fatalf("InsertBlobDB: %s", err)
+	}
+	return tx
+}
+
+func listed(t *testing.T, db *MessageDB, messageID *[message.MessageIDSize]byte) (bool, bool) {
+	var inMessages, inBlobs bool
+	err := db.ListMessages(func(msg *ExpireMessage) error {
+		inMessages = inMessages || msg.MessageID == *messageID
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListMessages: %s", err)
+	}
+	err = db.ListBlobsDB(func(mid *[message.MessageIDSize]byte) error {
+		inBlobs = inBlobs || *mid == *messageID
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListBlobsDB: %s", err)
+	}
+	return inMessages, inBlobs
+}
+
+func testTx(t *testing.T, db *MessageDB) {
+	signer, msg := testTxData()
+	// Rollback leaves nothing behind
+	if err := putTx(t, db, signer, msg).Rollback(); err != nil {
+		t.Fatalf("Rollback: %s", err)
+	}
+	if _, _, err := db.SelectSigner(&signer.PublicKey); err == nil {
+		t.Error("Signer written by rolled back transaction")
+	}
+	if db.MessageKnown(&msg.MessageID) {
+		t.Error("Message learned by rolled back transaction")
+	}
+	if inMessages, inBlobs := listed(t, db, &msg.MessageID); inMessages || inBlobs {
+		t.Errorf("Message written by rolled back transaction: %t %t", inMessages, inBlobs)
+	}
+	// Commit
+	if err := putTx(t, db, signer, msg).Commit(); err != nil {
+		t.Fatalf("Commit: %s", err)
+	}
+	_, sig, err := db.SelectSigner(&signer.PublicKey)
+	if err != nil {
+		t.Fatalf("SelectSigner: %s", err)
+	}
+	if sig.MessagesPosted != 1 || sig.MessagesRetained != 1 {
+		t.Errorf("Bad signer stats: %d %d", sig.MessagesPosted, sig.MessagesRetained)
+	}
+	if inMessages, inBlobs := listed(t, db, &msg.MessageID); !inMessages || !inBlobs {
+		t.Errorf("Message not listed: %t %t", inMessages, inBlobs)
+	}
+	// Retained counter
+	if err := db.SetSignerRetained(&signer.PublicKey, 5); err != nil {
+		t.Fatalf("SetSignerRetained: %s", err)
+	}
+	retained, err := db.SelectSignerRetained()
+	if err != nil {
+		t.Fatalf("SelectSignerRetained: %s", err)
+	}
+	found := false
+	for _, sr := range retained {
+		if sr.PublicKey == signer.PublicKey {
+			found = sr.Retained == 5 && sr.Messages == 1
+		}
+	}
+	if !found {
+		t.Error("SelectSignerRetained did not return signer")
+	}
+	if err := db.SetSignerRetained(&signer.PublicKey, 1); err != nil {
+		t.Fatalf("SetSignerRetained: %s", err)
+	}
+	// Post limit
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %s", err)
+	}
+	if err := tx.AddMessage(&signer.PublicKey); err != ErrNoModify {
+		t.Errorf("AddMessage must fail at post limit: %v", err)
+	}
+	tx.Rollback()
+	// Delete
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %s", err)
+	}
+	if err := tx.DeleteGlobalIndexByID(&msg.MessageID); err != nil {
+		t.Fatalf("DeleteGlobalIndexByID: %s", err)
+	}
+	if err := tx.DeleteMessageByID(&msg.MessageID); err != nil {
+		t.Fatalf("DeleteMessageByID: %s", err)
+	}
+	if err := tx.RememberMessage(&msg.MessageID); err != nil {
+		t.Fatalf("RememberMessage: %s", err)
+	}
+	if err := tx.DelMessage(&signer.PublicKey); err != nil {
+		t.Fatalf("DelMessage: %s", err)
+	}
+	if err := tx.DeleteBlobDB(&msg.MessageID); err != nil && err != ErrNoModify {
+		t.Fatalf("DeleteBlobDB: %s", err)
+	}
+	pubKey, privKey, _ := ed25519.GenerateKey(rand.Reader)
+	if err := tx.InsertTombstone(structs.NewExpireTombstone(&msg.MessageID, 10, pubKey, privKey), 1000); err != nil {
+		t.Fatalf("InsertTombstone: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %s", err)
+	}
+	// Expiry record is replaced
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %s", err)
+	}
+	if err := tx.InsertTombstone(structs.NewExpireTombstone(&msg.MessageID, 20, pubKey, privKey), 1000); err != nil {
+		t.Fatalf("InsertTombstone replace: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %s", err)
+	}
+	if ts, err := db.SelectTombstone(&msg.MessageID); err != nil || ts.AuthType != structs.TombstoneExpire || ts.Time != 20 {
+		t.Errorf("Expiry record not stored: %v %v", ts, err)
+	}
+	if err := db.ExpireTombstones(1001); err != nil {
+		t.Fatalf("ExpireTombstones: %s", err)
+	}
+	_, sig, err = db.SelectSigner(&signer.PublicKey)
+	if err != nil {
+		t.Fatalf("SelectSigner: %s", err)
+	}
+	if sig.MessagesPosted != 1 || sig.MessagesRetained != 0 {
+		t.Errorf("Bad signer stats after delete: %d %d", sig.MessagesPosted, sig.MessagesRetained)
+	}
+	if err := db.DelMessage(&signer.PublicKey); err != ErrNoModify {
+		t.Errorf("DelMessage must not go below zero: %v", err)
+	}
+	if inMessages, inBlobs := listed(t, db, &msg.MessageID); inMessages || inBlobs {
+		t.Errorf("Deleted message listed: %t %t", inMessages, inBlobs)
+	}
+	if err := db.DeleteMessageByID(&msg.MessageID); err != ErrNoModify {
+		t.Errorf("Second delete must not modify: %v", err)
+	}
+	if !db.MessageKnown(&msg.MessageID) {
+		t.Error("Deleted message not remembered")
+	}
+	if n, err := db.GlobalIndexOrphans(); err != nil || n != 0 {
+		t.Errorf("GlobalIndexOrphans: %d %v", n, err)
+	}
+}
+
+func testImport(t *testing.T, db *MessageDB) {
+	signer, msg := testTxData()
+	msg.Counter = 7
+	msg.MessageID[0] ^= 0xff
+	msg.ReceiverConstantPubKey[0] ^= 0xff
+	signer.PublicKey[0] ^= 0xff
+	signer.MessagesPosted, signer.MessagesRetained = 3, 2
+	if _, err := db.InsertSigner(signer); err != nil {
+		t.Fatalf("InsertSigner: %s", err)
+	}
+	if err := db.SetSignerCounters(&signer.PublicKey, signer.MessagesPosted, signer.MessagesRetained); err != nil {
+		t.Fatalf("SetSignerCounters: %s", err)
+	}
+	found := false
+	err := db.ListSigners(func(sig *structs.SignerStruct) error {
+		if sig.PublicKey == signer.PublicKey {
+			found = sig.MessagesPosted == 3 && sig.MessagesRetained == 2 && sig.MaxMessagesRetained == signer.MaxMessagesRetained
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListSigners: %s", err)
+	}
+	if !found {
+		t.Error("ListSigners did not return signer with counters")
+	}
+	if err := db.ImportMessageCounter(&msg.ReceiverConstantPubKey, 7, 100); err != nil {
+		t.Fatalf("ImportMessageCounter: %s", err)
+	}
+	found = false
+	err = db.ListMessageCounters(func(receiver *message.Curve25519Key, counter uint64, lastTime int64) error {
+		if *receiver == msg.ReceiverConstantPubKey {
+			found = counter == 7 && lastTime == 100
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListMessageCounters: %s", err)
+	}
+	if !found {
+		t.Error("ListMessageCounters did not return counter")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %s", err)
+	}
+	const index = 1 << 40
+	if _, err := tx.ImportMessage(msg, index, 200); err != nil {
+		t.Fatalf("ImportMessage: %s", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %s", err)
+	}
+	if err := db.ResetGlobalIndexSequence(); err != nil {
+		t.Fatalf("ResetGlobalIndexSequence: %s", err)
+	}
+	found = false
+	err = db.ListIndexedMessages(func(m *structs.MessageStruct, i, entryTime uint64) error {
+		if m.MessageID == msg.MessageID {
+			found = m.Counter == 7 && i == index && entryTime == 200
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListIndexedMessages: %s", err)
+	}
+	if !found {
+		t.Error("ListIndexedMessages did not return message with index")
+	}
+	if err := db.ImportKnownMessage(&msg.MessageID, 300); err != nil {
+		t.Fatalf("ImportKnownMessage: %s", err)
+	}
+	if err := db.ImportKnownMessage(&msg.MessageID, 300); err != nil {
+		t.Errorf("ImportKnownMessage must ignore known messages: %s", err)
+	}
+	found = false
+	err = db.ListKnownMessages(func(mid *[message.MessageIDSize]byte, entryTime int64) error {
+		found = found || (*mid == msg.MessageID && entryTime == 300)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListKnownMessages: %s", err)
+	}
+	if !found {
+		t.Error("ListKnownMessages did not return message")
+	}
+	ts := &structs.TombstoneStruct{MessageID: msg.MessageID, AuthType: structs.TombstoneSigner, Time: 400}
+	const tombstoneID = 1 << 40
+	if err := db.ImportTombstone(tombstoneID, ts, false, 400, 500); err != nil {
+		t.Fatalf("ImportTombstone: %s", err)
+	}
+	if err := db.ResetTombstoneSequence(); err != nil {
+		t.Fatalf("ResetTombstoneSequence: %s", err)
+	}
+	found = false
+	err = db.ListAllTombstones(func(id uint64, lts *structs.TombstoneStruct, authorized bool, entryTime, expireTime int64) error {
+		if lts.MessageID == msg.MessageID {
+			found = id == tombstoneID && *lts == *ts && !authorized && entryTime == 400 && expireTime == 500
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListAllTombstones: %s", err)
+	}
+	if !found {
+		t.Error("ListAllTombstones did not return pending tombstone with position")
+	}
+	ts.PublicKey[0] ^= 0xff
+	if err := db.InsertTombstone(ts, 500, true); err != nil {
+		t.Fatalf("InsertTombstone after import: %s", err)
+	}
+	if _, positions, err := db.ListTombstones(tombstoneID, 10); err != nil || len(positions) != 1 || positions[0] <= tombstoneID {
+		t.Errorf("Tombstone not inserted after imported ones: %v %v", positions, err)
+	}
+	if err := db.ExpireTombstones(600); err != nil {
+		t.Fatalf("ExpireTombstones: %s", err)
+	}
+	if err := db.DeleteMessageByID(&msg.MessageID); err != nil {
+		t.Fatalf("DeleteMessageByID: %s", err)
+	}
+	if _, err := db.DeleteGlobalIndexOrphans(); err != nil {
+		t.Fatalf("DeleteGlobalIndexOrphans: %s", err)
+	}
+}
+
+func TestTxMysql(t *testing.T) {
+	if !testing.Short() {
+		dir := path.Join(os.TempDir(), "repbinmsg")
+		db, err := newMySQLForTest(dir, 100)
+		if err != nil {
+			t.Fatalf("New Mysql: %s", err)
+		}
+		defer db.Close()
+		testTx(t, db)
+		testImport(t, db)
+	}
+}
+
+func TestTxSQLite(t *testing.T) {
+	dir := path.Join(os.TempDir(), "repbinmsg")
+	dbFile := path.Join(os.TempDir(), "db.test-tx")
+	db, err := New("sqlite3", dbFile, dir, 100)
+	if err != nil {
+		t.Fatalf("New sqlite3: %s", err)
+	}
+	defer os.Remove(dbFile)
+	defer db.Close()
+	testTx(t, db)
+	testImport(t, db)
+}
+
+func TestTxPostgres(t *testing.T) {
+	if os.Getenv(postgresTestEnv) == "" {
+		t.Skip("Set " + postgresTestEnv + " to test postgres")
+	}
+	db, err := newPostgresForTest(path.Join(os.TempDir(), "repbinmsg"), 100)
+	if err != nil {
+		t.Fatalf("New Postgres: %s", err)
+	}
+	defer db.Close()
+	testTx(t, db)
+	testImport(t, db)
+}
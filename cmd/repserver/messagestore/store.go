@@ -5,6 +5,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/agl/ed25519"
 	"github.com/repbin/repbin/cmd/repserver/messagestore/sql"
 )
 
@@ -41,7 +42,12 @@ var CurrentTime = func() int64 { return time.Now().UTC().Unix() }
 
 // Store implements a message store
 type Store struct {
-	db *sql.MessageDB
+	db          *sql.MessageDB
+	dir         string
+	blobs       BlobStore
+	watch       *watchers
+	peeringPub  *[ed25519.PublicKeySize]byte  // Signs expiry records
+	peeringPriv *[ed25519.PrivateKeySize]byte // Signs expiry records
 }
 
 // New Create a new message store at directory dir. Workers is the maximum concurrent access to an index
@@ -52,5 +58,12 @@ func New(driver, url, dir string, workers int) *Store {
 	if err != nil {
 		panic(err)
 	}
+	s.dir = dir
+	s.watch = newWatchers()
+	if dir == "" {
+		s.blobs = dbBlobStore{db: s.db}
+	} else {
+		s.blobs = fsBlobStore{db: s.db}
+	}
 	return s
 }
@@ -0,0 +1,148 @@
+package messagestore
+
+import (
+	"errors"
+
+	"github.com/agl/ed25519"
+	"github.com/repbin/repbin/cmd/repserver/messagestore/sql"
+	"github.com/repbin/repbin/cmd/repserver/stat"
+	log "github.com/repbin/repbin/deferconsole"
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+var (
+	// ErrTombstone is returned if a tombstone does not verify or is not authorized for the message
+	ErrTombstone = errors.New("messagestore: Tombstone verification failed")
+	// ErrDeleted is returned if a message was deleted by a tombstone
+	ErrDeleted = errors.New("messagestore: Message deleted")
+)
+
+// TombstoneMaxAge is the minimum time in seconds tombstones are kept. It should not be
+// shorter than the time messages are stored by any server.
+var TombstoneMaxAge = int64(2592000)
+
+// AddTombstone verifies and stores a tombstone and deletes the message. If requireMessage is
+// true the message must be stored locally. Otherwise the tombstone of an unknown message is kept
+// to refuse the message if it arrives later. Such pending tombstones are not listed until the
+// message proves them authorized, tombstones of other keys do not block them.
+func (store Store) AddTombstone(ts *structs.TombstoneStruct, requireMessage bool) error {
+	if ts.AuthType == structs.TombstoneExpire || !ts.Verify() {
+		return ErrTombstone
+	}
+	expireTime := CurrentTime() + TombstoneMaxAge
+	_, msg, err := store.db.SelectMessageByID(&ts.MessageID)
+	if err == nil {
+		if !ts.Authorizes(msg) {
+			return ErrTombstone
+		}
+		if int64(msg.ExpireTime) > expireTime {
+			expireTime = int64(msg.ExpireTime)
+		}
+	} else if requireMessage {
+		return ErrNotFound
+	} else {
+		msg = nil
+	}
+	if _, err := store.db.SelectTombstone(&ts.MessageID); err == nil {
+		return ErrDuplicate
+	}
+	if msg != nil {
+		// Pending tombstones that do not authorize the message are forged
+		if err := store.db.DeletePendingTombstones(&ts.MessageID); err != nil {
+			return err
+		}
+	} else {
+		pending, err := store.db.SelectPendingTombstones(&ts.MessageID)
+		if err != nil {
+			return err
+		}
+		for _, p := range pending {
+			if p.AuthType == ts.AuthType && p.PublicKey == ts.PublicKey {
+				return ErrDuplicate
+			}
+		}
+	}
+	if err := store.db.InsertTombstone(ts, expireTime, msg != nil); err != nil {
+		return err
+	}
+	stat.Tombstones.Inc()
+	if msg != nil {
+		if err := store.deleteMessage(&msg.MessageID, &msg.SignerPub); err != nil && err != ErrNotFound {
+			log.Errorf("AddTombstone, deleteMessage: %s %s\n", err, utils.B58encode(msg.MessageID[:]))
+		}
+	}
+	return nil
+}
+
+// AddExpiry expires the local copy of a message that a peer has expired. ts is the expiry
+// record listed by the peer, it must be signed by peerKey. The copy is only expired if it was
+// not requested to be kept longer than the peer kept it, a new expiry record of this server is
+// stored. Returns ErrNotFound if the message is not stored.
+func (store Store) AddExpiry(ts *structs.TombstoneStruct, peerKey *[ed25519.PublicKeySize]byte) error {
+	if ts.AuthType != structs.TombstoneExpire || ts.PublicKey != *peerKey || !ts.Verify() {
+		return ErrTombstone
+	}
+	_, msg, err := store.db.SelectMessageByID(&ts.MessageID)
+	if err != nil {
+		return ErrNotFound
+	}
+	if !ts.Authorizes(msg) {
+		return ErrTombstone
+	}
+	n, err := store.expireBatchTx([]sql.ExpireMessage{{MessageID: msg.MessageID, SignerPub: msg.SignerPub}})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// deleted returns true if a stored tombstone deletes msg. A pending tombstone that authorizes
+// msg is marked authorized, pending tombstones that do not are removed.
+func (store Store) deleted(msg *structs.MessageStruct) bool {
+	if ts, err := store.db.SelectTombstone(&msg.MessageID); err == nil && ts.Authorizes(msg) {
+		return true
+	}
+	pending, err := store.db.SelectPendingTombstones(&msg.MessageID)
+	if err != nil || len(pending) == 0 {
+		return false
+	}
+	for _, ts := range pending {
+		if ts.Authorizes(msg) {
+			if err := store.db.AuthorizeTombstone(ts, CurrentTime()+TombstoneMaxAge); err != nil {
+				log.Errorf("deleted, AuthorizeTombstone: %s %s\n", err, utils.B58encode(msg.MessageID[:]))
+			}
+			return true
+		}
+	}
+	if err := store.db.DeletePendingTombstones(&msg.MessageID); err != nil {
+		log.Errorf("deleted, DeletePendingTombstones: %s %s\n", err, utils.B58encode(msg.MessageID[:]))
+	}
+	return false
+}
+
+// GetTombstone returns an authorized tombstone of a message
+func (store Store) GetTombstone(messageID *[message.MessageIDSize]byte) (*structs.TombstoneStruct, error) {
+	ts, err := store.db.SelectTombstone(messageID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return ts, nil
+}
+
+// ListTombstones returns at most count authorized tombstones beginning at position start, their
+// positions and the start of the next call
+func (store Store) ListTombstones(start uint64, count int64) ([]*structs.TombstoneStruct, []uint64, uint64, error) {
+	tombstones, positions, err := store.db.ListTombstones(start, count)
+	if err != nil {
+		return nil, nil, start, err
+	}
+	if len(positions) == 0 {
+		return nil, nil, start, nil
+	}
+	return tombstones, positions, positions[len(positions)-1] + 1, nil
+}
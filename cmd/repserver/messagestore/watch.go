@@ -0,0 +1,73 @@
+package messagestore
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/repbin/repbin/message"
+)
+
+// ErrWatchBusy is returned if the maximum number of watchers is reached
+var ErrWatchBusy = errors.New("messagestore: Too many watchers, retry later")
+
+// MaxWatchKeys is the maximum number of keys watched at the same time
+var MaxWatchKeys = 10000
+
+// MaxWatchers is the maximum number of requests waiting at the same time
+var MaxWatchers = 20000
+
+// watchers wakes up requests waiting for new messages to a key.
+type watchers struct {
+	mutex sync.Mutex
+	keys  map[message.Curve25519Key]*watchEntry
+	total int // number of waiting requests over all keys
+}
+
+// watchEntry is shared by all requests waiting for the same key.
+type watchEntry struct {
+	wake  chan struct{} // closed when a message arrives
+	count int           // number of waiting requests
+}
+
+func newWatchers() *watchers {
+	return &watchers{keys: make(map[message.Curve25519Key]*watchEntry)}
+}
+
+// Watch returns a channel that is closed when the next message for key is stored.
+// Release must be called when the caller stops waiting. ErrWatchBusy is returned if
+// MaxWatchers requests or MaxWatchKeys keys are watched already.
+func (store Store) Watch(key *message.Curve25519Key) (wake <-chan struct{}, release func(), err error) {
+	w, k := store.watch, *key
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	entry, ok := w.keys[k]
+	if w.total >= MaxWatchers || (!ok && len(w.keys) >= MaxWatchKeys) {
+		return nil, nil, ErrWatchBusy
+	}
+	if !ok {
+		entry = &watchEntry{wake: make(chan struct{})}
+		w.keys[k] = entry
+	}
+	entry.count++
+	w.total++
+	release = func() {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		entry.count--
+		w.total--
+		if entry.count == 0 && w.keys[k] == entry {
+			delete(w.keys, k)
+		}
+	}
+	return entry.wake, release, nil
+}
+
+// notify wakes up all requests waiting for key.
+func (w *watchers) notify(key *message.Curve25519Key) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if entry, ok := w.keys[*key]; ok {
+		close(entry.wake)
+		delete(w.keys, *key)
+	}
+}
@@ -0,0 +1,78 @@
+package messagestore
+
+import (
+	"testing"
+
+	"github.com/repbin/repbin/message"
+)
+
+func TestWatch(t *testing.T) {
+	store := Store{watch: newWatchers()}
+	key1, key2 := &message.Curve25519Key{0x01}, &message.Curve25519Key{0x02}
+	wake1, release1, _ := store.Watch(key1)
+	wake2, release2, _ := store.Watch(key2)
+	store.watch.notify(key1)
+	select {
+	case <-wake1:
+	default:
+		t.Error("Watcher not woken up")
+	}
+	select {
+	case <-wake2:
+		t.Error("Watcher of other key woken up")
+	default:
+	}
+	release1()
+	release2()
+	if len(store.watch.keys) != 0 {
+		t.Errorf("Watchers not released: %d", len(store.watch.keys))
+	}
+	// Release of old watch must not remove the new one
+	_, release1, _ = store.Watch(key1)
+	store.watch.notify(key1)
+	wake1, release3, _ := store.Watch(key1)
+	release1()
+	store.watch.notify(key1)
+	select {
+	case <-wake1:
+	default:
+		t.Error("Watcher lost")
+	}
+	release3()
+	if store.watch.total != 0 {
+		t.Errorf("Waiting requests not released: %d", store.watch.total)
+	}
+}
+
+func TestWatchLimits(t *testing.T) {
+	defer func(keys, watchers int) { MaxWatchKeys, MaxWatchers = keys, watchers }(MaxWatchKeys, MaxWatchers)
+	MaxWatchKeys, MaxWatchers = 2, 3
+	store := Store{watch: newWatchers()}
+	key1, key2, key3 := &message.Curve25519Key{0x01}, &message.Curve25519Key{0x02}, &message.Curve25519Key{0x03}
+	_, release1, err := store.Watch(key1)
+	if err != nil {
+		t.Fatalf("Watch: %s", err)
+	}
+	_, release2, err := store.Watch(key2)
+	if err != nil {
+		t.Fatalf("Watch: %s", err)
+	}
+	if _, _, err := store.Watch(key3); err != ErrWatchBusy {
+		t.Errorf("Key limit not enforced: %v", err)
+	}
+	_, release3, err := store.Watch(key1)
+	if err != nil {
+		t.Fatalf("Watch of watched key: %s", err)
+	}
+	if _, _, err := store.Watch(key1); err != ErrWatchBusy {
+		t.Errorf("Watcher limit not enforced: %v", err)
+	}
+	release1()
+	release2()
+	release3()
+	if _, release, err := store.Watch(key3); err != nil {
+		t.Errorf("Watch after release: %v", err)
+	} else {
+		release()
+	}
+}
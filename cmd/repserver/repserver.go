@@ -20,6 +20,7 @@ import (
 	"github.com/repbin/repbin/utils/repproto/structs"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -27,8 +28,15 @@ import (
 const Version = "0.0.2 very alpha"
 
 var (
-	start *bool
-	stat  *bool
+	start        *bool
+	stat         *bool
+	migrate      *bool
+	schemaStatus *bool
+	bootstrap    *string
+	scrub        *bool
+	repair       *bool
+	export       *string
+	importFile   *string
 )
 
 func init() {
@@ -38,6 +46,13 @@ func init() {
 	verbose := flag.Bool("verbose", false, "Show some verbose output")
 	start = flag.Bool("start", false, "Start server")
 	stat = flag.Bool("stat", false, "Enable usage statistics")
+	migrate = flag.Bool("migrate", false, "Apply pending database schema migrations")
+	schemaStatus = flag.Bool("schema-status", false, "Show database schema migrations")
+	bootstrap = flag.String("bootstrap-from", "", "Import the snapshot of the peer with this URL")
+	scrub = flag.Bool("scrub", false, "Verify the integrity of the message store")
+	repair = flag.Bool("repair", false, "Repair problems found by --scrub")
+	export = flag.String("export", "", "Export the message store to this file")
+	importFile = flag.String("import", "", "Import the message store from this file")
 	flag.Parse()
 	if *version {
 		fmt.Printf("Repserver: %s\n", Version)
@@ -69,6 +84,14 @@ func init() {
 		fmt.Println("Error: option --stat requires option --verbose")
 		os.Exit(1)
 	}
+	if *repair && !*scrub {
+		fmt.Println("Error: option --repair requires option --scrub")
+		os.Exit(1)
+	}
+	if *export != "" && *importFile != "" {
+		fmt.Println("Error: options --export and --import are exclusive")
+		os.Exit(1)
+	}
 	err := loadConfig(*configfile)
 	if err != nil {
 		fmt.Printf("Error: %s\n", err)
@@ -92,14 +115,88 @@ func main() {
 
 	runtime.GOMAXPROCS(runtime.NumCPU() - 1)
 
+	if *schemaStatus {
+		if err := showSchemaStatus(); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if *migrate {
+		if err := migrateSchema(); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if err := checkSchema(); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+
 	ms, err := handlers.New(defaultSettings.DBDriver, defaultSettings.DBURL, defaultSettings.StoragePath, pubKey, privKey)
 	if err != nil {
 		fmt.Printf("Error: %s\n", err)
 		os.Exit(1)
 	}
-	applyConfig(ms)
+	if err := applyConfig(ms); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
 	ms.Stat = *stat
+	if *scrub {
+		report, err := ms.DB.Scrub(ms.MinHashCashBits, *repair)
+		log.Sync()
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Scrub: %s\n", report)
+		if report.Problems() > report.Repaired {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if *export != "" {
+		err := exportStore(ms, *export)
+		log.Sync()
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if *importFile != "" {
+		err := importStore(ms, *importFile)
+		log.Sync()
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if *bootstrap != "" {
+		err := ms.Bootstrap(*bootstrap)
+		log.Sync()
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		if !*start {
+			os.Exit(0)
+		}
+	}
 	if *start {
+		// Leftovers of an unclean shutdown are only reported, --scrub --repair removes them
+		blobs, messages, err := ms.DB.CheckBlobs()
+		if blobs > 0 || messages > 0 {
+			log.Errorf("Found %d orphaned blobs and %d messages without blob. Remove them with --scrub --repair\n", blobs, messages)
+		}
+		if err != nil {
+			log.Sync()
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
 		ms.RunServer()
 	} else {
 		fmt.Println("Server not started. Enable with --start")
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/repbin/repbin/cmd/repserver/messagestore/sql"
+)
+
+// showSchemaStatus prints the schema version of the database and all known migrations.
+func showSchemaStatus() error {
+	current, status, err := sql.SchemaStatus(defaultSettings.DBDriver, defaultSettings.DBURL)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schema version: %d (release: %d)\n", current, sql.LatestSchema())
+	for _, s := range status {
+		applied := "pending"
+		if s.Applied {
+			applied = time.Unix(s.AppliedAt, 0).UTC().Format(time.RFC3339)
+		}
+		fmt.Printf("%4d  %-20s  %s\n", s.Version, applied, s.Description)
+	}
+	return nil
+}
+
+// migrateSchema applies pending migrations.
+func migrateSchema() error {
+	applied, err := sql.Migrate(defaultSettings.DBDriver, defaultSettings.DBURL)
+	for _, version := range applied {
+		fmt.Printf("Applied migration %d\n", version)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Println("Schema is up to date")
+	}
+	return nil
+}
+
+// checkSchema verifies that the server can run with the database schema.
+func checkSchema() error {
+	sql.AutoMigrate = defaultSettings.AutoMigrate
+	current, _, err := sql.SchemaStatus(defaultSettings.DBDriver, defaultSettings.DBURL)
+	if err != nil {
+		return err
+	}
+	if current > sql.LatestSchema() {
+		return sql.ErrSchemaNewer
+	}
+	if current != 0 && current < sql.LatestSchema() && !sql.AutoMigrate {
+		return fmt.Errorf("%s. Run repserver with --migrate", sql.ErrSchemaOutdated)
+	}
+	return nil
+}
@@ -0,0 +1,340 @@
+package stat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DurationBuckets are the default histogram buckets for durations, in seconds.
+var DurationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 60}
+
+// metric is a value that can write itself in Prometheus text format.
+type metric interface {
+	write(w io.Writer, name, labels string)
+}
+
+type family struct {
+	name string
+	help string
+	kind string
+	m    metric
+}
+
+// Registry contains metrics and writes them in Prometheus text format.
+type Registry struct {
+	mutex    sync.Mutex
+	families []*family
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return new(Registry)
+}
+
+// Default is the registry of the repserver metrics.
+var Default = NewRegistry()
+
+func (r *Registry) register(name, help, kind string, m metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, f := range r.families {
+		if f.name == name {
+			panic("stat: Metric registered twice: " + name)
+		}
+	}
+	r.families = append(r.families, &family{name: name, help: help, kind: kind, m: m})
+}
+
+// NewCounter registers a counter.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := new(Counter)
+	r.register(name, help, "counter", c)
+	return c
+}
+
+// NewCounterVec registers a counter with labels.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(labels, func() metric { return new(Counter) })}
+	r.register(name, help, "counter", c.v)
+	return c
+}
+
+// NewGauge registers a gauge.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := new(Gauge)
+	r.register(name, help, "gauge", g)
+	return g
+}
+
+// NewGaugeVec registers a gauge with labels.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(labels, func() metric { return new(Gauge) })}
+	r.register(name, help, "gauge", g.v)
+	return g
+}
+
+// NewHistogram registers a histogram with the given upper bounds of buckets.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := newHistogram(buckets)
+	r.register(name, help, "histogram", h)
+	return h
+}
+
+// NewHistogramVec registers a histogram with labels.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{newVec(labels, func() metric { return newHistogram(buckets) })}
+	r.register(name, help, "histogram", h.v)
+	return h
+}
+
+// WritePrometheus writes all metrics in Prometheus text format.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mutex.Lock()
+	families := make([]*family, len(r.families))
+	copy(families, r.families)
+	r.mutex.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.kind)
+		f.m.write(bw, f.name, "")
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics in Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WritePrometheus(w)
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	v uint64
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.v, 1)
+}
+
+// Add adds n to the counter.
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.v, n)
+}
+
+// Value returns the current value.
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.v)
+}
+
+func (c *Counter) write(w io.Writer, name, labels string) {
+	fmt.Fprintf(w, "%s%s %d\n", name, braces(labels), c.Value())
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	bits uint64
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+// Add adds v to the gauge.
+func (g *Gauge) Add(v float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		if atomic.CompareAndSwapUint64(&g.bits, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Value returns the current value.
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+func (g *Gauge) write(w io.Writer, name, labels string) {
+	fmt.Fprintf(w, "%s%s %s\n", name, braces(labels), formatFloat(g.Value()))
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	mutex   sync.Mutex
+	buckets []float64
+	counts  []uint64 // per bucket, not cumulative
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	b := make([]float64, len(buckets))
+	copy(b, buckets)
+	sort.Float64s(b)
+	return &Histogram{buckets: b, counts: make([]uint64, len(b))}
+}
+
+// Observe adds an observation.
+func (h *Histogram) Observe(v float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+// Since observes the seconds passed since start. Use as: defer h.Since(time.Now())
+func (h *Histogram) Since(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) write(w io.Writer, name, labels string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	var cumulative uint64
+	for i, le := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s} %d\n", name, joinLabels(labels, "le=\""+formatFloat(le)+"\""), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s} %d\n", name, joinLabels(labels, "le=\"+Inf\""), h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, braces(labels), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, braces(labels), h.count)
+}
+
+// vec contains one metric per combination of label values.
+type vec struct {
+	mutex    sync.Mutex
+	labels   []string
+	newChild func() metric
+	children map[string]metric // by formatted labels
+}
+
+func newVec(labels []string, newChild func() metric) *vec {
+	return &vec{labels: labels, newChild: newChild, children: make(map[string]metric)}
+}
+
+func (v *vec) with(values []string) metric {
+	if len(values) != len(v.labels) {
+		panic("stat: Wrong number of label values")
+	}
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = v.labels[i] + "=\"" + escapeLabel(value) + "\""
+	}
+	key := strings.Join(pairs, ",")
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	child, ok := v.children[key]
+	if !ok {
+		child = v.newChild()
+		v.children[key] = child
+	}
+	return child
+}
+
+func (v *vec) delete(values []string) {
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = v.labels[i] + "=\"" + escapeLabel(value) + "\""
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	delete(v.children, strings.Join(pairs, ","))
+}
+
+func (v *vec) write(w io.Writer, name, labels string) {
+	v.mutex.Lock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	children := make(map[string]metric, len(v.children))
+	for key, child := range v.children {
+		children[key] = child
+	}
+	v.mutex.Unlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		children[key].write(w, name, joinLabels(labels, key))
+	}
+}
+
+// CounterVec is a counter with labels.
+type CounterVec struct {
+	v *vec
+}
+
+// With returns the counter for the label values.
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.v.with(values).(*Counter)
+}
+
+// GaugeVec is a gauge with labels.
+type GaugeVec struct {
+	v *vec
+}
+
+// With returns the gauge for the label values.
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.v.with(values).(*Gauge)
+}
+
+// Delete removes the gauge for the label values.
+func (g *GaugeVec) Delete(values ...string) {
+	g.v.delete(values)
+}
+
+// HistogramVec is a histogram with labels.
+type HistogramVec struct {
+	v *vec
+}
+
+// With returns the histogram for the label values.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.v.with(values).(*Histogram)
+}
+
+func escapeLabel(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	return strings.Replace(s, "\n", "\\n", -1)
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
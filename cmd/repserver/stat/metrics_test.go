@@ -0,0 +1,61 @@
+package stat
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Counter.")
+	cv := r.NewCounterVec("test_reason_total", "Counter with labels.", "reason")
+	g := r.NewGaugeVec("test_gauge", "Gauge.", "peer")
+	h := r.NewHistogram("test_seconds", "Histogram.", []float64{1, 0.1})
+	c.Add(2)
+	cv.With("hashcash").Inc()
+	cv.With("bad\"param").Inc()
+	g.With("http://a/").Set(1.5)
+	g.With("http://b/").Set(3)
+	g.Delete("http://b/")
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(7)
+	var buf bytes.Buffer
+	if err := r.WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus: %s", err)
+	}
+	expect := `# HELP test_total Counter.
+# TYPE test_total counter
+test_total 2
+# HELP test_reason_total Counter with labels.
+# TYPE test_reason_total counter
+test_reason_total{reason="bad\"param"} 1
+test_reason_total{reason="hashcash"} 1
+# HELP test_gauge Gauge.
+# TYPE test_gauge gauge
+test_gauge{peer="http://a/"} 1.5
+# HELP test_seconds Histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 7.55
+test_seconds_count 3
+`
+	if buf.String() != expect {
+		t.Errorf("Bad output:\n%s", buf.String())
+	}
+}
+
+func TestHistory(t *testing.T) {
+	var h history
+	for i := 0; i < oneDay+10; i++ {
+		h = h.add(uint64(i * 2))
+	}
+	if len(h) != oneDay+1 {
+		t.Errorf("History not limited: %d", len(h))
+	}
+	if h.perMinute(fiveMin) != 2 {
+		t.Errorf("Bad average: %f", h.perMinute(fiveMin))
+	}
+}
@@ -1,117 +1,127 @@
-// Package stat gathers and prints repbin server usage statistics.
-// Post and fetch statistics are shown as minute-by-minute averages over the
-// last minute, 5 minutes, 60 minutes and 24 hours.
+// Package stat gathers repbin server usage statistics.
+// The metrics are served in Prometheus text format by the Default registry.
+// Post and fetch statistics are also shown on the console as minute-by-minute
+// averages over the last minute, 5 minutes, 60 minutes and 24 hours.
 package stat
 
 import (
-	"container/list"
 	"time"
 
 	log "github.com/repbin/repbin/deferconsole"
 )
 
-// Event describes the stat event send to the input channel.
-type Event int
-
-const (
-	// Post adds a new post to the statistic.
-	Post = iota
-	// Fetch adds a new fetch to the statistic.
-	Fetch
-	// Show prints the statistics.
-	Show
+// Metrics of the repserver.
+var (
+	// PostsAccepted counts posts stored.
+	PostsAccepted = Default.NewCounter("repbin_posts_accepted_total", "Posts accepted.")
+	// PostsRejected counts posts rejected, by error code.
+	PostsRejected = Default.NewCounterVec("repbin_posts_rejected_total", "Posts rejected, by reason.", "reason")
+	// Fetches counts message fetches, by result ("ok" or error code).
+	Fetches = Default.NewCounterVec("repbin_fetches_total", "Message fetches, by result.", "result")
+	// IndexRequests counts index calls, by index ("key", "global" or "watch").
+	IndexRequests = Default.NewCounterVec("repbin_index_requests_total", "Index requests, by index.", "index")
+	// OneTimeBurns counts one-time messages deleted on fetch.
+	OneTimeBurns = Default.NewCounter("repbin_onetime_burns_total", "One-time messages deleted on fetch.")
+	// ExpireRuns counts expire runs.
+	ExpireRuns = Default.NewCounter("repbin_expire_runs_total", "Expire runs.")
+	// ExpireDuration is the duration of expire runs.
+	ExpireDuration = Default.NewHistogram("repbin_expire_duration_seconds", "Duration of expire runs.", DurationBuckets)
+	// ExpiredMessages counts messages deleted by expire runs.
+	ExpiredMessages = Default.NewCounter("repbin_expired_messages_total", "Messages deleted by expire runs.")
+	// PeerLastPosition is the position in the global index of a peer up to which messages were fetched.
+	PeerLastPosition = Default.NewGaugeVec("repbin_peer_last_position", "Position in the global index of the peer up to which messages were fetched.", "peer")
+	// PeerErrors is the error count of a peer.
+	PeerErrors = Default.NewGaugeVec("repbin_peer_errors", "Error count of the peer.", "peer")
+	// PeerLastFetch is the time of the last successful fetch from a peer.
+	PeerLastFetch = Default.NewGaugeVec("repbin_peer_last_fetch_timestamp_seconds", "Time of the last successful fetch from the peer.", "peer")
+	// PeerHealth is 1 for the health state of a peer ("healthy", "backoff" or "quarantined").
+	PeerHealth = Default.NewGaugeVec("repbin_peer_health", "Health state of the peer.", "peer", "state")
+	// PeerFailures is the number of consecutive failures to reach a peer.
+	PeerFailures = Default.NewGaugeVec("repbin_peer_failures", "Consecutive failures to reach the peer.", "peer")
+	// PeerQuarantines counts peers put into quarantine.
+	PeerQuarantines = Default.NewCounter("repbin_peer_quarantines_total", "Peers put into quarantine.")
+	// PeerProbes counts probes of quarantined peers, by result ("ok" or "failed").
+	PeerProbes = Default.NewCounterVec("repbin_peer_probes_total", "Probes of quarantined peers, by result.", "result")
+	// PeerRole is 1 for the role of a peer ("hub", "leaf" or "mesh").
+	PeerRole = Default.NewGaugeVec("repbin_peer_role", "Role of the peer in the replication topology.", "peer", "role")
+	// ServerRole is 1 for the role of the server.
+	ServerRole = Default.NewGaugeVec("repbin_server_role", "Role of the server in the replication topology.", "role")
+	// NotifyFailures counts failed notifications, by peer.
+	NotifyFailures = Default.NewCounterVec("repbin_notify_failures_total", "Failed notifications, by peer.", "peer")
+	// ReconciledMessages counts messages downloaded from peers by reconciliation.
+	ReconciledMessages = Default.NewCounter("repbin_reconciled_messages_total", "Messages downloaded from peers by reconciliation.")
+	// ReadThrough counts lookups of missing messages at peers, by result ("found", "miss", "cached", "busy" or "deleted").
+	ReadThrough = Default.NewCounterVec("repbin_read_through_total", "Lookups of missing messages at peers, by result.", "result")
+	// SnapshotMessages counts messages exported to and imported from snapshots, by direction ("exported" or "imported").
+	SnapshotMessages = Default.NewCounterVec("repbin_snapshot_messages_total", "Messages exported to and imported from snapshots, by direction.", "direction")
+	// ImportDistance is the distance to origin of messages fetched from peers.
+	ImportDistance = Default.NewHistogram("repbin_import_distance", "Distance to origin of messages fetched from peers.", []float64{1, 2, 3, 4, 6, 8, 12, 16})
+	// ImportDelay is the time between the storage of a message at a peer and its import.
+	ImportDelay = Default.NewHistogram("repbin_import_delay_seconds", "Time between storage at the peer and import of messages.", []float64{10, 60, 300, 600, 1800, 3600, 7200, 21600, 86400})
+	// DiscoveredPeers is the number of discovered peers, by state ("approved", "pending" or "rejected").
+	DiscoveredPeers = Default.NewGaugeVec("repbin_discovered_peers", "Discovered peers, by state.", "state")
+	// Tombstones counts tombstones stored, from clients and peers.
+	Tombstones = Default.NewCounter("repbin_tombstones_total", "Tombstones stored.")
+	// BlobStoreBytes is the size of the blob store, updated by expire runs.
+	BlobStoreBytes = Default.NewGauge("repbin_blob_store_bytes", "Size of the message blob store.")
+	// DBDuration is the duration of message store operations, by operation.
+	DBDuration = Default.NewHistogramVec("repbin_db_duration_seconds", "Duration of message store operations.", DurationBuckets, "op")
 )
 
 const (
-	oneMin  = 1 * 60
-	fiveMin = 5 * 60
-	oneHour = 60 * 60
-	oneDay  = 24 * 60 * 60
+	oneMin  = 1
+	fiveMin = 5
+	oneHour = 60
+	oneDay  = 24 * 60
 )
 
-// Input is the repserver statistics input channel.
-var Input = make(chan Event, 1024)
+// history contains the value of a counter at the end of each minute, newest last.
+type history []uint64
 
-func now() int64 {
-	return time.Now().UTC().Unix()
+func (h history) add(v uint64) history {
+	h = append(h, v)
+	if len(h) > oneDay+1 {
+		h = h[1:]
+	}
+	return h
 }
 
-func removeOld(l *list.List, cutoff int64) {
-	e := l.Front()
-	for e != nil {
-		if e.Value.(int64) <= cutoff {
-			l.Remove(e)
-			e = l.Front()
-		} else {
-			break
-		}
+// perMinute returns the average per minute over the last minutes.
+func (h history) perMinute(minutes int) float64 {
+	if len(h) <= minutes {
+		return 0
 	}
+	return float64(h[len(h)-1]-h[len(h)-1-minutes]) / float64(minutes)
 }
 
-// Run starts the statistics handler.
+// Run shows post and fetch statistics on the console once a minute.
 func Run() {
-	start := now()
-	postList1 := list.New()
-	postList5 := list.New()
-	postList60 := list.New()
-	postList1440 := list.New()
-	fetchList1 := list.New()
-	fetchList5 := list.New()
-	fetchList60 := list.New()
-	fetchList1440 := list.New()
-	for {
-		m := <-Input
-		t := now()
-		switch m {
-		case Post:
-			postList1.PushBack(t)
-			postList5.PushBack(t)
-			postList60.PushBack(t)
-			postList1440.PushBack(t)
-		case Fetch:
-			fetchList1.PushBack(t)
-			fetchList5.PushBack(t)
-			fetchList60.PushBack(t)
-			fetchList1440.PushBack(t)
-		case Show:
-			removeOld(postList1, t-oneMin)
-			removeOld(postList5, t-fiveMin)
-			removeOld(postList60, t-oneHour)
-			removeOld(postList1440, t-oneDay)
-			removeOld(fetchList1, t-oneMin)
-			removeOld(fetchList5, t-fiveMin)
-			removeOld(fetchList60, t-oneHour)
-			removeOld(fetchList1440, t-oneDay)
-			postOneMin := float64(postList1.Len())
-			postFiveMin := float64(postList5.Len()) / 5.0
-			postOneHour := float64(postList60.Len()) / 60.0
-			postOneDay := float64(postList1440.Len()) / 1440.0
-			fetchOneMin := float64(fetchList1.Len())
-			fetchFiveMin := float64(fetchList5.Len()) / 5.0
-			fetchOneHour := float64(fetchList60.Len()) / 60.0
-			fetchOneDay := float64(fetchList1440.Len()) / 1440.0
-			if t-oneDay >= start {
-				log.Debugf("Posts/minute:   %8.3f (1m) %8.3f (5m) %8.3f (1h) %8.3f (24h)\n",
-					postOneMin, postFiveMin, postOneHour, postOneDay)
-				log.Debugf("Fetches/minute: %8.3f (1m) %8.3f (5m) %8.3f (1h) %8.3f (24h)\n",
-					fetchOneMin, fetchFiveMin, fetchOneHour, fetchOneDay)
-			} else if t-oneHour >= start {
-				log.Debugf("Posts/minute:   %8.3f (1m) %8.3f (5m) %8.3f (1h)\n",
-					postOneMin, postFiveMin, postOneHour)
-				log.Debugf("Fetches/minute: %8.3f (1m) %8.3f (5m) %8.3f (1h)\n",
-					fetchOneMin, fetchFiveMin, fetchOneHour)
-
-			} else if t-fiveMin >= start {
-				log.Debugf("Posts/minute:   %8.3f (1m) %8.3f (5m)\n",
-					postOneMin, postFiveMin)
-				log.Debugf("Fetches/minute: %8.3f (1m) %8.3f (5m)\n",
-					fetchOneMin, fetchFiveMin)
-			} else {
-				log.Debugf("Posts/minute:   %8.3f (1m)\n",
-					postOneMin)
-				log.Debugf("Fetches/minute: %8.3f (1m)\n",
-					fetchOneMin)
-			}
+	posts := history{PostsAccepted.Value()}
+	fetches := history{Fetches.With("ok").Value()}
+	for range time.Tick(time.Minute) {
+		posts = posts.add(PostsAccepted.Value())
+		fetches = fetches.add(Fetches.With("ok").Value())
+		minutes := len(posts) - 1
+		if minutes >= oneDay {
+			log.Debugf("Posts/minute:   %8.3f (1m) %8.3f (5m) %8.3f (1h) %8.3f (24h)\n",
+				posts.perMinute(oneMin), posts.perMinute(fiveMin), posts.perMinute(oneHour), posts.perMinute(oneDay))
+			log.Debugf("Fetches/minute: %8.3f (1m) %8.3f (5m) %8.3f (1h) %8.3f (24h)\n",
+				fetches.perMinute(oneMin), fetches.perMinute(fiveMin), fetches.perMinute(oneHour), fetches.perMinute(oneDay))
+		} else if minutes >= oneHour {
+			log.Debugf("Posts/minute:   %8.3f (1m) %8.3f (5m) %8.3f (1h)\n",
+				posts.perMinute(oneMin), posts.perMinute(fiveMin), posts.perMinute(oneHour))
+			log.Debugf("Fetches/minute: %8.3f (1m) %8.3f (5m) %8.3f (1h)\n",
+				fetches.perMinute(oneMin), fetches.perMinute(fiveMin), fetches.perMinute(oneHour))
+		} else if minutes >= fiveMin {
+			log.Debugf("Posts/minute:   %8.3f (1m) %8.3f (5m)\n",
+				posts.perMinute(oneMin), posts.perMinute(fiveMin))
+			log.Debugf("Fetches/minute: %8.3f (1m) %8.3f (5m)\n",
+				fetches.perMinute(oneMin), fetches.perMinute(fiveMin))
+		} else {
+			log.Debugf("Posts/minute:   %8.3f (1m)\n",
+				posts.perMinute(oneMin))
+			log.Debugf("Fetches/minute: %8.3f (1m)\n",
+				fetches.perMinute(oneMin))
 		}
 	}
 }
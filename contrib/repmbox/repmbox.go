@@ -145,13 +145,14 @@ func (cfg *config) addSender(addSender, configFile string) error {
 	return nil
 }
 
-func (cfg *config) getList() (list []string, last int, more bool, err error) {
+func (cfg *config) getList(dldir string) (list []string, last int, more bool, err error) {
 	cmd := exec.Command("repclient",
 		"--index",
 		"--server", cfg.Server,
 		"--privkey", cfg.PrivateKey,
 		"--start", strconv.Itoa(cfg.Start+1),
 		"--count", strconv.Itoa(cfg.Count),
+		"--outdir", dldir,
 		"--appdata")
 	var out bytes.Buffer
 	cmd.Stderr = &out
@@ -217,20 +218,26 @@ func (cfg *config) getSenderKeys() map[string]senderKey {
 	return keys
 }
 
-func (cfg *config) getMessages(list []string, outdir, stmdir string, show, verbose bool) error {
+func (cfg *config) getMessages(list []string, dldir, outdir, stmdir string, show, verbose bool) error {
 	// make sure STM directory exists
 	if err := os.MkdirAll(stmdir, 0700); err != nil {
 		return err
 	}
 	for _, msg := range list {
 		fmt.Printf("retrieving message %s\n", msg)
-		cmd := exec.Command("repclient",
+		args := []string{
 			"--decrypt",
 			"--keymgt", "3",
 			"--server", cfg.Server,
 			"--stmdir", stmdir,
 			"--appdata",
-			msg)
+		}
+		if dlfile := path.Join(dldir, msg); fileExists(dlfile) {
+			args = append(args, "--in", dlfile)
+		} else {
+			args = append(args, msg)
+		}
+		cmd := exec.Command("repclient", args...)
 		var out bytes.Buffer
 		cmd.Stdout = &out
 		stderr, err := cmd.StderrPipe()
@@ -308,8 +315,18 @@ func (cfg *config) getMessages(list []string, outdir, stmdir string, show, verbo
 	return nil
 }
 
+func fileExists(filename string) bool {
+	fi, err := os.Stat(filename)
+	return err == nil && fi.Mode().IsRegular()
+}
+
 func (cfg *config) downloadNewMessages(outdir, stmdir, configFile string, show, verbose bool) error {
 	more := true
+	dldir, err := ioutil.TempDir("", "repmbox")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dldir)
 	for more {
 		var list []string
 		var last int
@@ -318,7 +335,7 @@ func (cfg *config) downloadNewMessages(outdir, stmdir, configFile string, show,
 		if verbose {
 			fmt.Printf("download new messages starting at #%d\n", cfg.Start)
 		}
-		list, last, more, err = cfg.getList()
+		list, last, more, err = cfg.getList(dldir)
 		if err != nil {
 			return err
 		}
@@ -329,7 +346,7 @@ func (cfg *config) downloadNewMessages(outdir, stmdir, configFile string, show,
 			break
 		}
 		// get new messages
-		if err := cfg.getMessages(list, outdir, stmdir, show, verbose); err != nil {
+		if err := cfg.getMessages(list, dldir, outdir, stmdir, show, verbose); err != nil {
 			return err
 		}
 		// set start
@@ -57,15 +57,27 @@ func genTempKey(time [8]byte, secret *[PrivateKeySize]byte) (privateKey *[Privat
 
 // Answer an authentication challenge. Secret is the private key belonging to the public key to be tested
 func Answer(challenge *[ChallengeSize]byte, secret *[PrivateKeySize]byte) *[AnswerSize]byte {
-	// return challenge|hash(challenge,DH(Secret,challenge.Pub))
+	return answer(challenge, secret, nil)
+}
+
+// AnswerOperation answers an authentication challenge for an operation on the object id. The
+// answer is only valid for this operation and id, it cannot be replayed for other operations
+// or objects. Operations must not be prefixes of each other if ids have different sizes
+func AnswerOperation(challenge *[ChallengeSize]byte, secret *[PrivateKeySize]byte, operation string, id []byte) *[AnswerSize]byte {
+	return answer(challenge, secret, binding(operation, id))
+}
+
+func answer(challenge *[ChallengeSize]byte, secret *[PrivateKeySize]byte, bind []byte) *[AnswerSize]byte {
+	// return challenge|hash(challenge,DH(Secret,challenge.Pub),bind)
 	var sharedSecret [PublicKeySize]byte
 	var tempPub [PublicKeySize]byte
-	var hashIn, answer [AnswerSize]byte
+	var answer [AnswerSize]byte
+	hashIn := make([]byte, AnswerSize, AnswerSize+len(bind))
 	copy(hashIn[0:ChallengeSize], challenge[:])
 	copy(tempPub[:], challenge[8:])
 	curve25519.ScalarMult(&sharedSecret, secret, &tempPub)
 	copy(hashIn[ChallengeSize:], sharedSecret[:])
-	mHash := sha256.Sum256(hashIn[:])
+	mHash := sha256.Sum256(append(hashIn, bind...))
 	copy(answer[:ChallengeSize], challenge[:])
 	copy(answer[ChallengeSize:], mHash[:])
 	return &answer
@@ -73,10 +85,18 @@ func Answer(challenge *[ChallengeSize]byte, secret *[PrivateKeySize]byte) *[Answ
 
 // Verify an answer. secret is the own secret key, testKey is the public key to test ownership on
 func Verify(answer *[AnswerSize]byte, secret *[PrivateKeySize]byte, testKey *[PublicKeySize]byte) bool {
+	return verify(answer, secret, testKey, nil)
+}
+
+// VerifyOperation verifies an answer created by AnswerOperation for operation and id
+func VerifyOperation(answer *[AnswerSize]byte, secret *[PrivateKeySize]byte, testKey *[PublicKeySize]byte, operation string, id []byte) bool {
+	return verify(answer, secret, testKey, binding(operation, id))
+}
+
+func verify(answer *[AnswerSize]byte, secret *[PrivateKeySize]byte, testKey *[PublicKeySize]byte, bind []byte) bool {
 	var sharedSecret [PublicKeySize]byte
 	var timeb [8]byte
 	var inChallenge [ChallengeSize]byte
-	var hashIn [AnswerSize]byte
 	var inHash [PrivateKeySize]byte
 	t1, t2 := false, false
 
@@ -88,9 +108,10 @@ func Verify(answer *[AnswerSize]byte, secret *[PrivateKeySize]byte, testKey *[Pu
 		t1 = true
 	}
 	curve25519.ScalarMult(&sharedSecret, tempPriv, testKey)
+	hashIn := make([]byte, AnswerSize, AnswerSize+len(bind))
 	copy(hashIn[0:ChallengeSize], outChallenge[:])
 	copy(hashIn[ChallengeSize:], sharedSecret[:])
-	outHash := sha256.Sum256(hashIn[:])
+	outHash := sha256.Sum256(append(hashIn, bind...))
 	if inHash == outHash {
 		t2 = true
 	}
@@ -100,6 +121,13 @@ func Verify(answer *[AnswerSize]byte, secret *[PrivateKeySize]byte, testKey *[Pu
 	return false
 }
 
+// binding returns the data an answer is bound to: operation | id. It is empty for plain answers
+func binding(operation string, id []byte) []byte {
+	bind := make([]byte, 0, len(operation)+len(id))
+	bind = append(bind, operation...)
+	return append(bind, id...)
+}
+
 // VerifyTime verifies that the answer is still valid
 func VerifyTime(answer *[AnswerSize]byte, now, timeRange uint64) bool {
 	challengeTime := binary.BigEndian.Uint64(answer[:8])
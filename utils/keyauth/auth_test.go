@@ -39,3 +39,28 @@ func TestGenTempKey(t *testing.T) {
 		t.Error("Time verification must fail")
 	}
 }
+
+func TestAnswerOperation(t *testing.T) {
+	privkey := [PrivateKeySize]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0xaf, 0xff}
+	testPriv := [PrivateKeySize]byte{0x00, 0x00, 0x03, 0x03, 0x03, 0x06, 0x07, 0x08, 0x09, 0x07, 0x07, 0x07, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0xaf, 0xff}
+	testPub := [PublicKeySize]byte{}
+	curve25519.ScalarBaseMult(&testPub, &testPriv)
+	_, _, challenge := GenTempKey(&privkey)
+	id := []byte("messageid")
+	answer := AnswerOperation(challenge, &testPriv, "delete", id)
+	if !VerifyOperation(answer, &privkey, &testPub, "delete", id) {
+		t.Error("Verification failed")
+	}
+	if VerifyOperation(answer, &privkey, &testPub, "delete", []byte("otherid")) {
+		t.Error("Verification must fail for other id")
+	}
+	if VerifyOperation(answer, &privkey, &testPub, "extend", id) {
+		t.Error("Verification must fail for other operation")
+	}
+	if Verify(answer, &privkey, &testPub) {
+		t.Error("Operation answer must not verify as plain answer")
+	}
+	if VerifyOperation(Answer(challenge, &testPriv), &privkey, &testPub, "delete", id) {
+		t.Error("Plain answer must not verify for operation")
+	}
+}
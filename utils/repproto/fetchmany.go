@@ -0,0 +1,184 @@
+package repproto
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/repproto/structs"
+	"github.com/repbin/repbin/utils/socks"
+)
+
+// MaxFetchMany is the number of messages requested per fetchmany call.
+const MaxFetchMany = 100
+
+// maxFetchManyMessage is the maximum size of a message returned by fetchmany.
+const maxFetchManyMessage = 512000
+
+// GetMany fetches the messages with the given IDs from server in batches and calls found for
+// each message returned. Missing messages are skipped. auth is required by hub servers, it may
+// be empty otherwise. Servers without fetchmany support return ErrBadProto before found is
+// called, callers should fall back to single fetches.
+func (proto *Proto) GetMany(server, auth string, messageIDs [][]byte, found func(messageID, data []byte) error) error {
+	return proto.GetManyContext(context.Background(), server, auth, messageIDs, found)
+}
+
+// GetManyContext fetches the messages with the given IDs from server in batches and calls found
+// for each message returned. Fetching stops with the error of found.
+func (proto *Proto) GetManyContext(ctx context.Context, server, auth string, messageIDs [][]byte, found func(messageID, data []byte) error) error {
+	version := proto.protoVersion(ctx, server)
+	for len(messageIDs) > 0 {
+		batch := messageIDs
+		if len(batch) > MaxFetchMany {
+			batch = batch[:MaxFetchMany]
+		}
+		messageIDs = messageIDs[len(batch):]
+		if err := proto.getManyBatch(ctx, version, server, auth, batch, found); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getManyBatch makes a single fetchmany call.
+func (proto *Proto) getManyBatch(ctx context.Context, version int, server, auth string, messageIDs [][]byte, found func(messageID, data []byte) error) error {
+	ids := make([]string, 0, len(messageIDs))
+	requested := make(map[string]bool)
+	for _, messageID := range messageIDs {
+		id := utils.B58encode(messageID)
+		ids = append(ids, id)
+		requested[id] = true
+	}
+	url := constructURL(server, apiPath(version, "/fetchmany"), "?messageids=", strings.Join(ids, ","))
+	if auth != "" {
+		url = constructURL(url, "&auth=", auth)
+	}
+	resp, err := socks.Proxy(proto.SocksServer).GetContext(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrBadProto // No fetchmany support
+	}
+	body := bufio.NewReaderSize(resp.Body, 65536)
+	next := func() (string, []byte, error) {
+		if version >= ProtoJSON {
+			return nextJSONFrame(body)
+		}
+		return nextTextFrame(body)
+	}
+	if version < ProtoJSON {
+		line, err := readLine(body)
+		if err != nil {
+			return err
+		}
+		if _, err := parseError(line); err != nil {
+			return err
+		}
+	}
+	for {
+		id, data, err := next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !requested[id] {
+			return ErrBadProto
+		}
+		if data == nil {
+			continue // Missing
+		}
+		if err := found(utils.B58decode(id), data); err != nil {
+			return err
+		}
+	}
+}
+
+// readLine reads a line of at most 4096 bytes, without the newline.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || (err == nil && len(line) > 4096) {
+		return nil, ErrBadProto
+	}
+	if err == io.EOF && len(line) > 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+// nextTextFrame reads the next message of a text fetchmany response. data is nil for missing
+// messages.
+func nextTextFrame(r *bufio.Reader) (string, []byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return "", nil, err
+	}
+	fields := strings.Fields(string(line))
+	switch {
+	case len(fields) == 2 && fields[0] == "MISSING:":
+		return fields[1], nil, nil
+	case len(fields) == 3 && fields[0] == "MESSAGE:":
+		length, err := strconv.Atoi(fields[2])
+		if err != nil || length <= 0 || length > maxFetchManyMessage {
+			return "", nil, ErrBadProto
+		}
+		data := make([]byte, length+1)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return "", nil, io.ErrUnexpectedEOF
+		}
+		if data[length] != '\n' {
+			return "", nil, ErrBadProto
+		}
+		return fields[1], data[:length], nil
+	}
+	return "", nil, ErrBadProto
+}
+
+// nextJSONFrame reads the next message of a JSON fetchmany response. data is nil for missing
+// messages.
+func nextJSONFrame(r *bufio.Reader) (string, []byte, error) {
+	var line []byte
+	for {
+		part, err := r.ReadSlice('\n')
+		line = append(line, part...)
+		if len(line) > 2*maxFetchManyMessage {
+			return "", nil, ErrBadProto
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && len(line) > 0 {
+			return "", nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		break
+	}
+	resp := new(structs.APIResponse)
+	if err := json.Unmarshal(line, resp); err != nil || resp.Version < ProtoJSON {
+		return "", nil, ErrBadProto
+	}
+	if resp.Error != nil {
+		if resp.MessageID == "" {
+			return "", nil, &ServerError{Code: resp.Error.Code, Message: resp.Error.Message}
+		}
+		return resp.MessageID, nil, nil
+	}
+	if resp.Data == "" {
+		return "", nil, ErrBadProto
+	}
+	return resp.MessageID, []byte(resp.Data), nil
+}
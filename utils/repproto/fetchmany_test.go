@@ -0,0 +1,88 @@
+package repproto
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/repproto/structs"
+	"github.com/repbin/repbin/utils/socks"
+)
+
+func TestGetMany(t *testing.T) {
+	defer func(accept bool) { socks.AcceptNoSocks = accept }(socks.AcceptNoSocks)
+	socks.AcceptNoSocks = true
+	stored := map[string]string{
+		utils.B58encode([]byte("message a")): "data\na",
+		utils.B58encode([]byte("message b")): "data b",
+	}
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		ids := strings.Split(r.URL.Query().Get("messageids"), ",")
+		switch r.URL.Path {
+		case "/fetchmany":
+			io.WriteString(w, "SUCCESS: Data follows\n")
+			for _, id := range ids {
+				if data, ok := stored[id]; ok {
+					io.WriteString(w, "MESSAGE: "+id+" "+strconv.Itoa(len(data))+"\n"+data+"\n")
+				} else {
+					io.WriteString(w, "MISSING: "+id+"\n")
+				}
+			}
+		case "/v2/fetchmany":
+			enc := json.NewEncoder(w)
+			for _, id := range ids {
+				resp := &structs.APIResponse{Version: structs.APIVersion, MessageID: id, Data: stored[id]}
+				if resp.Data == "" {
+					resp.Error = &structs.APIError{Code: structs.CodeNotFound, Message: "No data"}
+				}
+				enc.Encode(resp)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	messageIDs := [][]byte{[]byte("message a"), []byte("missing"), []byte("message b")}
+	for _, version := range []int{ProtoText, ProtoJSON} {
+		proto := New("", ts.URL)
+		proto.Version = version
+		found := make(map[string]string)
+		err := proto.GetMany(ts.URL, "", messageIDs, func(messageID, data []byte) error {
+			found[string(messageID)] = string(data)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("GetMany %d: %s", version, err)
+		}
+		if len(found) != 2 || found["message a"] != "data\na" || found["message b"] != "data b" {
+			t.Errorf("Bad messages %d: %v", version, found)
+		}
+	}
+	// Batches
+	requests = 0
+	proto := New("", ts.URL)
+	proto.Version = ProtoJSON
+	many := make([][]byte, MaxFetchMany+1)
+	for i := range many {
+		many[i] = []byte("missing " + strconv.Itoa(i))
+	}
+	if err := proto.GetMany(ts.URL, "", many, func(messageID, data []byte) error { return nil }); err != nil {
+		t.Errorf("GetMany: %s", err)
+	}
+	if requests != 2 {
+		t.Errorf("Bad number of requests: %d", requests)
+	}
+	// Servers without fetchmany
+	proto.Version = ProtoText
+	if err := proto.GetMany(ts.URL+"/old", "", messageIDs, nil); err != ErrBadProto {
+		t.Errorf("Missing support not detected: %v", err)
+	}
+}
@@ -3,9 +3,9 @@ package repproto
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"math/rand"
 	"strconv"
 	"strings"
@@ -44,8 +44,15 @@ type Proto struct {
 	ServerSelector func([]byte, ...string) (string, error)
 	// SelectorReset resets server selection
 	SelectorReset func()
-	selectorPerm  []int
-	selectorPos   int
+	// Version forces a protocol version (ProtoText or ProtoJSON). It is negotiated per server if 0
+	Version int
+	// Parallel is the number of servers Get and List query concurrently. The first valid
+	// response is returned. Servers are queried one after another if Parallel < 2
+	Parallel int
+	// MinHashCash is the minimum hashcash of messages returned by Get in parallel mode
+	MinHashCash  byte
+	selectorPerm []int
+	selectorPos  int
 }
 
 func init() {
@@ -181,16 +188,24 @@ func parseError(body []byte) ([]byte, error) {
 		return nil, nil
 	}
 	if len(l[0]) > 6 && string(l[0][:6]) == "ERROR:" {
-		return nil, fmt.Errorf("Server error: %s", string(l[0][7:]))
+		return nil, &ServerError{Message: string(l[0][7:])}
 	}
 	return nil, ErrBadProto
 }
 
 // Post a message
 func (proto *Proto) Post(messageID []byte, message []byte) (string, error) {
+	return proto.PostContext(context.Background(), messageID, message)
+}
+
+// PostContext posts a message. Trying further servers stops when ctx is done
+func (proto *Proto) PostContext(ctx context.Context, messageID []byte, message []byte) (string, error) {
 	var err error
 	maxLoops := len(proto.Servers)
 	for {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
 		server, selecterr := proto.selectServer(messageID)
 		if selecterr == ErrNoMoreServers {
 			if err == nil {
@@ -199,7 +214,7 @@ func (proto *Proto) Post(messageID []byte, message []byte) (string, error) {
 			// return last error when servers are all done
 			return "", err
 		}
-		err = proto.PostSpecific(server, message)
+		err = proto.PostSpecificContext(ctx, server, message)
 		// Return on success
 		if err == nil {
 			return server, nil
@@ -215,20 +230,37 @@ func (proto *Proto) Post(messageID []byte, message []byte) (string, error) {
 
 // PostSpecific posts a message to a specific server
 func (proto *Proto) PostSpecific(server string, message []byte) error {
-	body, err := socks.Proxy(proto.SocksServer).LimitPostBytes(constructURL(server, "/post"), "text/text", message, 512000)
+	return proto.PostSpecificContext(context.Background(), server, message)
+}
+
+// PostSpecificContext posts a message to a specific server
+func (proto *Proto) PostSpecificContext(ctx context.Context, server string, message []byte) error {
+	version := proto.protoVersion(ctx, server)
+	body, err := socks.Proxy(proto.SocksServer).LimitPostBytesContext(ctx, constructURL(server, apiPath(version, "/post")), "text/text", message, 512000)
 	if err != nil {
 		return err
 	}
-	_, err = parseError(body) // we do not care about the body
+	_, err = parseResponse(version, body) // we do not care about the body
 	return err
 }
 
 // Get a message
 func (proto *Proto) Get(messageID []byte) (string, []byte, error) {
+	return proto.GetContext(context.Background(), messageID)
+}
+
+// GetContext gets a message. Trying further servers stops when ctx is done
+func (proto *Proto) GetContext(ctx context.Context, messageID []byte) (string, []byte, error) {
 	var err error
 	var body []byte
+	if proto.Parallel > 1 {
+		return proto.getParallel(ctx, messageID)
+	}
 	maxLoops := len(proto.Servers)
 	for {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", nil, ctxErr
+		}
 		server, selecterr := proto.selectServer(messageID)
 		if selecterr == ErrNoMoreServers {
 			if err == nil {
@@ -237,7 +269,7 @@ func (proto *Proto) Get(messageID []byte) (string, []byte, error) {
 			// return last error when servers are all done
 			return "", nil, err
 		}
-		body, err = proto.GetSpecific(server, messageID)
+		body, err = proto.GetSpecificContext(ctx, server, messageID)
 		// Return on success
 		if err == nil {
 			return server, body, nil
@@ -253,12 +285,18 @@ func (proto *Proto) Get(messageID []byte) (string, []byte, error) {
 
 // GetSpecific fetches a message from a specific server
 func (proto *Proto) GetSpecific(server string, messageID []byte) ([]byte, error) {
+	return proto.GetSpecificContext(context.Background(), server, messageID)
+}
+
+// GetSpecificContext fetches a message from a specific server
+func (proto *Proto) GetSpecificContext(ctx context.Context, server string, messageID []byte) ([]byte, error) {
 	messageIDenc := utils.B58encode(messageID)
-	body, err := socks.Proxy(proto.SocksServer).LimitGet(constructURL(server, "/fetch", "?messageid=", messageIDenc), 512000)
+	version := proto.protoVersion(ctx, server)
+	body, err := socks.Proxy(proto.SocksServer).LimitGetContext(ctx, constructURL(server, apiPath(version, "/fetch"), "?messageid=", messageIDenc), 512000)
 	if err != nil {
 		return nil, err
 	}
-	return parseError(body)
+	return parseResponse(version, body)
 }
 
 // ServerInfo public server info
@@ -266,15 +304,22 @@ type ServerInfo struct {
 	Time            int64
 	AuthPubKey      string
 	AuthChallenge   string
-	MaxPostSize     int64    // Maximum post size
-	MinPostSize     int      // Minimum post size
-	MinHashCashBits byte     // Minimum hashcash bits required
-	Peers           []string // Peers of the server, if any
+	MaxPostSize     int64               // Maximum post size
+	MinPostSize     int                 // Minimum post size
+	MinHashCashBits byte                // Minimum hashcash bits required
+	Peers           []string            // Peers of the server, if any
+	APIVersion      int                 // Highest protocol version supported. 0 for text only servers
+	PeerRecord      *structs.PeerRecord `json:",omitempty"` // Signed peer record of the server, if any
 }
 
 // ID returns the ID of a specific server
 func (proto *Proto) ID(server string) (*ServerInfo, error) {
-	body, err := socks.Proxy(proto.SocksServer).LimitGet(constructURL(server, "/id"), 4096)
+	return proto.IDContext(context.Background(), server)
+}
+
+// IDContext returns the ID of a specific server
+func (proto *Proto) IDContext(ctx context.Context, server string) (*ServerInfo, error) {
+	body, err := socks.Proxy(proto.SocksServer).LimitGetContext(ctx, constructURL(server, "/id"), 4096)
 	if err != nil {
 		return nil, err
 	}
@@ -288,23 +333,49 @@ func (proto *Proto) ID(server string) (*ServerInfo, error) {
 
 // Auth creates an authentication for server and privKey
 func (proto *Proto) Auth(server string, privKey []byte) (string, error) {
+	return proto.AuthContext(context.Background(), server, privKey)
+}
+
+// AuthContext creates an authentication for server and privKey
+func (proto *Proto) AuthContext(ctx context.Context, server string, privKey []byte) (string, error) {
+	return proto.AuthOperationContext(ctx, server, privKey, "", nil)
+}
+
+// AuthOperation creates an authentication for server and privKey that is bound to operation and id
+func (proto *Proto) AuthOperation(server string, privKey []byte, operation string, id []byte) (string, error) {
+	return proto.AuthOperationContext(context.Background(), server, privKey, operation, id)
+}
+
+// AuthOperationContext creates an authentication for server and privKey that is bound to operation and id
+func (proto *Proto) AuthOperationContext(ctx context.Context, server string, privKey []byte, operation string, id []byte) (string, error) {
 	var challenge [keyauth.ChallengeSize]byte
 	var secret [keyauth.PrivateKeySize]byte
-	info, err := proto.ID(server)
+	info, err := proto.IDContext(ctx, server)
 	if err != nil {
 		return "", err
 	}
 	challengeS := utils.B58decode(info.AuthChallenge)
 	copy(challenge[:], challengeS)
 	copy(secret[:], privKey[:])
-	answer := keyauth.Answer(&challenge, &secret)
+	answer := keyauth.AnswerOperation(&challenge, &secret, operation, id)
 	return utils.B58encode(answer[:]), nil
 }
 
 // List messages for pubKey
 func (proto *Proto) List(pubKey, privKey []byte, start, count int) (server string, messages []*structs.MessageStruct, more bool, err error) {
+	return proto.ListContext(context.Background(), pubKey, privKey, start, count)
+}
+
+// ListContext lists messages for pubKey. Trying further servers stops when ctx is done
+func (proto *Proto) ListContext(ctx context.Context, pubKey, privKey []byte, start, count int) (server string, messages []*structs.MessageStruct, more bool, err error) {
+	if proto.Parallel > 1 {
+		return proto.listParallel(ctx, pubKey, privKey, start, count)
+	}
 	maxLoops := len(proto.Servers)
 	for {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", nil, false, ctxErr
+		}
 		server, selecterr := proto.selectServer(pubKey)
 		if selecterr == ErrNoMoreServers {
 			if err == nil {
@@ -313,7 +384,7 @@ func (proto *Proto) List(pubKey, privKey []byte, start, count int) (server strin
 			// return last error when servers are all done
 			return "", nil, false, err
 		}
-		messages, more, err = proto.ListSpecific(server, pubKey, privKey, start, count)
+		messages, more, err = proto.ListSpecificContext(ctx, server, pubKey, privKey, start, count)
 		// Return on success
 		if err == nil {
 			return server, messages, more, nil
@@ -329,6 +400,11 @@ func (proto *Proto) List(pubKey, privKey []byte, start, count int) (server strin
 
 // ListSpecific lists the messages for pubKey from a specific server
 func (proto *Proto) ListSpecific(server string, pubKey, privKey []byte, start, count int) (messages []*structs.MessageStruct, more bool, err error) {
+	return proto.ListSpecificContext(context.Background(), server, pubKey, privKey, start, count)
+}
+
+// ListSpecificContext lists the messages for pubKey from a specific server
+func (proto *Proto) ListSpecificContext(ctx context.Context, server string, pubKey, privKey []byte, start, count int) (messages []*structs.MessageStruct, more bool, err error) {
 	var authStr string
 	var myPubKey message.Curve25519Key
 	copy(myPubKey[:], pubKey)
@@ -336,18 +412,19 @@ func (proto *Proto) ListSpecific(server string, pubKey, privKey []byte, start, c
 		if privKey == nil {
 			return nil, false, ErrPrivKey
 		}
-		auth, err := proto.Auth(server, privKey)
+		auth, err := proto.AuthContext(ctx, server, privKey)
 		if err != nil {
 			return nil, false, err
 		}
 		authStr = "&auth=" + auth
 	}
-	url := constructURL(server, "/keyindex?key=", utils.B58encode(pubKey[:]), "&start=", strconv.Itoa(start), "count=", strconv.Itoa(count), authStr)
-	body, err := socks.Proxy(proto.SocksServer).LimitGet(url, 512000)
+	version := proto.protoVersion(ctx, server)
+	url := constructURL(server, apiPath(version, "/keyindex?key="), utils.B58encode(pubKey[:]), "&start=", strconv.Itoa(start), "count=", strconv.Itoa(count), authStr)
+	body, err := socks.Proxy(proto.SocksServer).LimitGetContext(ctx, url, 512000)
 	if err != nil {
 		return nil, false, err
 	}
-	return parseListResponse(body)
+	return parseListResponseVersion(version, body)
 }
 
 func parseListResponse(body []byte) (messages []*structs.MessageStruct, more bool, err error) {
@@ -369,30 +446,65 @@ func parseListResponse(body []byte) (messages []*structs.MessageStruct, more boo
 
 // Notify a server
 func (proto *Proto) Notify(server, auth string) error {
-	body, err := socks.Proxy(proto.SocksServer).LimitGet(constructURL(server, "/notify?auth=", auth), 4096)
+	return proto.NotifyContext(context.Background(), server, auth)
+}
+
+// NotifyContext notifies a server
+func (proto *Proto) NotifyContext(ctx context.Context, server, auth string) error {
+	version := proto.protoVersion(ctx, server)
+	body, err := socks.Proxy(proto.SocksServer).LimitGetContext(ctx, constructURL(server, apiPath(version, "/notify?auth="), auth), 4096)
 	if err != nil {
 		return err
 	}
-	_, err = parseError(body)
+	_, err = parseResponse(version, body)
 	return err
 }
 
 // GetGlobalIndex returns the global index of a server
 func (proto *Proto) GetGlobalIndex(server, auth string, start, count int) (messages []*structs.MessageStruct, more bool, err error) {
-	url := constructURL(server, "/globalindex?auth=", auth, "&start=", strconv.Itoa(start), "count=", strconv.Itoa(count))
-	body, err := socks.Proxy(proto.SocksServer).LimitGet(url, 5242880)
+	return proto.GetGlobalIndexContext(context.Background(), server, auth, start, count)
+}
+
+// GetGlobalIndexContext returns the global index of a server
+func (proto *Proto) GetGlobalIndexContext(ctx context.Context, server, auth string, start, count int) (messages []*structs.MessageStruct, more bool, err error) {
+	version := proto.protoVersion(ctx, server)
+	url := constructURL(server, apiPath(version, "/globalindex?auth="), auth, "&start=", strconv.Itoa(start), "count=", strconv.Itoa(count))
+	body, err := socks.Proxy(proto.SocksServer).LimitGetContext(ctx, url, 5242880)
 	if err != nil {
 		return nil, false, err
 	}
-	return parseListResponse(body)
+	return parseListResponseVersion(version, body)
 }
 
 // GetSpecificAuth fetches a message from a specific server using authentication
 func (proto *Proto) GetSpecificAuth(server, auth string, messageID []byte) ([]byte, error) {
+	return proto.GetSpecificAuthContext(context.Background(), server, auth, messageID)
+}
+
+// GetSpecificAuthContext fetches a message from a specific server using authentication
+func (proto *Proto) GetSpecificAuthContext(ctx context.Context, server, auth string, messageID []byte) ([]byte, error) {
+	messageIDenc := utils.B58encode(messageID)
+	version := proto.protoVersion(ctx, server)
+	body, err := socks.Proxy(proto.SocksServer).LimitGetContext(ctx, constructURL(server, apiPath(version, "/fetch"), "?messageid=", messageIDenc, "&auth=", auth), 512000)
+	if err != nil {
+		return nil, err
+	}
+	return parseResponse(version, body)
+}
+
+// GetSpecificAuthHops fetches a message from a specific server using authentication. If the
+// server does not have the message it may ask its peers, with at most hops further forwards
+func (proto *Proto) GetSpecificAuthHops(server, auth string, messageID []byte, hops int) ([]byte, error) {
+	return proto.GetSpecificAuthHopsContext(context.Background(), server, auth, messageID, hops)
+}
+
+// GetSpecificAuthHopsContext fetches a message from a specific server using authentication and a hop limit
+func (proto *Proto) GetSpecificAuthHopsContext(ctx context.Context, server, auth string, messageID []byte, hops int) ([]byte, error) {
 	messageIDenc := utils.B58encode(messageID)
-	body, err := socks.Proxy(proto.SocksServer).LimitGet(constructURL(server, "/fetch", "?messageid=", messageIDenc, "&auth=", auth), 512000)
+	version := proto.protoVersion(ctx, server)
+	body, err := socks.Proxy(proto.SocksServer).LimitGetContext(ctx, constructURL(server, apiPath(version, "/fetch"), "?messageid=", messageIDenc, "&auth=", auth, "&hops=", strconv.Itoa(hops)), 512000)
 	if err != nil {
 		return nil, err
 	}
-	return parseError(body)
+	return parseResponse(version, body)
 }
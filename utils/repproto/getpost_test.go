@@ -1,10 +1,16 @@
 package repproto
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/repbin/repbin/message"
 	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/repproto/structs"
+	"github.com/repbin/repbin/utils/socks"
 )
 
 func TestNew(t *testing.T) {
@@ -101,3 +107,60 @@ func TestIDSpecific(t *testing.T) {
 	// fmt.Printf("%+v\n", msgs)
 	_, _ = msgs, more
 }
+
+func TestParseResponse(t *testing.T) {
+	_, err := parseResponse(ProtoText, []byte("ERROR: Message too small\n"))
+	if err == nil || err.Error() != "Server error: Message too small" {
+		t.Errorf("Text error not parsed: %v", err)
+	}
+	_, err = parseResponse(ProtoJSON, []byte(`{"Version":2,"Error":{"Code":"duplicate","Message":"messagestore: Duplicate message"}}`))
+	if serr, ok := err.(*ServerError); !ok || serr.Code != structs.CodeDuplicate {
+		t.Errorf("JSON error not parsed: %v", err)
+	}
+	data, err := parseResponse(ProtoJSON, []byte(`{"Version":2,"Data":"abc"}`))
+	if err != nil || string(data) != "abc" {
+		t.Errorf("JSON data not parsed: %s %v", data, err)
+	}
+	if _, err := parseResponse(ProtoJSON, []byte("SUCCESS: Data follows\n")); err != ErrBadProto {
+		t.Errorf("Bad protocol not detected: %v", err)
+	}
+	msg := structs.MessageStruct{Counter: 3, MessageID: [message.MessageIDSize]byte{0x01}}
+	entry := structs.NewIndexEntry(&msg)
+	body := []byte(`{"Version":2,"Messages":[{"Counter":3,"MessageID":"` + entry.MessageID + `","ReceiverConstantPubKey":"` + entry.ReceiverConstantPubKey + `","SignerPub":"` + entry.SignerPub + `"}],"Next":4,"More":true}`)
+	msgs, more, err := parseListResponseVersion(ProtoJSON, body)
+	if err != nil {
+		t.Fatalf("JSON list not parsed: %s", err)
+	}
+	if !more || len(msgs) != 1 || msgs[0].Counter != 3 || msgs[0].MessageID != msg.MessageID {
+		t.Errorf("JSON list corrupted: %v", msgs)
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+	defer func(accept bool) { socks.AcceptNoSocks = accept }(socks.AcceptNoSocks)
+	socks.AcceptNoSocks = true
+	proto := New("", ts.URL)
+	proto.Version = ProtoText
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	startTime := time.Now()
+	_, err := proto.GetSpecificContext(ctx, ts.URL, []byte("testID"))
+	if err == nil {
+		t.Fatal("Request not cancelled")
+	}
+	if time.Since(startTime) > 5*time.Second {
+		t.Errorf("Cancellation too slow: %s", time.Since(startTime))
+	}
+	if _, _, err := proto.GetContext(ctx, []byte("testID")); err != context.DeadlineExceeded {
+		t.Errorf("Done context not detected: %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package repproto
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+// ErrBadMessage is returned if a server returned a message that does not verify
+var ErrBadMessage = errors.New("rep: Message does not verify")
+
+// raceResult is the result of a call to a single server.
+type raceResult struct {
+	server string
+	value  interface{}
+	err    error
+}
+
+// selectAll returns the servers in selection order.
+func (proto *Proto) selectAll(messageID []byte) ([]string, error) {
+	var servers []string
+	maxLoops := len(proto.Servers)
+	for {
+		server, err := proto.selectServer(messageID)
+		if err == ErrNoMoreServers {
+			return servers, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, server)
+		if maxLoops == 0 {
+			// Only to prevent faulty Proto.ServerSelector implementations
+			return servers, nil
+		}
+		maxLoops--
+	}
+}
+
+// race calls f for up to proto.Parallel servers concurrently and returns the first
+// successful result. Remaining calls are cancelled. Servers are taken from the
+// selection order, a new call is started whenever one fails.
+func (proto *Proto) race(ctx context.Context, messageID []byte, f func(ctx context.Context, server string) (interface{}, error)) (string, interface{}, error) {
+	servers, err := proto.selectAll(messageID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(servers) == 0 {
+		return "", nil, ErrNoMoreServers
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan raceResult, len(servers))
+	call := func(server string) {
+		value, err := f(ctx, server)
+		results <- raceResult{server: server, value: value, err: err}
+	}
+	next, running := 0, 0
+	for ; next < len(servers) && next < proto.Parallel; next++ {
+		go call(servers[next])
+		running++
+	}
+	err = ErrNoMoreServers
+	for running > 0 {
+		res := <-results
+		running--
+		if res.err == nil {
+			return res.server, res.value, nil
+		}
+		err = res.err
+		if ctx.Err() != nil {
+			return "", nil, ctx.Err()
+		}
+		if next < len(servers) {
+			go call(servers[next])
+			next++
+			running++
+		}
+	}
+	return "", nil, err
+}
+
+// getParallel fetches messageID from proto.Parallel servers concurrently.
+func (proto *Proto) getParallel(ctx context.Context, messageID []byte) (string, []byte, error) {
+	server, value, err := proto.race(ctx, messageID, func(ctx context.Context, server string) (interface{}, error) {
+		body, err := proto.GetSpecificContext(ctx, server, messageID)
+		if err != nil {
+			return nil, err
+		}
+		if err := VerifyMessage(messageID, body, proto.MinHashCash); err != nil {
+			return nil, err
+		}
+		return body, nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return server, value.([]byte), nil
+}
+
+// listResult is the result of a list call to a single server.
+type listResult struct {
+	messages []*structs.MessageStruct
+	more     bool
+}
+
+// listParallel lists messages for pubKey from proto.Parallel servers concurrently.
+func (proto *Proto) listParallel(ctx context.Context, pubKey, privKey []byte, start, count int) (string, []*structs.MessageStruct, bool, error) {
+	server, value, err := proto.race(ctx, pubKey, func(ctx context.Context, server string) (interface{}, error) {
+		messages, more, err := proto.ListSpecificContext(ctx, server, pubKey, privKey, start, count)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range messages {
+			if !bytes.Equal(msg.ReceiverConstantPubKey[:], pubKey) {
+				return nil, ErrBadProto
+			}
+		}
+		return listResult{messages: messages, more: more}, nil
+	})
+	if err != nil {
+		return "", nil, false, err
+	}
+	res := value.(listResult)
+	return server, res.messages, res.more, nil
+}
+
+// VerifyMessage verifies that data is the base64 encoded message messageID with a valid signature
+// header of at least minbits hashcash.
+func VerifyMessage(messageID, data []byte, minbits byte) error {
+	if minbits == 0 {
+		// hashcash.TestNonce fails for 0 bits
+		minbits = 1
+	}
+	signHeader, err := message.Base64Message(data).GetSignHeader()
+	if err != nil {
+		return ErrBadMessage
+	}
+	details, err := message.VerifySignature(*signHeader, minbits)
+	if err != nil {
+		return ErrBadMessage
+	}
+	if !bytes.Equal(details.MsgID[:], messageID) {
+		return ErrBadMessage
+	}
+	msg, err := message.Base64Message(data).Decode()
+	if err != nil || len(msg) < message.SignHeaderSize {
+		return ErrBadMessage
+	}
+	if *message.CalcMessageID(msg) != details.MsgID {
+		return ErrBadMessage
+	}
+	return nil
+}
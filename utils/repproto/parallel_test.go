@@ -0,0 +1,63 @@
+package repproto
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils/socks"
+)
+
+func TestGetParallel(t *testing.T) {
+	defer func(accept bool) { socks.AcceptNoSocks = accept }(socks.AcceptNoSocks)
+	socks.AcceptNoSocks = true
+	senderKey, _ := message.GenLongTermKey(false, false)
+	sender := &message.Sender{SenderPrivateKey: senderKey}
+	msg, meta, err := sender.Encrypt(0, []byte("Message for parallel fetch"))
+	if err != nil {
+		t.Fatalf("Encrypt: %s", err)
+	}
+	corrupt := append([]byte{}, msg...)
+	corrupt[len(corrupt)-8] ^= 0x01
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+	defer close(release)
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("SUCCESS: Data follows\n"))
+		w.Write(corrupt)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("SUCCESS: Data follows\n"))
+		w.Write(msg)
+	}))
+	defer good.Close()
+
+	proto := New("", slow.URL, bad.URL, good.URL)
+	proto.Version = ProtoText
+	proto.Parallel = 3
+	server, data, err := proto.Get(meta.MessageID[:])
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	if server != good.URL {
+		t.Errorf("Wrong server: %s", server)
+	}
+	if string(data) != string(msg) {
+		t.Error("Message corrupted")
+	}
+
+	proto = New("", bad.URL)
+	proto.Version = ProtoText
+	proto.Parallel = 2
+	if _, _, err := proto.Get(meta.MessageID[:]); err != ErrBadMessage {
+		t.Errorf("Bad message not detected: %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package repproto
+
+import (
+	"context"
+
+	"github.com/repbin/repbin/utils/repproto/structs"
+	"github.com/repbin/repbin/utils/socks"
+)
+
+// PeerRecords returns the signed peer records of server and its active peers.
+func (proto *Proto) PeerRecords(server, auth string) ([]structs.PeerRecord, error) {
+	return proto.PeerRecordsContext(context.Background(), server, auth)
+}
+
+// PeerRecordsContext returns the signed peer records of server and its active peers. The records
+// are not verified. Servers that do not speak the JSON protocol return ErrBadProto.
+func (proto *Proto) PeerRecordsContext(ctx context.Context, server, auth string) ([]structs.PeerRecord, error) {
+	if proto.protoVersion(ctx, server) < ProtoJSON {
+		return nil, ErrBadProto
+	}
+	body, err := socks.Proxy(proto.SocksServer).LimitGetContext(ctx, constructURL(server, "/v2/peers?auth=", auth), 512000)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := parseAPIResponse(body)
+	if err != nil {
+		return nil, err
+	}
+	return resp.PeerRecords, nil
+}
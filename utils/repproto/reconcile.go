@@ -0,0 +1,37 @@
+package repproto
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/repbin/repbin/utils/repproto/structs"
+	"github.com/repbin/repbin/utils/socks"
+)
+
+// Reconcile sends ranges of the own message set to server. It returns the ranges of the server
+// that differ and the start for the next global index call.
+func (proto *Proto) Reconcile(server, auth string, ranges []structs.ReconcileRange) ([]structs.ReconcileRange, uint64, error) {
+	return proto.ReconcileContext(context.Background(), server, auth, ranges)
+}
+
+// ReconcileContext sends ranges of the own message set to server. It returns the ranges of the
+// server that differ and the start for the next global index call. Servers that do not speak the
+// JSON protocol return ErrBadProto.
+func (proto *Proto) ReconcileContext(ctx context.Context, server, auth string, ranges []structs.ReconcileRange) ([]structs.ReconcileRange, uint64, error) {
+	if proto.protoVersion(ctx, server) < ProtoJSON {
+		return nil, 0, ErrBadProto
+	}
+	request, err := json.Marshal(&structs.ReconcileRequest{Ranges: ranges})
+	if err != nil {
+		return nil, 0, err
+	}
+	body, err := socks.Proxy(proto.SocksServer).LimitPostBytesContext(ctx, constructURL(server, "/v2/reconcile?auth=", auth), "application/json", request, 5242880)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := parseAPIResponse(body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Ranges, resp.Next, nil
+}
@@ -0,0 +1,119 @@
+package repproto
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+// ErrQuorum is returned if fewer servers than required accepted a message
+var ErrQuorum = errors.New("rep: Quorum not reached")
+
+// PostResult is the result of posting to a single server.
+type PostResult struct {
+	Server string // URL of the server
+	Err    error  // nil if the server accepted the message
+}
+
+// IsDuplicate returns true if err reports that the server already knows the message.
+func IsDuplicate(err error) bool {
+	serr, ok := err.(*ServerError)
+	if !ok {
+		return false
+	}
+	if serr.Code != "" {
+		return serr.Code == structs.CodeDuplicate
+	}
+	// Text protocol servers only return the message
+	return strings.Contains(serr.Message, "Duplicate")
+}
+
+// IsNotFound returns true if err reports that the server does not have the message.
+func IsNotFound(err error) bool {
+	serr, ok := err.(*ServerError)
+	if !ok {
+		return false
+	}
+	if serr.Code != "" {
+		return serr.Code == structs.CodeNotFound
+	}
+	return strings.Contains(serr.Message, "No data")
+}
+
+// PostReplicated posts message to replicas distinct servers concurrently. If server is not
+// empty it is always posted to, the remaining servers are taken from the selection order. A server
+// that fails is replaced by the next untried server. Servers that already know the message count
+// as accepting it. The results of all servers tried are returned, ErrQuorum is returned
+// if fewer than quorum servers accepted the message. If quorum < 1 all replicas are required,
+// but at most as many as servers are known. ErrQuorum is returned without posting if fewer
+// servers are known than an explicit quorum requires.
+func (proto *Proto) PostReplicated(ctx context.Context, server string, messageID, message []byte, replicas, quorum int) ([]PostResult, error) {
+	if replicas < 1 {
+		replicas = 1
+	}
+	selected, err := proto.selectAll(messageID)
+	if err != nil {
+		return nil, err
+	}
+	servers := make([]string, 0, len(selected)+1)
+	if server != "" {
+		servers = append(servers, server)
+	}
+	for _, s := range selected {
+		if s != server {
+			servers = append(servers, s)
+		}
+	}
+	if len(servers) == 0 {
+		return nil, ErrNoMoreServers
+	}
+	if quorum < 1 {
+		quorum = replicas
+		if quorum > len(servers) {
+			quorum = len(servers)
+		}
+	}
+	if quorum > replicas {
+		quorum = replicas
+	}
+	if quorum > len(servers) {
+		return nil, ErrQuorum
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan PostResult, len(servers))
+	call := func(server string) {
+		err := proto.PostSpecificContext(ctx, server, message)
+		if IsDuplicate(err) {
+			err = nil
+		}
+		results <- PostResult{Server: server, Err: err}
+	}
+	next, running := 0, 0
+	for ; next < len(servers) && next < replicas; next++ {
+		go call(servers[next])
+		running++
+	}
+	var done []PostResult
+	accepted := 0
+	for running > 0 {
+		res := <-results
+		running--
+		done = append(done, res)
+		if res.Err == nil {
+			accepted++
+			continue
+		}
+		if ctx.Err() == nil && next < len(servers) {
+			go call(servers[next])
+			next++
+			running++
+		}
+	}
+	if accepted < quorum {
+		return done, ErrQuorum
+	}
+	return done, nil
+}
@@ -0,0 +1,77 @@
+package repproto
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/repbin/repbin/utils/socks"
+)
+
+func TestIsDuplicate(t *testing.T) {
+	if !IsDuplicate(&ServerError{Message: "messagestore: Duplicate message"}) {
+		t.Error("Text duplicate not detected")
+	}
+	if !IsDuplicate(&ServerError{Code: "duplicate", Message: "messagestore: Duplicate message"}) {
+		t.Error("JSON duplicate not detected")
+	}
+	if IsDuplicate(&ServerError{Message: "Message too small"}) || IsDuplicate(ErrNoResponse) || IsDuplicate(nil) {
+		t.Error("False duplicate")
+	}
+}
+
+func TestPostReplicated(t *testing.T) {
+	defer func(accept bool) { socks.AcceptNoSocks = accept }(socks.AcceptNoSocks)
+	socks.AcceptNoSocks = true
+	accept := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("SUCCESS: Connection close\n"))
+	}))
+	defer accept.Close()
+	duplicate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ERROR: messagestore: Duplicate message\n"))
+	}))
+	defer duplicate.Close()
+	fail := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ERROR: HashCash\n"))
+	}))
+	defer fail.Close()
+
+	proto := New("", accept.URL, duplicate.URL, fail.URL)
+	proto.Version = ProtoText
+	results, err := proto.PostReplicated(context.Background(), "", nil, []byte("message"), 3, 2)
+	if err != nil {
+		t.Fatalf("PostReplicated: %s", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Bad number of results: %d", len(results))
+	}
+	for _, res := range results {
+		if (res.Err == nil) == (res.Server == fail.URL) {
+			t.Errorf("Bad result for %s: %v", res.Server, res.Err)
+		}
+	}
+	if _, err := proto.PostReplicated(context.Background(), "", nil, []byte("message"), 3, 0); err != ErrQuorum {
+		t.Errorf("Missing quorum not detected: %v", err)
+	}
+	// The default quorum is capped at the number of servers
+	if _, err := New("", accept.URL, duplicate.URL).PostReplicated(context.Background(), "", nil, []byte("message"), 3, 0); err != nil {
+		t.Errorf("Quorum not capped: %v", err)
+	}
+	// An explicit quorum above the number of servers fails before posting
+	if results, err := New("", accept.URL, duplicate.URL).PostReplicated(context.Background(), "", nil, []byte("message"), 3, 3); err != ErrQuorum || len(results) != 0 {
+		t.Errorf("Unreachable quorum not detected: %v %v", err, results)
+	}
+	// The given server is always posted to
+	for i := 0; i < 10; i++ {
+		results, _ := New("", accept.URL, duplicate.URL).PostReplicated(context.Background(), fail.URL, nil, []byte("message"), 1, 1)
+		if len(results) == 0 || results[0].Server != fail.URL {
+			t.Fatalf("Given server not posted to first: %v", results)
+		}
+	}
+	// Failed servers are replaced
+	results, err = proto.PostReplicated(context.Background(), "", nil, []byte("message"), 2, 2)
+	if err != nil {
+		t.Errorf("Failed server not replaced: %s %v", err, results)
+	}
+}
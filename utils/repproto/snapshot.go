@@ -0,0 +1,124 @@
+package repproto
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/repproto/structs"
+	"github.com/repbin/repbin/utils/socks"
+)
+
+// maxSnapshotLine is the maximum size of a snapshot record.
+const maxSnapshotLine = 1048576
+
+// ErrSnapshotTruncated is returned if a snapshot ends without trailer.
+var ErrSnapshotTruncated = errors.New("rep: Snapshot truncated")
+
+// Snapshot is a snapshot stream of a server.
+type Snapshot struct {
+	Head    uint64 // Highest global index position of the server when the snapshot was started
+	Resume  uint64 // Start parameter to continue the snapshot. Set after the trailer was read
+	More    bool   // The snapshot continues at Resume. Set after the trailer was read
+	body    io.ReadCloser
+	gz      *gzip.Reader
+	scanner *bufio.Scanner
+}
+
+// Snapshot requests a snapshot of the live messages of server beginning with global index
+// position start. auth is a proof token for the server.
+func (proto *Proto) Snapshot(server, auth string, start uint64) (*Snapshot, error) {
+	return proto.SnapshotContext(context.Background(), server, auth, start)
+}
+
+// SnapshotContext requests a snapshot of the live messages of server beginning with global index
+// position start. auth is a proof token for the server. The snapshot must be closed.
+func (proto *Proto) SnapshotContext(ctx context.Context, server, auth string, start uint64) (*Snapshot, error) {
+	if proto.protoVersion(ctx, server) < ProtoJSON {
+		return nil, ErrBadProto
+	}
+	resp, err := socks.Proxy(proto.SocksServer).GetContext(ctx, constructURL(server, "/v2/snapshot?auth=", auth, "&start=", strconv.FormatUint(start, 10)))
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/gzip") {
+		return nil, snapshotError(resp)
+	}
+	snapshot := &Snapshot{body: resp.Body}
+	if snapshot.gz, err = gzip.NewReader(resp.Body); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	snapshot.scanner = bufio.NewScanner(snapshot.gz)
+	snapshot.scanner.Buffer(make([]byte, 0, 65536), maxSnapshotLine)
+	header, err := snapshot.read()
+	if err != nil {
+		snapshot.Close()
+		return nil, err
+	}
+	if header.Version != structs.SnapshotVersion {
+		snapshot.Close()
+		return nil, ErrBadProto
+	}
+	snapshot.Head = header.Head
+	return snapshot, nil
+}
+
+// snapshotError returns the error of a failed snapshot request.
+func snapshotError(resp *http.Response) error {
+	defer resp.Body.Close()
+	body, err := utils.MaxRead(4096, resp.Body)
+	if err != nil {
+		return err
+	}
+	if _, err := parseAPIResponse(body); err != nil {
+		return err
+	}
+	return ErrBadProto
+}
+
+// read reads the next record.
+func (snapshot *Snapshot) read() (*structs.SnapshotRecord, error) {
+	if !snapshot.scanner.Scan() {
+		if err := snapshot.scanner.Err(); err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		return nil, ErrSnapshotTruncated
+	}
+	record := new(structs.SnapshotRecord)
+	if err := json.Unmarshal(snapshot.scanner.Bytes(), record); err != nil {
+		return nil, ErrBadProto
+	}
+	return record, nil
+}
+
+// Next returns the next message record of the snapshot, or nil after the trailer was read.
+func (snapshot *Snapshot) Next() (*structs.SnapshotRecord, error) {
+	record, err := snapshot.read()
+	if err != nil {
+		return nil, err
+	}
+	if record.End {
+		snapshot.Resume, snapshot.More = record.Next, record.More
+		return nil, nil
+	}
+	if record.Message == nil || record.Data == "" {
+		return nil, ErrBadProto
+	}
+	return record, nil
+}
+
+// Close closes the snapshot stream.
+func (snapshot *Snapshot) Close() error {
+	if snapshot.gz != nil {
+		snapshot.gz.Close()
+	}
+	return snapshot.body.Close()
+}
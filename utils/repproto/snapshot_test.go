@@ -0,0 +1,87 @@
+package repproto
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/repbin/repbin/utils/repproto/structs"
+	"github.com/repbin/repbin/utils/socks"
+)
+
+func TestSnapshot(t *testing.T) {
+	defer func(accept bool) { socks.AcceptNoSocks = accept }(socks.AcceptNoSocks)
+	socks.AcceptNoSocks = true
+	records := []structs.SnapshotRecord{
+		{Version: structs.SnapshotVersion, Head: 12},
+		{Message: &structs.IndexEntry{Counter: 3, MessageID: "a"}, Data: "data a"},
+		{Message: &structs.IndexEntry{Counter: 7, MessageID: "b"}, Data: "data b"},
+		{End: true, Next: 8, More: true},
+	}
+	truncate := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("auth") != "token" {
+			w.Write([]byte(`{"Version":2,"Error":{"Code":"authfailed","Message":"Authentication failure"}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		enc := json.NewEncoder(gz)
+		for i, record := range records {
+			if truncate && i == len(records)-1 {
+				return
+			}
+			enc.Encode(&record)
+		}
+	}))
+	defer ts.Close()
+
+	proto := New("", ts.URL)
+	proto.Version = ProtoJSON
+	if _, err := proto.Snapshot(ts.URL, "bad", 0); err == nil {
+		t.Error("Server error not returned")
+	}
+	snapshot, err := proto.Snapshot(ts.URL, "token", 0)
+	if err != nil {
+		t.Fatalf("Snapshot: %s", err)
+	}
+	if snapshot.Head != 12 {
+		t.Errorf("Bad head: %d", snapshot.Head)
+	}
+	var counters []uint64
+	for {
+		record, err := snapshot.Next()
+		if err != nil {
+			t.Fatalf("Next: %s", err)
+		}
+		if record == nil {
+			break
+		}
+		counters = append(counters, record.Message.Counter)
+	}
+	snapshot.Close()
+	if len(counters) != 2 || counters[0] != 3 || counters[1] != 7 {
+		t.Errorf("Bad records: %v", counters)
+	}
+	if snapshot.Resume != 8 || !snapshot.More {
+		t.Errorf("Bad trailer: %d %t", snapshot.Resume, snapshot.More)
+	}
+	truncate = true
+	snapshot, err = proto.Snapshot(ts.URL, "token", 0)
+	if err != nil {
+		t.Fatalf("Snapshot: %s", err)
+	}
+	defer snapshot.Close()
+	for {
+		record, err := snapshot.Next()
+		if err == ErrSnapshotTruncated {
+			break
+		}
+		if err != nil || record == nil {
+			t.Fatalf("Truncation not detected: %v", err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package structs
+
+import (
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+)
+
+// APIVersion is the version of the JSON API served under /v2/.
+const APIVersion = 2
+
+// Error codes returned by the JSON API.
+const (
+	CodeBadParam     = "bad_param"     // A parameter could not be parsed
+	CodeMissingParam = "missing_param" // A required parameter is missing
+	CodeBadMethod    = "bad_method"    // Wrong HTTP method
+	CodeAuthRequired = "auth_required" // Authentication is required but missing
+	CodeAuthFailed   = "auth_failed"   // Authentication did not verify
+	CodeAuthExpired  = "auth_expired"  // Authentication is too old or too young
+	CodeBadPeer      = "bad_peer"      // The peer is unknown
+	CodeNotFound     = "not_found"     // The message does not exist
+	CodeTooBig       = "too_big"       // The message is too big
+	CodeTooSmall     = "too_small"     // The message is too small
+	CodeBadMessage   = "bad_message"   // The message failed verification
+	CodeHashCash     = "hashcash"      // The signature or hashcash failed verification
+	CodeDuplicate    = "duplicate"     // The message is already known
+	CodePostLimit    = "post_limit"    // The signer has reached its limits
+	CodeDeleted      = "deleted"       // The message was deleted by a tombstone
+	CodeBusy         = "busy"          // The server is busy, retry later
+	CodeInternal     = "internal"      // Any other error
+)
+
+// Operations that key authentications of recipients are bound to, see keyauth.AnswerOperation.
+// Delete is the only recipient operation of the server. Retention extension and post-box policy
+// changes do not exist yet, they must define their own operation when they are added.
+const (
+	OpDelete = "delete" // Delete a message
+)
+
+// APIError is the error returned by the JSON API.
+type APIError struct {
+	Code    string // One of the Code* constants
+	Message string // Human readable message
+}
+
+// Error returns the message of the error.
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// IndexEntry is a MessageStruct as represented in the JSON API.
+type IndexEntry struct {
+	Counter                uint64
+	PostTime               uint64
+	ExpireTime             uint64
+	ExpireRequest          uint64
+	MessageID              string
+	ReceiverConstantPubKey string
+	SignerPub              string
+	Distance               uint64
+	OneTime                bool
+	Sync                   bool
+	Hidden                 bool
+}
+
+// APIResponse is the response of the JSON API.
+type APIResponse struct {
+	Version       int
+	Error         *APIError        `json:",omitempty"`
+	Messages      []IndexEntry     `json:",omitempty"` // Index entries
+	Next          uint64           `json:",omitempty"` // Start parameter for the next index call
+	More          bool             `json:",omitempty"` // More index entries may be available
+	MessageID     string           `json:",omitempty"` // ID of a posted or fetched message
+	Data          string           `json:",omitempty"` // The fetched message
+	Ranges        []ReconcileRange `json:",omitempty"` // Differing ranges of a reconcile call
+	Tombstones    []string         `json:",omitempty"` // Encoded tombstones of a global index call
+	NextTombstone uint64           `json:",omitempty"` // Tombstone start parameter for the next global index call
+	TombstoneIDs  []uint64         `json:",omitempty"` // Positions of the tombstones of a global index call
+	PeerRecords   []PeerRecord     `json:",omitempty"` // Signed records of the server and its peers
+}
+
+// NewIndexEntry converts a MessageStruct to an IndexEntry.
+func NewIndexEntry(ms *MessageStruct) IndexEntry {
+	return IndexEntry{
+		Counter:                ms.Counter,
+		PostTime:               ms.PostTime,
+		ExpireTime:             ms.ExpireTime,
+		ExpireRequest:          ms.ExpireRequest,
+		MessageID:              utils.B58encode(ms.MessageID[:]),
+		ReceiverConstantPubKey: utils.B58encode(ms.ReceiverConstantPubKey[:]),
+		SignerPub:              utils.B58encode(ms.SignerPub[:]),
+		Distance:               ms.Distance,
+		OneTime:                ms.OneTime,
+		Sync:                   ms.Sync,
+		Hidden:                 ms.Hidden,
+	}
+}
+
+// MessageStruct converts an IndexEntry to a MessageStruct. Returns nil on decoding errors.
+func (ie IndexEntry) MessageStruct() *MessageStruct {
+	ms := &MessageStruct{
+		Counter:       ie.Counter,
+		PostTime:      ie.PostTime,
+		ExpireTime:    ie.ExpireTime,
+		ExpireRequest: ie.ExpireRequest,
+		Distance:      ie.Distance,
+		OneTime:       ie.OneTime,
+		Sync:          ie.Sync,
+		Hidden:        ie.Hidden,
+	}
+	messageID := utils.B58decode(ie.MessageID)
+	receiver := utils.B58decode(ie.ReceiverConstantPubKey)
+	signer := utils.B58decode(ie.SignerPub)
+	if len(messageID) != message.MessageIDSize || len(receiver) != message.Curve25519KeySize || len(signer) != message.SignerPubKeySize {
+		return nil
+	}
+	copy(ms.MessageID[:], messageID)
+	copy(ms.ReceiverConstantPubKey[:], receiver)
+	copy(ms.SignerPub[:], signer)
+	return ms
+}
@@ -0,0 +1,51 @@
+package structs
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/repbin/repbin/message"
+)
+
+func TestIndexEntry(t *testing.T) {
+	td := MessageStruct{
+		Counter:                7,
+		PostTime:               5,
+		ExpireTime:             ^uint64(0),
+		ExpireRequest:          100,
+		MessageID:              [message.MessageIDSize]byte{0x00, 0x01, 0x00, 0x02},
+		ReceiverConstantPubKey: message.Curve25519Key{0x01, 0x02, 0x03, 0x04},
+		SignerPub:              [message.SignerPubKeySize]byte{0xff, 0x01, 0x00, 0xaa},
+		Distance:               3,
+		OneTime:                true,
+		Hidden:                 true,
+	}
+	resp := &APIResponse{
+		Version:  APIVersion,
+		Messages: []IndexEntry{NewIndexEntry(&td)},
+	}
+	d, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatalf("Marshal: %s", err)
+	}
+	dec := new(APIResponse)
+	if err := json.Unmarshal(d, dec); err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if dec.Error != nil {
+		t.Error("Error must be omitted")
+	}
+	if len(dec.Messages) != 1 {
+		t.Fatalf("Bad entry count: %d", len(dec.Messages))
+	}
+	ms := dec.Messages[0].MessageStruct()
+	if ms == nil {
+		t.Fatal("Decode failed")
+	}
+	if *ms != td {
+		t.Errorf("Roundtrip failed: %v", ms)
+	}
+	if (IndexEntry{MessageID: "x"}).MessageStruct() != nil {
+		t.Error("Bad entry not detected")
+	}
+}
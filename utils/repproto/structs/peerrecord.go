@@ -0,0 +1,88 @@
+package structs
+
+import (
+	"encoding/binary"
+
+	"github.com/agl/ed25519"
+	"github.com/repbin/repbin/utils"
+)
+
+// Trust policies of servers for discovered peers.
+const (
+	TrustOff    = "off"    // Peers are not discovered
+	TrustManual = "manual" // The operator approves discovered peers
+	TrustTOFU   = "tofu"   // Discovered peers are approved when first seen
+	TrustWoT    = "wot"    // Discovered peers are approved when announced by enough peers
+)
+
+var peerRecordContext = []byte("repbin peer record")
+
+// PeerRecord is the signed announcement of a server for peering.
+type PeerRecord struct {
+	URL       string // URL of the server
+	PubKey    string // Peering public key (ed25519) of the server, B58 encoded
+	IsHub     bool   // The server is a hub
+	Policy    string // Trust policy of the server for discovered peers
+	Time      int64  // Time of signature
+	Signature string // Signature by PubKey, B58 encoded
+}
+
+// NewPeerRecord returns a peer record signed by the peering key.
+func NewPeerRecord(url string, isHub bool, policy string, time int64, pubKey *[ed25519.PublicKeySize]byte, privKey *[ed25519.PrivateKeySize]byte) *PeerRecord {
+	pr := &PeerRecord{
+		URL:    url,
+		PubKey: utils.B58encode(pubKey[:]),
+		IsHub:  isHub,
+		Policy: policy,
+		Time:   time,
+	}
+	sig := ed25519.Sign(privKey, pr.signedData())
+	pr.Signature = utils.B58encode(sig[:])
+	return pr
+}
+
+// signedData returns the data covered by the signature.
+func (pr *PeerRecord) signedData() []byte {
+	var t [8]byte
+	out := make([]byte, 0, len(peerRecordContext)+len(pr.URL)+len(pr.PubKey)+len(pr.Policy)+12)
+	out = append(out, peerRecordContext...)
+	out = append(out, pr.URL...)
+	out = append(out, 0x00)
+	out = append(out, pr.PubKey...)
+	out = append(out, 0x00)
+	if pr.IsHub {
+		out = append(out, 0x01)
+	} else {
+		out = append(out, 0x00)
+	}
+	out = append(out, pr.Policy...)
+	out = append(out, 0x00)
+	binary.BigEndian.PutUint64(t[:], uint64(pr.Time))
+	return append(out, t[:]...)
+}
+
+// PublicKey returns the decoded peering public key. Returns nil on decoding errors.
+func (pr *PeerRecord) PublicKey() *[ed25519.PublicKeySize]byte {
+	var pubKey [ed25519.PublicKeySize]byte
+	d := utils.B58decode(pr.PubKey)
+	if len(d) != ed25519.PublicKeySize {
+		return nil
+	}
+	copy(pubKey[:], d)
+	return &pubKey
+}
+
+// Verify returns true if the record is signed by its public key.
+func (pr *PeerRecord) Verify() bool {
+	var sig [ed25519.SignatureSize]byte
+	pubKey := pr.PublicKey()
+	if pubKey == nil || pr.URL == "" {
+		return false
+	}
+	d := utils.B58decode(pr.Signature)
+	if len(d) != ed25519.SignatureSize {
+		return false
+	}
+	copy(sig[:], d)
+	return ed25519.Verify(pubKey, pr.signedData(), &sig)
+}
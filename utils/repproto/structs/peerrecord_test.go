@@ -0,0 +1,43 @@
+package structs
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/agl/ed25519"
+)
+
+func TestPeerRecord(t *testing.T) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+	pr := NewPeerRecord("http://example.onion/", true, TrustTOFU, 1000, pubKey, privKey)
+	if !pr.Verify() {
+		t.Fatal("Verify failed")
+	}
+	if *pr.PublicKey() != *pubKey {
+		t.Error("PublicKey wrong")
+	}
+	changed := *pr
+	changed.URL = "http://other.onion/"
+	if changed.Verify() {
+		t.Error("Verify must fail for changed URL")
+	}
+	changed = *pr
+	changed.IsHub = false
+	if changed.Verify() {
+		t.Error("Verify must fail for changed hub flag")
+	}
+	changed = *pr
+	changed.Time++
+	if changed.Verify() {
+		t.Error("Verify must fail for changed time")
+	}
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	changed = *pr
+	changed.PubKey = NewPeerRecord(pr.URL, true, TrustTOFU, 1000, otherPub, privKey).PubKey
+	if changed.Verify() {
+		t.Error("Verify must fail for other key")
+	}
+}
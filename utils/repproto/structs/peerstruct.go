@@ -29,6 +29,13 @@ type PeerStruct struct {
 	LastFetch      uint64                              // When did we last fetch from this peer
 	ErrorCount     uint64                              // Number of errors occured
 	LastPosition   uint64                              // Last successful position in download
+	LastTombstone  uint64                              // Position of the last tombstone downloaded. Not encoded
+	// Health of the peer. Not encoded
+	FailCount        uint64 // Consecutive failures to reach the peer
+	RetryAfter       uint64 // Time before which the peer is not contacted. Next probe if quarantined
+	QuarantinedSince uint64 // Time the peer was quarantined, 0 if not quarantined
+	LastError        string // Reason of the last failure
+	LastErrorTime    uint64 // Time of the last failure
 }
 
 // PeerStructEncoded represents an encoded PeerStruct
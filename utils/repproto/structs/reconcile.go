@@ -0,0 +1,130 @@
+package structs
+
+import (
+	"encoding/hex"
+	"sort"
+
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+)
+
+// Set reconciliation between peers. The MessageIDs of a server are split into ranges by the
+// prefix of their hex encoding. A range is described by the number of messages in it and the
+// XOR of their MessageIDs. Equal ranges are skipped, differing ranges are split into subranges
+// until they are small enough to be exchanged as a list of entries.
+
+const (
+	// ReconcileLeafSize is the maximum number of messages of a range that is sent as list of entries.
+	ReconcileLeafSize = 32
+	// ReconcileMaxRanges is the maximum number of ranges in a reconcile request.
+	ReconcileMaxRanges = 64
+)
+
+// ReconcileEntry is a message in a range sent as list.
+type ReconcileEntry struct {
+	MessageID  string
+	ExpireTime uint64
+	PostTime   uint64 `json:",omitempty"`
+	Distance   uint64 `json:",omitempty"`
+}
+
+// ReconcileRange describes the messages whose hex MessageID begins with Prefix.
+type ReconcileRange struct {
+	Prefix      string           // Hex prefix of the MessageIDs
+	Count       uint64           // Number of messages in the range
+	Fingerprint string           // Hex encoded XOR of the MessageIDs
+	Entries     []ReconcileEntry `json:",omitempty"` // The messages if the range is small enough
+}
+
+// ReconcileRequest is the body of a reconcile call.
+type ReconcileRequest struct {
+	Ranges []ReconcileRange
+}
+
+// NewReconcileRange returns the range for prefix that contains messages. Only MessageID is used.
+func NewReconcileRange(prefix string, messages []*MessageStruct) ReconcileRange {
+	var fingerprint [message.MessageIDSize]byte
+	for _, msg := range messages {
+		for i := range fingerprint {
+			fingerprint[i] ^= msg.MessageID[i]
+		}
+	}
+	return ReconcileRange{
+		Prefix:      prefix,
+		Count:       uint64(len(messages)),
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+	}
+}
+
+// Equal returns true if both ranges have the same count and fingerprint.
+func (r ReconcileRange) Equal(o ReconcileRange) bool {
+	return r.Count == o.Count && r.Fingerprint == o.Fingerprint
+}
+
+// WithEntries returns the range with the entries of messages. Uses MessageID, ExpireTime, PostTime and Distance.
+func (r ReconcileRange) WithEntries(messages []*MessageStruct) ReconcileRange {
+	r.Entries = make([]ReconcileEntry, 0, len(messages))
+	for _, msg := range messages {
+		r.Entries = append(r.Entries, ReconcileEntry{
+			MessageID:  utils.B58encode(msg.MessageID[:]),
+			ExpireTime: msg.ExpireTime,
+			PostTime:   msg.PostTime,
+			Distance:   msg.Distance,
+		})
+	}
+	return r
+}
+
+// MessageStructs converts the entries of a range. Returns nil on decoding errors.
+func (r ReconcileRange) MessageStructs() []*MessageStruct {
+	messages := make([]*MessageStruct, 0, len(r.Entries))
+	for _, entry := range r.Entries {
+		messageID := utils.B58decode(entry.MessageID)
+		if len(messageID) != message.MessageIDSize {
+			return nil
+		}
+		msg := &MessageStruct{ExpireTime: entry.ExpireTime, PostTime: entry.PostTime, Distance: entry.Distance}
+		copy(msg.MessageID[:], messageID)
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// SplitReconcileRange splits the messages of the range prefix into the non-empty subranges
+// one hex digit longer. It returns the subranges and their messages.
+func SplitReconcileRange(prefix string, messages []*MessageStruct) ([]ReconcileRange, [][]*MessageStruct) {
+	parts := make(map[string][]*MessageStruct)
+	for _, msg := range messages {
+		msgHex := hex.EncodeToString(msg.MessageID[:])
+		if len(msgHex) <= len(prefix) || msgHex[:len(prefix)] != prefix {
+			continue
+		}
+		sub := msgHex[:len(prefix)+1]
+		parts[sub] = append(parts[sub], msg)
+	}
+	prefixes := make([]string, 0, len(parts))
+	for sub := range parts {
+		prefixes = append(prefixes, sub)
+	}
+	sort.Strings(prefixes)
+	ranges := make([]ReconcileRange, 0, len(prefixes))
+	subMessages := make([][]*MessageStruct, 0, len(prefixes))
+	for _, sub := range prefixes {
+		ranges = append(ranges, NewReconcileRange(sub, parts[sub]))
+		subMessages = append(subMessages, parts[sub])
+	}
+	return ranges, subMessages
+}
+
+// ValidReconcilePrefix returns true if prefix is a lower case hex prefix of a MessageID.
+func ValidReconcilePrefix(prefix string) bool {
+	if len(prefix) > message.MessageIDSize*2 {
+		return false
+	}
+	for _, c := range prefix {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
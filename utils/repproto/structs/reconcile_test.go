@@ -0,0 +1,81 @@
+package structs
+
+import (
+	"testing"
+
+	"github.com/repbin/repbin/message"
+)
+
+func testReconcileMessages() []*MessageStruct {
+	var messages []*MessageStruct
+	for i := 0; i < 40; i++ {
+		msg := &MessageStruct{ExpireTime: uint64(i)}
+		msg.MessageID = [message.MessageIDSize]byte{0xab, byte(i), byte(i * 7)}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func TestReconcileRange(t *testing.T) {
+	messages := testReconcileMessages()
+	r1 := NewReconcileRange("ab", messages)
+	if r1.Count != 40 {
+		t.Errorf("Bad count: %d", r1.Count)
+	}
+	r2 := NewReconcileRange("ab", messages[1:])
+	if r1.Equal(r2) {
+		t.Error("Ranges with different messages must differ")
+	}
+	reversed := make([]*MessageStruct, 0, len(messages))
+	for i := len(messages) - 1; i >= 0; i-- {
+		reversed = append(reversed, messages[i])
+	}
+	if !r1.Equal(NewReconcileRange("ab", reversed)) {
+		t.Error("Fingerprint must not depend on order")
+	}
+	empty := NewReconcileRange("", nil)
+	if empty.Count != 0 || empty.Fingerprint != "0000000000000000000000000000000000000000000000000000000000000000" {
+		t.Errorf("Bad empty range: %+v", empty)
+	}
+	decoded := r2.WithEntries(messages[1:3]).MessageStructs()
+	if len(decoded) != 2 || decoded[0].MessageID != messages[1].MessageID || decoded[1].ExpireTime != 2 {
+		t.Error("Entries do not decode")
+	}
+	r2.Entries = []ReconcileEntry{{MessageID: "x"}}
+	if r2.MessageStructs() != nil {
+		t.Error("Bad entry must not decode")
+	}
+}
+
+func TestSplitReconcileRange(t *testing.T) {
+	messages := testReconcileMessages()
+	ranges, parts := SplitReconcileRange("ab", messages)
+	if len(ranges) != 3 || len(parts) != 3 {
+		t.Fatalf("Bad split: %d", len(ranges))
+	}
+	// Second bytes 0x00-0x0f, 0x10-0x1f, 0x20-0x27
+	if ranges[0].Prefix != "ab0" || ranges[1].Prefix != "ab1" || ranges[2].Prefix != "ab2" {
+		t.Errorf("Bad prefixes: %s %s %s", ranges[0].Prefix, ranges[1].Prefix, ranges[2].Prefix)
+	}
+	if ranges[2].Count != 8 || len(parts[2]) != 8 || !ranges[2].Equal(NewReconcileRange("ab2", messages[32:])) {
+		t.Errorf("Bad subrange: %+v", ranges[2])
+	}
+	if ranges, _ := SplitReconcileRange("cd", messages); len(ranges) != 0 {
+		t.Error("Messages outside of the range must be ignored")
+	}
+}
+
+func TestValidReconcilePrefix(t *testing.T) {
+	for prefix, valid := range map[string]bool{
+		"":                       true,
+		"0af9":                   true,
+		"0AF9":                   false,
+		"0g":                     false,
+		"%":                      false,
+		string(make([]byte, 65)): false,
+	} {
+		if ValidReconcilePrefix(prefix) != valid {
+			t.Errorf("ValidReconcilePrefix(%q) != %t", prefix, valid)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package structs
+
+// SnapshotVersion is the version of the snapshot format.
+const SnapshotVersion = 1
+
+// SnapshotRecord is a line of a snapshot. Snapshots are gzip compressed JSON lines: a header with
+// Version and Head, one record per message with Message and Data, and a trailer with End set.
+// Snapshots without trailer were truncated and can be resumed at the last Message.Counter + 1.
+type SnapshotRecord struct {
+	Version int         `json:",omitempty"` // Header: SnapshotVersion
+	Head    uint64      `json:",omitempty"` // Header: Highest global index position when the snapshot was started
+	Message *IndexEntry `json:",omitempty"` // Global index entry of a message
+	Data    string      `json:",omitempty"` // The message
+	End     bool        `json:",omitempty"` // Trailer
+	Next    uint64      `json:",omitempty"` // Trailer: Start parameter to continue the snapshot
+	More    bool        `json:",omitempty"` // Trailer: The snapshot continues at Next
+}
@@ -0,0 +1,151 @@
+package structs
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+
+	"github.com/agl/ed25519"
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils/xeddsa"
+)
+
+const (
+	// TombstoneSigner marks a tombstone signed by the ed25519 SignerPub of the message
+	TombstoneSigner = 1
+	// TombstoneRecipient marks a tombstone signed by the curve25519 key of the ReceiverConstantPubKey of the message
+	TombstoneRecipient = 2
+	// TombstoneExpire marks the expiry record of a server, signed by the ed25519 peering key of the
+	// server. Time is the time the message expired on that server
+	TombstoneExpire = 3
+	// TombstoneSize is the size of an encoded tombstone
+	// MessageID        32
+	// AuthType          1
+	// PublicKey        32
+	// Time              8
+	// Signature        64
+	// ------------------
+	//                 137
+	TombstoneSize = message.MessageIDSize + 1 + 32 + 8 + ed25519.SignatureSize
+)
+
+var tombstoneContext = []byte("repbin tombstone")
+
+// TombstoneStruct is the signed deletion of a message. Servers delete the message and refuse
+// to store it again while they keep the tombstone.
+type TombstoneStruct struct {
+	MessageID [message.MessageIDSize]byte // The deleted message
+	AuthType  byte                        // TombstoneSigner, TombstoneRecipient or TombstoneExpire
+	PublicKey [32]byte                    // SignerPub or ReceiverConstantPubKey of the message, or peering key
+	Time      uint64                      // Time of deletion
+	Signature [ed25519.SignatureSize]byte // Signature by PublicKey
+}
+
+// NewSignerTombstone returns a tombstone for messageID signed by the signer of the message.
+func NewSignerTombstone(messageID *[message.MessageIDSize]byte, time uint64, signer *message.SignKeyPair) *TombstoneStruct {
+	ts := &TombstoneStruct{
+		MessageID: *messageID,
+		AuthType:  TombstoneSigner,
+		PublicKey: *signer.PublicKey,
+		Time:      time,
+	}
+	ts.Signature = *ed25519.Sign(signer.PrivateKey, ts.signedData())
+	return ts
+}
+
+// NewRecipientTombstone returns a tombstone for messageID signed by the constant private key of the recipient.
+func NewRecipientTombstone(messageID *[message.MessageIDSize]byte, time uint64, privateKey *message.Curve25519Key) (*TombstoneStruct, error) {
+	ts := &TombstoneStruct{
+		MessageID: *messageID,
+		AuthType:  TombstoneRecipient,
+		PublicKey: *message.GenPubKey(privateKey),
+		Time:      time,
+	}
+	privKey := [xeddsa.PrivateKeySize]byte(*privateKey)
+	sig, err := xeddsa.Sign(rand.Reader, &privKey, ts.signedData())
+	if err != nil {
+		return nil, err
+	}
+	ts.Signature = *sig
+	return ts, nil
+}
+
+// NewExpireTombstone returns the expiry record of messageID signed by the peering key of a server.
+func NewExpireTombstone(messageID *[message.MessageIDSize]byte, time uint64, publicKey *[ed25519.PublicKeySize]byte, privateKey *[ed25519.PrivateKeySize]byte) *TombstoneStruct {
+	ts := &TombstoneStruct{
+		MessageID: *messageID,
+		AuthType:  TombstoneExpire,
+		PublicKey: *publicKey,
+		Time:      time,
+	}
+	ts.Signature = *ed25519.Sign(privateKey, ts.signedData())
+	return ts
+}
+
+// signedData returns the data covered by the signature.
+func (ts *TombstoneStruct) signedData() []byte {
+	out := make([]byte, 0, len(tombstoneContext)+TombstoneSize-ed25519.SignatureSize)
+	out = append(out, tombstoneContext...)
+	out = append(out, ts.MessageID[:]...)
+	out = append(out, ts.AuthType)
+	out = append(out, ts.PublicKey[:]...)
+	var t [8]byte
+	binary.BigEndian.PutUint64(t[:], ts.Time)
+	return append(out, t[:]...)
+}
+
+// Verify returns true if the tombstone is signed by its PublicKey.
+func (ts *TombstoneStruct) Verify() bool {
+	switch ts.AuthType {
+	case TombstoneSigner, TombstoneExpire:
+		return ed25519.Verify(&ts.PublicKey, ts.signedData(), &ts.Signature)
+	case TombstoneRecipient:
+		return xeddsa.Verify(&ts.PublicKey, ts.signedData(), &ts.Signature)
+	}
+	return false
+}
+
+// Authorizes returns true if the tombstone was issued for msg by its signer or recipient. An
+// expiry record authorizes copies of msg that were not requested to be kept beyond its Time,
+// the signer of the record is not checked. The signature is not verified.
+func (ts *TombstoneStruct) Authorizes(msg *MessageStruct) bool {
+	if ts.MessageID != msg.MessageID {
+		return false
+	}
+	switch ts.AuthType {
+	case TombstoneSigner:
+		return ts.PublicKey == msg.SignerPub
+	case TombstoneRecipient:
+		return ts.PublicKey == [32]byte(msg.ReceiverConstantPubKey)
+	case TombstoneExpire:
+		return msg.ExpireRequest <= ts.Time
+	}
+	return false
+}
+
+// Encode a tombstone into binary form
+func (ts *TombstoneStruct) Encode() []byte {
+	out := make([]byte, 0, TombstoneSize)
+	out = append(out, ts.MessageID[:]...)
+	out = append(out, ts.AuthType)
+	out = append(out, ts.PublicKey[:]...)
+	var t [8]byte
+	binary.BigEndian.PutUint64(t[:], ts.Time)
+	out = append(out, t[:]...)
+	return append(out, ts.Signature[:]...)
+}
+
+// TombstoneDecode decodes an encoded tombstone. Returns nil on error.
+func TombstoneDecode(d []byte) *TombstoneStruct {
+	if len(d) != TombstoneSize {
+		return nil
+	}
+	ts := new(TombstoneStruct)
+	cur := copy(ts.MessageID[:], d)
+	ts.AuthType = d[cur]
+	cur++
+	cur += copy(ts.PublicKey[:], d[cur:])
+	ts.Time = binary.BigEndian.Uint64(d[cur : cur+8])
+	cur += 8
+	copy(ts.Signature[:], d[cur:])
+	return ts
+}
@@ -0,0 +1,102 @@
+package structs
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/agl/ed25519"
+	"github.com/repbin/repbin/message"
+)
+
+func TestTombstone(t *testing.T) {
+	signer, err := message.GenKey(8)
+	if err != nil {
+		t.Fatalf("GenKey: %s", err)
+	}
+	recipient, err := message.GenLongTermKey(false, false)
+	if err != nil {
+		t.Fatalf("GenLongTermKey: %s", err)
+	}
+	msg := &MessageStruct{
+		MessageID:              [message.MessageIDSize]byte{0x01, 0x02, 0x03},
+		ReceiverConstantPubKey: *message.GenPubKey(recipient),
+		SignerPub:              *signer.PublicKey,
+	}
+	bySigner := NewSignerTombstone(&msg.MessageID, 100, signer)
+	byRecipient, err := NewRecipientTombstone(&msg.MessageID, 200, recipient)
+	if err != nil {
+		t.Fatalf("NewRecipientTombstone: %s", err)
+	}
+	for _, ts := range []*TombstoneStruct{bySigner, byRecipient} {
+		d := ts.Encode()
+		if len(d) != TombstoneSize {
+			t.Errorf("Bad size: %d", len(d))
+		}
+		ts2 := TombstoneDecode(d)
+		if ts2 == nil || *ts2 != *ts {
+			t.Fatal("Decode does not match")
+		}
+		if !ts2.Verify() {
+			t.Error("Tombstone does not verify")
+		}
+		if !ts2.Authorizes(msg) {
+			t.Error("Tombstone not authorized")
+		}
+		ts2.Time++
+		if ts2.Verify() {
+			t.Error("Modified tombstone verifies")
+		}
+	}
+	other := *msg
+	other.SignerPub[0] ^= 0x01
+	if bySigner.Authorizes(&other) {
+		t.Error("Signer tombstone authorizes other signer")
+	}
+	other.MessageID[0] ^= 0x01
+	if byRecipient.Authorizes(&other) {
+		t.Error("Tombstone authorizes other message")
+	}
+	if TombstoneDecode(bySigner.Encode()[1:]) != nil {
+		t.Error("Short tombstone decoded")
+	}
+}
+
+func TestExpireTombstone(t *testing.T) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+	msg := &MessageStruct{
+		MessageID:     [message.MessageIDSize]byte{0x01, 0x02, 0x03},
+		ExpireRequest: 100,
+	}
+	ts := NewExpireTombstone(&msg.MessageID, 100, pubKey, privKey)
+	ts2 := TombstoneDecode(ts.Encode())
+	if ts2 == nil || *ts2 != *ts {
+		t.Fatal("Decode does not match")
+	}
+	if !ts2.Verify() {
+		t.Error("Expiry record does not verify")
+	}
+	if !ts2.Authorizes(msg) {
+		t.Error("Expiry record not authorized")
+	}
+	msg.ExpireRequest++
+	if ts2.Authorizes(msg) {
+		t.Error("Expiry record authorizes message requested to be kept longer")
+	}
+	ts2.Time++
+	if ts2.Verify() {
+		t.Error("Modified expiry record verifies")
+	}
+	ts2.Time--
+	ts2.AuthType = TombstoneSigner
+	if ts2.Verify() {
+		t.Error("Expiry record verifies as signer tombstone")
+	}
+	ts2.AuthType = TombstoneExpire
+	ts2.MessageID[0] ^= 0x01
+	if ts2.Authorizes(&MessageStruct{MessageID: msg.MessageID}) {
+		t.Error("Expiry record authorizes other message")
+	}
+}
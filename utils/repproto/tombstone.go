@@ -0,0 +1,111 @@
+package repproto
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/listparse"
+	"github.com/repbin/repbin/utils/repproto/structs"
+	"github.com/repbin/repbin/utils/socks"
+)
+
+// GlobalIndex is a part of the global index of a server.
+type GlobalIndex struct {
+	Messages      []*structs.MessageStruct   // Index entries
+	More          bool                       // More entries or tombstones may be available
+	Tombstones    []*structs.TombstoneStruct // Tombstones
+	NextTombstone uint64                     // Tombstone start for the next call
+	Positions     []uint64                   // Positions of Tombstones. Empty if the server does not send them
+}
+
+// GetGlobalIndexTombstones returns the global index of a server including tombstones
+func (proto *Proto) GetGlobalIndexTombstones(server, auth string, start, count int, tombstoneStart uint64) (*GlobalIndex, error) {
+	return proto.GetGlobalIndexTombstonesContext(context.Background(), server, auth, start, count, tombstoneStart)
+}
+
+// GetGlobalIndexTombstonesContext returns the global index of a server beginning at start and
+// the tombstones beginning at tombstoneStart. Servers of the text protocol do not return tombstones.
+func (proto *Proto) GetGlobalIndexTombstonesContext(ctx context.Context, server, auth string, start, count int, tombstoneStart uint64) (*GlobalIndex, error) {
+	version := proto.protoVersion(ctx, server)
+	if version < ProtoJSON {
+		messages, more, err := proto.GetGlobalIndexContext(ctx, server, auth, start, count)
+		if err != nil && err != listparse.ErrNoEntries {
+			return nil, err
+		}
+		return &GlobalIndex{Messages: messages, More: more, NextTombstone: tombstoneStart}, nil
+	}
+	url := constructURL(server, "/v2/globalindex?auth=", auth, "&start=", strconv.Itoa(start), "&count=", strconv.Itoa(count), "&tombstones=", strconv.FormatUint(tombstoneStart, 10))
+	body, err := socks.Proxy(proto.SocksServer).LimitGetContext(ctx, url, 5242880)
+	if err != nil {
+		return nil, err
+	}
+	messages, more, err := parseAPIListResponse(body)
+	if err != nil && err != listparse.ErrNoEntries {
+		return nil, err
+	}
+	resp, _ := parseAPIResponse(body)
+	index := &GlobalIndex{Messages: messages, More: more, NextTombstone: resp.NextTombstone}
+	if len(resp.TombstoneIDs) == len(resp.Tombstones) {
+		index.Positions = resp.TombstoneIDs
+	}
+	if err == listparse.ErrNoEntries {
+		index.More = resp.More
+	}
+	for _, encoded := range resp.Tombstones {
+		ts := structs.TombstoneDecode(utils.B58decode(encoded))
+		if ts == nil {
+			return nil, listparse.ErrSomeErrors
+		}
+		index.Tombstones = append(index.Tombstones, ts)
+	}
+	if index.NextTombstone < tombstoneStart {
+		index.NextTombstone = tombstoneStart
+	}
+	return index, nil
+}
+
+// DeleteSpecific deletes a message from server. privKey is the private key of the recipient, it
+// is only used to authenticate the delete and to sign the tombstone for the peers of server
+func (proto *Proto) DeleteSpecific(server string, messageID *[message.MessageIDSize]byte, privKey *message.Curve25519Key) error {
+	return proto.DeleteSpecificContext(context.Background(), server, messageID, privKey)
+}
+
+// DeleteSpecificContext deletes a message from server
+func (proto *Proto) DeleteSpecificContext(ctx context.Context, server string, messageID *[message.MessageIDSize]byte, privKey *message.Curve25519Key) error {
+	auth, err := proto.AuthOperationContext(ctx, server, privKey[:], structs.OpDelete, messageID[:])
+	if err != nil {
+		return err
+	}
+	tombstone, err := structs.NewRecipientTombstone(messageID, uint64(time.Now().Unix()), privKey)
+	if err != nil {
+		return err
+	}
+	url := constructURL(server, "/delete?messageid=", utils.B58encode(messageID[:]), "&auth=", auth, "&tombstone=", utils.B58encode(tombstone.Encode()))
+	body, err := socks.Proxy(proto.SocksServer).LimitGetContext(ctx, url, 512000)
+	if err != nil {
+		return err
+	}
+	_, err = parseError(body)
+	return err
+}
+
+// DeleteSignerSpecific deletes a message from server. signer is the signature keypair the message
+// was posted with.
+func (proto *Proto) DeleteSignerSpecific(server string, messageID *[message.MessageIDSize]byte, signer *message.SignKeyPair) error {
+	return proto.DeleteSignerSpecificContext(context.Background(), server, messageID, signer)
+}
+
+// DeleteSignerSpecificContext deletes a message from server with a signer tombstone
+func (proto *Proto) DeleteSignerSpecificContext(ctx context.Context, server string, messageID *[message.MessageIDSize]byte, signer *message.SignKeyPair) error {
+	tombstone := structs.NewSignerTombstone(messageID, uint64(time.Now().Unix()), signer)
+	url := constructURL(server, "/delete?messageid=", utils.B58encode(messageID[:]), "&tombstone=", utils.B58encode(tombstone.Encode()))
+	body, err := socks.Proxy(proto.SocksServer).LimitGetContext(ctx, url, 512000)
+	if err != nil {
+		return err
+	}
+	_, err = parseError(body)
+	return err
+}
@@ -0,0 +1,129 @@
+package repproto
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/repbin/repbin/utils/listparse"
+	"github.com/repbin/repbin/utils/repproto/structs"
+)
+
+const (
+	// ProtoText is the text/plain protocol
+	ProtoText = 1
+	// ProtoJSON is the JSON protocol served under /v2/
+	ProtoJSON = 2
+)
+
+// VersionCacheTime is the time in seconds for which a negotiated protocol version is remembered
+var VersionCacheTime = int64(3600)
+
+type versionEntry struct {
+	version int
+	expire  int64
+}
+
+var versionCache = struct {
+	sync.Mutex
+	servers map[string]versionEntry
+}{servers: make(map[string]versionEntry)}
+
+// ServerError is an error returned by a server.
+type ServerError struct {
+	Code    string // Error code. Empty for text protocol servers
+	Message string // Error message of the server
+}
+
+// Error returns the error message.
+func (e *ServerError) Error() string {
+	return "Server error: " + e.Message
+}
+
+// protoVersion returns the protocol version to use with server.
+func (proto *Proto) protoVersion(ctx context.Context, server string) int {
+	if proto.Version != 0 {
+		return proto.Version
+	}
+	now := time.Now().Unix()
+	versionCache.Lock()
+	entry, ok := versionCache.servers[server]
+	versionCache.Unlock()
+	if ok && entry.expire > now {
+		return entry.version
+	}
+	info, err := proto.IDContext(ctx, server)
+	if err != nil {
+		// Do not remember failures
+		return ProtoText
+	}
+	version := ProtoText
+	if info.APIVersion >= ProtoJSON {
+		version = ProtoJSON
+	}
+	versionCache.Lock()
+	versionCache.servers[server] = versionEntry{version: version, expire: now + VersionCacheTime}
+	versionCache.Unlock()
+	return version
+}
+
+// apiPath returns the path for version.
+func apiPath(version int, path string) string {
+	if version >= ProtoJSON {
+		return "/v2" + path
+	}
+	return path
+}
+
+// parseAPIResponse decodes a JSON response and returns the server error, if any.
+func parseAPIResponse(body []byte) (*structs.APIResponse, error) {
+	resp := new(structs.APIResponse)
+	if err := json.Unmarshal(body, resp); err != nil || resp.Version < ProtoJSON {
+		return nil, ErrBadProto
+	}
+	if resp.Error != nil {
+		return nil, &ServerError{Code: resp.Error.Code, Message: resp.Error.Message}
+	}
+	return resp, nil
+}
+
+// parseAPIListResponse decodes a JSON index response.
+func parseAPIListResponse(body []byte) (messages []*structs.MessageStruct, more bool, err error) {
+	resp, err := parseAPIResponse(body)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(resp.Messages) == 0 {
+		return nil, false, listparse.ErrNoEntries
+	}
+	messages = make([]*structs.MessageStruct, 0, len(resp.Messages))
+	for _, entry := range resp.Messages {
+		msg := entry.MessageStruct()
+		if msg == nil {
+			return nil, false, listparse.ErrSomeErrors
+		}
+		messages = append(messages, msg)
+	}
+	return messages, resp.More, nil
+}
+
+// parseResponse parses the response of a call made with version.
+func parseResponse(version int, body []byte) ([]byte, error) {
+	if version >= ProtoJSON {
+		resp, err := parseAPIResponse(body)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(resp.Data), nil
+	}
+	return parseError(body)
+}
+
+// parseListResponseVersion parses the index response of a call made with version.
+func parseListResponseVersion(version int, body []byte) (messages []*structs.MessageStruct, more bool, err error) {
+	if version >= ProtoJSON {
+		return parseAPIListResponse(body)
+	}
+	return parseListResponse(body)
+}
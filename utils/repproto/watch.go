@@ -0,0 +1,47 @@
+package repproto
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/repbin/repbin/message"
+	"github.com/repbin/repbin/utils"
+	"github.com/repbin/repbin/utils/listparse"
+	"github.com/repbin/repbin/utils/repproto/structs"
+	"github.com/repbin/repbin/utils/socks"
+)
+
+// WatchSpecific waits for index entries of pubKey with a counter above since on server.
+// The list is empty if no message arrived before the server stopped waiting.
+func (proto *Proto) WatchSpecific(server string, pubKey, privKey []byte, since int) (messages []*structs.MessageStruct, more bool, err error) {
+	return proto.WatchSpecificContext(context.Background(), server, pubKey, privKey, since)
+}
+
+// WatchSpecificContext waits for index entries of pubKey with a counter above since on server.
+// The list is empty if no message arrived before the server stopped waiting.
+func (proto *Proto) WatchSpecificContext(ctx context.Context, server string, pubKey, privKey []byte, since int) (messages []*structs.MessageStruct, more bool, err error) {
+	var authStr string
+	var myPubKey message.Curve25519Key
+	copy(myPubKey[:], pubKey)
+	if message.KeyIsHidden(&myPubKey) {
+		if privKey == nil {
+			return nil, false, ErrPrivKey
+		}
+		auth, err := proto.AuthContext(ctx, server, privKey)
+		if err != nil {
+			return nil, false, err
+		}
+		authStr = "&auth=" + auth
+	}
+	version := proto.protoVersion(ctx, server)
+	url := constructURL(server, apiPath(version, "/keyindex/watch?key="), utils.B58encode(pubKey[:]), "&since=", strconv.Itoa(since), authStr)
+	body, err := socks.Proxy(proto.SocksServer).LimitGetContext(ctx, url, 512000)
+	if err != nil {
+		return nil, false, err
+	}
+	messages, more, err = parseListResponseVersion(version, body)
+	if err == listparse.ErrNoEntries {
+		return nil, false, nil
+	}
+	return messages, more, err
+}
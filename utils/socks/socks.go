@@ -3,6 +3,7 @@ package socks
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"net"
@@ -28,7 +29,12 @@ type Proxy string
 
 // LimitGet fetches at most limit bytes from url. Throws error if more data is available
 func (sprox Proxy) LimitGet(url string, limit int64) ([]byte, error) {
-	resp, err := sprox.Get(url)
+	return sprox.LimitGetContext(context.Background(), url, limit)
+}
+
+// LimitGetContext is LimitGet with a context for cancellation and deadlines
+func (sprox Proxy) LimitGetContext(ctx context.Context, url string, limit int64) ([]byte, error) {
+	resp, err := sprox.GetContext(ctx, url)
 	if err != nil {
 		return nil, err
 	}
@@ -49,7 +55,12 @@ func (sprox Proxy) PostBytes(url string, bodyType string, body []byte) (*http.Re
 
 // LimitPostBytes posts []byte and limits the return to limit. Throws error if more data is available
 func (sprox Proxy) LimitPostBytes(url string, bodyType string, body []byte, limit int64) ([]byte, error) {
-	resp, err := sprox.PostBytes(url, bodyType, body)
+	return sprox.LimitPostBytesContext(context.Background(), url, bodyType, body, limit)
+}
+
+// LimitPostBytesContext is LimitPostBytes with a context for cancellation and deadlines
+func (sprox Proxy) LimitPostBytesContext(ctx context.Context, url string, bodyType string, body []byte, limit int64) ([]byte, error) {
+	resp, err := sprox.PostContext(ctx, url, bodyType, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
@@ -81,11 +92,16 @@ func (sprox Proxy) LimitPost(url string, bodyType string, body io.Reader, limit
 
 // Get execute a get call
 func (sprox Proxy) Get(url string) (*http.Response, error) {
-	client, err := sprox.Create()
+	return sprox.GetContext(context.Background(), url)
+}
+
+// GetContext executes a get call that is aborted when ctx is done
+func (sprox Proxy) GetContext(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
-	return client.Get(url)
+	return sprox.Do(req.WithContext(ctx))
 }
 
 // PostForm posts a form. data is url.Values
@@ -117,11 +133,17 @@ func (sprox Proxy) Do(req *http.Request) (resp *http.Response, err error) {
 
 // Post execute a post call
 func (sprox Proxy) Post(url string, bodyType string, body io.Reader) (*http.Response, error) {
-	client, err := sprox.Create()
+	return sprox.PostContext(context.Background(), url, bodyType, body)
+}
+
+// PostContext executes a post call that is aborted when ctx is done
+func (sprox Proxy) PostContext(ctx context.Context, url string, bodyType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest("POST", url, body)
 	if err != nil {
 		return nil, err
 	}
-	return client.Post(url, bodyType, body)
+	req.Header.Set("Content-Type", bodyType)
+	return sprox.Do(req.WithContext(ctx))
 }
 
 // Create a new socks proxy
@@ -159,6 +181,10 @@ func (sprox Proxy) Create() (*http.Client, error) {
 		MaxIdleConnsPerHost:   1,
 		ResponseHeaderTimeout: time.Second * time.Duration(Timeout),
 	}
+	if contextDialer, ok := dialer.(proxy.ContextDialer); ok {
+		// Cancel dialing through the proxy with the request context
+		tr.DialContext = contextDialer.DialContext
+	}
 	client := &http.Client{
 		Transport: tr,
 		Timeout:   time.Second * time.Duration(Timeout), // 45 second timeout is pretty nice
@@ -0,0 +1,113 @@
+// Package xeddsa implements XEdDSA signatures with curve25519 keys.
+//
+// Signatures are made with a curve25519 private key and verify as ed25519 signatures with the
+// ed25519 public key derived from the curve25519 public key. This allows the owner of a
+// curve25519 key to prove authorship to anybody who knows only the public key.
+// See https://signal.org/docs/specifications/xeddsa/
+package xeddsa
+
+import (
+	"crypto/sha512"
+	"io"
+
+	"github.com/agl/ed25519"
+	"github.com/agl/ed25519/edwards25519"
+)
+
+const (
+	// PublicKeySize is the size of a curve25519 public key.
+	PublicKeySize = 32
+	// PrivateKeySize is the size of a curve25519 private key.
+	PrivateKeySize = 32
+	// SignatureSize is the size of a signature.
+	SignatureSize = ed25519.SignatureSize
+)
+
+// hashPrefix separates the nonce hash from other uses of SHA512 with the key.
+var hashPrefix = [32]byte{
+	0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+}
+
+// minusOne is L-1, L being the order of the base point.
+var minusOne = [32]byte{
+	0xec, 0xd3, 0xf5, 0x5c, 0x1a, 0x63, 0x12, 0x58, 0xd6, 0x9c, 0xf7, 0xa2, 0xde, 0xf9, 0xde, 0x14,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10,
+}
+
+// Sign signs message with the curve25519 privateKey. rand is used for the nonce.
+func Sign(rand io.Reader, privateKey *[PrivateKeySize]byte, message []byte) (*[SignatureSize]byte, error) {
+	var random [64]byte
+	if _, err := io.ReadFull(rand, random[:]); err != nil {
+		return nil, err
+	}
+	var zero, one, k, a, publicKey [32]byte
+	one[0] = 1
+	copy(k[:], privateKey[:])
+	k[0] &= 248
+	k[31] &= 127
+	k[31] |= 64
+	// Calculate the ed25519 key pair with sign bit zero
+	var A edwards25519.ExtendedGroupElement
+	edwards25519.GeScalarMultBase(&A, &k)
+	A.ToBytes(&publicKey)
+	if publicKey[31]&0x80 != 0 {
+		edwards25519.ScMulAdd(&a, &k, &minusOne, &zero)
+		publicKey[31] &= 0x7f
+	} else {
+		edwards25519.ScMulAdd(&a, &k, &one, &zero)
+	}
+
+	var digest [64]byte
+	h := sha512.New()
+	h.Write(hashPrefix[:])
+	h.Write(a[:])
+	h.Write(message)
+	h.Write(random[:])
+	h.Sum(digest[:0])
+	var r [32]byte
+	edwards25519.ScReduce(&r, &digest)
+
+	var R edwards25519.ExtendedGroupElement
+	var encodedR [32]byte
+	edwards25519.GeScalarMultBase(&R, &r)
+	R.ToBytes(&encodedR)
+
+	h.Reset()
+	h.Write(encodedR[:])
+	h.Write(publicKey[:])
+	h.Write(message)
+	h.Sum(digest[:0])
+	var hReduced, s [32]byte
+	edwards25519.ScReduce(&hReduced, &digest)
+	edwards25519.ScMulAdd(&s, &hReduced, &a, &r)
+
+	signature := new([SignatureSize]byte)
+	copy(signature[:32], encodedR[:])
+	copy(signature[32:], s[:])
+	return signature, nil
+}
+
+// Verify verifies a signature of message by the curve25519 publicKey.
+func Verify(publicKey *[PublicKeySize]byte, message []byte, signature *[SignatureSize]byte) bool {
+	return ed25519.Verify(EdwardsPublicKey(publicKey), message, signature)
+}
+
+// EdwardsPublicKey converts a curve25519 public key to the ed25519 public key with sign bit zero.
+func EdwardsPublicKey(publicKey *[PublicKeySize]byte) *[ed25519.PublicKeySize]byte {
+	// y = (u - 1) / (u + 1)
+	var u, one, numerator, denominator, y edwards25519.FieldElement
+	var uBytes [32]byte
+	copy(uBytes[:], publicKey[:])
+	uBytes[31] &= 0x7f
+	edwards25519.FeFromBytes(&u, &uBytes)
+	edwards25519.FeOne(&one)
+	edwards25519.FeSub(&numerator, &u, &one)
+	edwards25519.FeAdd(&denominator, &u, &one)
+	edwards25519.FeInvert(&denominator, &denominator)
+	edwards25519.FeMul(&y, &numerator, &denominator)
+	edwardsKey := new([ed25519.PublicKeySize]byte)
+	edwards25519.FeToBytes(edwardsKey, &y)
+	edwardsKey[31] &= 0x7f
+	return edwardsKey
+}
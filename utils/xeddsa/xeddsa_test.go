@@ -0,0 +1,33 @@
+package xeddsa
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+func TestSignVerify(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		var privateKey, publicKey [32]byte
+		if _, err := rand.Read(privateKey[:]); err != nil {
+			t.Fatalf("Rand: %s", err)
+		}
+		curve25519.ScalarBaseMult(&publicKey, &privateKey)
+		msg := []byte("Message to sign")
+		sig, err := Sign(rand.Reader, &privateKey, msg)
+		if err != nil {
+			t.Fatalf("Sign: %s", err)
+		}
+		if !Verify(&publicKey, msg, sig) {
+			t.Fatal("Signature does not verify")
+		}
+		if Verify(&publicKey, []byte("Other message"), sig) {
+			t.Error("Signature verifies for other message")
+		}
+		publicKey[0] ^= 0x01
+		if Verify(&publicKey, msg, sig) {
+			t.Error("Signature verifies for other key")
+		}
+	}
+}